@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Catalog is the source catalog (en.json): English strings localized by
+// Lua sources, by key, with where they're used.
+type Catalog map[string]*Entry
+
+// Entry is a key of the source catalog.
+type Entry struct {
+	// Refs are uses without context, "file:line"
+	Refs []string `json:"refs,omitempty"`
+	// Contexts are uses with a context, refs by context
+	Contexts map[string][]string `json:"contexts,omitempty"`
+	// Arguments are types of arguments of keys in ICU MessageFormat, by
+	// name: "plural", "select", or empty for simple arguments
+	Arguments map[string]string `json:"arguments,omitempty"`
+}
+
+func (c Catalog) add(key, context, ref string) {
+	e, ok := c[key]
+	if !ok {
+		e = &Entry{}
+		c[key] = e
+	}
+	if context == "" {
+		e.Refs = append(e.Refs, ref)
+		return
+	}
+	if e.Contexts == nil {
+		e.Contexts = make(map[string][]string)
+	}
+	e.Contexts[context] = append(e.Contexts[context], ref)
+}
+
+// merge adds keys of other to the catalog, with their references.
+func (c Catalog) merge(other Catalog) {
+	for _, key := range other.Keys() {
+		if _, ok := c[key]; !ok {
+			c[key] = &Entry{}
+		}
+		e := other[key]
+		for _, ref := range e.Refs {
+			c.add(key, "", ref)
+		}
+		for _, context := range e.ContextNames() {
+			for _, ref := range e.Contexts[context] {
+				c.add(key, context, ref)
+			}
+		}
+	}
+}
+
+// Keys returns keys of the catalog, sorted.
+func (c Catalog) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addArguments sets arguments of keys in ICU MessageFormat, returning
+// warnings about keys that can't be parsed.
+func (c Catalog) addArguments() []string {
+	warnings := make([]string, 0)
+	for _, key := range c.Keys() {
+		if !isMessage(key) {
+			continue
+		}
+		m, err := parseMessage(key)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%q: invalid ICU message: %v", key, err))
+			continue
+		}
+		if args := m.arguments(); len(args) > 0 {
+			c[key].Arguments = args
+		}
+	}
+	return warnings
+}
+
+// ContextNames returns contexts of an entry, sorted.
+func (e *Entry) ContextNames() []string {
+	names := make([]string, 0, len(e.Contexts))
+	for name := range e.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// template returns units in the shape of language files, with empty
+// values: a string, or a table of strings by context.
+func template(units []unit) map[string]interface{} {
+	t := make(map[string]interface{})
+	for _, u := range units {
+		if u.context == "" {
+			t[u.key] = ""
+			continue
+		}
+		contexts, ok := t[u.key].(map[string]string)
+		if !ok {
+			contexts = make(map[string]string)
+			t[u.key] = contexts
+		}
+		contexts[u.context] = ""
+	}
+	return t
+}
+
+func readCatalog(path string) (Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := make(Catalog)
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// marshalJSON encodes v like i18n files are: indented with 4 spaces,
+// without escaping HTML characters, keys of maps sorted.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "    ")
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
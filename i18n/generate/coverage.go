@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// coverage is the translation coverage of a language.
+type coverage struct {
+	Lang Language
+	// Total is the number of units of the source catalog, Translated
+	// the number of them translated
+	Total, Translated int
+	Missing           []unit
+	// Dead are keys and contexts of the language file that aren't in the
+	// source catalog anymore
+	Dead []unit
+	// Changed are missing units replacing a dead unit, their English source
+	// having changed (see planTranslation): translations to carry over and
+	// review, or to translate again
+	Changed []change
+}
+
+func (c *coverage) Percent() float64 {
+	if c.Total == 0 {
+		return 100
+	}
+	return float64(c.Translated) * 100 / float64(c.Total)
+}
+
+// deadUnits returns keys and contexts of a language file that aren't in
+// the source catalog. Keys translated by context but used without context
+// aren't dead, validate reports them.
+func deadUnits(catalog Catalog, m *Messages) []unit {
+	dead := make([]unit, 0)
+	for _, key := range m.Keys {
+		e, ok := catalog[key]
+		t := m.Values[key]
+		switch {
+		case !ok:
+			dead = append(dead, unit{key: key})
+		case t.Contexts != nil && len(e.Contexts) > 0:
+			for _, context := range t.ContextNames {
+				if _, ok := e.Contexts[context]; !ok {
+					dead = append(dead, unit{key: key, context: context})
+				}
+			}
+		}
+	}
+	return dead
+}
+
+func computeCoverage(lang Language, catalog Catalog, m *Messages, lock Lock) *coverage {
+	c := &coverage{Lang: lang, Missing: make([]unit, 0), Dead: deadUnits(catalog, m)}
+	for _, u := range catalog.units() {
+		c.Total++
+		if _, ok := m.get(u); ok {
+			c.Translated++
+		} else {
+			c.Missing = append(c.Missing, u)
+		}
+	}
+	p := planTranslation(catalog, m, lock, lang.Code)
+	c.Changed = append(make([]change, 0), p.changed...)
+	return c
+}
+
+// prune removes dead keys and contexts from a language file, and from
+// the lock.
+func prune(lang string, m *Messages, dead []unit, lock Lock) {
+	for _, u := range dead {
+		m.remove(u)
+		lock.remove(lang, u)
+	}
+}
+
+// prunable returns dead units of coverages that can be pruned, by language
+// code, and dead units that are kept. Keys of the external catalog are never
+// pruned. Units dead in all languages, translated for all of them, may be
+// used by worlds out of the repository, missing from the external catalog:
+// they're only pruned with force.
+func prunable(coverages []*coverage, external Catalog, force bool) (map[string][]unit, []unit) {
+	deadIn := make(map[unit]int)
+	for _, c := range coverages {
+		for _, u := range c.Dead {
+			deadIn[u]++
+		}
+	}
+
+	pruned := make(map[string][]unit)
+	kept := make([]unit, 0)
+	isKept := make(map[unit]bool)
+	for _, c := range coverages {
+		for _, u := range c.Dead {
+			_, isExternal := external[u.key]
+			if isExternal || (!force && deadIn[u] == len(coverages)) {
+				if !isKept[u] {
+					isKept[u] = true
+					kept = append(kept, u)
+				}
+				continue
+			}
+			pruned[c.Lang.Code] = append(pruned[c.Lang.Code], u)
+		}
+	}
+	return pruned, kept
+}
+
+// reportList is a list of strings of a report.
+type reportList struct {
+	name  string
+	items []string
+}
+
+// lists returns missing, dead and changed strings of a coverage.
+func (c *coverage) lists() []reportList {
+	lists := []reportList{{name: "Missing"}, {name: "Dead"}, {name: "Changed"}}
+	for _, u := range c.Missing {
+		lists[0].items = append(lists[0].items, u.String())
+	}
+	for _, u := range c.Dead {
+		lists[1].items = append(lists[1].items, u.String())
+	}
+	for _, change := range c.Changed {
+		lists[2].items = append(lists[2].items, change.String())
+	}
+	return lists
+}
+
+func writeTextReport(w io.Writer, coverages []*coverage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "language\ttranslated\tmissing\tdead\tchanged")
+	for _, c := range coverages {
+		fmt.Fprintf(tw, "%s\t%.1f%% (%d/%d)\t%d\t%d\t%d\n", c.Lang.Code, c.Percent(), c.Translated, c.Total, len(c.Missing), len(c.Dead), len(c.Changed))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, c := range coverages {
+		for _, list := range c.lists() {
+			for _, item := range list.items {
+				fmt.Fprintf(w, "%s: %s: %s\n", c.Lang.Code, strings.ToLower(list.name), item)
+			}
+		}
+	}
+	return nil
+}
+
+// markdownEscaper escapes characters of markdown tables and lists.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "`", "\\`", "*", `\*`, "_", `\_`, "<", "&lt;", "\n", " ")
+
+func writeMarkdownReport(w io.Writer, coverages []*coverage) error {
+	var b strings.Builder
+	b.WriteString("## Translation coverage\n\n")
+	b.WriteString("| Language | Translated | Missing | Dead | Changed |\n")
+	b.WriteString("|---|---:|---:|---:|---:|\n")
+	for _, c := range coverages {
+		fmt.Fprintf(&b, "| %s (%s) | %.1f%% (%d/%d) | %d | %d | %d |\n", c.Lang.Name, c.Lang.Code, c.Percent(), c.Translated, c.Total, len(c.Missing), len(c.Dead), len(c.Changed))
+	}
+	for _, c := range coverages {
+		for _, list := range c.lists() {
+			if len(list.items) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\n<details><summary>%s: %s (%d)</summary>\n\n", c.Lang.Name, strings.ToLower(list.name), len(list.items))
+			for _, item := range list.items {
+				fmt.Fprintf(&b, "- %s\n", markdownEscaper.Replace(item))
+			}
+			b.WriteString("\n</details>\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Translation coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Translation coverage</h1>
+<table>
+<tr><th>Language</th><th>Translated</th><th>Missing</th><th>Dead</th><th>Changed</th></tr>
+{{- range .}}
+<tr><td>{{.Lang.Name}} ({{.Lang.Code}})</td><td>{{printf "%.1f" .Percent}}% ({{.Translated}}/{{.Total}})</td><td>{{len .Missing}}</td><td>{{len .Dead}}</td><td>{{len .Changed}}</td></tr>
+{{- end}}
+</table>
+{{- range $c := .}}
+{{- if or $c.Missing $c.Dead $c.Changed}}
+<h2>{{$c.Lang.Name}}</h2>
+{{- if $c.Missing}}
+<details><summary>Missing ({{len $c.Missing}})</summary><ul>{{range $c.Missing}}<li>{{.}}</li>{{end}}</ul></details>
+{{- end}}
+{{- if $c.Dead}}
+<details><summary>Dead ({{len $c.Dead}})</summary><ul>{{range $c.Dead}}<li>{{.}}</li>{{end}}</ul></details>
+{{- end}}
+{{- if $c.Changed}}
+<details><summary>Changed ({{len $c.Changed}})</summary><ul>{{range $c.Changed}}<li>{{.}}</li>{{end}}</ul></details>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func writeHTMLReport(w io.Writer, coverages []*coverage) error {
+	return htmlReport.Execute(w, coverages)
+}
+
+var reportWriters = map[string]func(w io.Writer, coverages []*coverage) error{
+	"text":     writeTextReport,
+	"markdown": writeMarkdownReport,
+	"html":     writeHTMLReport,
+}
+
+var coverageCommand = &command{
+	name:  "coverage",
+	usage: "[flags]",
+	description: "Reports, by language, strings translated, dead keys that aren't in the " +
+		"source catalog anymore, and missing keys whose English source changed.",
+	run: runCoverage,
+}
+
+func runCoverage(flags *flag.FlagSet, args []string) error {
+
+	catalogPath := flags.String("catalog", "../en.json", "source catalog, written by extract")
+	dir := flags.String("dir", "..", "directory of language files")
+	externalPath := flags.String("external", "external.json", "catalog of strings localized out of Lua sources, never pruned (none if empty)")
+	lockPath := flags.String("lock", "lock.json", "lock file, with hashes of machine translations")
+	format := flags.String("format", "text", "format of the report: text, markdown or html")
+	output := flags.String("o", "", "file to write the report to (default standard output)")
+	pruneDead := flags.Bool("prune", false, "remove dead keys from language files and the lock")
+	force := flags.Bool("force", false, "with -prune, also remove keys that are dead in all languages")
+	flags.Parse(args)
+
+	writeReport, ok := reportWriters[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	external := make(Catalog)
+	if *externalPath != "" {
+		if external, err = readCatalog(*externalPath); err != nil {
+			return err
+		}
+	}
+	lock, err := readLock(*lockPath)
+	if err != nil {
+		return err
+	}
+
+	coverages := make([]*coverage, 0)
+	messages := make(map[string]*Messages)
+	for _, lang := range languages {
+		path := filepath.Join(*dir, lang.Code+".json")
+		m, err := readMessages(path)
+		if errors.Is(err, os.ErrNotExist) {
+			m = newMessages()
+		} else if err != nil {
+			return err
+		}
+		coverages = append(coverages, computeCoverage(lang, catalog, m, lock))
+		messages[lang.Code] = m
+	}
+
+	pruned := 0
+	if *pruneDead {
+		dead, kept := prunable(coverages, external, *force)
+		for _, lang := range languages {
+			if len(dead[lang.Code]) == 0 {
+				continue
+			}
+			m := messages[lang.Code]
+			prune(lang.Code, m, dead[lang.Code], lock)
+			if err := os.WriteFile(filepath.Join(*dir, lang.Code+".json"), m.Marshal(), 0644); err != nil {
+				return err
+			}
+			pruned += len(dead[lang.Code])
+		}
+		for _, u := range kept {
+			if _, ok := external[u.key]; ok {
+				fmt.Fprintf(os.Stderr, "kept %s: in the external catalog\n", u)
+			} else {
+				fmt.Fprintf(os.Stderr, "kept %s: dead in all languages, used out of the repository? (-force to prune it)\n", u)
+			}
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeReport(w, coverages); err != nil {
+		return err
+	}
+
+	if *pruneDead {
+		fmt.Fprintf(os.Stderr, "pruned %d dead keys and contexts\n", pruned)
+		if pruned > 0 {
+			return writeJSON(*lockPath, lock)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	catalog := Catalog{
+		"day":     {Refs: []string{"a.lua:1"}},
+		"month":   {Refs: []string{"a.lua:2"}},
+		"sign up": {Contexts: map[string][]string{"button": {"a.lua:3"}, "title": {"a.lua:4"}}},
+		"year":    {Refs: []string{"a.lua:5"}},
+	}
+	m, err := parseMessages([]byte(`{
+    "day": "jour",
+    "old": "vieux",
+    "sign up": {
+        "button": "s'inscrire",
+        "menu": "inscription"
+    },
+    "year": "an"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := make(Lock)
+	lock.set("fr", unit{key: "day"}, &LockEntry{Source: sourceHash(unit{key: "day"}), Translation: hash("jour")})
+	lock.set("fr", unit{key: "old"}, &LockEntry{Source: sourceHash(unit{key: "old"})})
+	lock.set("fr", unit{key: "sign up", context: "menu"}, &LockEntry{Source: sourceHash(unit{key: "sign up", context: "menu"})})
+	lock.set("fr", unit{key: "sign up", context: "button"}, &LockEntry{Source: sourceHash(unit{key: "sign up", context: "button"})})
+	lock.set("fr", unit{key: "year"}, &LockEntry{Source: sourceHash(unit{key: "year"}), Translation: hash("an")})
+
+	c := computeCoverage(french, catalog, m, lock)
+	if c.Total != 5 || c.Translated != 3 || c.Percent() != 60 {
+		t.Errorf("%d/%d translated, %.1f%%", c.Translated, c.Total, c.Percent())
+	}
+	if expected := []unit{{key: "month"}, {key: "sign up", context: "title"}}; !reflect.DeepEqual(c.Missing, expected) {
+		t.Errorf("missing %v", c.Missing)
+	}
+	if expected := []unit{{key: "old"}, {key: "sign up", context: "menu"}}; !reflect.DeepEqual(c.Dead, expected) {
+		t.Errorf("dead %v", c.Dead)
+	}
+	if len(c.Changed) != 0 {
+		t.Errorf("changed %v", c.Changed)
+	}
+
+	prune("fr", m, c.Dead, lock)
+	expected := `{
+    "day": "jour",
+    "sign up": {
+        "button": "s'inscrire"
+    },
+    "year": "an"
+}`
+	if string(m.Marshal()) != expected {
+		t.Errorf("pruned file:\n%s", m.Marshal())
+	}
+	if lock.get("fr", unit{key: "old"}) != nil || lock.get("fr", unit{key: "sign up", context: "menu"}) != nil ||
+		lock.get("fr", unit{key: "sign up", context: "button"}) == nil {
+		t.Errorf("pruned lock %+v", lock["fr"])
+	}
+	if c := computeCoverage(french, catalog, m, lock); len(c.Dead) != 0 || c.Translated != 3 {
+		t.Errorf("after pruning: %d dead, %d translated", len(c.Dead), c.Translated)
+	}
+}
+
+func TestPrunable(t *testing.T) {
+	spanish := Language{Name: "Spanish", Code: "es"}
+	coverages := []*coverage{
+		{Lang: french, Dead: []unit{{key: "old"}, {key: "Glider unlocked!"}, {key: "sign up", context: "menu"}}},
+		{Lang: spanish, Dead: []unit{{key: "old"}, {key: "Glider unlocked!"}}},
+	}
+	external := Catalog{"Glider unlocked!": {Refs: []string{"external"}}}
+
+	dead, kept := prunable(coverages, external, false)
+	if expected := map[string][]unit{"fr": {{key: "sign up", context: "menu"}}}; !reflect.DeepEqual(dead, expected) {
+		t.Errorf("pruned %v", dead)
+	}
+	if expected := []unit{{key: "old"}, {key: "Glider unlocked!"}}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("kept %v", kept)
+	}
+
+	// external keys are kept even with force
+	dead, kept = prunable(coverages, external, true)
+	expected := map[string][]unit{
+		"fr": {{key: "old"}, {key: "sign up", context: "menu"}},
+		"es": {{key: "old"}},
+	}
+	if !reflect.DeepEqual(dead, expected) {
+		t.Errorf("forced: pruned %v", dead)
+	}
+	if expected := []unit{{key: "Glider unlocked!"}}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("forced: kept %v", kept)
+	}
+}
+
+// TestCoverageChanged changes strings in Lua sources after they've been
+// translated, one of the translations having been edited.
+func TestCoverageChanged(t *testing.T) {
+	catalog := func(src string) Catalog {
+		c := make(Catalog)
+		if _, err := extractSource(c, src, "lua/modules/menu.lua", []string{"loc"}); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	before := catalog(`title = loc("Log in to play") .. loc("Sign up for free", "button") .. loc("Quit")`)
+	m, lock := newMessages(), make(Lock)
+	translated, err := parseMessages([]byte(`{"Log in to play": "Connecte-toi pour jouer", "Sign up for free": {"button": "Inscris-toi gratuitement"}, "Quit": "Quitter"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := merge(m, translated, before.units(), lock, "fr"); err != nil {
+		t.Fatal(err)
+	}
+	m.set(unit{key: "Sign up for free", context: "button"}, "Inscription gratuite")
+
+	after := catalog(`title = loc("Log in to play!") .. loc("Sign up now for free", "button") .. loc("Exit")`)
+	c := computeCoverage(french, after, m, lock)
+	expected := []change{
+		{unit: unit{key: "Log in to play!"}, previous: unit{key: "Log in to play"}},
+		{unit: unit{key: "Sign up now for free", context: "button"}, previous: unit{key: "Sign up for free", context: "button"}, edited: true},
+	}
+	if !reflect.DeepEqual(c.Changed, expected) {
+		t.Errorf("changed %+v", c.Changed)
+	}
+	if len(c.Missing) != 3 || len(c.Dead) != 3 {
+		t.Errorf("%d missing, %d dead", len(c.Missing), len(c.Dead))
+	}
+
+	var b bytes.Buffer
+	if err := writeTextReport(&b, []*coverage{c}); err != nil {
+		t.Fatal(err)
+	}
+	if s := `fr: changed: "Sign up now for free" (button) (was "Sign up for free")`; !strings.Contains(b.String(), s) {
+		t.Errorf("report without %q:\n%s", s, b.String())
+	}
+}
+
+func TestReports(t *testing.T) {
+	c := &coverage{
+		Lang:       french,
+		Total:      3,
+		Translated: 1,
+		Missing:    []unit{{key: "a | <b>"}},
+		Dead:       []unit{{key: "old", context: "title"}},
+		Changed:    make([]change, 0),
+	}
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{"text", []string{"fr        33.3% (1/3)  1        1     0", `fr: dead: "old" (title)`}},
+		{"markdown", []string{"| French (fr) | 33.3% (1/3) | 1 | 1 | 0 |", `- "a \| &lt;b>"`, "French: dead (1)"}},
+		{"html", []string{"<td>33.3% (1/3)</td>", "<li>&#34;a | &lt;b&gt;&#34;</li>", "Dead (1)"}},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := reportWriters[test.format](&b, []*coverage{c}); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range test.expected {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s report without %q:\n%s", test.format, s, b.String())
+			}
+		}
+		if test.format != "text" && strings.Contains(b.String(), "hanged (") {
+			t.Errorf("%s report lists empty changes", test.format)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// item is a string of a language file exchanged with translation tools,
+// in PO or XLIFF files.
+type item struct {
+	unit unit
+	// refs are uses of the string in Lua sources, "file:line"
+	refs []string
+	// text is the translation, if translated
+	text       string
+	translated bool
+}
+
+// exportItems returns items of a language file, in the order of the file,
+// followed by untranslated strings of the source catalog. Keys translated
+// without context are exported without context, even when they're used
+// with contexts.
+func exportItems(catalog Catalog, m *Messages) []item {
+	items := make([]item, 0)
+	for _, key := range m.Keys {
+		t := m.Values[key]
+		e := catalog[key]
+		if t.Contexts == nil {
+			it := item{unit: unit{key: key}, text: t.Text, translated: true}
+			if e != nil {
+				it.refs = append(it.refs, e.Refs...)
+				for _, context := range e.ContextNames() {
+					it.refs = append(it.refs, e.Contexts[context]...)
+				}
+			}
+			items = append(items, it)
+			continue
+		}
+		for _, context := range t.ContextNames {
+			it := item{unit: unit{key: key, context: context}, text: t.Contexts[context], translated: true}
+			if e != nil {
+				it.refs = e.Contexts[context]
+			}
+			items = append(items, it)
+		}
+	}
+
+	for _, u := range catalog.units() {
+		if _, ok := m.get(u); ok {
+			continue
+		}
+		e := catalog[u.key]
+		refs := e.Refs
+		if u.context != "" {
+			refs = e.Contexts[u.context]
+		}
+		items = append(items, item{unit: u, refs: refs})
+	}
+	return items
+}
+
+// importItems returns the language file of translated items, in order.
+func importItems(items []item) *Messages {
+	m := newMessages()
+	for _, it := range items {
+		if it.translated {
+			m.set(it.unit, it.text)
+		}
+	}
+	return m
+}
+
+// format is a file format of translation tools.
+type format struct {
+	extension string
+	encode    func(lang Language, items []item) []byte
+	// decode returns items and the code of their language
+	decode func(data []byte) ([]item, string, error)
+}
+
+var formats = map[string]*format{
+	"po":    {extension: ".po", encode: encodePO, decode: decodePO},
+	"xliff": {extension: ".xlf", encode: encodeXLIFF, decode: decodeXLIFF},
+}
+
+func languageByCode(code string) (Language, bool) {
+	for _, lang := range languages {
+		if lang.Code == code {
+			return lang, true
+		}
+	}
+	return Language{}, false
+}
+
+var exportCommand = &command{
+	name:  "export",
+	usage: "[flags]",
+	description: "Exports language files as PO or XLIFF 2.0 files, for translation tools, " +
+		"with untranslated strings and references.",
+	run: runExport,
+}
+
+func runExport(flags *flag.FlagSet, args []string) error {
+
+	catalogPath := flags.String("catalog", "../en.json", "source catalog, written by extract")
+	dir := flags.String("dir", "..", "directory of language files")
+	formatName := flags.String("format", "po", "format of exported files: po or xliff")
+	langs := flags.String("lang", "", "comma separated codes of languages to export (default all)")
+	output := flags.String("o", ".", "directory of exported files")
+	flags.Parse(args)
+
+	f, ok := formats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *formatName)
+	}
+
+	selected := languages
+	if *langs != "" {
+		selected = make([]Language, 0)
+		for _, code := range strings.Split(*langs, ",") {
+			lang, ok := languageByCode(code)
+			if !ok {
+				return fmt.Errorf("unknown language %q", code)
+			}
+			selected = append(selected, lang)
+		}
+	}
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+
+	for _, lang := range selected {
+		m, err := readMessages(filepath.Join(*dir, lang.Code+".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			m = newMessages()
+		} else if err != nil {
+			return err
+		}
+		path := filepath.Join(*output, lang.Code+f.extension)
+		if err := os.WriteFile(path, f.encode(lang, exportItems(catalog, m)), 0644); err != nil {
+			return err
+		}
+		fmt.Println("exported", path)
+	}
+	return nil
+}
+
+var importCommand = &command{
+	name:  "import",
+	usage: "[flags] file...",
+	description: "Imports PO or XLIFF 2.0 files (.po, .xlf), replacing language files " +
+		"with their translated strings.",
+	run: runImport,
+}
+
+func runImport(flags *flag.FlagSet, args []string) error {
+
+	dir := flags.String("dir", "..", "directory of language files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no file to import")
+	}
+
+	for _, path := range flags.Args() {
+		var f *format
+		for _, candidate := range formats {
+			if strings.EqualFold(filepath.Ext(path), candidate.extension) {
+				f = candidate
+			}
+		}
+		if strings.EqualFold(filepath.Ext(path), ".xliff") {
+			f = formats["xliff"]
+		}
+		if f == nil {
+			return fmt.Errorf("%s: unknown format", path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		items, code, err := f.decode(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		lang, ok := languageByCode(code)
+		if !ok {
+			return fmt.Errorf("%s: unknown language %q", path, code)
+		}
+
+		m := importItems(items)
+		output := filepath.Join(*dir, lang.Code+".json")
+		if err := os.WriteFile(output, m.Marshal(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("imported %s: %s, %d keys\n", path, output, len(m.Keys))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestExchangeRoundTrip exports language files and imports them back, in
+// all formats.
+func TestExchangeRoundTrip(t *testing.T) {
+	catalog, err := readCatalog("../en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lang := range languages {
+		m, err := readMessages(filepath.Join("..", lang.Code+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, f := range formats {
+			items := exportItems(catalog, m)
+			items, code, err := f.decode(f.encode(lang, items))
+			if err != nil {
+				t.Fatalf("%s %s: %v", lang.Code, name, err)
+			}
+			if code != lang.Code {
+				t.Errorf("%s %s: language %q", lang.Code, name, code)
+			}
+			if !reflect.DeepEqual(items, exportItems(catalog, m)) {
+				t.Errorf("%s %s: items aren't the same", lang.Code, name)
+			}
+			if imported := importItems(items); !bytes.Equal(imported.Marshal(), m.Marshal()) {
+				t.Errorf("%s %s: imported file isn't the same:\n%s", lang.Code, name, imported.Marshal())
+			}
+		}
+	}
+}
+
+func TestExportItems(t *testing.T) {
+	catalog := Catalog{
+		"day":     {Refs: []string{"a.lua:1"}},
+		"login":   {Contexts: map[string][]string{"button": {"a.lua:2"}, "title": {"a.lua:3"}}},
+		"sign up": {Contexts: map[string][]string{"button": {"a.lua:4"}, "title": {"a.lua:5"}}},
+	}
+	m, err := parseMessages([]byte(`{
+    "sign up": {
+        "title": "inscription"
+    },
+    "login": "connexion",
+    "old": "vieux"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []item{
+		{unit{key: "sign up", context: "title"}, []string{"a.lua:5"}, "inscription", true},
+		{unit{key: "login"}, []string{"a.lua:2", "a.lua:3"}, "connexion", true},
+		{unit{key: "old"}, nil, "vieux", true},
+		{unit{key: "day"}, []string{"a.lua:1"}, "", false},
+		{unit{key: "sign up", context: "button"}, []string{"a.lua:4"}, "", false},
+	}
+	if items := exportItems(catalog, m); !reflect.DeepEqual(items, expected) {
+		t.Errorf("got %+v", items)
+	}
+}
+
+func TestDecodePO(t *testing.T) {
+	po := `msgid ""
+msgstr ""
+"Language: pl\n"
+
+#: a.lua:1 a.lua:2
+#, c-format
+msgctxt "button"
+msgid "%s "
+"joined!"
+msgstr "%s dołączył!"
+#: a.lua:3
+#, fuzzy
+msgid "day"
+msgstr "dzień"
+
+msgid "month"
+msgstr ""
+
+#~ msgid "old"
+#~ msgstr "stary"
+`
+	items, code, err := decodePO([]byte(po))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []item{
+		{unit{key: "%s joined!", context: "button"}, []string{"a.lua:1", "a.lua:2"}, "%s dołączył!", true},
+		{unit{key: "day"}, []string{"a.lua:3"}, "dzień", false},
+		{unit{key: "month"}, nil, "", false},
+	}
+	if code != "pl" || !reflect.DeepEqual(items, expected) {
+		t.Errorf("got %q, %+v", code, items)
+	}
+
+	for _, po := range []string{
+		"msgstr \"orphan\"",
+		"msgid \"unfinished",
+		"msgid \"\\q\"",
+		"msgid \"day\"\nmsgid_plural \"days\"",
+		"\"string\"",
+	} {
+		if _, _, err := decodePO([]byte(po)); err == nil {
+			t.Errorf("%q: no error", po)
+		}
+	}
+}
+
+func TestDecodeXLIFF(t *testing.T) {
+	xlf := `<?xml version="1.0" encoding="UTF-8"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="en" trgLang="it">
+  <file id="f1">
+    <unit id="1" name="sign up">
+      <notes>
+        <note category="context">button</note>
+        <note category="location">a.lua:1</note>
+        <note>comment</note>
+      </notes>
+      <segment state="reviewed">
+        <source>sign up</source>
+        <target>registrati</target>
+      </segment>
+    </unit>
+    <unit id="2">
+      <segment>
+        <source>day</source>
+      </segment>
+    </unit>
+  </file>
+</xliff>`
+	items, code, err := decodeXLIFF([]byte(xlf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []item{
+		{unit{key: "sign up", context: "button"}, []string{"a.lua:1"}, "registrati", true},
+		{unit{key: "day"}, nil, "", false},
+	}
+	if code != "it" || !reflect.DeepEqual(items, expected) {
+		t.Errorf("got %q, %+v", code, items)
+	}
+
+	if _, _, err := decodeXLIFF([]byte(strings.Replace(xlf, `version="2.0"`, `version="1.2"`, 1))); err == nil {
+		t.Errorf("XLIFF 1.2: no error")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// token kinds of the Lua lexer
+const (
+	tokenOther = iota
+	tokenName
+	tokenString
+)
+
+// token is a Lua token. Comments are skipped, operators are single
+// characters, except "..", "..." and "::" (enough to find function calls).
+type token struct {
+	kind int
+	// text of names and operators, value of strings
+	text string
+	line int
+}
+
+// is returns true if the token is the given operator or name.
+func (t token) is(text string) bool {
+	return t.kind != tokenString && t.text == text
+}
+
+// lexer reads Lua tokens.
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1}
+}
+
+// next returns the next token, false at the end of the source.
+func (l *lexer) next() (token, bool, error) {
+	l.skipSpaceAndComments()
+	if l.pos >= len(l.src) {
+		return token{}, false, nil
+	}
+
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		s, err := l.quotedString()
+		return token{kind: tokenString, text: s, line: line}, true, err
+	case c == '[' && l.longBracketLevel() >= 0:
+		s, err := l.longString()
+		return token{kind: tokenString, text: s, line: line}, true, err
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, text: l.src[start:l.pos], line: line}, true, nil
+	}
+
+	// concatenation and labels aren't fields and methods
+	for _, op := range []string{"...", "..", "::"} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokenOther, text: op, line: line}, true, nil
+		}
+	}
+	l.pos++
+	return token{kind: tokenOther, text: string(c), line: line}, true, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "--"):
+			l.pos += 2
+			if l.pos < len(l.src) && l.src[l.pos] == '[' && l.longBracketLevel() >= 0 {
+				// errors in comments are ignored, the comment ends the file
+				l.longString()
+				continue
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// longBracketLevel returns the level of the long bracket at the current
+// position ([[ is 0, [==[ is 2), -1 if there's none.
+func (l *lexer) longBracketLevel() int {
+	level := 0
+	for i := l.pos + 1; i < len(l.src); i++ {
+		switch l.src[i] {
+		case '=':
+			level++
+		case '[':
+			return level
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// longString reads a [[long string]], without its first new line.
+func (l *lexer) longString() (string, error) {
+	level := l.longBracketLevel()
+	l.pos += level + 2
+	if strings.HasPrefix(l.src[l.pos:], "\r\n") {
+		l.pos += 2
+		l.line++
+	} else if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+		l.pos++
+		l.line++
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		l.pos = len(l.src)
+		return "", fmt.Errorf("unfinished long string")
+	}
+	s := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(s, "\n")
+	l.pos += end + len(closing)
+	return s, nil
+}
+
+// quotedString reads a string between quotes, decoding its escape sequences.
+func (l *lexer) quotedString() (string, error) {
+	quote := l.src[l.pos]
+	l.pos++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case quote:
+			return b.String(), nil
+		case '\n':
+			l.line++
+			return "", fmt.Errorf("unfinished string")
+		case '\\':
+			if err := l.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unfinished string")
+}
+
+var escapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '\n': '\n',
+}
+
+// escape decodes an escape sequence, after its backslash.
+func (l *lexer) escape(b *strings.Builder) error {
+	if l.pos >= len(l.src) {
+		return fmt.Errorf("unfinished string")
+	}
+	c := l.src[l.pos]
+	l.pos++
+
+	if e, ok := escapes[c]; ok {
+		if c == '\n' {
+			l.line++
+		}
+		b.WriteByte(e)
+		return nil
+	}
+
+	switch {
+	case c == 'z':
+		// skips following white spaces
+		for l.pos < len(l.src) && strings.IndexByte(" \t\r\n\f\v", l.src[l.pos]) >= 0 {
+			if l.src[l.pos] == '\n' {
+				l.line++
+			}
+			l.pos++
+		}
+	case c == 'x':
+		if l.pos+2 > len(l.src) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+		v, err := strconv.ParseUint(l.src[l.pos:l.pos+2], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid escape sequence \\x%s", l.src[l.pos:l.pos+2])
+		}
+		b.WriteByte(byte(v))
+		l.pos += 2
+	case c >= '0' && c <= '9':
+		// up to 3 decimal digits
+		start := l.pos - 1
+		for l.pos < len(l.src) && l.pos-start < 3 && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+			l.pos++
+		}
+		v, err := strconv.ParseUint(l.src[start:l.pos], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid escape sequence \\%s", l.src[start:l.pos])
+		}
+		b.WriteByte(byte(v))
+	case c == 'u':
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if l.pos >= len(l.src) || l.src[l.pos] != '{' || end < 0 {
+			return fmt.Errorf("invalid escape sequence \\u")
+		}
+		v, err := strconv.ParseUint(l.src[l.pos+1:l.pos+end], 16, 31)
+		if err != nil {
+			return fmt.Errorf("invalid escape sequence \\u%s", l.src[l.pos:l.pos+end+1])
+		}
+		b.WriteString(string(utf8.AppendRune(nil, rune(v))))
+		l.pos += end + 1
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// extractSource adds strings localized by a Lua source to the catalog,
+// path being used for references. funcs are names of localization
+// functions: loc("key") or loc("key", "context"). It returns warnings
+// about calls without literal strings, that can't be extracted.
+func extractSource(c Catalog, src, path string, funcs []string) ([]string, error) {
+	tokens := make([]token, 0)
+	l := newLexer(src)
+	for {
+		t, ok, err := l.next()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, l.line, err)
+		}
+		if !ok {
+			break
+		}
+		tokens = append(tokens, t)
+	}
+
+	isFunc := make(map[string]bool)
+	for _, f := range funcs {
+		isFunc[f] = true
+	}
+
+	warnings := make([]string, 0)
+	for i, t := range tokens {
+		if t.kind != tokenName || !isFunc[t.text] {
+			continue
+		}
+		// fields and methods with the same name, function definitions
+		if i > 0 && (tokens[i-1].is(".") || tokens[i-1].is(":") || tokens[i-1].is("function")) {
+			continue
+		}
+		args := tokens[i+1:]
+		ref := fmt.Sprintf("%s:%d", path, t.line)
+
+		switch {
+		// loc "key"
+		case len(args) > 0 && args[0].kind == tokenString:
+			c.add(args[0].text, "", ref)
+		// loc("key")
+		case len(args) > 2 && args[0].is("(") && args[1].kind == tokenString && args[2].is(")"):
+			c.add(args[1].text, "", ref)
+		// loc("key", "context")
+		case len(args) > 4 && args[0].is("(") && args[1].kind == tokenString && args[2].is(",") &&
+			args[3].kind == tokenString && args[4].is(")"):
+			c.add(args[1].text, args[3].text, ref)
+		case len(args) > 0 && args[0].is("("):
+			warnings = append(warnings, fmt.Sprintf("%s: %s called without literal strings, can't be extracted", ref, t.text))
+		}
+	}
+	return warnings, nil
+}
+
+// extract returns the catalog of strings localized by Lua files found in
+// dirs, references being relative to root, and keys of external.
+func extract(root string, dirs []string, funcs []string, external Catalog) (Catalog, []string, error) {
+	c := make(Catalog)
+	warnings := make([]string, 0)
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ".lua" {
+				return nil
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			w, err := extractSource(c, string(src), filepath.ToSlash(rel), funcs)
+			if err != nil {
+				return err
+			}
+			warnings = append(warnings, w...)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	c.merge(external)
+	warnings = append(warnings, c.addArguments()...)
+	return c, warnings, nil
+}
+
+var extractCommand = &command{
+	name:  "extract",
+	usage: "[flags]",
+	description: "Extracts strings localized by Lua sources, writing the source catalog " +
+		"(en.json) with file:line references, and strings of the external catalog.",
+	run: runExtract,
+}
+
+func runExtract(flags *flag.FlagSet, args []string) error {
+
+	root := flags.String("root", "../..", "root of the repository, references are relative to it")
+	dirs := flags.String("dirs", "lua/modules,mods", "comma separated directories of Lua sources, in root")
+	funcs := flags.String("funcs", "loc", "comma separated names of localization functions")
+	externalPath := flags.String("external", "external.json", "catalog of strings localized out of Lua sources (none if empty)")
+	output := flags.String("o", "../en.json", "source catalog to write")
+	flags.Parse(args)
+
+	external := make(Catalog)
+	if *externalPath != "" {
+		var err error
+		if external, err = readCatalog(*externalPath); err != nil {
+			return err
+		}
+	}
+
+	catalog, warnings, err := extract(*root, strings.Split(*dirs, ","), strings.Split(*funcs, ","), external)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "WARNING:", w)
+	}
+	if err := writeJSON(*output, catalog); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d keys\n", *output, len(catalog))
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractSource(t *testing.T) {
+	src := `local loc = require("localize")
+
+-- loc("commented")
+--[[ loc("commented")
+]]
+local title = loc("sign up", "title") .. loc('sign up', [[button]])
+print(loc("it's \"quoted\"\n\65\x42\u{1F642}"))
+local text = loc "day"
+local other = menu.loc("field") .. self:loc("method")
+local s = [==[
+loc("in a long string")]==] .. loc("%d / %d collected",
+	"number of collected glider parts")
+loc(variable)
+function loc(str) end
+`
+	c := make(Catalog)
+	warnings, err := extractSource(c, src, "lua/modules/test.lua", []string{"loc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Catalog{
+		"sign up": {Contexts: map[string][]string{
+			"title":  {"lua/modules/test.lua:6"},
+			"button": {"lua/modules/test.lua:6"},
+		}},
+		"it's \"quoted\"\nAB🙂": {Refs: []string{"lua/modules/test.lua:7"}},
+		"day":                  {Refs: []string{"lua/modules/test.lua:8"}},
+		"%d / %d collected": {Contexts: map[string][]string{
+			"number of collected glider parts": {"lua/modules/test.lua:11"},
+		}},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		for key, e := range c {
+			t.Logf("%q: %+v", key, e)
+		}
+		t.Errorf("unexpected catalog")
+	}
+
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "lua/modules/test.lua:13: ") {
+		t.Errorf("warnings %q", warnings)
+	}
+}
+
+func TestAddArguments(t *testing.T) {
+	c := make(Catalog)
+	src := `loc("{gender, select, other {{name} has {n, plural, one {# egg} other {# eggs}}}}")
+loc("{n, plural, one {# day}}")
+loc("days")`
+	if _, err := extractSource(c, src, "test.lua", []string{"loc"}); err != nil {
+		t.Fatal(err)
+	}
+	warnings := c.addArguments()
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], `"{n, plural, one {# day}}": invalid ICU message`) {
+		t.Errorf("warnings %q", warnings)
+	}
+	expected := map[string]string{"gender": "select", "name": "", "n": "plural"}
+	for key, e := range c {
+		if strings.HasPrefix(key, "{gender") && !reflect.DeepEqual(e.Arguments, expected) {
+			t.Errorf("%q: arguments %v", key, e.Arguments)
+		} else if !strings.HasPrefix(key, "{gender") && e.Arguments != nil {
+			t.Errorf("%q: arguments %v", key, e.Arguments)
+		}
+	}
+}
+
+func TestExtractSourceErrors(t *testing.T) {
+	for _, src := range []string{`loc("unfinished`, `loc("\q")`, "loc([[unfinished"} {
+		if _, err := extractSource(make(Catalog), src, "test.lua", []string{"loc"}); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "mods"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := `loc("day") loc("sign up", "button")`
+	if err := os.WriteFile(filepath.Join(root, "mods", "a.lua"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// strings of worlds out of the repository
+	external := Catalog{
+		"sign up":          {Contexts: map[string][]string{"title": {"external"}}},
+		"Glider unlocked!": {Refs: []string{"external"}},
+		"{n} eggs":         {},
+	}
+
+	c, warnings, err := extract(root, []string{"mods"}, []string{"loc"}, external)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("%v, warnings %q", err, warnings)
+	}
+	expected := Catalog{
+		"day": {Refs: []string{"mods/a.lua:1"}},
+		"sign up": {Contexts: map[string][]string{
+			"button": {"mods/a.lua:1"},
+			"title":  {"external"},
+		}},
+		"Glider unlocked!": {Refs: []string{"external"}},
+		"{n} eggs":         {Arguments: map[string]string{"n": ""}},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		for key, e := range c {
+			t.Logf("%q: %+v", key, e)
+		}
+		t.Errorf("unexpected catalog")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	c := Catalog{
+		"day":     {Refs: []string{"a.lua:1"}},
+		"sign up": {Refs: []string{"a.lua:2"}, Contexts: map[string][]string{"title": {"a.lua:3"}}},
+	}
+	data, err := marshalJSON(template(c.units()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\n    \"day\": \"\",\n    \"sign up\": {\n        \"title\": \"\"\n    }\n}\n"
+	if string(data) != expected {
+		t.Errorf("got:\n%s", data)
+	}
+}
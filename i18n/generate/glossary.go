@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Glossary lists game terms of a language, and how they're translated
+// (glossary/<code>.json).
+type Glossary map[string]*Term
+
+// Term is a term of a glossary. Terms and their translations match words
+// starting with them, ignoring case: "glider" matches "gliders", and they
+// can be stems of inflected words.
+type Term struct {
+	// Keep is true for terms that aren't translated (names)
+	Keep bool `json:"keep,omitempty"`
+	// Translations are approved translations, the first one being
+	// preferred, others being inflections
+	Translations []string `json:"translations,omitempty"`
+	// Forbidden are translations that shouldn't be used
+	Forbidden []string `json:"forbidden,omitempty"`
+}
+
+// containsWord returns true if s has a word starting with word, ignoring
+// case.
+func containsWord(s, word string) bool {
+	s, word = strings.ToLower(s), strings.ToLower(word)
+	if word == "" {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], word)
+		if i < 0 {
+			return false
+		}
+		i += offset
+		r, _ := utf8.DecodeLastRuneInString(s[:i])
+		if i == 0 || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return true
+		}
+		offset = i + len(word)
+	}
+}
+
+// Terms returns terms of the glossary found in a source string, sorted.
+func (g Glossary) Terms(source string) []string {
+	terms := make([]string, 0)
+	for term := range g {
+		if containsWord(source, term) {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// check returns how a translation violates the glossary, for terms of
+// the source.
+func (g Glossary) check(source, translation string) []string {
+	violations := make([]string, 0)
+	for _, name := range g.Terms(source) {
+		violations = append(violations, g.checkTerm(name, translation)...)
+	}
+	return violations
+}
+
+// checkTerm returns how a translation violates a term of the glossary.
+func (g Glossary) checkTerm(name, translation string) []string {
+	violations := make([]string, 0)
+	term := g[name]
+	if term.Keep && !containsWord(translation, name) {
+		violations = append(violations, fmt.Sprintf("%q must not be translated", name))
+	}
+	if len(term.Translations) > 0 && !containsAnyWord(translation, term.Translations) {
+		violations = append(violations, fmt.Sprintf("%q must be translated as %q", name, term.Translations[0]))
+	}
+	for _, forbidden := range term.Forbidden {
+		if containsWord(translation, forbidden) {
+			violations = append(violations, fmt.Sprintf("%q translated as %q, not approved", name, forbidden))
+		}
+	}
+	return violations
+}
+
+func containsAnyWord(s string, words []string) bool {
+	for _, word := range words {
+		if containsWord(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// prompt returns instructions about terms found in units, for models.
+func (g Glossary) prompt(units []unit) string {
+	found := make(map[string]bool)
+	for _, u := range units {
+		for _, term := range g.Terms(u.key) {
+			found[term] = true
+		}
+	}
+	if len(found) == 0 {
+		return ""
+	}
+
+	terms := make([]string, 0, len(found))
+	for term := range found {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	var b strings.Builder
+	b.WriteString("\n\nUse this glossary of game terms:")
+	for _, name := range terms {
+		term := g[name]
+		switch {
+		case term.Keep:
+			fmt.Fprintf(&b, "\n- %q: don't translate it", name)
+		case len(term.Translations) > 0:
+			fmt.Fprintf(&b, "\n- %q: %q", name, term.Translations[0])
+		}
+		if len(term.Forbidden) > 0 {
+			fmt.Fprintf(&b, " (never %q)", strings.Join(term.Forbidden, `", "`))
+		}
+	}
+	return b.String()
+}
+
+func readGlossary(path string) (Glossary, error) {
+	g := make(Glossary)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return g, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g, nil
+}
+
+// readGlossaries returns glossaries of languages found in dir, by code.
+// Languages without glossary have empty ones.
+func readGlossaries(dir string) (map[string]Glossary, error) {
+	glossaries := make(map[string]Glossary)
+	for _, lang := range languages {
+		g, err := readGlossary(filepath.Join(dir, lang.Code+".json"))
+		if err != nil {
+			return nil, err
+		}
+		glossaries[lang.Code] = g
+	}
+	return glossaries, nil
+}
+
+// translations calls f for each translation of a language file.
+func (m *Messages) translations(f func(u unit, text string)) {
+	for _, key := range m.Keys {
+		t := m.Values[key]
+		if t.Contexts == nil {
+			f(unit{key: key}, t.Text)
+			continue
+		}
+		for _, context := range t.ContextNames {
+			f(unit{key: key, context: context}, t.Contexts[context])
+		}
+	}
+}
+
+var glossaryCommand = &command{
+	name:  "glossary",
+	usage: "[flags]",
+	description: "Reports how terms of glossaries are translated in language files, " +
+		"listing translations that violate them.",
+	run: runGlossary,
+}
+
+func runGlossary(flags *flag.FlagSet, args []string) error {
+
+	dir := flags.String("dir", "..", "directory of language files")
+	glossaryDir := flags.String("glossary", "../glossary", "directory of glossaries (<code>.json)")
+	flags.Parse(args)
+
+	glossaries, err := readGlossaries(*glossaryDir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	violations := make([]string, 0)
+	for _, lang := range languages {
+		g := glossaries[lang.Code]
+		if len(g) == 0 {
+			continue
+		}
+		m, err := readMessages(filepath.Join(*dir, lang.Code+".json"))
+		if err != nil {
+			return err
+		}
+
+		// strings using terms, and strings violating the glossary, by term
+		used, violating := make(map[string]int), make(map[string]int)
+		m.translations(func(u unit, text string) {
+			for _, term := range g.Terms(u.key) {
+				used[term]++
+				v := g.checkTerm(term, text)
+				if len(v) > 0 {
+					violating[term]++
+				}
+				for _, v := range v {
+					violations = append(violations, fmt.Sprintf("%s: %s: %s", lang.Code, u, v))
+				}
+			}
+		})
+
+		names := make([]string, 0, len(g))
+		for name := range g {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "%s\tterm\ttranslation\tstrings\tviolations\n", lang.Code)
+		for _, name := range names {
+			translation := "(kept)"
+			if !g[name].Keep {
+				translation = strings.Join(g[name].Translations, ", ")
+			}
+			fmt.Fprintf(w, "\t%s\t%s\t%d\t%d\n", name, translation, used[name], violating[name])
+		}
+	}
+	w.Flush()
+
+	if len(violations) > 0 {
+		fmt.Println()
+	}
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContainsWord(t *testing.T) {
+	tests := []struct {
+		s, word  string
+		expected bool
+	}{
+		{"Glider unlocked!", "glider", true},
+		{"Ready to explore other Worlds?", "World", true},
+		{"Underworld", "world", false},
+		{"use the changing room!", "changing room", true},
+		{"Przymierzalni", "przymierzaln", true},
+		{"Готов исследовать другие Миры?", "мир", true},
+		{"примир", "мир", false},
+		{"gliding", "glider", false},
+		{"anything", "", false},
+	}
+	for _, test := range tests {
+		if containsWord(test.s, test.word) != test.expected {
+			t.Errorf("%q in %q: got %v", test.word, test.s, !test.expected)
+		}
+	}
+}
+
+var frenchGlossary = Glossary{
+	"Cubzh":         {Keep: true},
+	"glider":        {Translations: []string{"planeur"}},
+	"changing room": {Translations: []string{"cabine d'essayage"}, Forbidden: []string{"salle de changement"}},
+}
+
+func TestGlossaryCheck(t *testing.T) {
+	tests := []struct {
+		source, translation string
+		expected            []string
+	}{
+		{"Glider unlocked!", "Planeur débloqué!", []string{}},
+		{"Glider unlocked!", "Deltaplane débloqué!", []string{`"glider" must be translated as "planeur"`}},
+		{"Welcome to Cubzh!", "Bienvenue dans Cubzh!", []string{}},
+		{"Welcome to Cubzh!", "Bienvenue!", []string{`"Cubzh" must not be translated`}},
+		{"Use the changing room or a glider in Cubzh", "Utilise la salle de changement, ou un planeur dans Cubzh", []string{
+			`"changing room" must be translated as "cabine d'essayage"`,
+			`"changing room" translated as "salle de changement", not approved`,
+		}},
+	}
+	for _, test := range tests {
+		if v := frenchGlossary.check(test.source, test.translation); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("%q: got %q", test.translation, v)
+		}
+	}
+
+	m := newMessages()
+	m.set(unit{key: "Glider unlocked!"}, "Deltaplane débloqué!")
+	issues := validate(Catalog{"Glider unlocked!": {}}, m, "fr", frenchGlossary, 1)
+	if len(issues) != 1 || !issues[0].error || !strings.HasPrefix(issues[0].message, "glossary: ") {
+		t.Errorf("issues %v", issues)
+	}
+}
+
+func TestGlossaryPrompt(t *testing.T) {
+	units := []unit{{key: "Glider unlocked!"}, {key: "Cubzh's Discord"}, {key: "day"}}
+	expected := `
+
+Use this glossary of game terms:
+- "Cubzh": don't translate it
+- "glider": "planeur"`
+	if p := frenchGlossary.prompt(units); p != expected {
+		t.Errorf("got %q", p)
+	}
+	if p := frenchGlossary.prompt([]unit{{key: "day"}}); p != "" {
+		t.Errorf("got %q", p)
+	}
+
+}
+
+func TestReadGlossaries(t *testing.T) {
+	glossaries, err := readGlossaries("../glossary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lang := range languages {
+		if _, ok := glossaries[lang.Code]; !ok {
+			t.Errorf("%s: no glossary", lang.Code)
+		}
+	}
+
+	example, err := readGlossary("../glossary/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(example) == 0 || !example["Cubzh"].Keep {
+		t.Errorf("example glossary %+v", example)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// message is an ICU MessageFormat message, supporting simple arguments,
+// plural and select arguments: "{count, plural, one {# day} other {# days}}",
+// "{gender, select, female {elle} other {il}}".
+type message []messageNode
+
+// messageNode is literal text, a "#" in a plural option, or an argument
+// when arg isn't empty.
+type messageNode struct {
+	text  string
+	pound bool
+	arg   string
+	// kind is "plural", "select", or empty for simple arguments
+	kind    string
+	offset  int64
+	options []messageOption
+}
+
+// messageOption is an option of plural and select arguments, its selector
+// being a plural category, "=N" for plurals, or a value for selects.
+type messageOption struct {
+	selector string
+	message  message
+}
+
+// isMessage returns true for strings in ICU MessageFormat, other strings
+// are used as they are.
+func isMessage(s string) bool {
+	return strings.ContainsAny(s, "{}")
+}
+
+// parseMessage parses an ICU MessageFormat message. Apostrophes quote
+// special characters ("'{'"), two apostrophes are an apostrophe, other
+// apostrophes are literal.
+func parseMessage(s string) (message, error) {
+	p := &messageParser{s: s}
+	m, err := p.message(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected }")
+	}
+	return m, nil
+}
+
+type messageParser struct {
+	s   string
+	pos int
+}
+
+func (p *messageParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, a...))
+}
+
+// message parses a message, until the end or a closing brace.
+func (p *messageParser) message(inPlural bool) (message, error) {
+	m := make(message, 0)
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			m = append(m, messageNode{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '}':
+			flush()
+			return m, nil
+		case c == '{':
+			flush()
+			p.pos++
+			node, err := p.argument()
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, node)
+		case c == '#' && inPlural:
+			flush()
+			p.pos++
+			m = append(m, messageNode{pound: true})
+		case c == '\'':
+			p.pos++
+			p.quoted(&text, inPlural)
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	return m, nil
+}
+
+// quoted reads what follows an apostrophe.
+func (p *messageParser) quoted(text *strings.Builder, inPlural bool) {
+	if p.pos >= len(p.s) {
+		text.WriteByte('\'')
+		return
+	}
+	c := p.s[p.pos]
+	if c == '\'' {
+		text.WriteByte('\'')
+		p.pos++
+		return
+	}
+	if c != '{' && c != '}' && !(c == '#' && inPlural) {
+		text.WriteByte('\'')
+		return
+	}
+	// quoted until the next single apostrophe, or the end
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c != '\'' {
+			text.WriteByte(c)
+			continue
+		}
+		if p.pos < len(p.s) && p.s[p.pos] == '\'' {
+			text.WriteByte('\'')
+			p.pos++
+			continue
+		}
+		return
+	}
+}
+
+func (p *messageParser) skipSpaces() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// word reads a name, a number, or a selector.
+func (p *messageParser) word() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n{},'#", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *messageParser) expect(c byte) error {
+	p.skipSpaces()
+	if p.pos >= len(p.s) || p.s[p.pos] != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// argument parses an argument, after its opening brace.
+func (p *messageParser) argument() (messageNode, error) {
+	node := messageNode{arg: p.word()}
+	if node.arg == "" {
+		return node, p.errorf("expected an argument name")
+	}
+	p.skipSpaces()
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		return node, nil
+	}
+	if err := p.expect(','); err != nil {
+		return node, err
+	}
+
+	node.kind = p.word()
+	if node.kind != "plural" && node.kind != "select" {
+		return node, p.errorf("unsupported argument type %q", node.kind)
+	}
+	if err := p.expect(','); err != nil {
+		return node, err
+	}
+
+	seen := make(map[string]bool)
+	for {
+		selector := p.word()
+		if selector == "" {
+			break
+		}
+		if node.kind == "plural" {
+			if offset, ok := strings.CutPrefix(selector, "offset:"); ok && len(node.options) == 0 && node.offset == 0 {
+				n, err := strconv.ParseInt(offset, 10, 64)
+				if err != nil {
+					return node, p.errorf("invalid offset %q", offset)
+				}
+				node.offset = n
+				continue
+			}
+			if exact, ok := strings.CutPrefix(selector, "="); ok {
+				if _, err := strconv.ParseInt(exact, 10, 64); err != nil {
+					return node, p.errorf("invalid selector %q", selector)
+				}
+			} else if !isPluralCategory(selector) {
+				return node, p.errorf("invalid plural category %q", selector)
+			}
+		}
+		if seen[selector] {
+			return node, p.errorf("duplicate selector %q", selector)
+		}
+		seen[selector] = true
+
+		if err := p.expect('{'); err != nil {
+			return node, err
+		}
+		m, err := p.message(node.kind == "plural")
+		if err != nil {
+			return node, err
+		}
+		if err := p.expect('}'); err != nil {
+			return node, err
+		}
+		node.options = append(node.options, messageOption{selector: selector, message: m})
+	}
+
+	if !seen["other"] {
+		return node, p.errorf("%s argument %q without other option", node.kind, node.arg)
+	}
+	if err := p.expect('}'); err != nil {
+		return node, err
+	}
+	return node, nil
+}
+
+// option returns the message of an option, the other option if there's
+// no option for the selector.
+func (node *messageNode) option(selector string) message {
+	var other message
+	for _, o := range node.options {
+		if o.selector == selector {
+			return o.message
+		}
+		if o.selector == "other" {
+			other = o.message
+		}
+	}
+	return other
+}
+
+// format formats a message in a language, with arguments by name: numbers
+// for plurals (integers), strings for selects. "#" is the number minus the
+// offset, without grouping separators.
+func (m message) format(lang string, args map[string]interface{}) (string, error) {
+	var b strings.Builder
+	if err := m.formatTo(&b, lang, args, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (m message) formatTo(b *strings.Builder, lang string, args map[string]interface{}, pound *int64) error {
+	for _, node := range m {
+		switch {
+		case node.pound:
+			b.WriteString(strconv.FormatInt(*pound, 10))
+		case node.arg == "":
+			b.WriteString(node.text)
+		case node.kind == "":
+			v, ok := args[node.arg]
+			if !ok {
+				return fmt.Errorf("missing argument %q", node.arg)
+			}
+			b.WriteString(formatValue(v))
+		case node.kind == "plural":
+			n, err := integerArgument(args, node.arg)
+			if err != nil {
+				return err
+			}
+			// exact values are matched before the offset is subtracted
+			selector := "=" + strconv.FormatInt(n, 10)
+			if !node.hasOption(selector) {
+				selector = pluralCategory(lang, n-node.offset)
+			}
+			option := node.option(selector)
+			value := n - node.offset
+			if err := option.formatTo(b, lang, args, &value); err != nil {
+				return err
+			}
+		case node.kind == "select":
+			v, ok := args[node.arg]
+			if !ok {
+				return fmt.Errorf("missing argument %q", node.arg)
+			}
+			if err := node.option(formatValue(v)).formatTo(b, lang, args, pound); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (node *messageNode) hasOption(selector string) bool {
+	for _, o := range node.options {
+		if o.selector == selector {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// integerArgument returns an integer argument: an int, or a float64
+// without fraction (numbers decoded from JSON).
+func integerArgument(args map[string]interface{}, name string) (int64, error) {
+	switch v := args[name].(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case nil:
+		return 0, fmt.Errorf("missing argument %q", name)
+	}
+	return 0, fmt.Errorf("argument %q isn't an integer: %v", name, args[name])
+}
+
+// arguments returns the types of arguments of a message, by name.
+func (m message) arguments() map[string]string {
+	args := make(map[string]string)
+	m.walk(func(node *messageNode) {
+		if node.arg != "" {
+			args[node.arg] = node.kind
+		}
+	})
+	return args
+}
+
+// walk calls f for nodes of the message, and of its options.
+func (m message) walk(f func(node *messageNode)) {
+	for i := range m {
+		f(&m[i])
+		for _, o := range m[i].options {
+			o.message.walk(f)
+		}
+	}
+}
+
+// String encodes the message, quoting special characters of texts.
+func (m message) String() string {
+	var b strings.Builder
+	m.writeTo(&b, false)
+	return b.String()
+}
+
+func (m message) writeTo(b *strings.Builder, inPlural bool) {
+	for _, node := range m {
+		switch {
+		case node.pound:
+			b.WriteString("#")
+		case node.arg == "":
+			b.WriteString(quoteMessageText(node.text, inPlural))
+		case node.kind == "":
+			b.WriteString("{" + node.arg + "}")
+		default:
+			b.WriteString("{" + node.arg + ", " + node.kind + ",")
+			if node.offset != 0 {
+				b.WriteString(" offset:" + strconv.FormatInt(node.offset, 10))
+			}
+			for _, o := range node.options {
+				b.WriteString(" " + o.selector + " {")
+				o.message.writeTo(b, node.kind == "plural")
+				b.WriteString("}")
+			}
+			b.WriteString("}")
+		}
+	}
+}
+
+func quoteMessageText(s string, inPlural bool) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	special := "{}"
+	if inPlural {
+		special += "#"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteString("'" + string(r) + "'")
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// mapText returns the message with texts replaced by f(text).
+func (m message) mapText(f func(string) string) message {
+	mapped := make(message, len(m))
+	for i, node := range m {
+		if node.arg == "" && !node.pound {
+			node.text = f(node.text)
+		}
+		options := make([]messageOption, len(node.options))
+		for j, o := range node.options {
+			options[j] = messageOption{selector: o.selector, message: o.message.mapText(f)}
+		}
+		node.options = options
+		mapped[i] = node
+	}
+	return mapped
+}
+
+// checkPlurals returns errors for plural arguments without the categories
+// of a language, and warnings for categories the language doesn't use.
+func (m message) checkPlurals(lang string) (errs []string, warnings []string) {
+	rule := pluralRuleOf(lang)
+	m.walk(func(node *messageNode) {
+		if node.kind != "plural" {
+			return
+		}
+		missing := make([]string, 0)
+		for _, category := range rule.categories {
+			if !node.hasOption(category) {
+				missing = append(missing, category)
+			}
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Sprintf("plural argument %q without categories %q", node.arg, missing))
+		}
+		unused := make([]string, 0)
+		for _, o := range node.options {
+			if isPluralCategory(o.selector) && !contains(rule.categories, o.selector) {
+				unused = append(unused, o.selector)
+			}
+		}
+		if len(unused) > 0 {
+			sort.Strings(unused)
+			warnings = append(warnings, fmt.Sprintf("plural argument %q with categories %q, not used in %s", node.arg, unused, lang))
+		}
+	})
+	return errs, warnings
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		lang     string
+		n        int64
+		expected string
+	}{
+		{"en", 0, "other"}, {"en", 1, "one"}, {"en", 2, "other"},
+		{"fr", 0, "one"}, {"fr", 1, "one"}, {"fr", 2, "other"}, {"fr", 1000000, "many"}, {"fr", 1000001, "other"},
+		{"es", 0, "other"}, {"es", 1, "one"}, {"es", 2000000, "many"},
+		{"pt", 0, "one"}, {"it", 0, "other"},
+		{"pl", 0, "many"}, {"pl", 1, "one"}, {"pl", 2, "few"}, {"pl", 5, "many"}, {"pl", 12, "many"}, {"pl", 21, "many"}, {"pl", 22, "few"},
+		{"ru", 1, "one"}, {"ru", 11, "many"}, {"ru", 21, "one"}, {"ru", 22, "few"}, {"ru", 112, "many"}, {"ru", 101, "one"},
+		{"ua", 3, "few"}, {"ua", -1, "one"},
+		{"unknown", 1, "one"},
+	}
+	for _, test := range tests {
+		if c := pluralCategory(test.lang, test.n); c != test.expected {
+			t.Errorf("%s %d: got %s, want %s", test.lang, test.n, c, test.expected)
+		}
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	tests := []struct {
+		lang     string
+		message  string
+		args     map[string]interface{}
+		expected string
+	}{
+		{"en", "{count, plural, one {# day} other {# days}}", map[string]interface{}{"count": 1}, "1 day"},
+		{"pl", "{count, plural, one {# dzień} few {# dni} many {# dni} other {# dnia}}", map[string]interface{}{"count": 22}, "22 dni"},
+		{"ru", "{count, plural, one {# день} few {# дня} many {# дней} other {# дня}}", map[string]interface{}{"count": 11}, "11 дней"},
+		{"en", "{n, plural, =0 {none} =1 {just {name}} other {#}}", map[string]interface{}{"n": 1, "name": "Bob"}, "just Bob"},
+		{"en", "{n, plural, offset:1 =0 {nobody} =1 {{name}} one {{name} and # other} other {{name} and # others}}",
+			map[string]interface{}{"n": 3, "name": "Bob"}, "Bob and 2 others"},
+		{"fr", "{gender, select, female {Elle a} other {Il a}} {n, plural, one {# œuf} other {# œufs}}",
+			map[string]interface{}{"gender": "female", "n": 0.0}, "Elle a 0 œuf"},
+		{"fr", "l''avatar de '{'{name}'}', besoin d'aide '#'", map[string]interface{}{"name": "Bob"}, "l'avatar de {Bob}, besoin d'aide '#'"},
+		{"en", "{n, plural, other {'#' #}}", map[string]interface{}{"n": 5}, "# 5"},
+	}
+	for _, test := range tests {
+		m, err := parseMessage(test.message)
+		if err != nil {
+			t.Errorf("%s: %v", test.message, err)
+			continue
+		}
+		s, err := m.format(test.lang, test.args)
+		if err != nil || s != test.expected {
+			t.Errorf("%s: got %q, %v, want %q", test.message, s, err, test.expected)
+		}
+
+		// encoded messages are the same messages
+		again, err := parseMessage(m.String())
+		if err != nil {
+			t.Errorf("%s: %v", m, err)
+		} else if again.String() != m.String() {
+			t.Errorf("%s: encoded again as %s", m, again)
+		}
+	}
+
+	m, _ := parseMessage("{n, plural, one {# day} other {# days}}")
+	for _, args := range []map[string]interface{}{{}, {"n": "two"}, {"n": 1.5}} {
+		if _, err := m.format("en", args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	for _, s := range []string{
+		"{",
+		"}",
+		"{}",
+		"{n, number}",
+		"{n, plural, one {day}}",
+		"{n, plural, one {day} other {days} other {days}}",
+		"{n, plural, some {day} other {days}}",
+		"{n, plural, =x {day} other {days}}",
+		"{n, plural, one day other {days}}",
+		"{n, select, other {x}",
+		"{n, plural, one {day} offset:1 other {days}}",
+	} {
+		if _, err := parseMessage(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	key := "{name} has {count, plural, one {# egg} other {# eggs}}"
+	tests := []struct {
+		lang        string
+		translation string
+		expected    []string
+	}{
+		{"pl", "{name} ma {count, plural, one {# jajko} few {# jajka} many {# jajek} other {# jajka}}", nil},
+		{"pl", "{name} ma {count, plural, one {# jajko} other {# jajka}}",
+			[]string{`ERROR: "` + key + `": plural argument "count" without categories ["few" "many"]`}},
+		{"fr", "{count, plural, one {# œuf} few {# œufs} many {# d'œufs} other {# œufs}} {nom}", []string{
+			`ERROR: "` + key + `": argument "nom" not in English`,
+			`WARNING: "` + key + `": argument "name" not used`,
+			`WARNING: "` + key + `": plural argument "count" with categories ["few"], not used in fr`,
+		}},
+		{"fr", "{name} a {count} œufs", []string{`ERROR: "` + key + `": argument "count" is simple, expected plural`}},
+		{"fr", "{name} a {count, plural, one {# œuf}", []string{`ERROR: "` + key + `": invalid ICU message: offset 37: plural argument "count" without other option`}},
+	}
+	for _, test := range tests {
+		issues := make([]string, 0)
+		for _, i := range validateMessage(unit{key: key}, test.translation, test.lang) {
+			issues = append(issues, i.String())
+		}
+		sort.Strings(issues)
+		sort.Strings(test.expected)
+		if !equalStrings(issues, test.expected) {
+			t.Errorf("%s: got %q, want %q", test.translation, issues, test.expected)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// vectors are formatted messages, to check other implementations
+// (localize.lua) against.
+type vectors struct {
+	Plurals  []pluralVector  `json:"plurals"`
+	Messages []messageVector `json:"messages"`
+}
+
+type pluralVector struct {
+	Lang     string `json:"lang"`
+	N        int64  `json:"n"`
+	Category string `json:"category"`
+}
+
+type messageVector struct {
+	Lang     string                 `json:"lang"`
+	Message  string                 `json:"message"`
+	Args     map[string]interface{} `json:"args"`
+	Expected string                 `json:"expected"`
+}
+
+var vectorNumbers = []int64{0, 1, 2, 3, 4, 5, 10, 11, 12, 14, 21, 22, 25, 101, 111, 112, 1000000}
+
+var vectorMessages = map[string]string{
+	"en": "{count, plural, one {# day} other {# days}}",
+	"fr": "{count, plural, one {# jour} many {# de jours} other {# jours}}",
+	"es": "{count, plural, one {# día} many {# de días} other {# días}}",
+	"it": "{count, plural, one {# giorno} many {# di giorni} other {# giorni}}",
+	"pt": "{count, plural, one {# dia} many {# de dias} other {# dias}}",
+	"pl": "{count, plural, one {# dzień} few {# dni} many {# dni} other {# dnia}}",
+	"ru": "{count, plural, one {# день} few {# дня} many {# дней} other {# дня}}",
+	"ua": "{count, plural, one {# день} few {# дні} many {# днів} other {# дня}}",
+}
+
+// TestVectors checks testdata/vectors.json, written with -update.
+func TestVectors(t *testing.T) {
+	v := vectors{Plurals: make([]pluralVector, 0), Messages: make([]messageVector, 0)}
+	add := func(lang, message string, args map[string]interface{}) {
+		m, err := parseMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := m.format(lang, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Messages = append(v.Messages, messageVector{Lang: lang, Message: message, Args: args, Expected: s})
+	}
+
+	langs := make([]string, 0)
+	for lang := range vectorMessages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		for _, n := range vectorNumbers {
+			v.Plurals = append(v.Plurals, pluralVector{Lang: lang, N: n, Category: pluralCategory(lang, n)})
+			add(lang, vectorMessages[lang], map[string]interface{}{"count": n})
+		}
+	}
+
+	collected := "{gender, select, female {She has} male {He has} other {They have}} {collected, plural, =0 {no parts} one {# part} other {# parts}} of {total}"
+	for _, gender := range []string{"female", "male", "unknown"} {
+		for _, n := range []int64{0, 1, 2} {
+			add("en", collected, map[string]interface{}{"gender": gender, "collected": n, "total": 5})
+		}
+	}
+	others := "{count, plural, offset:1 =0 {personne} =1 {{name}} one {{name} et # autre} other {{name} et # autres}}"
+	for _, n := range []int64{0, 1, 2, 3} {
+		add("fr", others, map[string]interface{}{"count": n, "name": "Léa"})
+	}
+	add("fr", "l''avatar de '{'{name}'}', besoin d'aide", map[string]interface{}{"name": "Léa"})
+
+	data, err := marshalJSON(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, filepath.Join("testdata", "vectors.json"), data)
+}
+
+func golden(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skipf("no golden file %s, run tests with -update to create it", path)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("differs from %s, run tests with -update if changes are expected", path)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Messages is a language file (fr.json...): translations by key,
+// keeping the order of keys of the file.
+type Messages struct {
+	Keys   []string
+	Values map[string]*Translation
+}
+
+// Translation is the translation of a key: Text, or translations by context
+// when Contexts isn't nil.
+type Translation struct {
+	Text string
+	// ContextNames are the keys of Contexts, in file order
+	ContextNames []string
+	Contexts     map[string]string
+}
+
+func newMessages() *Messages {
+	return &Messages{Values: make(map[string]*Translation)}
+}
+
+// unit is a string to translate: a key, with a context or not.
+type unit struct {
+	key, context string
+}
+
+func (u unit) String() string {
+	if u.context == "" {
+		return fmt.Sprintf("%q", u.key)
+	}
+	return fmt.Sprintf("%q (%s)", u.key, u.context)
+}
+
+// units returns the strings to translate of the catalog: keys used with
+// contexts are translated by context, other keys without context.
+func (c Catalog) units() []unit {
+	units := make([]unit, 0)
+	for _, key := range c.Keys() {
+		e := c[key]
+		if len(e.Contexts) == 0 {
+			units = append(units, unit{key: key})
+			continue
+		}
+		for _, context := range e.ContextNames() {
+			units = append(units, unit{key: key, context: context})
+		}
+	}
+	return units
+}
+
+// get returns the translation of a unit. Like localize.lua, a key
+// translated without context is used for all contexts.
+func (m *Messages) get(u unit) (string, bool) {
+	msg, ok := m.Values[u.key]
+	if !ok {
+		return "", false
+	}
+	if msg.Contexts == nil {
+		return msg.Text, true
+	}
+	if u.context == "" {
+		return "", false
+	}
+	text, ok := msg.Contexts[u.context]
+	return text, ok
+}
+
+// set sets the translation of a unit, new keys and contexts being added
+// after existing ones.
+func (m *Messages) set(u unit, text string) {
+	msg, ok := m.Values[u.key]
+	if !ok {
+		msg = &Translation{}
+		m.Values[u.key] = msg
+		m.Keys = append(m.Keys, u.key)
+	}
+
+	if u.context == "" {
+		*msg = Translation{Text: text}
+		return
+	}
+	if msg.Contexts == nil {
+		*msg = Translation{Contexts: make(map[string]string)}
+	}
+	if _, ok := msg.Contexts[u.context]; !ok {
+		msg.ContextNames = append(msg.ContextNames, u.context)
+	}
+	msg.Contexts[u.context] = text
+}
+
+// remove removes the translation of a unit, keys without contexts left
+// being removed.
+func (m *Messages) remove(u unit) {
+	msg, ok := m.Values[u.key]
+	if !ok {
+		return
+	}
+	if u.context != "" && msg.Contexts != nil {
+		if _, ok := msg.Contexts[u.context]; !ok {
+			return
+		}
+		delete(msg.Contexts, u.context)
+		for i, context := range msg.ContextNames {
+			if context == u.context {
+				msg.ContextNames = append(msg.ContextNames[:i], msg.ContextNames[i+1:]...)
+				break
+			}
+		}
+		if len(msg.ContextNames) > 0 {
+			return
+		}
+	}
+	delete(m.Values, u.key)
+	for i, key := range m.Keys {
+		if key == u.key {
+			m.Keys = append(m.Keys[:i], m.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// parseMessages decodes a language file, keeping the order of keys.
+func parseMessages(data []byte) (*Messages, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	m := newMessages()
+
+	err := readObject(d, func(key string) error {
+		msg := &Translation{}
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case string:
+			msg.Text = t
+		case json.Delim:
+			if t != '{' {
+				return fmt.Errorf("%q: unexpected %v", key, t)
+			}
+			msg.Contexts = make(map[string]string)
+			err := readMembers(d, func(context string) error {
+				var text string
+				if err := d.Decode(&text); err != nil {
+					return fmt.Errorf("%q (%s): %w", key, context, err)
+				}
+				if _, ok := msg.Contexts[context]; !ok {
+					msg.ContextNames = append(msg.ContextNames, context)
+				}
+				msg.Contexts[context] = text
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%q: unexpected %v", key, t)
+		}
+
+		if _, ok := m.Values[key]; !ok {
+			m.Keys = append(m.Keys, key)
+		}
+		m.Values[key] = msg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readObject reads a JSON object, calling member for each of its keys,
+// to read the value that follows.
+func readObject(d *json.Decoder, member func(key string) error) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected an object, got %v", t)
+	}
+	return readMembers(d, member)
+}
+
+// readMembers reads members of an object, after its opening brace.
+func readMembers(d *json.Decoder, member func(key string) error) error {
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if err := member(t.(string)); err != nil {
+			return err
+		}
+	}
+	_, err := d.Token()
+	return err
+}
+
+func readMessages(path string) (*Messages, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMessages(data)
+}
+
+// Marshal encodes messages like language files are written: keys in
+// order, indented with 4 spaces, without new line at the end.
+func (m *Messages) Marshal() []byte {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, key := range m.Keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n    " + jsonString(key) + ": ")
+
+		msg := m.Values[key]
+		if msg.Contexts == nil {
+			b.WriteString(jsonString(msg.Text))
+			continue
+		}
+		if len(msg.ContextNames) == 0 {
+			b.WriteString("{}")
+			continue
+		}
+		b.WriteString("{")
+		for j, context := range msg.ContextNames {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n        " + jsonString(context) + ": " + jsonString(msg.Contexts[context]))
+		}
+		b.WriteString("\n    }")
+	}
+	if len(m.Keys) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("}")
+	return []byte(b.String())
+}
+
+func jsonString(s string) string {
+	data, _ := marshalJSON(s)
+	return strings.TrimSuffix(string(data), "\n")
+}
@@ -0,0 +1,109 @@
+package main
+
+// pluralRule gives the CLDR plural category of integers in a language.
+type pluralRule struct {
+	// categories are the categories integers can have, and "other",
+	// that ICU messages always have
+	categories []string
+	category   func(n int64) string
+}
+
+// CLDR cardinal plural rules, for integers (the v = 0 operand):
+// https://www.unicode.org/cldr/charts/latest/supplemental/language_plural_rules.html
+var (
+	englishPlurals = pluralRule{
+		categories: []string{"one", "other"},
+		category: func(n int64) string {
+			if n == 1 {
+				return "one"
+			}
+			return "other"
+		},
+	}
+	// millions are "many" in Romance languages ("1 million de jours")
+	frenchPlurals     = romancePlurals(func(n int64) bool { return n == 0 || n == 1 })
+	portuguesePlurals = frenchPlurals
+	spanishPlurals    = romancePlurals(func(n int64) bool { return n == 1 })
+	italianPlurals    = spanishPlurals
+	polishPlurals     = pluralRule{
+		categories: []string{"one", "few", "many", "other"},
+		category: func(n int64) string {
+			switch {
+			case n == 1:
+				return "one"
+			case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+				return "few"
+			}
+			return "many"
+		},
+	}
+	// Russian and Ukrainian
+	eastSlavicPlurals = pluralRule{
+		categories: []string{"one", "few", "many", "other"},
+		category: func(n int64) string {
+			switch {
+			case n%10 == 1 && n%100 != 11:
+				return "one"
+			case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+				return "few"
+			}
+			return "many"
+		},
+	}
+)
+
+func romancePlurals(one func(n int64) bool) pluralRule {
+	return pluralRule{
+		categories: []string{"one", "many", "other"},
+		category: func(n int64) string {
+			switch {
+			case one(n):
+				return "one"
+			case n != 0 && n%1000000 == 0:
+				return "many"
+			}
+			return "other"
+		},
+	}
+}
+
+// pluralRules are plural rules by language code, "xx" being the
+// pseudo-locale, in English.
+var pluralRules = map[string]pluralRule{
+	"en": englishPlurals,
+	"xx": englishPlurals,
+	"fr": frenchPlurals,
+	"es": spanishPlurals,
+	"it": italianPlurals,
+	"pt": portuguesePlurals,
+	"pl": polishPlurals,
+	"ru": eastSlavicPlurals,
+	"ua": eastSlavicPlurals,
+	"uk": eastSlavicPlurals,
+}
+
+// pluralRuleOf returns the plural rule of a language, English's for
+// unknown languages.
+func pluralRuleOf(lang string) pluralRule {
+	if r, ok := pluralRules[lang]; ok {
+		return r
+	}
+	return englishPlurals
+}
+
+// pluralCategory returns the plural category of n in a language.
+func pluralCategory(lang string, n int64) string {
+	if n < 0 {
+		n = -n
+	}
+	return pluralRuleOf(lang).category(n)
+}
+
+// isPluralCategory returns true for CLDR plural categories.
+func isPluralCategory(s string) bool {
+	switch s {
+	case "zero", "one", "two", "few", "many", "other":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// encodePO encodes items as a gettext PO file: contexts are msgctxt,
+// references are "#:" comments, untranslated strings have empty msgstr.
+func encodePO(lang Language, items []item) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s translation of Cubzh\n", lang.Name)
+	b.WriteString("msgid \"\"\nmsgstr \"\"\n")
+	fmt.Fprintf(&b, "\"Language: %s\\n\"\n", lang.Code)
+	b.WriteString("\"MIME-Version: 1.0\\n\"\n")
+	b.WriteString("\"Content-Type: text/plain; charset=UTF-8\\n\"\n")
+	b.WriteString("\"Content-Transfer-Encoding: 8bit\\n\"\n")
+
+	for _, it := range items {
+		b.WriteString("\n")
+		for _, ref := range it.refs {
+			fmt.Fprintf(&b, "#: %s\n", ref)
+		}
+		if len(placeholders(it.unit.key)) > 0 {
+			b.WriteString("#, c-format\n")
+		}
+		if it.unit.context != "" {
+			writePOString(&b, "msgctxt", it.unit.context)
+		}
+		writePOString(&b, "msgid", it.unit.key)
+		writePOString(&b, "msgstr", it.text)
+	}
+	return b.Bytes()
+}
+
+// writePOString writes a keyword and its string, on several lines for
+// strings with new lines.
+func writePOString(b *bytes.Buffer, keyword, s string) {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s %s\n", keyword, quotePO(s))
+		return
+	}
+	fmt.Fprintf(b, "%s \"\"\n", keyword)
+	for _, line := range lines {
+		b.WriteString(quotePO(line) + "\n")
+	}
+}
+
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+func quotePO(s string) string {
+	return `"` + poEscaper.Replace(s) + `"`
+}
+
+func unquotePO(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	s = s[1 : len(s)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return "", fmt.Errorf("unescaped quote")
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("invalid escape sequence")
+		}
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\', '"':
+			b.WriteByte(s[i])
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// poEntry is an entry of a PO file being read.
+type poEntry struct {
+	refs                    []string
+	fuzzy                   bool
+	context, id, str        string
+	hasContext, hasID, done bool
+}
+
+// decodePO decodes a PO file. Fuzzy translations, to be reviewed, are
+// considered untranslated, like obsolete entries ("#~") they're skipped.
+func decodePO(data []byte) ([]item, string, error) {
+	items := make([]item, 0)
+	lang := ""
+
+	var e poEntry
+	// field is the string continuation lines are added to
+	var field *string
+	end := func() {
+		if !e.hasID {
+			e = poEntry{}
+			field = nil
+			return
+		}
+		if e.id == "" && !e.hasContext {
+			// header
+			for _, line := range strings.Split(e.str, "\n") {
+				if code, ok := strings.CutPrefix(line, "Language:"); ok {
+					lang = strings.TrimSpace(code)
+				}
+			}
+		} else {
+			items = append(items, item{
+				unit:       unit{key: e.id, context: e.context},
+				refs:       e.refs,
+				text:       e.str,
+				translated: e.str != "" && !e.fuzzy,
+			})
+		}
+		e = poEntry{}
+		field = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		keyword, value, _ := strings.Cut(line, " ")
+
+		// a new entry starts with comments, a context or an id
+		if e.done && (strings.HasPrefix(line, "#") || keyword == "msgctxt" || keyword == "msgid") {
+			end()
+		}
+
+		var err error
+		switch {
+		case line == "":
+			end()
+		case strings.HasPrefix(line, "#:"):
+			e.refs = append(e.refs, strings.Fields(line[2:])...)
+		case strings.HasPrefix(line, "#,"):
+			for _, flag := range strings.Split(line[2:], ",") {
+				if strings.TrimSpace(flag) == "fuzzy" {
+					e.fuzzy = true
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, `"`):
+			if field == nil {
+				return nil, "", fmt.Errorf("line %d: unexpected string", n)
+			}
+			var s string
+			s, err = unquotePO(line)
+			*field += s
+		case keyword == "msgctxt":
+			e.hasContext = true
+			field = &e.context
+			e.context, err = unquotePO(value)
+		case keyword == "msgid":
+			e.hasID = true
+			field = &e.id
+			e.id, err = unquotePO(value)
+		case keyword == "msgstr":
+			if !e.hasID {
+				return nil, "", fmt.Errorf("line %d: msgstr without msgid", n)
+			}
+			e.done = true
+			field = &e.str
+			e.str, err = unquotePO(value)
+		case keyword == "msgid_plural" || strings.HasPrefix(keyword, "msgstr["):
+			return nil, "", fmt.Errorf("line %d: plural forms aren't supported", n)
+		default:
+			return nil, "", fmt.Errorf("line %d: unexpected %q", n, keyword)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	end()
+	return items, lang, nil
+}
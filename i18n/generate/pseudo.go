@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// pseudoTranslator translates to pseudo-localized English, to test layouts
+// before translations: accented letters, longer strings, between brackets.
+// Placeholders (%s, %d...) are kept as they are.
+type pseudoTranslator struct {
+	// expansion is the length added to strings, 0.4 for 40%
+	expansion float64
+	// brackets are the opening and closing characters around strings,
+	// none if empty
+	brackets string
+}
+
+func (p pseudoTranslator) Translate(lang Language, units []unit) (*Messages, error) {
+	m := newMessages()
+	for _, u := range units {
+		m.set(u, p.localize(u.key))
+	}
+	return m, nil
+}
+
+var accents = map[rune]rune{
+	'a': 'á', 'c': 'ç', 'e': 'é', 'i': 'í', 'n': 'ñ', 'o': 'ó', 'u': 'ú', 'y': 'ý',
+	'A': 'Á', 'C': 'Ç', 'E': 'É', 'I': 'Í', 'N': 'Ñ', 'O': 'Ó', 'U': 'Ú', 'Y': 'Ý',
+}
+
+// localize accents letters of s, pads it with tildes and puts it between
+// brackets, keeping placeholders and the syntax of ICU messages as they are.
+func (p pseudoTranslator) localize(s string) string {
+	var b strings.Builder
+	open, close := "", ""
+	if p.brackets != "" {
+		r, size := utf8.DecodeRuneInString(p.brackets)
+		open, close = string(r), p.brackets[size:]
+	}
+	b.WriteString(open)
+
+	// only texts of ICU messages are localized
+	if m, err := parseMessage(s); err == nil && isMessage(s) {
+		b.WriteString(m.mapText(accent).String())
+	} else {
+		b.WriteString(accent(s))
+	}
+
+	padding := int(math.Ceil(float64(utf8.RuneCountInString(s)) * p.expansion))
+	b.WriteString(strings.Repeat("~", padding))
+	b.WriteString(close)
+	return b.String()
+}
+
+// accent accents letters of s, keeping placeholders as they are.
+func accent(s string) string {
+	var b strings.Builder
+	last := 0
+	accentText := func(s string) {
+		for _, r := range s {
+			if accented, ok := accents[r]; ok {
+				r = accented
+			}
+			b.WriteRune(r)
+		}
+	}
+	for _, match := range placeholder.FindAllStringIndex(s, -1) {
+		accentText(s[last:match[0]])
+		b.WriteString(s[match[0]:match[1]])
+		last = match[1]
+	}
+	accentText(s[last:])
+	return b.String()
+}
+
+var pseudoCommand = &command{
+	name:  "pseudo",
+	usage: "[flags]",
+	description: "Writes a pseudo-locale (xx.json) from the source catalog, with longer " +
+		"accented strings, to test layouts before translations.",
+	run: runPseudo,
+}
+
+func runPseudo(flags *flag.FlagSet, args []string) error {
+
+	catalogPath := flags.String("catalog", "../en.json", "source catalog, written by extract")
+	output := flags.String("o", "../xx.json", "pseudo-locale file to write")
+	expansion := flags.Float64("expansion", 0.4, "length added to strings, 0.4 for 40%")
+	brackets := flags.String("brackets", "[]", "opening and closing characters around strings, none if empty")
+	flags.Parse(args)
+
+	if *brackets != "" && utf8.RuneCountInString(*brackets) != 2 {
+		return errors.New("brackets must be 2 characters")
+	}
+	if *expansion < 0 {
+		return errors.New("expansion can't be negative")
+	}
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+
+	p := pseudoTranslator{expansion: *expansion, brackets: *brackets}
+	m, err := p.Translate(Language{Name: "Pseudo", Code: "xx"}, catalog.units())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, m.Marshal(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d keys\n", *output, len(m.Keys))
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestPseudoLocalize(t *testing.T) {
+	tests := []struct {
+		p        pseudoTranslator
+		s        string
+		expected string
+	}{
+		{pseudoTranslator{brackets: "[]"}, "%d / %d collected", "[%d / %d çólléçtéd]"},
+		{pseudoTranslator{expansion: 0.4, brackets: "[]"}, "settings", "[séttíñgs~~~~]"},
+		{pseudoTranslator{expansion: 0.5, brackets: "«»"}, "%s joined! 🙂", "«%s jóíñéd! 🙂~~~~~~»"},
+		{pseudoTranslator{}, "100% of %5.2f", "100% óf %5.2f"},
+		{pseudoTranslator{brackets: "[]"}, "{n, plural, one {# egg} other {# eggs}} for {name}'s pet",
+			"[{n, plural, one {# égg} other {# éggs}} fór {name}''s pét]"},
+	}
+	for _, test := range tests {
+		if s := test.p.localize(test.s); s != test.expected {
+			t.Errorf("%q: got %q, want %q", test.s, s, test.expected)
+		}
+	}
+}
+
+func TestPseudoLocale(t *testing.T) {
+	catalog := Catalog{
+		"day":     {Refs: []string{"a.lua:1"}},
+		"sign up": {Contexts: map[string][]string{"button": {"a.lua:2"}, "title": {"a.lua:3"}}},
+	}
+	p := pseudoTranslator{expansion: 0.4, brackets: "[]"}
+	m, err := p.Translate(Language{Name: "Pseudo", Code: "xx"}, catalog.units())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+    "day": "[dáý~~]",
+    "sign up": {
+        "button": "[sígñ úp~~~]",
+        "title": "[sígñ úp~~~]"
+    }
+}`
+	if string(m.Marshal()) != expected {
+		t.Errorf("got:\n%s", m.Marshal())
+	}
+
+	// pseudo-locales are valid translations, except for their length
+	for _, i := range validate(catalog, m, "fr", nil, 0.5) {
+		if i.error {
+			t.Errorf("%s", i)
+		}
+	}
+}
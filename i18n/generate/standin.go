@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// languageInPrompt finds the language in system prompts.
+var languageInPrompt = regexp.MustCompile(`native (\S+) translator`)
+
+// standInHandler is a stand-in for OpenAI compatible chat completions APIs,
+// to test translations without network: strings are "translated" to
+// "[<language>] <key>". Requests need key as bearer token, if not empty.
+func standInHandler(key string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if key != "" && r.Header.Get("Authorization") != "Bearer "+key {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		var req ChatGptReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		language := "?"
+		var input *Messages
+		for _, message := range req.Messages {
+			switch message.Role {
+			case "system":
+				if match := languageInPrompt.FindStringSubmatch(message.Content); match != nil {
+					language = match[1]
+				}
+			case "user":
+				m, err := parseMessages([]byte(message.Content))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				input = m
+			}
+		}
+		if input == nil {
+			http.Error(w, "no user message", http.StatusBadRequest)
+			return
+		}
+
+		output := newMessages()
+		for _, key := range input.Keys {
+			t := input.Values[key]
+			if t.Contexts == nil {
+				output.set(unit{key: key}, fmt.Sprintf("[%s] %s", language, key))
+				continue
+			}
+			for _, context := range t.ContextNames {
+				output.set(unit{key: key, context: context}, fmt.Sprintf("[%s] %s", language, key))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ChatGptResp{
+			ID:    "stand-in",
+			Model: req.Model,
+			Choices: []Choice{{
+				Message:      Message{Role: "assistant", Content: string(output.Marshal())},
+				FinishReason: "stop",
+			}},
+		})
+	})
+}
+
+var standInCommand = &command{
+	name:  "standin",
+	usage: "[flags]",
+	description: "Serves a stand-in for OpenAI compatible APIs, giving deterministic " +
+		"translations, to run translate without network or secrets.",
+	run: runStandIn,
+}
+
+func runStandIn(flags *flag.FlagSet, args []string) error {
+
+	address := flags.String("http", "localhost:8080", "address to serve on")
+	key := flags.String("api-key", "", "API key requests must have, none if empty")
+	flags.Parse(args)
+
+	fmt.Printf("serving on http://%s, translate with -api-url http://%s\n", *address, *address)
+	return http.ListenAndServe(*address, standInHandler(*key))
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Lock records, by language and key, hashes of machine translations, to
+// find manually edited translations (lock.json).
+type Lock map[string]map[string]*LockEntry
+
+// LockEntry is the lock of a key, or of one of its contexts.
+type LockEntry struct {
+	// Source is the hash of the key and context translated, empty for keys
+	// only locked by context
+	Source string `json:"source,omitempty"`
+	// Translation is the hash of the machine translation, empty for
+	// translations found in language files before they were locked
+	Translation string                `json:"translation,omitempty"`
+	Contexts    map[string]*LockEntry `json:"contexts,omitempty"`
+}
+
+func hash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:8])
+}
+
+// sourceHash returns the hash of what's sent to be translated for a unit.
+func sourceHash(u unit) string {
+	return hash(u.key + "\x00" + u.context)
+}
+
+func (l Lock) get(lang string, u unit) *LockEntry {
+	e := l[lang][u.key]
+	if e == nil || u.context == "" {
+		return e
+	}
+	return e.Contexts[u.context]
+}
+
+func (l Lock) set(lang string, u unit, entry *LockEntry) {
+	if l[lang] == nil {
+		l[lang] = make(map[string]*LockEntry)
+	}
+	if u.context == "" {
+		l[lang][u.key] = entry
+		return
+	}
+	e := l[lang][u.key]
+	if e == nil {
+		e = &LockEntry{}
+		l[lang][u.key] = e
+	}
+	if e.Contexts == nil {
+		e.Contexts = make(map[string]*LockEntry)
+	}
+	e.Contexts[u.context] = entry
+}
+
+// remove removes the lock of a unit, keys without contexts left being
+// removed.
+func (l Lock) remove(lang string, u unit) {
+	e := l[lang][u.key]
+	if e == nil {
+		return
+	}
+	if u.context != "" {
+		delete(e.Contexts, u.context)
+		if len(e.Contexts) > 0 || e.Source != "" {
+			return
+		}
+	}
+	delete(l[lang], u.key)
+}
+
+func readLock(path string) (Lock, error) {
+	l := make(Lock)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// plan lists what has to be done for a language.
+//
+// Keys are English sources: when one changes, it's a new key, missing from
+// language files, and the previous one is dead. Missing units are paired
+// with dead units they're likely edits of, for translations edited since
+// they were machine translated to be carried over.
+type plan struct {
+	// missing are units without translation
+	missing []unit
+	// changed are missing units paired with the dead unit they replace
+	changed []change
+	// unlocked are translations that aren't in the lock yet
+	unlocked []unit
+}
+
+// change is a unit whose English source changed: it replaces previous,
+// a dead unit of the language file.
+type change struct {
+	unit, previous unit
+	// edited is true when the lock shows that the translation of previous
+	// was machine translated from its source, and edited since: it's
+	// carried over instead of translating unit again. Translations that
+	// were never locked are translated again.
+	edited bool
+}
+
+func (c change) String() string {
+	return fmt.Sprintf("%s (was %q)", c.unit, c.previous.key)
+}
+
+// translate returns units to translate: missing ones, except edited
+// translations carried over.
+func (p *plan) translate() []unit {
+	carried := make(map[unit]bool)
+	for _, c := range p.changed {
+		if c.edited {
+			carried[c.unit] = true
+		}
+	}
+	units := make([]unit, 0, len(p.missing))
+	for _, u := range p.missing {
+		if !carried[u] {
+			units = append(units, u)
+		}
+	}
+	return units
+}
+
+func planTranslation(catalog Catalog, m *Messages, lock Lock, lang string) *plan {
+	p := &plan{}
+	for _, u := range catalog.units() {
+		_, ok := m.get(u)
+		switch {
+		case !ok:
+			p.missing = append(p.missing, u)
+		case lock.get(lang, u) == nil:
+			p.unlocked = append(p.unlocked, u)
+		}
+	}
+	for _, c := range pairChanges(p.missing, deadTranslations(catalog, m)) {
+		text, _ := m.get(c.previous)
+		entry := lock.get(lang, c.previous)
+		c.edited = entry != nil && entry.Source == sourceHash(c.previous) &&
+			entry.Translation != "" && entry.Translation != hash(text)
+		p.changed = append(p.changed, c)
+	}
+	return p
+}
+
+// deadTranslations returns dead units of a language file (see deadUnits),
+// dead keys translated by context being split in their contexts.
+func deadTranslations(catalog Catalog, m *Messages) []unit {
+	units := make([]unit, 0)
+	for _, u := range deadUnits(catalog, m) {
+		t := m.Values[u.key]
+		if u.context != "" || t.Contexts == nil {
+			units = append(units, u)
+			continue
+		}
+		for _, context := range t.ContextNames {
+			units = append(units, unit{key: u.key, context: context})
+		}
+	}
+	return units
+}
+
+const (
+	// minChangeSimilarity is the similarity (see similarity) above which a
+	// dead key is considered to be a previous version of a missing one.
+	minChangeSimilarity = 0.5
+	// minChangeLength is the length, in runes, of the shortest keys that
+	// are paired: short keys are similar without being edits of each other
+	// ("day" and "days").
+	minChangeLength = 8
+)
+
+// pairChanges pairs missing units with dead units of the same context,
+// most similar keys first, each unit being paired at most once. Keys
+// shorter than minChangeLength aren't paired. Changes are in the order of
+// missing units.
+func pairChanges(missing, dead []unit) []change {
+	type candidate struct {
+		missing, dead int
+		similarity    float64
+	}
+	candidates := make([]candidate, 0)
+	for i, u := range missing {
+		for j, d := range dead {
+			if u.context != d.context || utf8.RuneCountInString(u.key) < minChangeLength ||
+				utf8.RuneCountInString(d.key) < minChangeLength {
+				continue
+			}
+			if s := similarity(u.key, d.key); s > minChangeSimilarity {
+				candidates = append(candidates, candidate{i, j, s})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].similarity > candidates[b].similarity
+	})
+
+	previous := make(map[int]int)
+	paired := make(map[int]bool)
+	for _, c := range candidates {
+		if _, ok := previous[c.missing]; ok || paired[c.dead] {
+			continue
+		}
+		previous[c.missing] = c.dead
+		paired[c.dead] = true
+	}
+
+	changes := make([]change, 0)
+	for i, u := range missing {
+		if j, ok := previous[i]; ok {
+			changes = append(changes, change{unit: u, previous: dead[j]})
+		}
+	}
+	return changes
+}
+
+// similarity returns 1 minus the edit distance between a and b (in runes,
+// ignoring case) divided by the length of the longest: 1 for keys that
+// only differ by case, 0 for keys with nothing in common.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	// Levenshtein distance, row by row
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			diagonal, row[j] = row[j], min(row[j]+1, row[j-1]+1, diagonal+cost)
+		}
+	}
+	return 1 - float64(row[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// batches splits units in batches of at most size units, and chars
+// characters of keys and contexts, to fit in model contexts (0 for no
+// limit). Units longer than chars are alone in their batch.
+func batches(units []unit, size, chars int) [][]unit {
+	batches := make([][]unit, 0)
+	batch, batchChars := make([]unit, 0), 0
+	for _, u := range units {
+		n := len(u.key) + len(u.context)
+		if len(batch) > 0 && ((size > 0 && len(batch) >= size) || (chars > 0 && batchChars+n > chars)) {
+			batches = append(batches, batch)
+			batch, batchChars = make([]unit, 0), 0
+		}
+		batch = append(batch, u)
+		batchChars += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// lockFile is the lock shared by translations running concurrently,
+// written after each batch when path isn't empty.
+type lockFile struct {
+	sync.Mutex
+	lock Lock
+	path string
+}
+
+func (l *lockFile) save() error {
+	if l.path == "" {
+		return nil
+	}
+	return writeJSON(l.path, l.lock)
+}
+
+// languageRun is the translation of a language, its batches being
+// translated concurrently.
+type languageRun struct {
+	lang     Language
+	glossary Glossary
+	path     string
+	// original is the language file before the translation
+	original []byte
+	// units are units to translate, in order
+	units []unit
+
+	sync.Mutex
+	translated map[unit]string
+	failed     int
+	errs       []error
+}
+
+// prepare plans the translation of a language, locking translations that
+// aren't locked yet. It returns nil if there's nothing to translate.
+func prepare(lang Language, catalog Catalog, glossary Glossary, dir string, lock *lockFile, dryRun bool) (*languageRun, error) {
+	run := &languageRun{
+		lang:       lang,
+		glossary:   glossary,
+		path:       filepath.Join(dir, lang.Code+".json"),
+		translated: make(map[unit]string),
+	}
+
+	m, err := readMessages(run.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		m = newMessages()
+	} else if err != nil {
+		return nil, err
+	}
+	run.original = m.Marshal()
+
+	p := planTranslation(catalog, m, lock.lock, lang.Code)
+	fmt.Printf("%s: %d missing, %d of them changed since translated\n", lang.Code, len(p.missing), len(p.changed))
+	for _, c := range p.changed {
+		if c.edited {
+			fmt.Printf("%s: %s: English source changed, edited translation carried over (review it)\n", lang.Code, c)
+		} else {
+			fmt.Printf("%s: %s: English source changed, translated again\n", lang.Code, c)
+		}
+	}
+	if dryRun {
+		for _, u := range p.translate() {
+			fmt.Printf("%s: %s: to translate\n", lang.Code, u)
+		}
+		return nil, nil
+	}
+
+	// translations found in language files are locked as they are,
+	// they're considered edited
+	for _, u := range p.unlocked {
+		lock.lock.set(lang.Code, u, &LockEntry{Source: sourceHash(u)})
+	}
+
+	// edited translations of changed English sources are moved to their
+	// new key, and stay considered edited. Previous machine translations
+	// are left to be pruned (see coverage).
+	carried := false
+	for _, c := range p.changed {
+		if !c.edited {
+			continue
+		}
+		text, _ := m.get(c.previous)
+		m.remove(c.previous)
+		lock.lock.remove(lang.Code, c.previous)
+		m.set(c.unit, text)
+		lock.lock.set(lang.Code, c.unit, &LockEntry{Source: sourceHash(c.unit)})
+		carried = true
+	}
+	if carried {
+		run.original = m.Marshal()
+		if err := os.WriteFile(run.path, run.original, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	run.units = p.translate()
+	if len(run.units) == 0 {
+		return nil, nil
+	}
+	return run, nil
+}
+
+// done records the translation of a batch, writing the lock and the
+// language file: completed batches aren't translated again if the run
+// is interrupted. Translations violating the glossary are reported.
+func (run *languageRun) done(batch []unit, translated *Messages, lock *lockFile) error {
+	run.Lock()
+	defer run.Unlock()
+
+	lock.Lock()
+	m := newMessages()
+	err := merge(m, translated, batch, lock.lock, run.lang.Code)
+	if err == nil {
+		err = lock.save()
+	}
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range batch {
+		text, _ := m.get(u)
+		run.translated[u] = text
+		for _, v := range run.glossary.check(u.key, text) {
+			fmt.Printf("%s: %s: glossary: %s\n", run.lang.Code, u, v)
+		}
+	}
+
+	// new keys are added in the order of units, whatever the order in
+	// which batches are done
+	file, err := parseMessages(run.original)
+	if err != nil {
+		return err
+	}
+	for _, u := range run.units {
+		if text, ok := run.translated[u]; ok {
+			file.set(u, text)
+		}
+	}
+	return os.WriteFile(run.path, file.Marshal(), 0644)
+}
+
+// translateOptions configure translation runs.
+type translateOptions struct {
+	// workers is the number of batches translated at the same time
+	workers int
+	// batchSize and batchChars limit batches, see batches
+	batchSize, batchChars int
+}
+
+// translate translates missing strings of the source catalog
+// in languages, updating language files in dir (other translations are
+// kept as they are, in the same order) and the lock. Batches of all
+// languages are translated concurrently, by workers. It returns runs of
+// languages with strings to translate, with their errors.
+func translate(translator Translator, langs []Language, catalog Catalog, glossaries map[string]Glossary, dir string, lock *lockFile, opts translateOptions) ([]*languageRun, error) {
+	runs := make([]*languageRun, 0)
+	for _, lang := range langs {
+		run, err := prepare(lang, catalog, glossaries[lang.Code], dir, lock, false)
+		if err != nil {
+			return nil, err
+		}
+		if run != nil {
+			runs = append(runs, run)
+		}
+	}
+	if err := lock.save(); err != nil {
+		return nil, err
+	}
+
+	type job struct {
+		run   *languageRun
+		batch []unit
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				translated, err := translator.Translate(j.run.lang, j.batch)
+				if err == nil {
+					err = j.run.done(j.batch, translated, lock)
+				}
+				if err != nil {
+					fmt.Printf("%s: batch of %d strings failed: %v\n", j.run.lang.Code, len(j.batch), err)
+					j.run.Lock()
+					j.run.failed++
+					j.run.errs = append(j.run.errs, err)
+					j.run.Unlock()
+				}
+			}
+		}()
+	}
+
+	// batches of languages are interleaved, for languages to progress
+	// at the same time
+	langBatches := make([][][]unit, len(runs))
+	for i, run := range runs {
+		langBatches[i] = batches(run.units, opts.batchSize, opts.batchChars)
+	}
+	for i := 0; ; i++ {
+		sent := false
+		for r, run := range runs {
+			if i < len(langBatches[r]) {
+				jobs <- job{run: run, batch: langBatches[r][i]}
+				sent = true
+			}
+		}
+		if !sent {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return runs, nil
+}
+
+// merge sets translations of units in m, locking them.
+func merge(m, translated *Messages, units []unit, lock Lock, lang string) error {
+	for _, u := range units {
+		if _, ok := translated.get(u); !ok {
+			return fmt.Errorf("%s missing from translation", u)
+		}
+	}
+	for _, u := range units {
+		text, _ := translated.get(u)
+		m.set(u, text)
+		lock.set(lang, u, &LockEntry{Source: sourceHash(u), Translation: hash(text)})
+	}
+	return nil
+}
+
+var translateCommand = &command{
+	name:  "translate",
+	usage: "[flags]",
+	description: "Translates missing strings of the source catalog, " +
+		"carrying over translations edited in language files when their English source changed.",
+	run: runTranslate,
+}
+
+func runTranslate(flags *flag.FlagSet, args []string) error {
+
+	catalogPath := flags.String("catalog", "../en.json", "source catalog, written by extract")
+	dir := flags.String("dir", "..", "directory of language files")
+	lockPath := flags.String("lock", "lock.json", "lock file, with hashes of machine translations")
+	dryRun := flags.Bool("n", false, "only list strings to translate")
+	glossaryDir := flags.String("glossary", "../glossary", "directory of glossaries (<code>.json)")
+	workers := flags.Int("workers", 4, "number of batches translated at the same time")
+	batchSize := flags.Int("batch", 40, "maximum number of strings by request")
+	batchChars := flags.Int("batch-chars", 6000, "maximum number of characters of strings by request, for model contexts")
+	translatorFlags := addTranslatorFlags(flags)
+	flags.Parse(args)
+
+	glossaries, err := readGlossaries(*glossaryDir)
+	if err != nil {
+		return err
+	}
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	l, err := readLock(*lockPath)
+	if err != nil {
+		return err
+	}
+	lock := &lockFile{lock: l, path: *lockPath}
+
+	// nothing is translated in dry runs
+	if *dryRun {
+		for _, lang := range languages {
+			if _, err := prepare(lang, catalog, glossaries[lang.Code], *dir, lock, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	translator, err := translatorFlags.translator(glossaries)
+	if err != nil {
+		return err
+	}
+
+	opts := translateOptions{workers: *workers, batchSize: *batchSize, batchChars: *batchChars}
+	runs, err := translate(translator, languages, catalog, glossaries, *dir, lock, opts)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	fmt.Println()
+	for _, run := range runs {
+		status := "done"
+		if run.failed > 0 {
+			failed++
+			status = fmt.Sprintf("%d batches failed (%v), run again to resume", run.failed, run.errs[0])
+		}
+		fmt.Printf("%s: %d/%d strings translated, %s\n", run.lang.Code, len(run.translated), len(run.units), status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d languages failed", failed, len(runs))
+	}
+	return nil
+}
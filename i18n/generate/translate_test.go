@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMessagesRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("../*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == "en.json" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := parseMessages(data)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if string(m.Marshal()) != string(data) {
+			t.Errorf("%s: encoded differently", path)
+		}
+	}
+}
+
+func TestPlanTranslation(t *testing.T) {
+	// "too long", "too short", "not big enough" and "hour" have been changed
+	// in Lua sources
+	catalog := Catalog{
+		"day":           {Refs: []string{"a.lua:1"}},
+		"month":         {Refs: []string{"a.lua:2"}},
+		"year":          {Refs: []string{"a.lua:3"}},
+		"login":         {Contexts: map[string][]string{"button": {"a.lua:4"}}},
+		"sign up":       {Contexts: map[string][]string{"button": {"a.lua:5"}, "title": {"a.lua:6"}}},
+		"way too long":  {Refs: []string{"a.lua:7"}},
+		"far too short": {Refs: []string{"a.lua:8"}},
+		"big enough":    {Refs: []string{"a.lua:9"}},
+		"hours":         {Refs: []string{"a.lua:10"}},
+	}
+	file := `{
+    "year": "année",
+    "day": "jour",
+    "login": "connexion",
+    "sign up": {
+        "title": "inscription"
+    },
+    "too long": "trop long",
+    "too short": "trop court!",
+    "not big enough": "pas assez grand",
+    "hour": "heure",
+    "dead": "mort"
+}`
+	m, err := parseMessages([]byte(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := Lock{"fr": {
+		"day":      {Source: sourceHash(unit{key: "day"}), Translation: hash("jour")},
+		"too long": {Source: sourceHash(unit{key: "too long"}), Translation: hash("trop long")},
+		// edited since translated
+		"too short": {Source: sourceHash(unit{key: "too short"}), Translation: hash("trop court")},
+		// translated by someone else, or locked for another source
+		"hour": {Source: sourceHash(unit{key: "hours"}), Translation: hash("heures")},
+	}}
+
+	p := planTranslation(catalog, m, lock, "fr")
+	u := func(key, context string) unit { return unit{key: key, context: context} }
+	expected := &plan{
+		missing: []unit{u("big enough", ""), u("far too short", ""), u("hours", ""), u("month", ""), u("sign up", "button"), u("way too long", "")},
+		// never locked, "not big enough" is translated again, "hour" is
+		// too short to be paired
+		changed: []change{
+			{unit: u("big enough", ""), previous: u("not big enough", "")},
+			{unit: u("far too short", ""), previous: u("too short", ""), edited: true},
+			{unit: u("way too long", ""), previous: u("too long", "")},
+		},
+		unlocked: []unit{u("login", "button"), u("sign up", "title"), u("year", "")},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Fatalf("got %+v, want %+v", p, expected)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fr.json"), []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run, err := prepare(french, catalog, nil, dir, &lockFile{lock: lock}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []unit{u("big enough", ""), u("hours", ""), u("month", ""), u("sign up", "button"), u("way too long", "")}; !reflect.DeepEqual(run.units, expected) {
+		t.Errorf("to translate: %v", run.units)
+	}
+	// the edited translation is carried over, the machine translation
+	// is left to be pruned
+	if e := lock.get("fr", u("far too short", "")); e == nil || e.Translation != "" || lock.get("fr", u("too short", "")) != nil {
+		t.Errorf("lock: %+v", lock["fr"])
+	}
+	m, err = readMessages(filepath.Join(dir, "fr.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	translated, err := parseMessages([]byte(`{"big enough": "assez grand", "hours": "heures", "month": "mois", "sign up": {"button": "s'inscrire"}, "way too long": "bien trop long"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := merge(m, translated, run.units, lock, "fr"); err != nil {
+		t.Fatal(err)
+	}
+	// keys keep their order, new ones are added at the end
+	expectedFile := `{
+    "year": "année",
+    "day": "jour",
+    "login": "connexion",
+    "sign up": {
+        "title": "inscription",
+        "button": "s'inscrire"
+    },
+    "too long": "trop long",
+    "not big enough": "pas assez grand",
+    "hour": "heure",
+    "dead": "mort",
+    "far too short": "trop court!",
+    "big enough": "assez grand",
+    "hours": "heures",
+    "month": "mois",
+    "way too long": "bien trop long"
+}`
+	if string(m.Marshal()) != expectedFile {
+		t.Errorf("got:\n%s", m.Marshal())
+	}
+	if p := planTranslation(catalog, m, lock, "fr"); len(p.translate()) != 0 || len(p.changed) != 0 {
+		t.Errorf("still to translate: %+v", p)
+	}
+
+	if err := merge(m, newMessages(), []unit{u("month", "")}, lock, "fr"); err == nil {
+		t.Errorf("no error for a missing translation")
+	}
+}
+
+func TestPairChanges(t *testing.T) {
+	u := func(key, context string) unit { return unit{key: key, context: context} }
+	missing := []unit{u("Sign up now!", ""), u("Sign up for free!", "title"), u("Settings", ""), u("Sign up to play", ""), u("days", ""), u("years", "")}
+	dead := []unit{u("Sign up for free", "title"), u("sign up to play", ""), u("Quit", ""), u("day", ""), u("year", "")}
+	expected := []change{
+		// most similar first: "Sign up to play" gets "sign up to play"
+		{unit: u("Sign up for free!", "title"), previous: u("Sign up for free", "title")},
+		{unit: u("Sign up to play", ""), previous: u("sign up to play", "")},
+		// short keys ("days" and "day") aren't paired
+	}
+	if changes := pairChanges(missing, dead); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %+v", changes)
+	}
+
+	for _, test := range []struct {
+		a, b     string
+		expected float64
+	}{
+		{"day", "Day", 1},
+		{"abcd", "abxd", 0.75},
+		{"", "", 1},
+		{"éb", "eb", 0.5},
+		{"abc", "", 0},
+	} {
+		if s := similarity(test.a, test.b); s != test.expected {
+			t.Errorf("similarity(%q, %q) = %v, want %v", test.a, test.b, s, test.expected)
+		}
+	}
+}
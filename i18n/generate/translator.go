@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Translator translates units of the source catalog.
+type Translator interface {
+	// Translate returns translations of units, in the shape of language files.
+	Translate(lang Language, units []unit) (*Messages, error)
+}
+
+// translatorFlags are flags selecting and configuring translators.
+type translatorFlags struct {
+	backend *string
+	url     *string
+	model   *string
+	key     *string
+	retries *int
+}
+
+func addTranslatorFlags(flags *flag.FlagSet) *translatorFlags {
+	return &translatorFlags{
+		backend: flags.String("backend", "openai", "translation backend: openai, pseudo or passthrough"),
+		url:     flags.String("api-url", "", "URL of the OpenAI compatible chat completions API (default $I18N_API_URL or OpenAI's)"),
+		model:   flags.String("model", "", "model of the API (default $I18N_MODEL or gpt-4)"),
+		key:     flags.String("api-key", "", "key of the API (default $OPENAI_API_KEY)"),
+		retries: flags.Int("retries", 5, "retries of requests failing because of rate limits or server errors"),
+	}
+}
+
+// translator returns the selected translator, glossaries by language
+// being given to models.
+func (f *translatorFlags) translator(glossaries map[string]Glossary) (Translator, error) {
+	switch *f.backend {
+	case "openai":
+		key := valueOrEnv(*f.key, "OPENAI_API_KEY", "")
+		if key == "" {
+			return nil, errors.New("no API key, set OPENAI_API_KEY")
+		}
+		return &openAITranslator{
+			url:        valueOrEnv(*f.url, "I18N_API_URL", "https://api.openai.com/v1/chat/completions"),
+			model:      valueOrEnv(*f.model, "I18N_MODEL", "gpt-4"),
+			key:        key,
+			client:     &http.Client{Timeout: 5 * time.Minute},
+			retries:    *f.retries,
+			backoff:    time.Second,
+			glossaries: glossaries,
+		}, nil
+	case "pseudo":
+		return pseudoTranslator{brackets: "[]"}, nil
+	case "passthrough":
+		return passthroughTranslator{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", *f.backend)
+}
+
+func valueOrEnv(value, env, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// openAITranslator translates with an OpenAI compatible chat completions API.
+type openAITranslator struct {
+	url    string
+	model  string
+	key    string
+	client *http.Client
+	// retries is the number of retries of failed requests, backoff the
+	// delay before the first one
+	retries int
+	backoff time.Duration
+	// glossaries are glossaries by language code, terms found in
+	// units being added to prompts
+	glossaries map[string]Glossary
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ChatGptReq struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+}
+
+type ChatGptResp struct {
+	ID      string   `json:"id"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// Translate sends the template of units to the API,
+// its response being the template with translations.
+func (t *openAITranslator) Translate(lang Language, units []unit) (*Messages, error) {
+
+	input, err := marshalJSON(template(units))
+	if err != nil {
+		return nil, err
+	}
+
+	req := ChatGptReq{
+		Model:       t.model,
+		Temperature: 0,
+		Messages: []Message{
+			{
+				Role: "system",
+				Content: `You're a native ` + lang.Name + ` translator. You translate JSON files from english to ` + lang.Name + `. Translations must be kids-friendly, direct, and as short as possible (never longer than 10% over english version). Translations are for a gaming mobile application like Roblox (audience between 12 and 16 yo). Output has to sound natural and casual for kids. Use natural spoken language, modifying sentence structures if needed.
+
+Here's our the input JSON is structured:
+
+{
+	"key_a" = "value_a"
+	"key_b" = {
+		"context1" = "value_b1",
+		"context2" = "value_b2"
+	}
+}
+
+The value is a table when the top level key could be translated in different ways depending on the context. Each sub-key then describes the context that should be use to translate the top level key. The context itself should not be translated.` + messagePrompt(lang, units) + t.glossaries[lang.Code].prompt(units),
+			},
+			{
+				Role:    "user",
+				Content: string(input),
+			},
+		},
+	}
+
+	// Convert the request to JSON
+	reqBodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.post(reqBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	var respData ChatGptResp
+	err = json.Unmarshal(body, &respData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(respData.Choices) != 1 {
+		return nil, errors.New("invalid response")
+	}
+
+	translated, err := parseMessages(trimCodeFence([]byte(respData.Choices[0].Message.Content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid translation: %w", err)
+	}
+	return translated, nil
+}
+
+// statusError is an error response of the API.
+type statusError struct {
+	status string
+	code   int
+	body   []byte
+	// retryAfter is the delay given by Retry-After headers, 0 if none
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.status, bytes.TrimSpace(e.body))
+}
+
+// temporary returns true for rate limits and server errors.
+func (e *statusError) temporary() bool {
+	switch e.code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// post sends a request to the API, returning the body of its response.
+// Rate limits, server and network errors are retried, after the delay
+// of Retry-After headers, or with exponential backoff.
+func (t *openAITranslator) post(body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := t.send(body)
+		if err == nil {
+			return response, nil
+		}
+
+		var statusErr *statusError
+		isStatus := errors.As(err, &statusErr)
+		if attempt >= t.retries || (isStatus && !statusErr.temporary()) {
+			return nil, err
+		}
+
+		delay := t.backoff << attempt
+		if delay > maxBackoff || delay <= 0 {
+			delay = maxBackoff
+		}
+		// jitter, so that workers don't retry at the same time
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if isStatus && statusErr.retryAfter > 0 {
+			delay = statusErr.retryAfter
+		}
+		fmt.Printf("%v, retrying in %v\n", err, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+// maxBackoff is the longest delay between retries, without Retry-After.
+const maxBackoff = time.Minute
+
+func (t *openAITranslator) send(body []byte) ([]byte, error) {
+
+	// Prepare the request
+	httpReq, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// the API key is a bearer token
+	if t.key != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+t.key)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Send the request
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{
+			status:     resp.Status,
+			code:       resp.StatusCode,
+			body:       respBody,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return respBody, nil
+}
+
+// parseRetryAfter parses Retry-After headers: seconds or a date.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// messagePrompt explains how to translate keys in ICU MessageFormat, if
+// there are some in units.
+func messagePrompt(lang Language, units []unit) string {
+	for _, u := range units {
+		if isMessage(u.key) {
+			return `
+
+Keys with braces are in ICU MessageFormat: keep their syntax and argument names, only translate texts. Plural arguments must have exactly these categories in ` + lang.Name + `: ` + strings.Join(pluralRuleOf(lang.Code).categories, ", ") + `.`
+		}
+	}
+	return ""
+}
+
+// trimCodeFence removes the markdown code block models may put JSON in.
+func trimCodeFence(data []byte) []byte {
+	s := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(s, "```") {
+		return data
+	}
+	s = strings.TrimPrefix(s, "```")
+	s = strings.TrimPrefix(s, "json")
+	return []byte(strings.TrimSuffix(s, "```"))
+}
+
+// passthroughTranslator "translates" to English, keys as they are.
+type passthroughTranslator struct{}
+
+func (passthroughTranslator) Translate(lang Language, units []unit) (*Messages, error) {
+	m := newMessages()
+	for _, u := range units {
+		m.set(u, u.key)
+	}
+	return m, nil
+}
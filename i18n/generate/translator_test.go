@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var french = Language{Name: "French", Code: "fr"}
+
+func TestOpenAITranslatorRetries(t *testing.T) {
+	standIn := standInHandler("")
+	responses := []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if len(responses) > 0 {
+			w.Header().Set("Retry-After", "1")
+			if responses[0] == http.StatusServiceUnavailable {
+				w.Header().Del("Retry-After")
+			}
+			http.Error(w, "try later", responses[0])
+			responses = responses[1:]
+			return
+		}
+		standIn.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	translator := &openAITranslator{url: server.URL, client: server.Client(), retries: 2, backoff: time.Millisecond}
+	start := time.Now()
+	if _, err := translator.Translate(french, []unit{{key: "day"}}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || time.Since(start) < time.Second {
+		t.Errorf("%d requests in %v, want 3, after Retry-After", requests, time.Since(start))
+	}
+
+	// too many failures, errors that aren't temporary
+	responses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusBadGateway}
+	translator.retries = 1
+	if _, err := translator.Translate(french, []unit{{key: "day"}}); err == nil {
+		t.Errorf("no error after retries")
+	}
+	responses, requests = []int{http.StatusBadRequest}, 0
+	if _, err := translator.Translate(french, []unit{{key: "day"}}); err == nil || requests != 1 {
+		t.Errorf("bad request: %v, %d requests", err, requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Errorf("got %v", d)
+	}
+	if d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("got %v", d)
+	}
+	if d := parseRetryAfter(""); d != 0 {
+		t.Errorf("got %v", d)
+	}
+}
+
+func TestOpenAITranslator(t *testing.T) {
+	server := httptest.NewServer(standInHandler("secret"))
+	defer server.Close()
+
+	units := []unit{{key: "day"}, {key: "sign up", context: "button"}, {key: "sign up", context: "title"}}
+	translator := &openAITranslator{url: server.URL, model: "test", key: "secret", client: server.Client()}
+	m, err := translator.Translate(french, units)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range units {
+		if text, _ := m.get(u); text != "[French] "+u.key {
+			t.Errorf("%s: %q", u, text)
+		}
+	}
+
+	translator.key = "wrong"
+	if _, err := translator.Translate(french, units); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want an unauthorized error", err)
+	}
+}
+
+// TestTranslate runs translations offline, with the pseudo translator.
+func TestTranslate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fr.json")
+	if err := os.WriteFile(path, []byte("{\n    \"day\": \"jour\"\n}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := Catalog{
+		"day":     {Refs: []string{"a.lua:1"}},
+		"%s left": {Refs: []string{"a.lua:2"}},
+		"sign up": {Contexts: map[string][]string{"title": {"a.lua:3"}}},
+	}
+	lock := &lockFile{lock: make(Lock)}
+	opts := translateOptions{workers: 3, batchSize: 1}
+	runs, err := translate(pseudoTranslator{brackets: "[]"}, []Language{french}, catalog, nil, dir, lock, opts)
+	if err != nil || len(runs) != 1 || runs[0].failed != 0 {
+		t.Fatal(err, runs)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+    "day": "jour",
+    "%s left": "[%s léft]",
+    "sign up": {
+        "title": "[sígñ úp]"
+    }
+}`
+	if string(data) != expected {
+		t.Errorf("got:\n%s", data)
+	}
+	if e := lock.lock.get("fr", unit{key: "day"}); e == nil || e.Translation != "" {
+		t.Errorf("day lock %+v", e)
+	}
+	if e := lock.lock.get("fr", unit{key: "sign up", context: "title"}); e == nil || e.Translation != hash("[sígñ úp]") {
+		t.Errorf("sign up lock %+v", e)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	units := []unit{{key: "a"}, {key: "bb"}, {key: "cc", context: "c"}, {key: "dddddd"}, {key: "e"}}
+	sizes := func(batches [][]unit) []int {
+		s := make([]int, 0)
+		for _, b := range batches {
+			s = append(s, len(b))
+		}
+		return s
+	}
+	tests := []struct {
+		size, chars int
+		expected    []int
+	}{
+		{0, 0, []int{5}},
+		{2, 0, []int{2, 2, 1}},
+		{0, 4, []int{2, 1, 1, 1}},
+		{2, 6, []int{2, 1, 1, 1}},
+	}
+	for _, test := range tests {
+		if s := sizes(batches(units, test.size, test.chars)); !reflect.DeepEqual(s, test.expected) {
+			t.Errorf("%d units, %d chars: got %v, want %v", test.size, test.chars, s, test.expected)
+		}
+	}
+}
+
+// failingTranslator fails to translate batches with a key, once.
+type failingTranslator struct {
+	sync.Mutex
+	Translator
+	key    string
+	failed bool
+}
+
+func (f *failingTranslator) Translate(lang Language, units []unit) (*Messages, error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, u := range units {
+		if u.key == f.key && !f.failed {
+			f.failed = true
+			return nil, errors.New("failed")
+		}
+	}
+	return f.Translator.Translate(lang, units)
+}
+
+// TestTranslateResume translates languages concurrently, a batch failing:
+// translating again translates what's missing only.
+func TestTranslateResume(t *testing.T) {
+	catalog := make(Catalog)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		catalog[key] = &Entry{Refs: []string{"a.lua:1"}}
+	}
+	langs := []Language{french, {Name: "Polish", Code: "pl"}}
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "lock.json")
+	opts := translateOptions{workers: 4, batchSize: 2}
+
+	translator := &failingTranslator{Translator: passthroughTranslator{}, key: "c"}
+	lock := &lockFile{lock: make(Lock), path: lockPath}
+	runs, err := translate(translator, langs, catalog, nil, dir, lock, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for _, run := range runs {
+		failed += run.failed
+	}
+	if len(runs) != 2 || failed != 1 {
+		t.Fatalf("%d runs, %d failed batches", len(runs), failed)
+	}
+
+	// the lock is saved with completed batches
+	l, err := readLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err = translate(passthroughTranslator{}, langs, catalog, nil, dir, &lockFile{lock: l, path: lockPath}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || len(runs[0].units) != 2 || runs[0].failed != 0 {
+		t.Fatalf("resumed runs %+v", runs)
+	}
+
+	// keys translated when resuming are added at the end
+	expected := map[string]string{
+		"fr": "abefgcd",
+		"pl": "abcdefg",
+	}
+	for _, lang := range langs {
+		m, err := readMessages(filepath.Join(dir, lang.Code+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := strings.Join(m.Keys, ""); keys != expected[lang.Code] {
+			t.Errorf("%s: keys %s, want %s", lang.Code, keys, expected[lang.Code])
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// issue is a problem found in a language file, an error or a warning.
+type issue struct {
+	unit    unit
+	error   bool
+	message string
+}
+
+func (i issue) String() string {
+	if i.error {
+		return fmt.Sprintf("ERROR: %s: %s", i.unit, i.message)
+	}
+	return fmt.Sprintf("WARNING: %s: %s", i.unit, i.message)
+}
+
+// placeholder matches string.format placeholders ("%%" included, to be
+// skipped). The space flag isn't supported, "100% of" isn't a placeholder.
+var placeholder = regexp.MustCompile(`%(?:%|[-+#0]*[0-9]*(?:\.[0-9]+)?[cdiouxXeEfgGqsaA])`)
+
+// placeholders returns the placeholders of a string, in order.
+func placeholders(s string) []string {
+	found := make([]string, 0)
+	for _, p := range placeholder.FindAllString(s, -1) {
+		if p != "%%" {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// emoji returns the emoji of a string, in order (symbols, without
+// variation selectors).
+func emoji(s string) []string {
+	found := make([]string, 0)
+	for _, r := range s {
+		if unicode.Is(unicode.So, r) {
+			found = append(found, string(r))
+		}
+	}
+	return found
+}
+
+func hasLetters(s string) bool {
+	return strings.IndexFunc(s, unicode.IsLetter) >= 0
+}
+
+// validate checks a language file against the source catalog: keys and
+// contexts, placeholders and emoji of translations, and their length, that
+// shouldn't be more than budget (0.1 for 10%) over English. Translations of
+// keys in ICU MessageFormat must have their arguments, and the plural
+// categories of the language. Translations violating the glossary are
+// errors.
+func validate(catalog Catalog, m *Messages, lang string, glossary Glossary, budget float64) []issue {
+	issues := make([]issue, 0)
+	add := func(u unit, error bool, format string, a ...interface{}) {
+		issues = append(issues, issue{unit: u, error: error, message: fmt.Sprintf(format, a...)})
+	}
+
+	for _, key := range catalog.Keys() {
+		e := catalog[key]
+		t, ok := m.Values[key]
+		switch {
+		case !ok:
+			add(unit{key: key}, true, "missing")
+			continue
+		case len(e.Contexts) == 0 && t.Contexts != nil:
+			add(unit{key: key}, true, "translated by context, used without context")
+			continue
+		case len(e.Contexts) > 0 && t.Contexts == nil:
+			// localize.lua uses it for all contexts
+			add(unit{key: key}, false, "translated without context, used with contexts %q", e.ContextNames())
+		case len(e.Contexts) > 0:
+			for _, context := range e.ContextNames() {
+				if _, ok := t.Contexts[context]; !ok {
+					add(unit{key: key, context: context}, true, "missing")
+				}
+			}
+			for _, context := range t.ContextNames {
+				if _, ok := e.Contexts[context]; !ok {
+					add(unit{key: key, context: context}, false, "context not in the source catalog")
+				}
+			}
+		}
+	}
+
+	for _, key := range m.Keys {
+		if _, ok := catalog[key]; !ok {
+			add(unit{key: key}, false, "not in the source catalog")
+		}
+	}
+
+	for _, u := range catalog.units() {
+		text, ok := m.get(u)
+		if !ok {
+			continue
+		}
+		if expected, got := placeholders(u.key), placeholders(text); strings.Join(expected, " ") != strings.Join(got, " ") {
+			add(u, true, "placeholders %q, expected %q", got, expected)
+		}
+		if expected, got := emoji(u.key), emoji(text); strings.Join(expected, "") != strings.Join(got, "") {
+			add(u, true, "emoji %q, expected %q", got, expected)
+		}
+		if text == u.key && hasLetters(text) {
+			add(u, false, "not translated")
+		}
+		if isMessage(u.key) {
+			issues = append(issues, validateMessage(u, text, lang)...)
+			continue
+		}
+		length, max := utf8.RuneCountInString(text), float64(utf8.RuneCountInString(u.key))*(1+budget)
+		if float64(length) > max {
+			add(u, false, "%d characters, more than %d%% over English (%d)", length, int(budget*100), utf8.RuneCountInString(u.key))
+		}
+	}
+
+	m.translations(func(u unit, text string) {
+		for _, v := range glossary.check(u.key, text) {
+			add(u, true, "glossary: %s", v)
+		}
+	})
+	return issues
+}
+
+// validateMessage checks the translation of a key in ICU MessageFormat.
+func validateMessage(u unit, text, lang string) []issue {
+	source, err := parseMessage(u.key)
+	if err != nil {
+		// reported by extract
+		return nil
+	}
+	issues := make([]issue, 0)
+	add := func(error bool, format string, a ...interface{}) {
+		issues = append(issues, issue{unit: u, error: error, message: fmt.Sprintf(format, a...)})
+	}
+
+	translation, err := parseMessage(text)
+	if err != nil {
+		add(true, "invalid ICU message: %v", err)
+		return issues
+	}
+
+	expected, args := source.arguments(), translation.arguments()
+	for _, name := range sortedKeys(args) {
+		kind, ok := expected[name]
+		switch {
+		case !ok:
+			add(true, "argument %q not in English", name)
+		case kind != args[name]:
+			add(true, "argument %q is %s, expected %s", name, argumentKind(args[name]), argumentKind(kind))
+		}
+	}
+	for _, name := range sortedKeys(expected) {
+		if _, ok := args[name]; !ok {
+			add(false, "argument %q not used", name)
+		}
+	}
+
+	errs, warnings := translation.checkPlurals(lang)
+	for _, e := range errs {
+		add(true, "%s", e)
+	}
+	for _, w := range warnings {
+		add(false, "%s", w)
+	}
+	return issues
+}
+
+func argumentKind(kind string) string {
+	if kind == "" {
+		return "simple"
+	}
+	return kind
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var validateCommand = &command{
+	name:  "validate",
+	usage: "[flags]",
+	description: "Validates language files against the source catalog: keys, contexts, " +
+		"placeholders, emoji and length. Fails on errors.",
+	run: runValidate,
+}
+
+func runValidate(flags *flag.FlagSet, args []string) error {
+
+	catalogPath := flags.String("catalog", "../en.json", "source catalog, written by extract")
+	dir := flags.String("dir", "..", "directory of language files")
+	budget := flags.Float64("budget", 0.1, "length of translations over English, before being reported")
+	strict := flags.Bool("strict", false, "fail on warnings too")
+	glossaryDir := flags.String("glossary", "../glossary", "directory of glossaries (<code>.json)")
+	flags.Parse(args)
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	glossaries, err := readGlossaries(*glossaryDir)
+	if err != nil {
+		return err
+	}
+
+	errorCount, warningCount := 0, 0
+	for _, lang := range languages {
+		m, err := readMessages(filepath.Join(*dir, lang.Code+".json"))
+		if err != nil {
+			return err
+		}
+		for _, i := range validate(catalog, m, lang.Code, glossaries[lang.Code], *budget) {
+			fmt.Printf("%s: %s\n", lang.Code, i)
+			if i.error {
+				errorCount++
+			} else {
+				warningCount++
+			}
+		}
+	}
+
+	fmt.Printf("%d errors, %d warnings\n", errorCount, warningCount)
+	if errorCount > 0 || (*strict && warningCount > 0) {
+		return errors.New("validation failed")
+	}
+	return nil
+}
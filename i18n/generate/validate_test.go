@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlaceholders(t *testing.T) {
+	if p := placeholders("%d / %5.2f%% of %s, 100%"); !reflect.DeepEqual(p, []string{"%d", "%5.2f", "%s"}) {
+		t.Errorf("got %q", p)
+	}
+	if e := emoji("⚠️ Be safe! ⚠️ 🙂"); !reflect.DeepEqual(e, []string{"⚠", "⚠", "🙂"}) {
+		t.Errorf("got %q", e)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	catalog := Catalog{
+		"day":               {},
+		"days":              {},
+		"sign up":           {Contexts: map[string][]string{"button": nil, "title": nil}},
+		"login":             {Contexts: map[string][]string{"button": nil}},
+		"settings":          {},
+		"%d / %d collected": {},
+		"Sure! 🙂":           {},
+		"Discord":           {},
+		"ok":                {},
+	}
+	m, err := parseMessages([]byte(`{
+    "day": "jour",
+    "sign up": {
+        "button": "s'inscrire",
+        "other": "inscription"
+    },
+    "login": "connexion",
+    "settings": {
+        "title": "réglages"
+    },
+    "%d / %d collected": "%d collectés sur %s",
+    "Sure! 🙂": "🙂 Bien sûr !",
+    "Discord": "Discord",
+    "ok": "ok",
+    "old": "vieux"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []issue{
+		{unit{key: "days"}, true, "missing"},
+		{unit{key: "login"}, false, `translated without context, used with contexts ["button"]`},
+		{unit{key: "settings"}, true, "translated by context, used without context"},
+		{unit{key: "sign up", context: "title"}, true, "missing"},
+		{unit{key: "sign up", context: "other"}, false, "context not in the source catalog"},
+		{unit{key: "old"}, false, "not in the source catalog"},
+		{unit{key: "%d / %d collected"}, true, `placeholders ["%d" "%s"], expected ["%d" "%d"]`},
+		{unit{key: "Discord"}, false, "not translated"},
+		{unit{key: "Sure! 🙂"}, false, "12 characters, more than 50% over English (7)"},
+		{unit{key: "login", context: "button"}, false, "9 characters, more than 50% over English (5)"},
+		{unit{key: "ok"}, false, "not translated"},
+	}
+	issues := validate(catalog, m, "fr", nil, 0.5)
+	if !reflect.DeepEqual(issues, expected) {
+		for _, i := range issues {
+			t.Log(i)
+		}
+		t.Errorf("unexpected issues")
+	}
+
+	m.set(unit{key: "Sure! 🙂"}, "Sûr !")
+	for _, i := range validate(catalog, m, "fr", nil, 0.5) {
+		if i.unit.key == "Sure! 🙂" && i.message != `emoji [], expected ["🙂"]` {
+			t.Errorf("got %s", i)
+		}
+	}
+}
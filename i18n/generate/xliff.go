@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// xliff is an XLIFF 2.0 document, with the elements used for language
+// files: units are named after keys, with their context and references in
+// notes.
+type xliff struct {
+	XMLName xml.Name    `xml:"urn:oasis:names:tc:xliff:document:2.0 xliff"`
+	Version string      `xml:"version,attr"`
+	SrcLang string      `xml:"srcLang,attr"`
+	TrgLang string      `xml:"trgLang,attr"`
+	Files   []xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	ID       string      `xml:"id,attr"`
+	Original string      `xml:"original,attr,omitempty"`
+	Units    []xliffUnit `xml:"unit"`
+}
+
+type xliffUnit struct {
+	ID       string         `xml:"id,attr"`
+	Name     string         `xml:"name,attr"`
+	Notes    *xliffNotes    `xml:"notes"`
+	Segments []xliffSegment `xml:"segment"`
+}
+
+// note categories
+const (
+	noteContext  = "context"
+	noteLocation = "location"
+)
+
+// xliffNotes has at least a note, units without notes have none.
+type xliffNotes struct {
+	Notes []xliffNote `xml:"note"`
+}
+
+type xliffNote struct {
+	Category string `xml:"category,attr,omitempty"`
+	Text     string `xml:",chardata"`
+}
+
+type xliffSegment struct {
+	State  string  `xml:"state,attr,omitempty"`
+	Source string  `xml:"source"`
+	Target *string `xml:"target"`
+}
+
+// encodeXLIFF encodes items as an XLIFF 2.0 file: untranslated strings
+// have no target.
+func encodeXLIFF(lang Language, items []item) []byte {
+	file := xliffFile{ID: "f1", Original: "i18n/" + lang.Code + ".json"}
+	for i, it := range items {
+		u := xliffUnit{ID: "u" + strconv.Itoa(i+1), Name: it.unit.key}
+		notes := make([]xliffNote, 0)
+		if it.unit.context != "" {
+			notes = append(notes, xliffNote{Category: noteContext, Text: it.unit.context})
+		}
+		for _, ref := range it.refs {
+			notes = append(notes, xliffNote{Category: noteLocation, Text: ref})
+		}
+		if len(notes) > 0 {
+			u.Notes = &xliffNotes{Notes: notes}
+		}
+		segment := xliffSegment{State: "initial", Source: it.unit.key}
+		if it.translated {
+			text := it.text
+			segment.State = "translated"
+			segment.Target = &text
+		}
+		u.Segments = []xliffSegment{segment}
+		file.Units = append(file.Units, u)
+	}
+
+	doc := xliff{Version: "2.0", SrcLang: "en", TrgLang: lang.Code, Files: []xliffFile{file}}
+	data, err := xml.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		// documents only have strings
+		panic(err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...)
+}
+
+// decodeXLIFF decodes an XLIFF 2.0 file. Units have a single segment,
+// translated if it has a target.
+func decodeXLIFF(data []byte) ([]item, string, error) {
+	var doc xliff
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, "", err
+	}
+	if doc.Version != "2.0" {
+		return nil, "", fmt.Errorf("unsupported XLIFF version %q", doc.Version)
+	}
+
+	items := make([]item, 0)
+	for _, file := range doc.Files {
+		for _, u := range file.Units {
+			if len(u.Segments) != 1 {
+				return nil, "", fmt.Errorf("unit %s: %d segments, expected 1", u.ID, len(u.Segments))
+			}
+			it := item{unit: unit{key: u.Name}}
+			if it.unit.key == "" {
+				it.unit.key = u.Segments[0].Source
+			}
+			notes := make([]xliffNote, 0)
+			if u.Notes != nil {
+				notes = u.Notes.Notes
+			}
+			for _, note := range notes {
+				switch note.Category {
+				case noteContext:
+					it.unit.context = note.Text
+				case noteLocation:
+					it.refs = append(it.refs, note.Text)
+				}
+			}
+			if target := u.Segments[0].Target; target != nil {
+				it.text, it.translated = *target, true
+			}
+			items = append(items, it)
+		}
+	}
+	return items, doc.TrgLang, nil
+}
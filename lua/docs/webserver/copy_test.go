@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// copyableTypes lists all types of the doc model having a Copy method.
+var copyableTypes = []interface{}{
+	// content
+	&ContentBlock{},
+	&Sample{},
+	// documentation model
+	&Doc{},
+	&DocType{},
+	&DocFunction{},
+	&DocParameter{},
+	&DocValue{},
+	&DocProperty{},
+	// reference pages
+	&Page{},
+	&Function{},
+	&Argument{},
+	&Value{},
+	&Property{},
+	// modules
+	&Module{},
+	&ModuleType{},
+	&ModuleFunction{},
+	&Parameter{},
+	&ModuleValue{},
+	&ModuleProperty{},
+}
+
+// sharedFields lists fields that are expected to be shared
+// between original and copy, indexed by "Type.Field".
+var sharedFields = map[string]bool{
+	// the copy extends the same base page
+	"Page.Base": true,
+}
+
+// filler sets all fields of a value to distinct non-zero values.
+type filler struct {
+	n int
+	// struct types being filled, to stop recursion
+	filling map[reflect.Type]bool
+}
+
+func (f *filler) fill(v reflect.Value) {
+	f.n++
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprintf("s%d", f.n))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(f.n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(f.n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(f.n))
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		// recursive types (Page.Base) get a zero value
+		if !f.filling[v.Type().Elem()] {
+			f.fill(p.Elem())
+		}
+		v.Set(p)
+	case reflect.Struct:
+		f.filling[v.Type()] = true
+		for i := 0; i < v.NumField(); i++ {
+			f.fill(v.Field(i))
+		}
+		delete(f.filling, v.Type())
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 2, 2)
+		for i := 0; i < s.Len(); i++ {
+			f.fill(s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := 0; i < 2; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			f.fill(key)
+			value := reflect.New(v.Type().Elem()).Elem()
+			f.fill(value)
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	default:
+		panic("can't fill " + v.Type().String())
+	}
+}
+
+// checkNotShared reports pointers, slices and maps shared by original and copy.
+// Values that differ are ignored, TestCopyIsComplete reports them.
+func checkNotShared(t *testing.T, path string, original, copied reflect.Value) {
+	t.Helper()
+
+	if !copied.IsValid() {
+		return
+	}
+
+	switch original.Kind() {
+	case reflect.Ptr:
+		if original.IsNil() || copied.IsNil() {
+			return
+		}
+		if original.Pointer() == copied.Pointer() {
+			t.Errorf("%s is shared with the copy", path)
+			return
+		}
+		checkNotShared(t, path, original.Elem(), copied.Elem())
+	case reflect.Struct:
+		for i := 0; i < original.NumField(); i++ {
+			field := original.Type().Field(i)
+			if sharedFields[original.Type().Name()+"."+field.Name] {
+				continue
+			}
+			checkNotShared(t, path+"."+field.Name, original.Field(i), copied.Field(i))
+		}
+	case reflect.Slice:
+		if original.Len() == 0 || original.Len() != copied.Len() {
+			return
+		}
+		if original.Pointer() == copied.Pointer() {
+			t.Errorf("%s is shared with the copy", path)
+			return
+		}
+		for i := 0; i < original.Len(); i++ {
+			checkNotShared(t, fmt.Sprintf("%s[%d]", path, i), original.Index(i), copied.Index(i))
+		}
+	case reflect.Map:
+		if original.IsNil() || copied.IsNil() {
+			return
+		}
+		if original.Pointer() == copied.Pointer() {
+			t.Errorf("%s is shared with the copy", path)
+			return
+		}
+		iter := original.MapRange()
+		for iter.Next() {
+			checkNotShared(t, fmt.Sprintf("%s[%v]", path, iter.Key()), iter.Value(), copied.MapIndex(iter.Key()))
+		}
+	}
+}
+
+// TestCopyIsComplete ensures Copy methods copy all fields,
+// without sharing anything with the original.
+// It fails when a field is added to a type but not to its Copy method.
+func TestCopyIsComplete(t *testing.T) {
+
+	for _, typ := range copyableTypes {
+
+		name := reflect.TypeOf(typ).Elem().Name()
+
+		t.Run(name, func(t *testing.T) {
+
+			original := reflect.New(reflect.TypeOf(typ).Elem())
+			f := &filler{filling: make(map[reflect.Type]bool)}
+			f.fill(original.Elem())
+
+			method := original.MethodByName("Copy")
+			if !method.IsValid() {
+				t.Fatalf("%s has no Copy method", name)
+			}
+
+			copied := method.Call(nil)[0]
+
+			if !reflect.DeepEqual(original.Interface(), copied.Interface()) {
+				for i := 0; i < original.Elem().NumField(); i++ {
+					field := original.Elem().Type().Field(i)
+					if !reflect.DeepEqual(original.Elem().Field(i).Interface(), copied.Elem().Field(i).Interface()) {
+						t.Errorf("%s.%s is not copied", name, field.Name)
+					}
+				}
+			}
+
+			checkNotShared(t, name, original, copied)
+		})
+	}
+}
+
+// TestCopyableTypes ensures all struct types of the doc model
+// are listed in copyableTypes, for TestCopyIsComplete to check them.
+func TestCopyableTypes(t *testing.T) {
+
+	listed := make(map[reflect.Type]bool)
+	for _, typ := range copyableTypes {
+		listed[reflect.TypeOf(typ).Elem()] = true
+	}
+
+	var check func(typ reflect.Type, path string, visited map[reflect.Type]bool)
+	check = func(typ reflect.Type, path string, visited map[reflect.Type]bool) {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			check(typ.Elem(), path, visited)
+		case reflect.Struct:
+			if visited[typ] {
+				return
+			}
+			visited[typ] = true
+			if !listed[typ] {
+				t.Errorf("%s (%s) is not listed in copyableTypes", typ.Name(), path)
+			}
+			for i := 0; i < typ.NumField(); i++ {
+				check(typ.Field(i).Type, path+"."+typ.Field(i).Name, visited)
+			}
+		}
+	}
+
+	visited := make(map[reflect.Type]bool)
+	for _, root := range []interface{}{&Doc{}, &Page{}, &Module{}} {
+		check(reflect.TypeOf(root), reflect.TypeOf(root).Elem().Name(), visited)
+	}
+}
+
+// TestSanitizeInheritedMembers ensures that sanitizing a doc
+// doesn't affect the page it's been built from, or its base.
+func TestSanitizeInheritedMembers(t *testing.T) {
+
+	typeRoutes = map[string]string{"Object": "/reference/object"}
+
+	object := &Page{
+		Type:      "Object",
+		Functions: []*Function{{Name: "AddChild", Description: "Adds a child to [This]."}},
+	}
+	shape := &Page{Type: "Shape", Extends: "Object"}
+	shape.SetExtentionBase(object)
+
+	for i := 0; i < 2; i++ {
+		doc := shape.Doc()
+		doc.Sanitize()
+		doc.Sanitize()
+
+		expected := `Adds a child to <a class="type" href="#type-shape">Shape</a>.`
+		if result := string(doc.Types[0].BaseFunctions["Object"][0].Description[0].TextHTML); result != expected {
+			t.Errorf("got %s, want %s", result, expected)
+		}
+	}
+
+	if object.Functions[0].Description != "Adds a child to [This]." {
+		t.Errorf("base page has been modified: %q", object.Functions[0].Description)
+	}
+}
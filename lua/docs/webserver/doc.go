@@ -0,0 +1,444 @@
+package main
+
+import (
+	"html/template"
+	"sort"
+
+	"github.com/gosimple/slug"
+)
+
+// DocKind indicates how a Doc has been written,
+// which defines how it should be presented.
+type DocKind int
+
+const (
+	// DocKindPage is a documentation page written in YAML
+	// (reference types, guides, etc.)
+	DocKindPage DocKind = iota
+	// DocKindModule is generated in JSON from Lua modules inline documentation
+	DocKindModule
+)
+
+// Doc is the representation all documentation content is converted to,
+// whatever the source format (see Page and Module).
+// Templates, exports and content checks only deal with Docs.
+type Doc struct {
+	Kind DocKind
+
+	// Page title, or module name
+	Title string
+
+	// meta keywords
+	Keywords []string
+
+	// meta description, plain text
+	MetaDescription string
+
+	// Displayable content blocks (text, code sample, image...)
+	// describing the page or module.
+	Blocks []*ContentBlock
+
+	// Types described in the page or module.
+	// A reference page describes at most one type.
+	Types []*DocType
+
+	// path of the source file, relative to content directory
+	ResourcePath string
+}
+
+// DocType describes one type and its members.
+type DocType struct {
+	Name string
+
+	// Type that's being extended (optional)
+	Extends string
+
+	BasicType bool
+
+	// Indicates that instances can be created, even if there's no constructor
+	Creatable bool
+
+	Description []*ContentBlock
+
+	Constructors []*DocFunction
+
+	BuiltIns []*DocProperty
+
+	Functions []*DocFunction
+
+	Properties []*DocProperty
+
+	// Functions from extended types, indexed by type name
+	BaseFunctions map[string][]*DocFunction
+
+	// Properties from extended types, indexed by type name
+	BaseProperties map[string][]*DocProperty
+}
+
+type DocFunction struct {
+	Name string
+	// Sets of parameters accepted by the function,
+	// there's only one in most cases.
+	ParameterSets [][]*DocParameter
+	Description   []*ContentBlock
+	Samples       []*Sample
+	Return        []*DocValue
+	ComingSoon    bool
+	Hide          bool
+}
+
+type DocParameter struct {
+	Name string
+	// Using array because the same parameter can be of several types.
+	Types       []string
+	Description string
+	Optional    bool
+}
+
+type DocValue struct {
+	// Using array because the same value can be of several types.
+	Types       []string
+	Description string
+}
+
+type DocProperty struct {
+	Name string
+	// Using array because the same property can be of several types.
+	Types       []string
+	Description []*ContentBlock
+	Samples     []*Sample
+	ReadOnly    bool
+	ComingSoon  bool
+	Hide        bool
+}
+
+// Only one attribute can be set, others will
+// be ignored if set.
+type ContentBlock struct {
+	Text string `yaml:"text,omitempty" json:"text,omitempty"`
+	// Lua code
+	Code     string   `yaml:"code,omitempty" json:"code,omitempty"`
+	List     []string `yaml:"list,omitempty" json:"list,omitempty"`
+	Title    string   `yaml:"title,omitempty" json:"title,omitempty"`
+	Subtitle string   `yaml:"subtitle,omitempty" json:"subtitle,omitempty"`
+	// Can be a relative link to an image (png / jpeg)
+	Image string `yaml:"image,omitempty" json:"image,omitempty"`
+	// Can be a relative link to a movie, a link to a youtube video...
+	Media string `yaml:"media,omitempty" json:"media,omitempty"`
+	// Keys couple:
+	//  title: Display name for the audio player
+	//  file: Relative link to a sound file (.mp3)
+	Audio map[string]string `yaml:"audio,omitempty" json:"audio,omitempty"`
+	// List of key couples:
+	//  title: Display name for the audio player
+	//  file: Relative link to a sound file (.mp3)
+	AudioList []map[string]string `yaml:"audiolist,omitempty" json:"audiolist,omitempty"`
+
+	// HTML rendering of Text and List items, set by Sanitize
+	TextHTML template.HTML   `yaml:"-" json:"-"`
+	ListHTML []template.HTML `yaml:"-" json:"-"`
+}
+
+type Sample struct {
+	Code  string `yaml:"code,omitempty"`
+	Media string `yaml:"media,omitempty"`
+}
+
+func (s *Sample) Copy() *Sample {
+	sample := &Sample{
+		Code:  s.Code,
+		Media: s.Media,
+	}
+	return sample
+}
+
+func SampleHasCodeAndMedia(s *Sample) bool {
+	return s.Code != "" && s.Media != ""
+}
+
+func GetAnchorLink(s string) string {
+	return slug.Make(s)
+}
+
+func (b *ContentBlock) Copy() *ContentBlock {
+	block := &ContentBlock{
+		Text:     b.Text,
+		Code:     b.Code,
+		List:     copyStrings(b.List),
+		Title:    b.Title,
+		Subtitle: b.Subtitle,
+		Image:    b.Image,
+		Media:    b.Media,
+		Audio:    copyStringMap(b.Audio),
+		TextHTML: b.TextHTML,
+	}
+
+	if b.ListHTML != nil {
+		block.ListHTML = append(make([]template.HTML, 0, len(b.ListHTML)), b.ListHTML...)
+	}
+
+	if b.AudioList != nil {
+		block.AudioList = make([]map[string]string, 0, len(b.AudioList))
+		for _, audio := range b.AudioList {
+			block.AudioList = append(block.AudioList, copyStringMap(audio))
+		}
+	}
+
+	return block
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// copyAll returns a slice containing copies of all elements,
+// or nil if s is nil.
+func copyAll[T interface{ Copy() T }](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	c := make([]T, 0, len(s))
+	for _, e := range s {
+		c = append(c, e.Copy())
+	}
+	return c
+}
+
+// copySets copies sets of elements (like function parameter sets).
+func copySets[T interface{ Copy() T }](sets [][]T) [][]T {
+	if sets == nil {
+		return nil
+	}
+	c := make([][]T, 0, len(sets))
+	for _, set := range sets {
+		c = append(c, copyAll(set))
+	}
+	return c
+}
+
+// copyByKey copies elements indexed by key (like base functions indexed by type name).
+func copyByKey[T interface{ Copy() T }](m map[string][]T) map[string][]T {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string][]T, len(m))
+	for k, v := range m {
+		c[k] = copyAll(v)
+	}
+	return c
+}
+
+// textBlocks returns a description made of a single text block,
+// or nil if the text is empty.
+func textBlocks(text string) []*ContentBlock {
+	if text == "" {
+		return nil
+	}
+	return []*ContentBlock{{Text: text}}
+}
+
+// Copy returns a deep copy of the doc.
+func (d *Doc) Copy() *Doc {
+	return &Doc{
+		Kind:            d.Kind,
+		Title:           d.Title,
+		Keywords:        copyStrings(d.Keywords),
+		MetaDescription: d.MetaDescription,
+		Blocks:          copyAll(d.Blocks),
+		Types:           copyAll(d.Types),
+		ResourcePath:    d.ResourcePath,
+	}
+}
+
+func (t *DocType) Copy() *DocType {
+	return &DocType{
+		Name:           t.Name,
+		Extends:        t.Extends,
+		BasicType:      t.BasicType,
+		Creatable:      t.Creatable,
+		Description:    copyAll(t.Description),
+		Constructors:   copyAll(t.Constructors),
+		BuiltIns:       copyAll(t.BuiltIns),
+		Functions:      copyAll(t.Functions),
+		Properties:     copyAll(t.Properties),
+		BaseFunctions:  copyByKey(t.BaseFunctions),
+		BaseProperties: copyByKey(t.BaseProperties),
+	}
+}
+
+func (f *DocFunction) Copy() *DocFunction {
+	return &DocFunction{
+		Name:          f.Name,
+		ParameterSets: copySets(f.ParameterSets),
+		Description:   copyAll(f.Description),
+		Samples:       copyAll(f.Samples),
+		Return:        copyAll(f.Return),
+		ComingSoon:    f.ComingSoon,
+		Hide:          f.Hide,
+	}
+}
+
+func (p *DocParameter) Copy() *DocParameter {
+	return &DocParameter{
+		Name:        p.Name,
+		Types:       copyStrings(p.Types),
+		Description: p.Description,
+		Optional:    p.Optional,
+	}
+}
+
+func (v *DocValue) Copy() *DocValue {
+	return &DocValue{
+		Types:       copyStrings(v.Types),
+		Description: v.Description,
+	}
+}
+
+func (p *DocProperty) Copy() *DocProperty {
+	return &DocProperty{
+		Name:        p.Name,
+		Types:       copyStrings(p.Types),
+		Description: copyAll(p.Description),
+		Samples:     copyAll(p.Samples),
+		ReadOnly:    p.ReadOnly,
+		ComingSoon:  p.ComingSoon,
+		Hide:        p.Hide,
+	}
+}
+
+// GetTitle returns best possible title for the doc
+func (d *Doc) GetTitle() string {
+	if d.Title != "" {
+		return d.Title
+	}
+	if len(d.Types) > 0 {
+		return d.Types[0].Name
+	}
+	return ""
+}
+
+// MainType returns the first type described in the doc,
+// or an empty type if there's none.
+func (d *Doc) MainType() *DocType {
+	if len(d.Types) > 0 {
+		return d.Types[0]
+	}
+	return &DocType{}
+}
+
+// IsNotCreatableObject returns true if the type describes an object
+// that can't be created, has to be accessed through its global variable.
+func (t *DocType) IsNotCreatableObject() bool {
+	return t.Creatable == false && t.BasicType == false && t.Name != "" && len(t.Constructors) == 0
+}
+
+// Parameters returns the first set of parameters
+func (f *DocFunction) Parameters() []*DocParameter {
+	if len(f.ParameterSets) > 0 {
+		return f.ParameterSets[0]
+	}
+	return nil
+}
+
+// sanitizeBlocks renders text and list markup into HTML.
+// currentType is the type [This] refers to.
+func sanitizeBlocks(blocks []*ContentBlock, currentType string) {
+	for _, b := range blocks {
+		b.TextHTML = ""
+		b.ListHTML = nil
+		if b.Text != "" {
+			b.TextHTML = renderMarkup(b.Text, currentType)
+		}
+		if b.List != nil {
+			b.ListHTML = make([]template.HTML, 0, len(b.List))
+			for _, item := range b.List {
+				b.ListHTML = append(b.ListHTML, renderMarkup(item, currentType))
+			}
+		}
+	}
+}
+
+func (f *DocFunction) Sanitize(currentType string) {
+	sanitizeBlocks(f.Description, currentType)
+}
+
+func (p *DocProperty) Sanitize(currentType string) {
+	sanitizeBlocks(p.Description, currentType)
+}
+
+// Sanitize renders all descriptions into HTML and sorts type members by name.
+func (d *Doc) Sanitize() {
+
+	d.MetaDescription = stripMarkup(d.MetaDescription)
+	sanitizeBlocks(d.Blocks, d.MainType().Name)
+
+	for _, t := range d.Types {
+		t.Sanitize()
+	}
+}
+
+// Sanitize renders all descriptions into HTML and sorts members by name.
+func (t *DocType) Sanitize() {
+
+	sanitizeBlocks(t.Description, t.Name)
+
+	for _, c := range t.Constructors {
+		c.Sanitize(t.Name)
+	}
+
+	for _, b := range t.BuiltIns {
+		b.Sanitize(t.Name)
+	}
+
+	for _, f := range t.Functions {
+		f.Sanitize(t.Name)
+	}
+
+	for _, p := range t.Properties {
+		p.Sanitize(t.Name)
+	}
+
+	for _, functions := range t.BaseFunctions {
+		for _, f := range functions {
+			f.Sanitize(t.Name)
+		}
+	}
+
+	for _, properties := range t.BaseProperties {
+		for _, p := range properties {
+			p.Sanitize(t.Name)
+		}
+	}
+
+	sort.Sort(FunctionsByName(t.Functions))
+	sort.Sort(PropertiesByName(t.Properties))
+	sort.Sort(PropertiesByName(t.BuiltIns))
+}
+
+// sort.Interface implementations
+
+type PropertiesByName []*DocProperty
+
+func (a PropertiesByName) Len() int           { return len(a) }
+func (a PropertiesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a PropertiesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type FunctionsByName []*DocFunction
+
+func (a FunctionsByName) Len() int           { return len(a) }
+func (a FunctionsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a FunctionsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDocSanitize(t *testing.T) {
+
+	typeRoutes = map[string]string{
+		"Object": "/reference/object",
+		"Shape":  "/reference/shape",
+	}
+
+	doc := &Doc{
+		MetaDescription: "A [Shape] is an [Object] made of `blocks`.\nSee <b>docs</b>.",
+		Blocks:          []*ContentBlock{{Text: "About [This]."}},
+		Types: []*DocType{
+			{
+				Name:        "Shape",
+				Description: []*ContentBlock{{Text: "[This] <script>"}, {List: []string{"`a`", "[Object]"}}},
+				Functions: []*DocFunction{
+					{Name: "GetBlock", Description: []*ContentBlock{{Text: "In [This]."}}},
+					{Name: "AddBlock"},
+				},
+				Properties: []*DocProperty{
+					{Name: "Width"},
+					{Name: "Depth", Description: []*ContentBlock{{Text: "`Depth`"}}},
+				},
+				BuiltIns: []*DocProperty{
+					{Name: "b"},
+					{Name: "a"},
+				},
+				BaseFunctions: map[string][]*DocFunction{
+					"Object": {
+						{Name: "RemoveChild", Description: []*ContentBlock{{Text: "From [This]."}}},
+						{Name: "AddChild"},
+					},
+				},
+			},
+		},
+	}
+
+	doc.Sanitize()
+
+	tests := []struct {
+		name     string
+		result   string
+		expected string
+	}{
+		{"meta description", doc.MetaDescription, "A Shape is an Object made of blocks. See docs."},
+		{"doc blocks", string(doc.Blocks[0].TextHTML), `About <a class="type" href="/reference/shape">Shape</a>.`},
+		{"type description", string(doc.Types[0].Description[0].TextHTML), `<a class="type" href="/reference/shape">Shape</a> &lt;script&gt;`},
+		{"list item", string(doc.Types[0].Description[1].ListHTML[0]), `<span class="code">a</span>`},
+		{"list type link", string(doc.Types[0].Description[1].ListHTML[1]), `<a class="type" href="/reference/object">Object</a>`},
+		{"function", string(doc.Types[0].Functions[1].Description[0].TextHTML), `In <a class="type" href="/reference/shape">Shape</a>.`},
+		{"property", string(doc.Types[0].Properties[0].Description[0].TextHTML), `<span class="code">Depth</span>`},
+		{"base function", string(doc.Types[0].BaseFunctions["Object"][0].Description[0].TextHTML), `From <a class="type" href="/reference/shape">Shape</a>.`},
+		{"sorted functions", doc.Types[0].Functions[0].Name + "," + doc.Types[0].Functions[1].Name, "AddBlock,GetBlock"},
+		{"sorted properties", doc.Types[0].Properties[0].Name + "," + doc.Types[0].Properties[1].Name, "Depth,Width"},
+		{"sorted built-ins", doc.Types[0].BuiltIns[0].Name + "," + doc.Types[0].BuiltIns[1].Name, "a,b"},
+		{"base functions keep order", doc.Types[0].BaseFunctions["Object"][0].Name, "RemoveChild"},
+		{"text markup is kept", doc.Types[0].Description[0].Text, "[This] <script>"},
+	}
+
+	for _, test := range tests {
+		if test.result != test.expected {
+			t.Errorf("%s: got %q, want %q", test.name, test.result, test.expected)
+		}
+	}
+
+	// Sanitize can be called again, rendering from source markup
+	doc.Sanitize()
+	if result := string(doc.Blocks[0].TextHTML); result != `About <a class="type" href="/reference/shape">Shape</a>.` {
+		t.Errorf("second Sanitize: got %q", result)
+	}
+}
+
+func TestDocGetTitle(t *testing.T) {
+	tests := []struct {
+		doc      *Doc
+		expected string
+	}{
+		{&Doc{}, ""},
+		{&Doc{Title: "Guides"}, "Guides"},
+		{&Doc{Types: []*DocType{{Name: "Shape"}}}, "Shape"},
+		{&Doc{Title: "Guides", Types: []*DocType{{Name: "Shape"}}}, "Guides"},
+	}
+
+	for _, test := range tests {
+		if result := test.doc.GetTitle(); result != test.expected {
+			t.Errorf("GetTitle() = %q, want %q", result, test.expected)
+		}
+	}
+}
+
+func TestIsNotCreatableObject(t *testing.T) {
+	tests := []struct {
+		name     string
+		docType  *DocType
+		expected bool
+	}{
+		{"no type", &DocType{}, false},
+		{"global", &DocType{Name: "Camera"}, true},
+		{"basic type", &DocType{Name: "number", BasicType: true}, false},
+		{"creatable", &DocType{Name: "Box", Creatable: true}, false},
+		{"constructor", &DocType{Name: "Shape", Constructors: []*DocFunction{{}}}, false},
+	}
+
+	for _, test := range tests {
+		if result := test.docType.IsNotCreatableObject(); result != test.expected {
+			t.Errorf("%s: IsNotCreatableObject() = %v, want %v", test.name, result, test.expected)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 module cu.bzh/lua/docs/webserver
 
-go 1.20
+go 1.22.6
 
 require (
+	cu.bzh/tools/shapefile v0.0.0
 	github.com/gosimple/slug v1.13.1
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require github.com/gosimple/unidecode v1.0.1 // indirect
+
+replace cu.bzh/tools/shapefile => ../../../tools/shapefile
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/render"
+)
+
+// Items are .3zh files found in content/media.
+// Image blocks can reference them, to show their preview
+// along with a panel describing them.
+
+const (
+	itemExtension        = ".3zh"
+	itemPreviewExtension = ".png"
+	// directory from which items can be served
+	itemDirectory = "media"
+)
+
+var (
+	// key: route of the .3zh file (/media/...), value: item
+	items map[string]*Item
+
+	// decoded items, kept between calls to parseContent,
+	// key: path of the .3zh file
+	itemCache      = make(map[string]*cachedItem)
+	itemCacheMutex sync.Mutex
+)
+
+// cachedItem is the result of loadItem for a given version of a file.
+type cachedItem struct {
+	modTime time.Time
+	size    int64
+	item    *Item
+	err     error
+}
+
+// Item describes a .3zh file.
+type Item struct {
+	Name string
+	// route of the .3zh file
+	Path string
+	// route of the preview image
+	PreviewPath string
+	// preview found in the file, or rendered when first requested
+	preview     []byte
+	previewOnce sync.Once
+	file        *shapefile.File
+
+	Width      int
+	Height     int
+	Depth      int
+	BlockCount int
+	// CSS colors of the palette
+	Colors []string
+	// root shape, children are sub shapes
+	Shape *ItemShape
+}
+
+// ItemShape is a shape of an item.
+type ItemShape struct {
+	Name       string
+	Width      int
+	Height     int
+	Depth      int
+	BlockCount int
+	Points     []*ItemPoint
+	Children   []*ItemShape
+}
+
+// ItemPoint is a named point of a shape.
+type ItemPoint struct {
+	Name string
+	X    float32
+	Y    float32
+	Z    float32
+}
+
+// isItemPath returns true if a content path refers to a .3zh file.
+func isItemPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), itemExtension)
+}
+
+// loadItem decodes the .3zh file at walkPath, served at route.
+func loadItem(walkPath string, route string) (*Item, error) {
+
+	f, err := shapefile.ReadFile(walkPath)
+	if err != nil {
+		return nil, err
+	}
+
+	root := f.Root()
+	if root == nil {
+		return nil, fmt.Errorf("no shape")
+	}
+
+	item := &Item{
+		Name:        strings.TrimSuffix(filepath.Base(route), filepath.Ext(route)),
+		Path:        route,
+		PreviewPath: route + itemPreviewExtension,
+		preview:     f.Preview(),
+		file:        f,
+		Width:       int(root.Width),
+		Height:      int(root.Height),
+		Depth:       int(root.Depth),
+		Colors:      make([]string, 0),
+	}
+
+	for _, s := range f.Shapes() {
+		item.BlockCount += s.BlockCount()
+	}
+
+	palette := root.Palette
+	if palette == nil {
+		palette = f.Palette()
+	}
+	if palette != nil {
+		for _, c := range palette.Colors {
+			item.Colors = append(item.Colors, fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A))
+		}
+	}
+
+	item.Shape = newItemShape(f, root, make(map[*shapefile.Shape]bool))
+
+	return item, nil
+}
+
+// loadCachedItem is like loadItem, but files are only decoded again
+// when they've been modified since last call.
+// In DEBUG, content is parsed for each request.
+func loadCachedItem(walkPath string, route string) (*Item, error) {
+
+	info, err := os.Stat(walkPath)
+	if err != nil {
+		return nil, err
+	}
+
+	itemCacheMutex.Lock()
+	defer itemCacheMutex.Unlock()
+
+	cached, ok := itemCache[walkPath]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.item, cached.err
+	}
+
+	item, err := loadItem(walkPath, route)
+	itemCache[walkPath] = &cachedItem{modTime: info.ModTime(), size: info.Size(), item: item, err: err}
+	return item, err
+}
+
+func newItemShape(f *shapefile.File, s *shapefile.Shape, visited map[*shapefile.Shape]bool) *ItemShape {
+
+	visited[s] = true
+
+	itemShape := &ItemShape{
+		Name:       s.Name,
+		Width:      int(s.Width),
+		Height:     int(s.Height),
+		Depth:      int(s.Depth),
+		BlockCount: s.BlockCount(),
+		Points:     make([]*ItemPoint, 0),
+		Children:   make([]*ItemShape, 0),
+	}
+
+	if itemShape.Name == "" {
+		itemShape.Name = fmt.Sprintf("shape #%d", s.ID)
+	}
+
+	for _, p := range s.Points {
+		itemShape.Points = append(itemShape.Points, &ItemPoint{Name: p.Name, X: p.Value.X, Y: p.Value.Y, Z: p.Value.Z})
+	}
+
+	for _, child := range f.Children(s) {
+		// corrupted files could have cycles
+		if !visited[child] {
+			itemShape.Children = append(itemShape.Children, newItemShape(f, child, visited))
+		}
+	}
+
+	return itemShape
+}
+
+// previewImage returns the PNG preview of the item,
+// rendering it if the file doesn't contain one.
+func (item *Item) previewImage() ([]byte, error) {
+	var err error
+	item.previewOnce.Do(func() {
+		if item.preview != nil {
+			return
+		}
+		var buf bytes.Buffer
+		err = render.EncodePNG(&buf, item.file, render.DefaultOptions())
+		if err == nil {
+			item.preview = buf.Bytes()
+		}
+	})
+	if item.preview == nil && err == nil {
+		err = fmt.Errorf("can't render preview")
+	}
+	return item.preview, err
+}
+
+// GetItem returns the item found at given path, nil if there's none.
+// Used by templates to render image blocks referencing .3zh files.
+// Paths are relative to the content directory, with or without
+// leading "/" or "./".
+func GetItem(itemPath string) *Item {
+	if !isItemPath(itemPath) {
+		return nil
+	}
+	return items[path.Clean("/"+itemPath)]
+}
+
+// itemPreviewHandler serves item previews (/media/item.3zh.png),
+// other requests are passed to next.
+func itemPreviewHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		itemPath := strings.TrimSuffix(r.URL.Path, itemPreviewExtension)
+		if itemPath == r.URL.Path || !isItemPath(itemPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if debug {
+			parseContent()
+		}
+
+		item, ok := items[itemPath]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		preview, err := item.previewImage()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(preview)
+	})
+}
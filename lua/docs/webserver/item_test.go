@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cu.bzh/tools/shapefile"
+)
+
+func TestLoadItem(t *testing.T) {
+
+	item, err := loadItem(filepath.Join(fixtureContentDirectory, "media", "chest.3zh"), "/media/chest.3zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "chest" || item.PreviewPath != "/media/chest.3zh.png" || len(item.preview) == 0 {
+		t.Errorf("name %q, preview path %q", item.Name, item.PreviewPath)
+	}
+	if item.Width != 3 || item.Height != 2 || item.Depth != 3 || item.BlockCount != 13 {
+		t.Errorf("size %dx%dx%d, %d blocks", item.Width, item.Height, item.Depth, item.BlockCount)
+	}
+	if !reflect.DeepEqual(item.Colors, []string{"#8b4513ff", "#ffd700ff"}) {
+		t.Errorf("colors %v", item.Colors)
+	}
+
+	expectedShape := &ItemShape{
+		Name: "chest", Width: 3, Height: 2, Depth: 3, BlockCount: 10,
+		Points: []*ItemPoint{{Name: "ModelPoint_Hand", X: 1.5, Y: 2, Z: 0.5}},
+		Children: []*ItemShape{
+			{Name: "lid", Width: 3, Height: 1, Depth: 3, BlockCount: 3, Points: []*ItemPoint{}, Children: []*ItemShape{}},
+		},
+	}
+	if !reflect.DeepEqual(item.Shape, expectedShape) {
+		t.Errorf("shape tree:\n got: %+v\nwant: %+v", item.Shape, expectedShape)
+	}
+}
+
+func TestLoadItemWithoutPreview(t *testing.T) {
+
+	root := shapefile.NewShape(1, 1, 1)
+	root.ID = 1
+	root.SetBlock(0, 0, 0, 0)
+
+	f := &shapefile.File{Chunks: []shapefile.Chunk{
+		&shapefile.Preview{},
+		&shapefile.PaletteChunk{Palette: &shapefile.Palette{Colors: []shapefile.Color{{R: 1, G: 2, B: 3, A: 255}}}},
+		root,
+	}}
+
+	path := filepath.Join(t.TempDir(), "cube.3zh")
+	if err := shapefile.WriteFile(path, f); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := loadItem(path, "/media/cube.3zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// preview is rendered
+	if item.PreviewPath != "/media/cube.3zh.png" {
+		t.Errorf("preview path %q", item.PreviewPath)
+	}
+	preview, err := item.previewImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(preview)); err != nil {
+		t.Errorf("rendered preview: %v", err)
+	}
+	if !reflect.DeepEqual(item.Colors, []string{"#010203ff"}) {
+		t.Errorf("colors %v, want file palette", item.Colors)
+	}
+	if item.Shape.Name != "shape #1" {
+		t.Errorf("unnamed shape %q", item.Shape.Name)
+	}
+}
+
+func TestLoadInvalidItem(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "invalid.3zh")
+	if err := os.WriteFile(path, []byte("version https://git-lfs.github.com/spec/v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadItem(path, "/media/invalid.3zh"); err == nil {
+		t.Errorf("no error")
+	}
+}
+
+func TestParseContentWithInvalidItem(t *testing.T) {
+	setupFixtureContent(t)
+
+	contentDirectory = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(contentDirectory, itemDirectory), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(contentDirectory, itemDirectory, "broken.3zh"), []byte("3ZH broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parseContent(); err != nil {
+		t.Fatal(err)
+	}
+	if GetItem("/media/broken.3zh") != nil {
+		t.Errorf("invalid item should be skipped")
+	}
+}
+
+func TestLoadCachedItem(t *testing.T) {
+
+	root := shapefile.NewShape(1, 1, 1)
+	root.ID = 1
+	root.SetBlock(0, 0, 0, 0)
+
+	path := filepath.Join(t.TempDir(), "cube.3zh")
+	if err := shapefile.WriteFile(path, &shapefile.File{Chunks: []shapefile.Chunk{root}}); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := loadCachedItem(path, "/media/cube.3zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := loadCachedItem(path, "/media/cube.3zh"); cached != item {
+		t.Errorf("item decoded again while file didn't change")
+	}
+
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := loadCachedItem(path, "/media/cube.3zh"); changed == item || changed == nil {
+		t.Errorf("item not decoded again after file change")
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	setupFixtureContent(t)
+
+	for _, path := range []string{"/media/chest.3zh", "media/chest.3zh", "./media/chest.3zh", "/media/../media/chest.3zh", "//media/chest.3zh"} {
+		if GetItem(path) == nil {
+			t.Errorf("%s: item not found", path)
+		}
+	}
+	if GetItem("/media/missing.3zh") != nil || GetItem("/images/logo.png") != nil || GetItem("chest.3zh") != nil {
+		t.Errorf("unexpected item")
+	}
+}
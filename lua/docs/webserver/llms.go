@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Plain-text export of the documentation for coding assistants,
+// following the llms.txt convention (https://llmstxt.org).
+// llms.txt is an index linking to one Markdown file per type / module,
+// llms-full.txt contains all these files concatenated.
+
+const (
+	llmsIndexFile = "llms.txt"
+	llmsFullFile  = "llms-full.txt"
+)
+
+var (
+	reHTMLTag       = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9]*)(\s[^<>]*)?/?>`)
+	reHTMLHref      = regexp.MustCompile(`\bhref="([^"]*)"`)
+	reHTMLClass     = regexp.MustCompile(`\bclass="([^"]*)"`)
+	reHTMLSpaces    = regexp.MustCompile(`\s+`)
+	reMarkdownHTML  = regexp.MustCompile(`<([a-zA-Z/!?])`)
+	reTrailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	reBlankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText converts HTML descriptions to Markdown text.
+// Type links become plain type names, other links, inline code, code blocks,
+// bold and italic text and lists are turned into their Markdown equivalent.
+// Scripts and styles are dropped with their content, other tags are stripped.
+// Entities are decoded, except for "<" starting what Markdown would read as HTML.
+func htmlToText(s string) string {
+	var sb strings.Builder
+
+	newLine := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+
+	// element skipped with its content (script or style)
+	skip := ""
+	// inside <pre> and inline code
+	pre, code := false, false
+	// for each open <span>, whether it is inline code
+	spans := make([]bool, 0)
+	// for each open list, number of items for <ol>, -1 for <ul>
+	lists := make([]int, 0)
+	// URL of the link being written, "" for type links
+	link := ""
+
+	writeText := func(text string) {
+		switch {
+		case pre:
+			sb.WriteString(html.UnescapeString(text))
+		case code:
+			sb.WriteString(html.UnescapeString(reHTMLSpaces.ReplaceAllString(text, " ")))
+		default:
+			text = reHTMLSpaces.ReplaceAllString(text, " ")
+			if strings.HasPrefix(text, " ") && (sb.Len() == 0 || strings.HasSuffix(sb.String(), "\n") || strings.HasSuffix(sb.String(), " ")) {
+				text = text[1:]
+			}
+			sb.WriteString(reMarkdownHTML.ReplaceAllString(html.UnescapeString(text), "&lt;$1"))
+		}
+	}
+
+	end := 0
+	for _, m := range reHTMLTag.FindAllStringSubmatchIndex(s, -1) {
+		text := s[end:m[0]]
+		end = m[1]
+		closing := m[3] > m[2]
+		name := strings.ToLower(s[m[4]:m[5]])
+		attributes := ""
+		if m[6] >= 0 {
+			attributes = s[m[6]:m[7]]
+		}
+
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+		writeText(text)
+
+		// code blocks only keep their text and line breaks
+		if pre && name != "pre" && name != "br" && name != "script" && name != "style" {
+			continue
+		}
+
+		switch name {
+		case "script", "style":
+			if !closing {
+				skip = name
+			}
+		case "br":
+			sb.WriteString("\n")
+		case "pre":
+			newLine()
+			sb.WriteString("```\n")
+			pre = !closing
+		case "code":
+			sb.WriteString("`")
+			code = !closing
+		case "span":
+			if closing {
+				if len(spans) > 0 && spans[len(spans)-1] {
+					sb.WriteString("`")
+					code = false
+				}
+				if len(spans) > 0 {
+					spans = spans[:len(spans)-1]
+				}
+				break
+			}
+			isCode := false
+			if c := reHTMLClass.FindStringSubmatch(attributes); c != nil && c[1] == "code" {
+				isCode = true
+				sb.WriteString("`")
+				code = true
+			}
+			spans = append(spans, isCode)
+		case "a":
+			if closing {
+				if link != "" {
+					sb.WriteString("](" + link + ")")
+				}
+				link = ""
+				break
+			}
+			if c := reHTMLClass.FindStringSubmatch(attributes); c != nil && c[1] == "type" {
+				break
+			}
+			if h := reHTMLHref.FindStringSubmatch(attributes); h != nil {
+				link = html.UnescapeString(h[1])
+				sb.WriteString("[")
+			}
+		case "b", "strong":
+			sb.WriteString("**")
+		case "i", "em":
+			sb.WriteString("*")
+		case "ul", "ol":
+			newLine()
+			if closing {
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+			} else if name == "ol" {
+				lists = append(lists, 0)
+			} else {
+				lists = append(lists, -1)
+			}
+		case "li":
+			if closing {
+				break
+			}
+			newLine()
+			if len(lists) == 0 {
+				sb.WriteString("- ")
+				break
+			}
+			sb.WriteString(strings.Repeat("  ", len(lists)-1))
+			if n := lists[len(lists)-1]; n >= 0 {
+				lists[len(lists)-1]++
+				sb.WriteString(fmt.Sprintf("%d. ", n+1))
+			} else {
+				sb.WriteString("- ")
+			}
+		case "p", "div":
+			newLine()
+			sb.WriteString("\n")
+		}
+	}
+	if skip == "" {
+		writeText(s[end:])
+	}
+
+	text := reTrailingSpace.ReplaceAllString(sb.String(), "\n")
+	text = reBlankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// llmsPath returns the path of the Markdown file
+// describing the page found at given route.
+func llmsPath(route string) string {
+	if route == "/" {
+		return "/index.md"
+	}
+	return route + ".md"
+}
+
+// llmsRoutes returns sorted routes of docs that should be exported:
+// pages describing a type and modules.
+func llmsRoutes() []string {
+	routes := make([]string, 0)
+	for route, doc := range docs {
+		if doc.Kind == DocKindModule || len(doc.Types) > 0 {
+			routes = append(routes, route)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// llmsMarkdown returns Markdown for the doc found at given route.
+func llmsMarkdown(route string) (string, bool) {
+	if doc, ok := docs[route]; ok && (doc.Kind == DocKindModule || len(doc.Types) > 0) {
+		return docMarkdown(doc), true
+	}
+	return "", false
+}
+
+// llmsIndex builds llms.txt content.
+func llmsIndex() string {
+	var sb strings.Builder
+
+	sb.WriteString("# Cubzh\n\n")
+	sb.WriteString("> Cubzh scripting documentation: the Lua API available to create games (reference types) and the Lua modules that can be required.\n\n")
+
+	reference := make([]string, 0)
+	modules := make([]string, 0)
+
+	for _, route := range llmsRoutes() {
+		if docs[route].Kind == DocKindModule {
+			modules = append(modules, route)
+		} else {
+			reference = append(reference, route)
+		}
+	}
+
+	sb.WriteString("## Reference\n\n")
+	for _, route := range reference {
+		doc := docs[route]
+		sb.WriteString("- [" + doc.GetTitle() + "](" + llmsPath(route) + ")")
+		if doc.MetaDescription != "" {
+			sb.WriteString(": " + firstLine(doc.MetaDescription))
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("\n## Modules\n\n")
+	for _, route := range modules {
+		doc := docs[route]
+		sb.WriteString("- [" + doc.GetTitle() + "](" + llmsPath(route) + ")\n")
+	}
+
+	return sb.String()
+}
+
+// llmsFull builds llms-full.txt content.
+func llmsFull() string {
+	var sb strings.Builder
+	for i, route := range llmsRoutes() {
+		if i > 0 {
+			sb.WriteString("\n---\n\n")
+		}
+		md, _ := llmsMarkdown(route)
+		sb.WriteString(md)
+	}
+	return sb.String()
+}
+
+// exportLLMs writes llms.txt, llms-full.txt and
+// one Markdown file per type / module in given directory.
+func exportLLMs(dir string) error {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, llmsIndexFile), []byte(llmsIndex()), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, llmsFullFile), []byte(llmsFull()), 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, route := range llmsRoutes() {
+		md, _ := llmsMarkdown(route)
+		path := filepath.Join(dir, filepath.FromSlash(llmsPath(route)))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path, []byte(md), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func docMarkdown(d *Doc) string {
+	var sb strings.Builder
+
+	// reference pages describe one type, their members are
+	// listed at the top level, modules list each type in a section.
+	h := "##"
+
+	if d.Kind == DocKindModule {
+		sb.WriteString("# Module: " + d.GetTitle() + "\n\n")
+		sb.WriteString("```lua\nlocal " + d.GetTitle() + " = require(\"" + d.GetTitle() + "\")\n```\n\n")
+		h = "###"
+	} else {
+		sb.WriteString("# " + d.GetTitle() + "\n\n")
+	}
+
+	for _, t := range d.Types {
+		if t.Extends != "" {
+			sb.WriteString(t.Name + " extends " + t.Extends + ", adding functions and properties to it.\n\n")
+		}
+		if d.Kind == DocKindPage && t.IsNotCreatableObject() {
+			sb.WriteString(t.Name + " is not creatable, there's only one instance of it. It can only be accessed through its globally exposed variable.\n\n")
+		}
+	}
+
+	writeBlocksMarkdown(&sb, d.Blocks)
+
+	for _, t := range d.Types {
+		if d.Kind == DocKindModule {
+			sb.WriteString("## " + t.Name + "\n\n")
+		}
+		writeTypeMarkdown(&sb, t, h)
+	}
+
+	return sb.String()
+}
+
+// writeTypeMarkdown writes type description and members,
+// h being the heading prefix for member sections.
+func writeTypeMarkdown(sb *strings.Builder, t *DocType, h string) {
+
+	writeBlocksMarkdown(sb, t.Description)
+
+	if len(t.Constructors) > 0 {
+		sb.WriteString(h + " Constructors\n\n")
+		for _, c := range t.Constructors {
+			sb.WriteString("```lua\n")
+			for _, params := range functionParameterSets(c) {
+				sb.WriteString(t.Name + "(" + parametersMarkdown(params) + ")\n")
+			}
+			sb.WriteString("```\n\n")
+			writeFunctionBodyMarkdown(sb, c)
+		}
+	}
+
+	if len(t.BuiltIns) > 0 {
+		sb.WriteString(h + " Built-in instances\n\n")
+		for _, b := range t.BuiltIns {
+			writePropertyMarkdown(sb, b, "", h+"#")
+		}
+	}
+
+	if len(t.Functions) > 0 || len(t.BaseFunctions) > 0 {
+		sb.WriteString(h + " Functions\n\n")
+		for _, f := range t.Functions {
+			writeFunctionMarkdown(sb, f, "", h+"#")
+		}
+		for _, base := range sortedKeys(t.BaseFunctions) {
+			for _, f := range t.BaseFunctions[base] {
+				writeFunctionMarkdown(sb, f, base, h+"#")
+			}
+		}
+	}
+
+	if len(t.Properties) > 0 || len(t.BaseProperties) > 0 {
+		sb.WriteString(h + " Properties\n\n")
+		for _, p := range t.Properties {
+			writePropertyMarkdown(sb, p, "", h+"#")
+		}
+		for _, base := range sortedKeys(t.BaseProperties) {
+			for _, p := range t.BaseProperties[base] {
+				writePropertyMarkdown(sb, p, base, h+"#")
+			}
+		}
+	}
+}
+
+func writeFunctionMarkdown(sb *strings.Builder, f *DocFunction, base string, h string) {
+	if f.Hide {
+		return
+	}
+
+	sb.WriteString(h + " " + f.Name)
+	if base != "" {
+		sb.WriteString(" (inherited from " + base + ")")
+	}
+	sb.WriteString("\n\n")
+
+	returns := make([]string, 0)
+	for _, v := range f.Return {
+		returns = append(returns, strings.Join(v.Types, "|"))
+	}
+
+	sb.WriteString("```lua\n")
+	for _, params := range functionParameterSets(f) {
+		sb.WriteString(f.Name + "(" + parametersMarkdown(params) + ")")
+		if len(returns) > 0 {
+			sb.WriteString(" -> " + strings.Join(returns, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("```\n\n")
+
+	writeFunctionBodyMarkdown(sb, f)
+}
+
+func writeFunctionBodyMarkdown(sb *strings.Builder, f *DocFunction) {
+	if f.ComingSoon {
+		sb.WriteString("Coming soon.\n\n")
+	}
+	writeBlocksMarkdown(sb, f.Description)
+	writeSamplesMarkdown(sb, f.Samples)
+}
+
+func writePropertyMarkdown(sb *strings.Builder, p *DocProperty, base string, h string) {
+	if p.Hide {
+		return
+	}
+
+	sb.WriteString(h + " " + p.Name)
+	if base != "" {
+		sb.WriteString(" (inherited from " + base + ")")
+	}
+	sb.WriteString("\n\n")
+
+	sb.WriteString("Type: " + strings.Join(p.Types, " or "))
+	if p.ReadOnly {
+		sb.WriteString(" (read-only)")
+	}
+	if p.ComingSoon {
+		sb.WriteString(" (coming soon)")
+	}
+	sb.WriteString("\n\n")
+
+	writeBlocksMarkdown(sb, p.Description)
+	writeSamplesMarkdown(sb, p.Samples)
+}
+
+// functionParameterSets returns all accepted sets of parameters,
+// with at least one (empty) set for functions without parameters.
+func functionParameterSets(f *DocFunction) [][]*DocParameter {
+	if len(f.ParameterSets) > 0 {
+		return f.ParameterSets
+	}
+	return [][]*DocParameter{nil}
+}
+
+func parametersMarkdown(params []*DocParameter) string {
+	args := make([]string, 0)
+	for _, p := range params {
+		arg := strings.Join(p.Types, "|") + " " + p.Name
+		if p.Optional {
+			arg += " (optional)"
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, ", ")
+}
+
+func writeSamplesMarkdown(sb *strings.Builder, samples []*Sample) {
+	for _, s := range samples {
+		if s.Code != "" {
+			writeCodeMarkdown(sb, s.Code)
+		}
+	}
+}
+
+func writeCodeMarkdown(sb *strings.Builder, code string) {
+	sb.WriteString("```lua\n" + strings.TrimRight(code, "\n") + "\n```\n\n")
+}
+
+func writeBlocksMarkdown(sb *strings.Builder, blocks []*ContentBlock) {
+	for _, b := range blocks {
+		if b.Text != "" {
+			sb.WriteString(htmlToText(string(b.TextHTML)) + "\n\n")
+		} else if b.Title != "" {
+			sb.WriteString("## " + b.Title + "\n\n")
+		} else if b.Subtitle != "" {
+			sb.WriteString("### " + b.Subtitle + "\n\n")
+		} else if b.Image != "" {
+			sb.WriteString("![](" + b.Image + ")\n\n")
+		} else if b.Media != "" {
+			sb.WriteString("Video: " + b.Media + "\n\n")
+		} else if b.Audio != nil {
+			sb.WriteString(fmt.Sprintf("Audio: %s (%s)\n\n", b.Audio["title"], b.Audio["file"]))
+		} else if b.AudioList != nil {
+			for _, a := range b.AudioList {
+				sb.WriteString(fmt.Sprintf("Audio: %s (%s)\n", a["title"], a["file"]))
+			}
+			sb.WriteString("\n")
+		} else if b.Code != "" {
+			writeCodeMarkdown(sb, b.Code)
+		} else if b.List != nil {
+			for _, item := range b.ListHTML {
+				sb.WriteString("- " + htmlToText(string(item)) + "\n")
+			}
+			sb.WriteString("\n")
+		}
+	}
+}
+
+func firstLine(s string) string {
+	s = htmlToText(s)
+	if i := strings.Index(s, ". "); i >= 0 {
+		s = s[:i+1]
+	}
+	return s
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"text", "  plain text ", "plain text"},
+		{"spaces", "one\n  two\tthree", "one two three"},
+		{"break", "one<br>two<br/>three", "one\ntwo\nthree"},
+		{"bold", "<b>bold</b> and <strong>strong</strong>", "**bold** and **strong**"},
+		{"italic", "<i>italic</i> and <em>emphasis</em>", "*italic* and *emphasis*"},
+		{"other tags", "<u>under</u><div class=\"x\">lined</div>", "under\n\nlined"},
+		{"type link", `a <a class="type" href="/reference/shape">Shape</a>`, "a Shape"},
+		{"link", `see <a href="https://cu.bzh">the site</a>`, "see [the site](https://cu.bzh)"},
+		{"link with entities", `<a href="/search?a=1&amp;b=2">search</a>`, "[search](/search?a=1&b=2)"},
+		{"inline code", `call <span class="code">shape:GetBlock(x, y, z)</span>`, "call `shape:GetBlock(x, y, z)`"},
+		{"code tag", "call <code>Clear()</code>", "call `Clear()`"},
+		{"nested span in code", `<span class="code">a <span>b</span> c</span> d`, "`a b c` d"},
+
+		{"entities", "fish &amp; chips &quot;&#39;&eacute;&#x263A;", "fish & chips \"'é☺"},
+		{"entities decoded once", "&amp;lt;b&amp;gt;", "&lt;b&gt;"},
+		{"comparison", "a &lt; b &amp;&amp; b &gt; c", "a < b && b > c"},
+		{"escaped tags", "use &lt;b&gt; for bold, not &lt;/b&gt; alone", "use &lt;b> for bold, not &lt;/b> alone"},
+		{"escaped tags in code", `<span class="code">&lt;b&gt;</span>`, "`<b>`"},
+
+		{"pre", "<pre>local x = 1\n  if x &lt; 2 then\n    print(x)\n  end</pre>", "```\nlocal x = 1\n  if x < 2 then\n    print(x)\n  end\n```"},
+		{"pre with code", "before<pre><code>a &amp;&amp; b\n<b>c</b></code></pre>after", "before\n```\na && b\nc\n```\nafter"},
+
+		{"list", "<ul><li>one</li><li>two</li></ul>", "- one\n- two"},
+		{"ordered list", "<ol><li>one</li><li>two</li></ol>", "1. one\n2. two"},
+		{"formatted list", "<ul>\n  <li>one</li>\n  <li><b>two</b></li>\n</ul>", "- one\n- **two**"},
+		{"nested lists", "<ul><li>one<ul><li>a</li><li>b<ol><li>i</li></ol></li></ul></li><li>two</li></ul>", "- one\n  - a\n  - b\n    1. i\n- two"},
+		{"list after text", "items:<ul><li>one</li></ul>done", "items:\n- one\ndone"},
+
+		{"script", `before<script>alert("<b>x</b>")</script>after`, "beforeafter"},
+		{"script with attributes", `a <SCRIPT type="text/javascript">document.write(1)</SCRIPT> b`, "a b"},
+		{"style", "<style>body { color: red; }</style>text", "text"},
+		{"unclosed script", "text<script>alert(1)", "text"},
+		{"script in code block", "<pre>a<script>alert(1)</script>\nb</pre>", "```\na\nb\n```"},
+		{"script in list", "<ul><li>one<script>alert(1)</script></li></ul>", "- one"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := htmlToText(test.html); got != test.expected {
+				t.Errorf("htmlToText(%q)\n got: %q\nwant: %q", test.html, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestLLMsHandler(t *testing.T) {
+	setupFixtureContent(t)
+
+	// blocks as rendered from descriptions, with HTML
+	// that markup can't produce but that exports must handle
+	docs["/reference/html"] = &Doc{
+		Kind: DocKindPage,
+		Types: []*DocType{{
+			Name: "HTML",
+			Description: []*ContentBlock{
+				{Text: "-", TextHTML: template.HTML(`Entities: a &lt; b &amp;&amp; <span class="code">x &gt; y</span>`)},
+				{Text: "-", TextHTML: template.HTML("<pre><code>print(&quot;pre&quot;)</code></pre>")},
+				{Text: "-", TextHTML: template.HTML("<ul><li>outer<ul><li>inner</li></ul></li></ul>")},
+				{Text: "-", TextHTML: template.HTML(`<script>alert("script")</script><style>.leak { }</style>Kept.`)},
+			},
+		}},
+	}
+
+	server := httptest.NewServer(newServeMux())
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name:        "index",
+			path:        "/llms.txt",
+			contentType: "text/plain; charset=utf-8",
+			contains:    []string{"# Cubzh", "## Reference", "- [HTML](/reference/html.md)", "- [Shape](/reference/shape.md)", "## Modules", "- [sample](/modules/sample.md)"},
+		},
+		{
+			name:        "full",
+			path:        "/llms-full.txt",
+			contentType: "text/plain; charset=utf-8",
+			contains: []string{
+				"# HTML",
+				"Entities: a < b && `x > y`",
+				"```\nprint(\"pre\")\n```",
+				"- outer\n  - inner",
+				"Kept.",
+				"# Shape",
+				"# Module: sample",
+				"\n---\n",
+			},
+			excludes: []string{"<script", "&lt;script>alert(\"script\")", "alert(\"script\")", ".leak", "&amp;", "<span"},
+		},
+		{
+			name:        "markdown",
+			path:        "/reference/html.md",
+			contentType: "text/markdown; charset=utf-8",
+			contains:    []string{"# HTML", "- outer\n  - inner"},
+			excludes:    []string{"# Shape", "alert"},
+		},
+		{
+			name:        "escaped tags",
+			path:        "/reference/shape.md",
+			contentType: "text/markdown; charset=utf-8",
+			contains:    []string{`Don't use &lt;script>alert("shapes")&lt;/script> in descriptions.`},
+			excludes:    []string{"<script"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := get(t, server, test.path)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s: status %d", test.path, resp.StatusCode)
+			}
+			if resp.Header.Get("Content-Type") != test.contentType {
+				t.Errorf("GET %s: content type %q, want %q", test.path, resp.Header.Get("Content-Type"), test.contentType)
+			}
+			for _, s := range test.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("GET %s: body does not contain %q:\n%s", test.path, s, body)
+				}
+			}
+			for _, s := range test.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("GET %s: body should not contain %q", test.path, s)
+				}
+			}
+		})
+	}
+}
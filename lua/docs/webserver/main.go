@@ -5,33 +5,39 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"text/template"
 
 	"github.com/gosimple/slug"
 	yaml "gopkg.in/yaml.v2"
 )
 
 const (
-	contentDirectory = "/www"
-	templateDir      = "/www/templates"
-	templateFile     = "page.tmpl"
-	templateFileV2   = "pageV2.tmpl"
-	serverCertFile   = "/cubzh/certs/cu.bzh.chained.crt"
-	serverKeyFile    = "/cubzh/certs/cu.bzh.key"
+	templateFile   = "page.tmpl"
+	templateFileV2 = "pageV2.tmpl"
+	serverCertFile = "/cubzh/certs/cu.bzh.chained.crt"
+	serverKeyFile  = "/cubzh/certs/cu.bzh.key"
 )
 
 var (
 	debug bool = true
 
+	// variables rather than constants for tests to use fixture content
+	contentDirectory = "/www"
+	templateDir      = "/www/templates"
+
 	pages   map[string]*Page
 	pagesV2 map[string]*Module
 
+	// key: route, value: documentation served at that route,
+	// built from both pages and modules
+	docs map[string]*Doc
+
 	pageTemplate   *template.Template
 	pageTemplateV2 *template.Template
 
@@ -61,6 +67,26 @@ func main() {
 			fmt.Println("OK")
 			return
 		}
+
+		if command == "llms" {
+
+			if nbArgs < 3 {
+				fmt.Println("usage: webserver llms <output directory>")
+				os.Exit(1)
+			}
+
+			err := parseContent()
+			if err == nil {
+				err = exportLLMs(os.Args[2])
+			}
+			if err != nil {
+				fmt.Println("ERR:", err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Println("OK")
+			return
+		}
 	}
 
 	// --------------------------------------------------
@@ -78,11 +104,7 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
-	for _, staticDir := range staticFileDirectories {
-		http.Handle("/"+staticDir+"/", http.StripPrefix("/"+staticDir+"/", http.FileServer(http.Dir(filepath.Join(contentDirectory, staticDir)))))
-	}
-
-	http.HandleFunc("/", httpHandler)
+	mux := newServeMux()
 
 	fmt.Println("✨ Cubzh documentation running...")
 
@@ -94,12 +116,33 @@ func main() {
 			}
 		}()
 		// listen for connections on port 443
-		log.Fatal(http.ListenAndServeTLS(":443", serverCertFile, serverKeyFile, nil))
+		log.Fatal(http.ListenAndServeTLS(":443", serverCertFile, serverKeyFile, mux))
 	} else {
-		log.Fatal(http.ListenAndServe(":80", nil))
+		log.Fatal(http.ListenAndServe(":80", mux))
 	}
 }
 
+// newServeMux returns a handler serving static files,
+// llms.txt exports and documentation pages.
+func newServeMux() *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	for _, staticDir := range staticFileDirectories {
+		var handler http.Handler = http.StripPrefix("/"+staticDir+"/", http.FileServer(http.Dir(filepath.Join(contentDirectory, staticDir))))
+		if staticDir == itemDirectory {
+			handler = itemPreviewHandler(handler)
+		}
+		mux.Handle("/"+staticDir+"/", handler)
+	}
+
+	mux.HandleFunc("/"+llmsIndexFile, llmsHandler)
+	mux.HandleFunc("/"+llmsFullFile, llmsHandler)
+	mux.HandleFunc("/", httpHandler)
+
+	return mux
+}
+
 func httpHandler(w http.ResponseWriter, r *http.Request) {
 
 	if debug {
@@ -108,22 +151,14 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 
 	path := cleanPath(r.URL.Path)
 
-	page, ok := pages[path]
-
-	if ok {
-		if r.URL.Path != path {
-			fmt.Println(r.URL.Path, "!=", path)
-			http.Redirect(w, r, path, http.StatusMovedPermanently)
-			return
-		}
-
-		if page != nil {
-			_ = replyPage(w, page)
+	if filepath.Ext(r.URL.Path) == ".md" {
+		if md, ok := llmsMarkdown(path); ok {
+			replyMarkdown(w, md)
 			return
 		}
 	}
 
-	module, ok := pagesV2[path]
+	doc, ok := docs[path]
 
 	if ok {
 		if r.URL.Path != path {
@@ -132,8 +167,8 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if module != nil {
-			_ = replyModule(w, module)
+		if doc != nil {
+			_ = replyDoc(w, doc)
 			return
 		}
 	}
@@ -142,9 +177,9 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 		// not found, redirect to /
 		fmt.Println("not found:", path)
 
-		if page404, ok := pages["/404"]; ok {
+		if doc404, ok := docs["/404"]; ok {
 			w.WriteHeader(http.StatusNotFound)
-			_ = replyPage(w, page404)
+			_ = replyDoc(w, doc404)
 			return
 		}
 
@@ -159,32 +194,46 @@ func replyText(w http.ResponseWriter, text string) {
 	fmt.Fprintln(w, text)
 }
 
-func replyPage(w http.ResponseWriter, page *Page) error {
-	err := pageTemplate.Execute(w, page)
-	if err != nil {
-		fmt.Println("🔥 error:", err.Error())
+func replyMarkdown(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	fmt.Fprint(w, text)
+}
+
+// llmsHandler serves llms.txt and llms-full.txt
+func llmsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if debug {
+		parseContent()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if r.URL.Path == "/"+llmsFullFile {
+		fmt.Fprint(w, llmsFull())
+	} else {
+		fmt.Fprint(w, llmsIndex())
 	}
-	return err
 }
 
-func replyModule(w http.ResponseWriter, module *Module) error {
-	err := pageTemplateV2.Execute(w, module)
+// replyDoc renders doc with the template corresponding to its kind
+func replyDoc(w http.ResponseWriter, doc *Doc) error {
+	tmpl := pageTemplate
+	if doc.Kind == DocKindModule {
+		tmpl = pageTemplateV2
+	}
+	err := tmpl.Execute(w, doc)
 	if err != nil {
 		fmt.Println("🔥 error:", err.Error())
 	}
 	return err
 }
 
-func GetTitle(page *Page) string {
-	return page.GetTitle()
+func GetTitle(doc *Doc) string {
+	return doc.GetTitle()
 }
 
-func GetTitleV2(module *Module) string {
-	return module.Name
-}
-
-func IsNotCreatableObject(page *Page) bool {
-	return page.IsNotCreatableObject()
+func IsNotCreatableObject(t *DocType) bool {
+	return t.IsNotCreatableObject()
 }
 
 // GetTypeLink returns an non empty string if the type
@@ -204,6 +253,8 @@ func parseContent() error {
 
 	pages = make(map[string]*Page)
 	pagesV2 = make(map[string]*Module)
+	docs = make(map[string]*Doc)
+	items = make(map[string]*Item)
 
 	typeRoutes = make(map[string]string)
 
@@ -221,14 +272,17 @@ func parseContent() error {
 
 	templateFilePath := filepath.Join(templateDir, templateFile)
 
-	pageTemplate = template.New("page.tmpl").Funcs(template.FuncMap{
+	templateFuncs := template.FuncMap{
 		"Join":                  strings.Join,
 		"GetTitle":              GetTitle,
 		"GetAnchorLink":         GetAnchorLink,
 		"SampleHasCodeAndMedia": SampleHasCodeAndMedia,
 		"IsNotCreatableObject":  IsNotCreatableObject,
 		"GetTypeRoute":          GetTypeRoute,
-	})
+		"GetItem":               GetItem,
+	}
+
+	pageTemplate = template.New("page.tmpl").Funcs(templateFuncs)
 
 	pageTemplate, err = pageTemplate.ParseFiles(headTmplPath, footerTmplPath, headerTmplPath, menuTmplPath, sidemenuTmplPath, contentblocksTmplPath, typesTmplPath, templateFilePath)
 	if err != nil {
@@ -238,14 +292,7 @@ func parseContent() error {
 
 	templateFilePathV2 := filepath.Join(templateDir, templateFileV2)
 
-	pageTemplateV2 = template.New("pageV2.tmpl").Funcs(template.FuncMap{
-		"Join":                  strings.Join,
-		"GetTitle":              GetTitleV2,
-		"GetAnchorLink":         GetAnchorLink,
-		"SampleHasCodeAndMedia": SampleHasCodeAndMedia,
-		"IsNotCreatableObject":  IsNotCreatableObject,
-		"GetTypeRoute":          GetTypeRoute,
-	})
+	pageTemplateV2 = template.New("pageV2.tmpl").Funcs(templateFuncs)
 
 	pageTemplateV2, err = pageTemplateV2.ParseFiles(headTmplPath, footerTmplPath, headerTmplPath, menuTmplPath, sidemenuTmplPath, contentblocksTmplPath, typesTmplPath, templateFilePathV2)
 	if err != nil {
@@ -318,6 +365,25 @@ func parseContent() error {
 				// fmt.Println("from json:", cleanPath)
 				pagesV2[cleanPath] = &module
 			}
+
+		} else if isItemPath(walkPath) { // 3ZH FILE
+
+			// example: from /www/media/chest.3zh to /media/chest.3zh
+			route := filepath.ToSlash(strings.TrimPrefix(walkPath, contentDirectory))
+
+			// only items in the media directory can be served
+			if strings.HasPrefix(route, "/"+itemDirectory+"/") && regularFileExists(walkPath) {
+
+				// a broken item shouldn't prevent the docs from being served,
+				// image blocks referencing it are displayed without preview
+				item, err := loadCachedItem(walkPath, route)
+				if err != nil {
+					fmt.Println("🔥 error:", route, err.Error())
+					return nil
+				}
+
+				items[route] = item
+			}
 		}
 
 		return nil
@@ -360,12 +426,19 @@ func parseContent() error {
 		}
 	}
 
-	for _, page := range pages {
-		page.Sanitize()
+	for route, page := range pages {
+		docs[route] = page.Doc()
+	}
+
+	for route, module := range pagesV2 {
+		// pages have priority over modules
+		if _, ok := docs[route]; !ok {
+			docs[route] = module.Doc()
+		}
 	}
 
-	for _, module := range pagesV2 {
-		module.Sanitize()
+	for _, doc := range docs {
+		doc.Sanitize()
 	}
 
 	fmt.Println("content parsed!")
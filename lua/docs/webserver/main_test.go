@@ -0,0 +1,350 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+)
+
+const (
+	fixtureContentDirectory = "testdata/content"
+	fixtureTemplateDir      = "../content/templates"
+	goldenHTMLDirectory     = "testdata/golden"
+)
+
+// setupFixtureContent parses fixture content,
+// using the templates of the documentation.
+func setupFixtureContent(t *testing.T) {
+	t.Helper()
+
+	previousContentDirectory := contentDirectory
+	previousTemplateDir := templateDir
+	previousDebug := debug
+
+	t.Cleanup(func() {
+		contentDirectory = previousContentDirectory
+		templateDir = previousTemplateDir
+		debug = previousDebug
+	})
+
+	contentDirectory = fixtureContentDirectory
+	templateDir = fixtureTemplateDir
+	debug = false
+
+	if err := parseContent(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, server *httptest.Server, path string) (*http.Response, string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/index", "/"},
+		{"/index.yml", "/"},
+		{"/index.html", "/"},
+		{"/reference/shape", "/reference/shape"},
+		{"/reference/Shape", "/reference/shape"},
+		{"/reference/shape.yml", "/reference/shape"},
+		{"/reference/shape/", "/reference/shape"},
+		{"/reference//shape", "/reference/shape"},
+		{"/reference/../reference/shape", "/reference/shape"},
+		{"/reference/index.yml", "/reference"},
+		{"/modules/sample.json", "/modules/sample"},
+		{"/reference/shape.md", "/reference/shape"},
+	}
+
+	for _, test := range tests {
+		if result := cleanPath(test.path); result != test.expected {
+			t.Errorf("cleanPath(%q) = %q, want %q", test.path, result, test.expected)
+		}
+	}
+}
+
+func TestParseContent(t *testing.T) {
+	setupFixtureContent(t)
+
+	expectedTypeRoutes := map[string]string{
+		"Object":  "/reference/object",
+		"Shape":   "/reference/shape",
+		"Number3": "/reference/number3",
+	}
+	for typeName, route := range expectedTypeRoutes {
+		if typeRoutes[typeName] != route {
+			t.Errorf("typeRoutes[%q] = %q, want %q", typeName, typeRoutes[typeName], route)
+		}
+	}
+
+	expectedKinds := map[string]DocKind{
+		"/":                  DocKindPage,
+		"/404":               DocKindPage,
+		"/reference/object":  DocKindPage, // pages have priority over modules
+		"/reference/shape":   DocKindPage,
+		"/reference/number3": DocKindPage,
+		"/modules/sample":    DocKindModule,
+		"/guides/chest":      DocKindPage,
+	}
+	if len(docs) != len(expectedKinds) {
+		t.Errorf("%d docs, want %d", len(docs), len(expectedKinds))
+	}
+	for route, kind := range expectedKinds {
+		doc, ok := docs[route]
+		if !ok {
+			t.Errorf("no doc at %s", route)
+			continue
+		}
+		if doc.Kind != kind {
+			t.Errorf("%s: kind %d, want %d", route, doc.Kind, kind)
+		}
+	}
+
+	if title := docs["/modules/sample"].GetTitle(); title != "sample" {
+		t.Errorf("module title %q, want %q", title, "sample")
+	}
+
+	if _, ok := items["/media/chest.3zh"]; !ok || len(items) != 1 {
+		t.Errorf("items: %v, want /media/chest.3zh", items)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	setupFixtureContent(t)
+
+	server := httptest.NewServer(newServeMux())
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		location    string
+		contentType string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name:     "index",
+			path:     "/",
+			status:   http.StatusOK,
+			contains: []string{"Welcome to the", `<a class="type" href="/reference/object">Object</a>`},
+		},
+		{
+			name:     "redirect index",
+			path:     "/index",
+			status:   http.StatusMovedPermanently,
+			location: "/",
+		},
+		{
+			name:     "redirect uppercase",
+			path:     "/reference/Shape",
+			status:   http.StatusMovedPermanently,
+			location: "/reference/shape",
+		},
+		{
+			name:     "redirect extension",
+			path:     "/reference/shape.yml",
+			status:   http.StatusMovedPermanently,
+			location: "/reference/shape",
+		},
+		{
+			name:     "redirect trailing slash",
+			path:     "/modules/sample/",
+			status:   http.StatusMovedPermanently,
+			location: "/modules/sample",
+		},
+		{
+			name:     "reference page",
+			path:     "/reference/shape",
+			status:   http.StatusOK,
+			contains: []string{"GetBlock", "Width", "&lt;script&gt;"},
+			excludes: []string{"Module:", "<script>alert"},
+		},
+		{
+			name:     "page has priority over module",
+			path:     "/reference/object",
+			status:   http.StatusOK,
+			contains: []string{"AddChild"},
+			excludes: []string{"Module:"},
+		},
+		{
+			name:     "module",
+			path:     "/modules/sample",
+			status:   http.StatusOK,
+			contains: []string{"Module: sample", "create", "count"},
+		},
+		{
+			name:     "not found",
+			path:     "/reference/unknown",
+			status:   http.StatusNotFound,
+			contains: []string{"This page does not exist."},
+		},
+		{
+			name:        "static file",
+			path:        "/style/css/style.css",
+			status:      http.StatusOK,
+			contentType: "text/css; charset=utf-8",
+			contains:    []string{"margin: 0;"},
+		},
+		{
+			name:   "missing static file",
+			path:   "/style/css/missing.css",
+			status: http.StatusNotFound,
+		},
+		{
+			name:        "markdown",
+			path:        "/reference/shape.md",
+			status:      http.StatusOK,
+			contentType: "text/markdown; charset=utf-8",
+			contains:    []string{"# Shape", "GetBlock"},
+		},
+		{
+			name:     "item",
+			path:     "/guides/chest",
+			status:   http.StatusOK,
+			contains: []string{`<img src="/media/chest.3zh.png"`, "ModelPoint_Hand", "lid", `<img src="/media/missing.3zh"`},
+		},
+		{
+			name:        "item preview",
+			path:        "/media/chest.3zh.png",
+			status:      http.StatusOK,
+			contentType: "image/png",
+			contains:    []string{"\x89PNG"},
+		},
+		{
+			name:     "item file",
+			path:     "/media/chest.3zh",
+			status:   http.StatusOK,
+			contains: []string{shapefile.MagicBytes},
+		},
+		{
+			name:   "missing item preview",
+			path:   "/media/missing.3zh.png",
+			status: http.StatusNotFound,
+		},
+		{
+			name:        "llms.txt",
+			path:        "/llms.txt",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			contains:    []string{"/reference/shape.md", "/modules/sample.md"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := get(t, server, test.path)
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("GET %s: status %d, want %d", test.path, resp.StatusCode, test.status)
+			}
+			if test.location != "" && resp.Header.Get("Location") != test.location {
+				t.Errorf("GET %s: redirected to %q, want %q", test.path, resp.Header.Get("Location"), test.location)
+			}
+			if test.contentType != "" && resp.Header.Get("Content-Type") != test.contentType {
+				t.Errorf("GET %s: content type %q, want %q", test.path, resp.Header.Get("Content-Type"), test.contentType)
+			}
+			for _, s := range test.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("GET %s: body does not contain %q", test.path, s)
+				}
+			}
+			for _, s := range test.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("GET %s: body should not contain %q", test.path, s)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPHandlerWithout404Page(t *testing.T) {
+	setupFixtureContent(t)
+	delete(docs, "/404")
+
+	server := httptest.NewServer(newServeMux())
+	defer server.Close()
+
+	resp, _ := get(t, server, "/reference/unknown")
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	if resp.Header.Get("Location") != "/" {
+		t.Errorf("redirected to %q, want %q", resp.Header.Get("Location"), "/")
+	}
+}
+
+// TestGoldenHTML compares rendered pages with snapshots in testdata/golden.
+// Run with -update to regenerate snapshots.
+func TestGoldenHTML(t *testing.T) {
+	setupFixtureContent(t)
+
+	server := httptest.NewServer(newServeMux())
+	defer server.Close()
+
+	routes := map[string]string{
+		"/":                 "index.html",
+		"/reference/object": "reference_object.html",
+		"/reference/shape":  "reference_shape.html",
+		"/modules/sample":   "modules_sample.html",
+		"/404":              "404.html",
+		"/guides/chest":     "guides_chest.html",
+	}
+
+	for route, file := range routes {
+		t.Run(file, func(t *testing.T) {
+			_, body := get(t, server, route)
+
+			goldenPath := filepath.Join(goldenHTMLDirectory, file)
+
+			if *update {
+				if err := os.MkdirAll(goldenHTMLDirectory, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(goldenPath, []byte(body), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if body != string(golden) {
+				t.Errorf("GET %s differs from %s, run tests with -update if changes are expected", route, goldenPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gosimple/slug"
+)
+
+// Descriptions are written with a small inline markup:
+//
+//	`code`       inline code, displayed as is
+//	[text](url)  link
+//	[Type]       link to the page describing Type ([This] refers to current type)
+//	new line     line break
+//
+// A few HTML tags used by content authors are also accepted (see allowedTags),
+// any other HTML is escaped and displayed as text.
+
+// allowedTags lists HTML tags that can be used in descriptions.
+// Tags are accepted without attributes, except for links (see reAllowedLink).
+var allowedTags = map[string]bool{
+	"b":      true,
+	"i":      true,
+	"u":      true,
+	"strong": true,
+	"br":     true,
+}
+
+// allowedURLSchemes lists schemes accepted in links,
+// URLs without scheme (relative links, anchors) are always accepted.
+// Any other scheme is rejected.
+var allowedURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+var (
+	reAllowedTag  = regexp.MustCompile(`^<(/?)([a-zA-Z]+)\s*/?>`)
+	reAllowedLink = regexp.MustCompile(`^<a\s+href="([^"<>]*)"\s*>`)
+	reLinkMarkup  = regexp.MustCompile(`^\[([^\]]+)\]\(([^)]+)\)`)
+	reTypeMarkup  = regexp.MustCompile(`^\[([A-Za-z0-9]+)\]`)
+
+	reInlineCode = regexp.MustCompile("`([^`]+)`")
+	reLink       = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+	reTypeLink   = regexp.MustCompile(`\[([A-Za-z0-9]+)\]`)
+)
+
+// renderMarkup turns description markup into HTML.
+// currentType is the type [This] refers to.
+func renderMarkup(text string, currentType string) template.HTML {
+	var sb strings.Builder
+	writeMarkup(&sb, strings.TrimSpace(text), currentType, true)
+	return template.HTML(sb.String())
+}
+
+// writeMarkup writes HTML for given markup.
+// Links are not rendered when allowLinks is false,
+// to avoid nested anchors when rendering link texts.
+func writeMarkup(sb *strings.Builder, text string, currentType string, allowLinks bool) {
+
+	// start of text that has not been written yet
+	start := 0
+
+	// tags that have been opened and not closed yet
+	open := make([]string, 0)
+
+	flush := func(end int) {
+		sb.WriteString(template.HTMLEscapeString(text[start:end]))
+	}
+
+	for i := 0; i < len(text); {
+
+		rest := text[i:]
+		consumed := 0
+
+		switch text[i] {
+		case '\n':
+			flush(i)
+			sb.WriteString("<br>")
+			consumed = 1
+
+		case '`':
+			end := strings.IndexByte(rest[1:], '`')
+			if end > 0 {
+				flush(i)
+				sb.WriteString(`<span class="code">`)
+				sb.WriteString(template.HTMLEscapeString(rest[1 : end+1]))
+				sb.WriteString(`</span>`)
+				consumed = end + 2
+			}
+
+		case '[':
+			if !allowLinks {
+				break
+			}
+			if m := reLinkMarkup.FindStringSubmatch(rest); m != nil {
+				flush(i)
+				sb.WriteString(`<a href="` + sanitizeURL(m[2]) + `">`)
+				writeMarkup(sb, m[1], currentType, false)
+				sb.WriteString(`</a>`)
+				consumed = len(m[0])
+			} else if m := reTypeMarkup.FindStringSubmatch(rest); m != nil {
+				flush(i)
+				sb.WriteString(getTypeLink(m[1], currentType))
+				consumed = len(m[0])
+			}
+
+		case '<':
+			if m := reAllowedTag.FindStringSubmatch(rest); m != nil {
+				name := strings.ToLower(m[2])
+				closing := m[1] == "/"
+				if name == "br" {
+					flush(i)
+					sb.WriteString("<br>")
+					consumed = len(m[0])
+				} else if allowedTags[name] || (name == "a" && closing && allowLinks) {
+					flush(i)
+					if closing {
+						open = closeTag(sb, open, name)
+					} else {
+						sb.WriteString("<" + name + ">")
+						open = append(open, name)
+					}
+					consumed = len(m[0])
+				}
+			} else if m := reAllowedLink.FindStringSubmatch(rest); m != nil && allowLinks {
+				flush(i)
+				sb.WriteString(`<a href="` + sanitizeURL(m[1]) + `">`)
+				open = append(open, "a")
+				consumed = len(m[0])
+			}
+		}
+
+		if consumed > 0 {
+			i += consumed
+			start = i
+		} else {
+			i++
+		}
+	}
+
+	flush(len(text))
+
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString("</" + open[i] + ">")
+	}
+}
+
+// closeTag writes the closing tag for name, closing first the tags
+// opened after it, and returns the tags still open.
+// Closing tags that don't match an open tag are dropped.
+func closeTag(sb *strings.Builder, open []string, name string) []string {
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i] != name {
+			continue
+		}
+		for j := len(open) - 1; j >= i; j-- {
+			sb.WriteString("</" + open[j] + ">")
+		}
+		return open[:i]
+	}
+	return open
+}
+
+// sanitizeURL returns an escaped URL that can be used as href attribute value.
+// ASCII control and whitespace characters are removed first, as browsers
+// ignore them when reading the scheme ("java\tscript:" is "javascript:").
+// URLs that can't be parsed or with a scheme that's not allowed are replaced by "#".
+func sanitizeURL(rawURL string) string {
+	rawURL = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "#"
+	}
+	if u.Scheme != "" && !allowedURLSchemes[strings.ToLower(u.Scheme)] {
+		return "#"
+	}
+	return template.HTMLEscapeString(rawURL)
+}
+
+// getTypeLink returns HTML link to the page describing given type.
+// "This" refers to currentType.
+func getTypeLink(typeName string, currentType string) string {
+
+	if typeName == "This" {
+		typeName = currentType
+	}
+
+	route, ok := typeRoutes[typeName]
+	if !ok {
+		// fallback to local type anchor (may not work)
+		route = "#type-" + slug.Make(typeName)
+	}
+
+	return `<a class="type" href="` + template.HTMLEscapeString(route) + `">` + template.HTMLEscapeString(typeName) + `</a>`
+}
+
+// stripMarkup removes description markup, keeping text only.
+func stripMarkup(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.ReplaceAll(text, "\n", " ")
+	text = reInlineCode.ReplaceAllString(text, `$1`)
+	text = reLink.ReplaceAllString(text, `$1`)
+	text = reTypeLink.ReplaceAllString(text, `$1`)
+	text = reHTMLTag.ReplaceAllString(text, "")
+	return text
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	testContentDirectory   = "../content"
+	testModulesDirectory   = "testdata/modules"
+	markupGoldenFile       = "testdata/markup.golden"
+	moduleMarkupGoldenFile = "testdata/modules.golden"
+)
+
+func TestRenderMarkup(t *testing.T) {
+
+	typeRoutes = map[string]string{
+		"Shape":  "/reference/shape",
+		"Object": "/reference/object",
+	}
+
+	tests := []struct {
+		name        string
+		text        string
+		currentType string
+		expected    string
+	}{
+		{"plain", "hello", "", "hello"},
+		{"trim", "  hello\n", "", "hello"},
+		{"new lines", "a\nb\n\nc", "", "a<br>b<br><br>c"},
+		{"escaping", `a < b & "c" > 'd'`, "", "a &lt; b &amp; &#34;c&#34; &gt; &#39;d&#39;"},
+		{"code", "call `foo()`", "", `call <span class="code">foo()</span>`},
+		{"code with brackets", "`t[i] = [Shape]`", "", `<span class="code">t[i] = [Shape]</span>`},
+		{"code with html", "`a<b>c`", "", `<span class="code">a&lt;b&gt;c</span>`},
+		{"unterminated code", "a ` b", "", "a ` b"},
+		{"empty code", "a `` b", "", "a `` b"},
+		{"link", "[docs](https://docs.cu.bzh)", "", `<a href="https://docs.cu.bzh">docs</a>`},
+		{"relative link", "[see](#functions-foo)", "", `<a href="#functions-foo">see</a>`},
+		{"link with code", "[`Player`](/reference/player)", "", `<a href="/reference/player"><span class="code">Player</span></a>`},
+		{"link with bold", "[<b>Tutorial</b>](/guides)", "", `<a href="/guides"><b>Tutorial</b></a>`},
+		{"link without nested type link", "[a [Shape]](/x)", "", `[a <a class="type" href="/reference/shape">Shape</a>](/x)`},
+		{"javascript link", "[x](javascript:alert`1`)", "", `<a href="#">x</a>`},
+		{"javascript link with tab", "[x](java\tscript:alert`1`)", "", `<a href="#">x</a>`},
+		{"javascript link with newline", "[x](java\nscript:alert`1`)", "", `<a href="#">x</a>`},
+		{"javascript link with control character", "[x](\x01javascript:alert`1`)", "", `<a href="#">x</a>`},
+		{"javascript link with uppercase scheme", "[x](JavaScript:alert`1`)", "", `<a href="#">x</a>`},
+		{"data link", "[x](data:text/html,x)", "", `<a href="#">x</a>`},
+		{"mailto link", "[x](mailto:a@cu.bzh)", "", `<a href="mailto:a@cu.bzh">x</a>`},
+		{"unparsable link", "[x](http://[::1)", "", `<a href="#">x</a>`},
+		{"link url escaping", `[x](/a"b)`, "", `<a href="/a&#34;b">x</a>`},
+		{"type link", "a [Shape].", "", `a <a class="type" href="/reference/shape">Shape</a>.`},
+		{"local type link", "[Foo]", "", `<a class="type" href="#type-foo">Foo</a>`},
+		{"this", "[This]", "Object", `<a class="type" href="/reference/object">Object</a>`},
+		{"not a type link", "[a b]", "", "[a b]"},
+		{"allowed tags", "<b>a</b><i>b</i><u>c</u><strong>d</strong>", "", "<b>a</b><i>b</i><u>c</u><strong>d</strong>"},
+		{"br", "a<br>b<br/>c<BR />d", "", "a<br>b<br>c<br>d"},
+		{"html link", `<a href="/reference/world">World</a>`, "", `<a href="/reference/world">World</a>`},
+		{"html javascript link", `<a href="javascript:alert(1)">x</a>`, "", `<a href="#">x</a>`},
+		{"html javascript link with tab", "<a href=\"java\tscript:alert(1)\">x</a>", "", `<a href="#">x</a>`},
+		{"html javascript link with newline", "<a href=\"java\nscript:alert(1)\">x</a>", "", `<a href="#">x</a>`},
+		{"html javascript link with control character", "<a href=\"\x01javascript:alert(1)\">x</a>", "", `<a href="#">x</a>`},
+		{"tag with attributes", `<b onclick="alert(1)">x</b>`, "", `&lt;b onclick=&#34;alert(1)&#34;&gt;x`},
+		{"link with other attributes", `<a href="/" onclick="alert(1)">x</a>`, "", `&lt;a href=&#34;/&#34; onclick=&#34;alert(1)&#34;&gt;x`},
+		{"unclosed tags", "<b>a<i>b", "", "<b>a<i>b</i></b>"},
+		{"unclosed html link", `<a href="/x"><b>y`, "", `<a href="/x"><b>y</b></a>`},
+		{"misnested tags", "<b><i>x</b>y</i>", "", "<b><i>x</i></b>y"},
+		{"unmatched closing tags", "</b>x</a></strong>", "", "x"},
+		{"unclosed tag in link text", "[<b>x](/y) z", "", `<a href="/y"><b>x</b></a> z`},
+		{"closing link in link text", "[x</a>](/y)", "", `<a href="/y">x&lt;/a&gt;</a>`},
+		{"script", "<script>alert(1)</script>", "", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"img", `<img src=x onerror=alert(1)>`, "", "&lt;img src=x onerror=alert(1)&gt;"},
+		{"not a tag", "DefaultColors[<your reference>]", "", "DefaultColors[&lt;your reference&gt;]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := string(renderMarkup(test.text, test.currentType))
+			if result != test.expected {
+				t.Errorf("renderMarkup(%q)\n got: %s\nwant: %s", test.text, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetTypeLink(t *testing.T) {
+
+	typeRoutes = map[string]string{
+		"Shape": "/reference/shape",
+	}
+
+	tests := []struct {
+		typeName    string
+		currentType string
+		expected    string
+	}{
+		{"Shape", "", `<a class="type" href="/reference/shape">Shape</a>`},
+		{"Shape", "Object", `<a class="type" href="/reference/shape">Shape</a>`},
+		{"This", "Shape", `<a class="type" href="/reference/shape">Shape</a>`},
+		{"LocalType", "", `<a class="type" href="#type-localtype">LocalType</a>`},
+		{"This", "LocalType", `<a class="type" href="#type-localtype">LocalType</a>`},
+	}
+
+	for _, test := range tests {
+		if result := getTypeLink(test.typeName, test.currentType); result != test.expected {
+			t.Errorf("getTypeLink(%q, %q) = %s, want %s", test.typeName, test.currentType, result, test.expected)
+		}
+	}
+}
+
+func TestStripMarkup(t *testing.T) {
+	text := "A [Shape] with `code`,\n[a link](/x) and <b>bold</b> text."
+	expected := "A Shape with code, a link and bold text."
+	if result := stripMarkup(text); result != expected {
+		t.Errorf("got %q, want %q", result, expected)
+	}
+}
+
+// markupFragment is a piece of description found in content.
+type markupFragment struct {
+	source      string
+	text        string
+	currentType string
+	list        bool
+	html        string
+}
+
+// loadContentFragments renders all descriptions found in YAML content files.
+func loadContentFragments(t *testing.T) []*markupFragment {
+
+	typeRoutes = make(map[string]string)
+	pages := make(map[string]*Page)
+
+	err := filepath.Walk(testContentDirectory, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(walkPath, ".yml") {
+			return nil
+		}
+		data, err := os.ReadFile(walkPath)
+		if err != nil {
+			return err
+		}
+		var page Page
+		if err := yaml.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		route := cleanPath(strings.TrimPrefix(filepath.ToSlash(walkPath), testContentDirectory))
+		pages[route] = &page
+		if page.Type != "" {
+			typeRoutes[page.Type] = route
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := make([]*markupFragment, 0)
+	for _, route := range sortedKeys(pages) {
+		fragments = append(fragments, docFragments(route, pages[route].Doc())...)
+	}
+
+	if len(fragments) == 0 {
+		t.Fatal("no content found in", testContentDirectory)
+	}
+
+	return fragments
+}
+
+// loadModuleFragments renders all descriptions found in module JSON files,
+// as generated by the parser from Lua modules.
+func loadModuleFragments(t *testing.T) []*markupFragment {
+
+	typeRoutes = make(map[string]string)
+
+	files, err := filepath.Glob(filepath.Join(testModulesDirectory, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := make([]*markupFragment, 0)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var module Module
+		if err := json.Unmarshal(data, &module); err != nil {
+			t.Fatal(file, err)
+		}
+		module.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+		fragments = append(fragments, docFragments("/modules/"+module.Name, module.Doc())...)
+	}
+
+	if len(fragments) == 0 {
+		t.Fatal("no module found in", testModulesDirectory)
+	}
+
+	return fragments
+}
+
+// docFragments renders all descriptions of a documentation page.
+func docFragments(route string, doc *Doc) []*markupFragment {
+
+	fragments := make([]*markupFragment, 0)
+
+	addBlocks := func(source string, blocks []*ContentBlock, currentType string) {
+		sanitizeBlocks(blocks, currentType)
+		for _, b := range blocks {
+			if b.Text != "" {
+				fragments = append(fragments, &markupFragment{source, b.Text, currentType, false, string(b.TextHTML)})
+			}
+			for i, item := range b.List {
+				fragments = append(fragments, &markupFragment{source, item, currentType, true, string(b.ListHTML[i])})
+			}
+		}
+	}
+
+	addBlocks(route, doc.Blocks, doc.MainType().Name)
+	for _, docType := range doc.Types {
+		source := route + " " + docType.Name
+		addBlocks(source, docType.Description, docType.Name)
+		for _, f := range docType.Constructors {
+			addBlocks(source+" constructor", f.Description, docType.Name)
+		}
+		for _, f := range docType.Functions {
+			addBlocks(source+" "+f.Name, f.Description, docType.Name)
+		}
+		for _, p := range docType.BuiltIns {
+			addBlocks(source+" "+p.Name, p.Description, docType.Name)
+		}
+		for _, p := range docType.Properties {
+			addBlocks(source+" "+p.Name, p.Description, docType.Name)
+		}
+	}
+
+	return fragments
+}
+
+// TestMarkupGolden renders all descriptions of the content tree
+// and compares them with testdata/markup.golden.
+// Run with -update to regenerate the golden file.
+func TestMarkupGolden(t *testing.T) {
+	checkMarkupGolden(t, loadContentFragments(t), markupGoldenFile)
+}
+
+// TestModuleMarkupGolden renders all descriptions of module JSON files
+// in testdata/modules and compares them with testdata/modules.golden.
+// Run with -update to regenerate the golden file.
+func TestModuleMarkupGolden(t *testing.T) {
+	checkMarkupGolden(t, loadModuleFragments(t), moduleMarkupGoldenFile)
+}
+
+func checkMarkupGolden(t *testing.T, fragments []*markupFragment, goldenFile string) {
+
+	var sb strings.Builder
+	for _, f := range fragments {
+		sb.WriteString("== " + f.source + "\n" + f.html + "\n")
+	}
+	result := sb.String()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile, []byte(result), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != string(golden) {
+		resultLines := strings.Split(result, "\n")
+		goldenLines := strings.Split(string(golden), "\n")
+		for i := 0; i < len(resultLines) && i < len(goldenLines); i++ {
+			if resultLines[i] != goldenLines[i] {
+				t.Fatalf("output differs from %s at line %d:\n got: %s\nwant: %s", goldenFile, i+1, resultLines[i], goldenLines[i])
+			}
+		}
+		t.Fatalf("output differs from %s: %d lines, want %d", goldenFile, len(resultLines), len(goldenLines))
+	}
+}
+
+// legacyRender is how descriptions used to be turned into HTML,
+// with regular expressions and no escaping. List items were not transformed.
+func legacyRender(text string, currentType string, list bool) string {
+	if list {
+		return text
+	}
+	text = strings.TrimSpace(text)
+	text = strings.ReplaceAll(text, "\n", "<br>")
+	text = reInlineCode.ReplaceAllString(text, `<span class="code">$1</span>`)
+	text = reLink.ReplaceAllString(text, `<a href="$2">$1</a>`)
+	text = reTypeLink.ReplaceAllStringFunc(text, func(s string) string {
+		return getTypeLink(s[1:len(s)-1], currentType)
+	})
+	return text
+}
+
+// legacyDifferences lists descriptions for which the new rendering
+// is expected to differ from the legacy one: brackets in inline code
+// used to be turned into type links.
+var legacyDifferences = regexp.MustCompile("`[^`]*\\[[A-Za-z0-9]+\\][^`]*`")
+
+// TestMarkupMatchesLegacy ensures that, once HTML entities are decoded,
+// the new rendering of the content tree is the same as the legacy one.
+func TestMarkupMatchesLegacy(t *testing.T) {
+
+	fragments := loadContentFragments(t)
+
+	differences := make([]string, 0)
+
+	for _, f := range fragments {
+		legacy := legacyRender(f.text, f.currentType, f.list)
+		if html.UnescapeString(legacy) == html.UnescapeString(f.html) {
+			continue
+		}
+		if legacyDifferences.MatchString(f.text) {
+			differences = append(differences, f.source)
+			continue
+		}
+		t.Errorf("%s: rendering differs\n new: %s\n old: %s", f.source, f.html, legacy)
+	}
+
+	sort.Strings(differences)
+	t.Logf("expected differences: %v", differences)
+}
@@ -1,11 +1,5 @@
 package main
 
-import (
-	"regexp"
-	"sort"
-	"strings"
-)
-
 // Module documents a module,
 // based on code inline documentation
 type Module struct {
@@ -23,13 +17,21 @@ type Module struct {
 	// but it can also be enriched with medias, code samples, etc.
 	Description []*ContentBlock `json:"description,omitempty"`
 
-	// meta description, built from Description
-	MetaDescription string `json:"-,omitempty"`
-
 	// not set in JSON, set dynamically when parsing files
 	ResourcePath string `json:"-"`
 }
 
+// Copy returns a deep copy of the module.
+func (m *Module) Copy() *Module {
+	return &Module{
+		Name:         m.Name,
+		Keywords:     copyStrings(m.Keywords),
+		Types:        copyAll(m.Types),
+		Description:  copyAll(m.Description),
+		ResourcePath: m.ResourcePath,
+	}
+}
+
 type ModuleType struct {
 	// Type name.
 	Name string `json:"name,omitempty"`
@@ -42,6 +44,15 @@ type ModuleType struct {
 	Functions []*ModuleFunction `json:"functions,omitempty"`
 }
 
+func (t *ModuleType) Copy() *ModuleType {
+	return &ModuleType{
+		Name:        t.Name,
+		Description: copyAll(t.Description),
+		Properties:  copyAll(t.Properties),
+		Functions:   copyAll(t.Functions),
+	}
+}
+
 type ModuleFunction struct {
 	// The name of the function.
 	Name string `json:"name,omitempty"`
@@ -56,33 +67,12 @@ type ModuleFunction struct {
 }
 
 func (f *ModuleFunction) Copy() *ModuleFunction {
-
-	function := &ModuleFunction{
+	return &ModuleFunction{
 		Name:          f.Name,
-		ParameterSets: make([][]*Parameter, 0),
-		Description:   make([]*ContentBlock, 0),
-		Return:        make([]*ModuleValue, 0),
-	}
-
-	for _, set := range f.ParameterSets {
-
-		params := make([]*Parameter, 0)
-		for _, param := range set {
-			params = append(params, param.Copy())
-		}
-
-		function.ParameterSets = append(function.ParameterSets, set)
+		ParameterSets: copySets(f.ParameterSets),
+		Description:   copyAll(f.Description),
+		Return:        copyAll(f.Return),
 	}
-
-	for _, block := range f.Description {
-		function.Description = append(function.Description, block) // need copy?
-	}
-
-	for _, v := range f.Return {
-		function.Return = append(function.Return, v.Copy())
-	}
-
-	return function
 }
 
 type Parameter struct {
@@ -95,17 +85,12 @@ type Parameter struct {
 }
 
 func (p *Parameter) Copy() *Parameter {
-	param := &Parameter{
-		Name:     p.Name,
-		Types:    make([]string, 0),
-		Optional: p.Optional,
-	}
-
-	for _, t := range p.Types {
-		param.Types = append(param.Types, t)
+	return &Parameter{
+		Name:        p.Name,
+		Types:       copyStrings(p.Types),
+		Description: p.Description,
+		Optional:    p.Optional,
 	}
-
-	return param
 }
 
 type ModuleValue struct {
@@ -116,16 +101,10 @@ type ModuleValue struct {
 }
 
 func (v *ModuleValue) Copy() *ModuleValue {
-	value := &ModuleValue{
-		Types:       make([]string, 0),
+	return &ModuleValue{
+		Types:       copyStrings(v.Types),
 		Description: v.Description,
 	}
-
-	for _, t := range v.Types {
-		value.Types = append(value.Types, t)
-	}
-
-	return value
 }
 
 type ModuleProperty struct {
@@ -140,93 +119,97 @@ type ModuleProperty struct {
 }
 
 func (p *ModuleProperty) Copy() *ModuleProperty {
-	property := &ModuleProperty{
+	return &ModuleProperty{
 		Name:        p.Name,
-		Types:       make([]string, 0),
-		Description: make([]*ContentBlock, 0),
+		Types:       copyStrings(p.Types),
+		Description: copyAll(p.Description),
 		ReadOnly:    p.ReadOnly,
 	}
+}
+
+// Doc converts the module into the documentation model.
+func (m *Module) Doc() *Doc {
 
-	for _, t := range p.Types {
-		property.Types = append(property.Types, t)
+	doc := &Doc{
+		Kind:         DocKindModule,
+		Title:        m.Name,
+		Keywords:     copyStrings(m.Keywords),
+		Blocks:       copyAll(m.Description),
+		Types:        make([]*DocType, 0),
+		ResourcePath: m.ResourcePath,
 	}
 
-	for _, block := range p.Description {
-		property.Description = append(property.Description, block) // need copy?
+	for _, mType := range m.Types {
+		doc.Types = append(doc.Types, mType.Doc())
 	}
 
-	return property
+	return doc
 }
 
-// Returns best possible title for page
-func (m *Module) GetTitle() string {
-	if m.Name != "" {
-		return m.Name
+func (t *ModuleType) Doc() *DocType {
+
+	docType := &DocType{
+		Name:        t.Name,
+		Description: copyAll(t.Description),
+		Functions:   make([]*DocFunction, 0),
+		Properties:  make([]*DocProperty, 0),
 	}
-	if m.Types == nil && len(m.Types) > 0 {
-		return m.Types[0].Name
+
+	for _, f := range t.Functions {
+		docType.Functions = append(docType.Functions, f.Doc())
 	}
-	return "Module"
-}
 
-func sanitizeBlocks(blocks []*ContentBlock) {
-	if blocks == nil {
-		return
+	for _, p := range t.Properties {
+		docType.Properties = append(docType.Properties, p.Doc())
 	}
 
-	reInlineCode := regexp.MustCompile("`([^`]+)`")
-	inlineCodeReplacement := `<span class="code">$1</span>`
-	// inlineCodeReplacementMetaDescription := `$1`
+	return docType
+}
 
-	reLink := regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
-	linkReplacement := `<a href="$2">$1</a>`
-	// linkReplacementMetaDescription := `$1`
+func (f *ModuleFunction) Doc() *DocFunction {
 
-	reTypeLink := regexp.MustCompile(`\[([A-Za-z0-9]+)\]`)
-	// typeLinkReplacementMetaDescription := `$1`
+	function := &DocFunction{
+		Name:        f.Name,
+		Description: copyAll(f.Description),
+		Return:      make([]*DocValue, 0),
+	}
 
-	for _, b := range blocks {
-		if b.Text != "" {
-			b.Text = strings.TrimSpace(b.Text)
-			b.Text = strings.ReplaceAll(b.Text, "\n", "<br>")
-			b.Text = reInlineCode.ReplaceAllString(b.Text, inlineCodeReplacement)
-			b.Text = reLink.ReplaceAllString(b.Text, linkReplacement)
-			b.Text = reTypeLink.ReplaceAllStringFunc(b.Text, getTypeLink)
+	for _, set := range f.ParameterSets {
+		params := make([]*DocParameter, 0)
+		for _, param := range set {
+			params = append(params, param.Doc())
 		}
+		function.ParameterSets = append(function.ParameterSets, params)
 	}
-}
-
-func (m *Module) Sanitize() {
-
-	sanitizeBlocks(m.Description)
-
-	for _, mType := range m.Types {
-
-		sanitizeBlocks(mType.Description)
 
-		for _, f := range mType.Functions {
-			sanitizeBlocks(f.Description)
-		}
+	for _, v := range f.Return {
+		function.Return = append(function.Return, v.Doc())
+	}
 
-		for _, p := range mType.Properties {
-			sanitizeBlocks(p.Description)
-		}
+	return function
+}
 
-		sort.Sort(ModuleFunctionsByName(mType.Functions))
-		sort.Sort(ModulePropertiesByName(mType.Properties))
+func (p *Parameter) Doc() *DocParameter {
+	return &DocParameter{
+		Name:        p.Name,
+		Types:       append(make([]string, 0), p.Types...),
+		Description: p.Description,
+		Optional:    p.Optional,
 	}
 }
 
-// sort.Interface implementations
-
-type ModulePropertiesByName []*ModuleProperty
-
-func (a ModulePropertiesByName) Len() int           { return len(a) }
-func (a ModulePropertiesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ModulePropertiesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
-
-type ModuleFunctionsByName []*ModuleFunction
+func (v *ModuleValue) Doc() *DocValue {
+	return &DocValue{
+		Types:       append(make([]string, 0), v.Types...),
+		Description: v.Description,
+	}
+}
 
-func (a ModuleFunctionsByName) Len() int           { return len(a) }
-func (a ModuleFunctionsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ModuleFunctionsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (p *ModuleProperty) Doc() *DocProperty {
+	return &DocProperty{
+		Name:        p.Name,
+		Types:       append(make([]string, 0), p.Types...),
+		Description: copyAll(p.Description),
+		ReadOnly:    p.ReadOnly,
+	}
+}
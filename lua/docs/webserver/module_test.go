@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestModuleCopy ensures copies of module members are equal to their
+// original, and can be modified without affecting it.
+// TestCopyIsComplete checks that all fields are copied.
+func TestModuleCopy(t *testing.T) {
+
+	value := &ModuleValue{Types: []string{"Object", "nil"}, Description: "created object"}
+
+	property := &ModuleProperty{
+		Name:        "count",
+		Types:       []string{"integer"},
+		Description: []*ContentBlock{{Text: "Number of objects."}},
+		ReadOnly:    true,
+	}
+
+	function := &ModuleFunction{
+		Name: "create",
+		ParameterSets: [][]*Parameter{
+			{{Name: "config", Types: []string{"table"}, Optional: true, Description: "Creation config"}},
+			{{Name: "name", Types: []string{"string"}, Description: "Object name"}, {Name: "parent", Types: []string{"Object"}}},
+		},
+		Description: []*ContentBlock{{Text: "Creates an object."}},
+		Return:      []*ModuleValue{value},
+	}
+
+	tests := []struct {
+		name     string
+		original interface{}
+		copy     func() interface{}
+	}{
+		{"value", value, func() interface{} { return value.Copy() }},
+		{"property", property, func() interface{} { return property.Copy() }},
+		{"function", function, func() interface{} { return function.Copy() }},
+		{"parameter", function.ParameterSets[0][0], func() interface{} { return function.ParameterSets[0][0].Copy() }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := test.copy()
+			if c == test.original {
+				t.Fatalf("Copy returned the original")
+			}
+			if !reflect.DeepEqual(c, test.original) {
+				t.Errorf("copy differs from original:\n got: %+v\nwant: %+v", c, test.original)
+			}
+		})
+	}
+
+	// modifying copies doesn't affect originals
+	valueCopy := value.Copy()
+	valueCopy.Types[0] = "Shape"
+	valueCopy.Description = "modified"
+	if value.Types[0] != "Object" || value.Description != "created object" {
+		t.Errorf("value modified through its copy: %+v", value)
+	}
+
+	propertyCopy := property.Copy()
+	propertyCopy.Types[0] = "number"
+	propertyCopy.Description[0].Text = "modified"
+	if property.Types[0] != "integer" || property.Description[0].Text != "Number of objects." {
+		t.Errorf("property modified through its copy: %+v", property)
+	}
+
+	functionCopy := function.Copy()
+	functionCopy.Return[0].Types[0] = "Shape"
+	functionCopy.ParameterSets[1][0].Name = "modified"
+	functionCopy.ParameterSets[1][0].Description = "modified"
+	functionCopy.ParameterSets[0] = append(functionCopy.ParameterSets[0], &Parameter{Name: "added"})
+	functionCopy.Description[0].Text = "modified"
+	if function.Return[0].Types[0] != "Object" {
+		t.Errorf("function return values are shared with the copy")
+	}
+	if p := function.ParameterSets[1][0]; p.Name != "name" || p.Description != "Object name" || len(function.ParameterSets[0]) != 1 {
+		t.Errorf("function parameters are shared with the copy")
+	}
+	if function.Description[0].Text != "Creates an object." {
+		t.Errorf("function description blocks are shared with the copy")
+	}
+}
+
+func TestModuleDoc(t *testing.T) {
+
+	module := &Module{
+		Name:        "sample",
+		Keywords:    []string{"test"},
+		Description: []*ContentBlock{{Text: "A module."}},
+		Types: []*ModuleType{
+			{
+				Name: "sample",
+				Functions: []*ModuleFunction{
+					{
+						Name: "create",
+						ParameterSets: [][]*Parameter{
+							{{Name: "config", Types: []string{"table"}, Optional: true, Description: "config"}},
+						},
+						Return: []*ModuleValue{{Types: []string{"Object"}}},
+					},
+				},
+				Properties: []*ModuleProperty{{Name: "count", Types: []string{"integer"}, ReadOnly: true}},
+			},
+		},
+	}
+
+	doc := module.Doc()
+
+	if doc.Kind != DocKindModule || doc.GetTitle() != "sample" {
+		t.Errorf("kind %d, title %q", doc.Kind, doc.GetTitle())
+	}
+	if doc.Blocks[0] == module.Description[0] {
+		t.Errorf("description blocks are shared with the module")
+	}
+
+	expectedFunction := &DocFunction{
+		Name: "create",
+		ParameterSets: [][]*DocParameter{
+			{{Name: "config", Types: []string{"table"}, Optional: true, Description: "config"}},
+		},
+		Return: []*DocValue{{Types: []string{"Object"}}},
+	}
+	if !reflect.DeepEqual(doc.Types[0].Functions[0], expectedFunction) {
+		t.Errorf("function:\n got: %+v\nwant: %+v", doc.Types[0].Functions[0], expectedFunction)
+	}
+
+	expectedProperty := &DocProperty{Name: "count", Types: []string{"integer"}, ReadOnly: true}
+	if !reflect.DeepEqual(doc.Types[0].Properties[0], expectedProperty) {
+		t.Errorf("property:\n got: %+v\nwant: %+v", doc.Types[0].Properties[0], expectedProperty)
+	}
+}
@@ -1,13 +1,5 @@
 package main
 
-import (
-	"regexp"
-	"sort"
-	"strings"
-
-	"github.com/gosimple/slug"
-)
-
 // Page describes possible content for one page
 // in the documentation.
 type Page struct {
@@ -15,9 +7,6 @@ type Page struct {
 	// meta keywords
 	Keywords []string `yaml:"keywords,omitempty"`
 
-	// meta description, built from Description
-	MetaDescription string `yaml:"-,omitempty"`
-
 	// meta description
 	Description string `yaml:"description,omitempty"`
 
@@ -67,6 +56,30 @@ type Page struct {
 	ExtentionBaseSet bool `yaml:"-"`
 }
 
+// Copy returns a deep copy of the page.
+// Base is not copied, the copy extends the same base page.
+func (p *Page) Copy() *Page {
+	return &Page{
+		Keywords:         copyStrings(p.Keywords),
+		Description:      p.Description,
+		Title:            p.Title,
+		Type:             p.Type,
+		Extends:          p.Extends,
+		Base:             p.Base,
+		BasicType:        p.BasicType,
+		Creatable:        p.Creatable,
+		Blocks:           copyAll(p.Blocks),
+		Constructors:     copyAll(p.Constructors),
+		Properties:       copyAll(p.Properties),
+		BaseProperties:   copyByKey(p.BaseProperties),
+		BuiltIns:         copyAll(p.BuiltIns),
+		Functions:        copyAll(p.Functions),
+		BaseFunctions:    copyByKey(p.BaseFunctions),
+		ResourcePath:     p.ResourcePath,
+		ExtentionBaseSet: p.ExtentionBaseSet,
+	}
+}
+
 type Function struct {
 	Name      string      `yaml:"name,omitempty"`
 	Arguments []*Argument `yaml:"arguments,omitempty"`
@@ -80,30 +93,16 @@ type Function struct {
 }
 
 func (f *Function) Copy() *Function {
-
-	function := &Function{
-		Name:        f.Name,
-		Description: f.Description,
-		ComingSoon:  f.ComingSoon,
-		Hide:        f.Hide,
-		Arguments:   make([]*Argument, 0),
-		Samples:     make([]*Sample, 0),
-		Return:      make([]*Value, 0),
-	}
-
-	for _, a := range f.Arguments {
-		function.Arguments = append(function.Arguments, a.Copy())
-	}
-
-	for _, s := range f.Samples {
-		function.Samples = append(function.Samples, s.Copy())
-	}
-
-	for _, v := range f.Return {
-		function.Return = append(function.Return, v.Copy())
+	return &Function{
+		Name:         f.Name,
+		Arguments:    copyAll(f.Arguments),
+		ArgumentSets: copySets(f.ArgumentSets),
+		Description:  f.Description,
+		Samples:      copyAll(f.Samples),
+		Return:       copyAll(f.Return),
+		ComingSoon:   f.ComingSoon,
+		Hide:         f.Hide,
 	}
-
-	return function
 }
 
 type Argument struct {
@@ -134,23 +133,6 @@ func (v *Value) Copy() *Value {
 	return value
 }
 
-type Sample struct {
-	Code  string `yaml:"code,omitempty"`
-	Media string `yaml:"media,omitempty"`
-}
-
-func (s *Sample) Copy() *Sample {
-	sample := &Sample{
-		Code:  s.Code,
-		Media: s.Media,
-	}
-	return sample
-}
-
-func SampleHasCodeAndMedia(s *Sample) bool {
-	return s.Code != "" && s.Media != ""
-}
-
 type Property struct {
 	Name string `yaml:"name,omitempty"`
 	Type string `yaml:"type,omitempty"`
@@ -164,21 +146,16 @@ type Property struct {
 }
 
 func (p *Property) Copy() *Property {
-	property := &Property{
+	return &Property{
 		Name:        p.Name,
 		Type:        p.Type,
+		Types:       copyStrings(p.Types),
 		Description: p.Description,
+		Samples:     copyAll(p.Samples),
 		ReadOnly:    p.ReadOnly,
 		ComingSoon:  p.ComingSoon,
 		Hide:        p.Hide,
-		Samples:     make([]*Sample, 0),
 	}
-
-	for _, s := range p.Samples {
-		property.Samples = append(property.Samples, s.Copy())
-	}
-
-	return property
 }
 
 // SetExtensionBase sets base property fields when extension ones are empty
@@ -204,76 +181,15 @@ func (p *Property) SetExtensionBase(baseProperty *Property) {
 	}
 
 	if p.Samples == nil || len(p.Samples) == 0 {
-		p.Samples = make([]*Sample, 0)
-		for _, s := range baseProperty.Samples {
-			p.Samples = append(p.Samples, s.Copy())
-		}
+		p.Samples = copyAll(baseProperty.Samples)
 	}
 }
 
-// Only one attribute can be set, others will
-// be ignored if set.
-type ContentBlock struct {
-	Text string `yaml:"text,omitempty" json:"text,omitempty"`
-	// Lua code
-	Code     string   `yaml:"code,omitempty" json:"code,omitempty"`
-	List     []string `yaml:"list,omitempty" json:"list,omitempty"`
-	Title    string   `yaml:"title,omitempty" json:"title,omitempty"`
-	Subtitle string   `yaml:"subtitle,omitempty" json:"subtitle,omitempty"`
-	// Can be a relative link to an image (png / jpeg)
-	Image string `yaml:"image,omitempty" json:"image,omitempty"`
-	// Can be a relative link to a movie, a link to a youtube video...
-	Media string `yaml:"media,omitempty" json:"media,omitempty"`
-	// Keys couple:
-	//  title: Display name for the audio player
-	//  file: Relative link to a sound file (.mp3)
-	Audio map[string]string `yaml:"audio,omitempty" json:"audio,omitempty"`
-	// List of key couples:
-	//  title: Display name for the audio player
-	//  file: Relative link to a sound file (.mp3)
-	AudioList []map[string]string `yaml:"audiolist,omitempty" json:"audiolist,omitempty"`
-}
-
-// Returns best possible title for page
-func (p *Page) GetTitle() string {
-	if p.Type != "" {
-		return p.Type
-	}
-	return p.Title
-}
-
-// IsNotCreatableObject returns true if the page describes an object
-// that can't be created, has to be accessed through its global variable.
-func (p *Page) IsNotCreatableObject() bool {
-	return p.Creatable == false && p.BasicType == false && p.Type != "" && (p.Constructors == nil || len(p.Constructors) == 0)
-}
-
 // ReadyToBeSetAsBase ...
 func (p *Page) ReadyToBeSetAsBase() bool {
 	return p.Extends == "" || p.ExtentionBaseSet == true
 }
 
-var currentType = ""
-
-func getTypeLink(str string) string {
-
-	str = strings.TrimSuffix(str, "]")
-	str = strings.TrimPrefix(str, "[")
-
-	if str == "This" {
-		str = currentType
-	}
-
-	if route, ok := typeRoutes[str]; ok {
-		str = "<a class=\"type\" href=\"" + route + "\">" + str + "</a>"
-	} else {
-		// fallback to local type anchor (may not work)
-		str = "<a class=\"type\" href=\"#type-" + slug.Make(str) + "\">" + str + "</a>"
-	}
-
-	return str
-}
-
 // SetExtentionBase imports definition from extension base
 func (p *Page) SetExtentionBase(base *Page) {
 
@@ -345,142 +261,166 @@ func (p *Page) SetExtentionBase(base *Page) {
 	}
 }
 
-func (p *Page) Sanitize() {
-
-	currentType = p.Type
-
-	reInlineCode := regexp.MustCompile("`([^`]+)`")
-	inlineCodeReplacement := `<span class="code">$1</span>`
-	inlineCodeReplacementMetaDescription := `$1`
+// Doc converts the page into the documentation model.
+// Extension base has to be set before that, for inherited
+// functions and properties to be part of the doc.
+func (p *Page) Doc() *Doc {
+
+	doc := &Doc{
+		Kind:            DocKindPage,
+		Title:           p.Title,
+		Keywords:        copyStrings(p.Keywords),
+		MetaDescription: p.Description,
+		ResourcePath:    p.ResourcePath,
+	}
 
-	reLink := regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
-	linkReplacement := `<a href="$2">$1</a>`
-	linkReplacementMetaDescription := `$1`
+	// Description is only used as meta description
+	// when the page is made of content blocks.
+	if len(p.Blocks) > 0 {
+		doc.Blocks = copyAll(p.Blocks)
+	} else {
+		doc.Blocks = textBlocks(p.Description)
+	}
 
-	reTypeLink := regexp.MustCompile(`\[([A-Za-z0-9]+)\]`)
-	typeLinkReplacementMetaDescription := `$1`
+	if p.Type == "" {
+		return doc
+	}
 
-	if p.Description != "" {
-		p.Description = strings.TrimSpace(p.Description)
-		p.MetaDescription = p.Description
-		p.Description = strings.ReplaceAll(p.Description, "\n", "<br>")
-		p.Description = reInlineCode.ReplaceAllString(p.Description, inlineCodeReplacement)
-		p.Description = reLink.ReplaceAllString(p.Description, linkReplacement)
-		p.Description = reTypeLink.ReplaceAllStringFunc(p.Description, getTypeLink)
+	doc.Title = p.Type
+
+	t := &DocType{
+		Name:         p.Type,
+		Extends:      p.Extends,
+		BasicType:    p.BasicType,
+		Creatable:    p.Creatable,
+		Constructors: make([]*DocFunction, 0),
+		BuiltIns:     make([]*DocProperty, 0),
+		Functions:    make([]*DocFunction, 0),
+		Properties:   make([]*DocProperty, 0),
+	}
 
-		p.MetaDescription = strings.ReplaceAll(p.MetaDescription, "\n", " ")
-		p.MetaDescription = reInlineCode.ReplaceAllString(p.MetaDescription, inlineCodeReplacementMetaDescription)
-		p.MetaDescription = reLink.ReplaceAllString(p.MetaDescription, linkReplacementMetaDescription)
-		p.MetaDescription = reTypeLink.ReplaceAllString(p.MetaDescription, typeLinkReplacementMetaDescription)
+	for _, c := range p.Constructors {
+		t.Constructors = append(t.Constructors, c.Doc())
 	}
 
-	if p.Blocks != nil {
-		for _, b := range p.Blocks {
-			if b.Text != "" {
-				b.Text = strings.TrimSpace(b.Text)
-				b.Text = strings.ReplaceAll(b.Text, "\n", "<br>")
-				b.Text = reInlineCode.ReplaceAllString(b.Text, inlineCodeReplacement)
-				b.Text = reLink.ReplaceAllString(b.Text, linkReplacement)
-				b.Text = reTypeLink.ReplaceAllStringFunc(b.Text, getTypeLink)
-			}
-		}
+	for _, b := range p.BuiltIns {
+		t.BuiltIns = append(t.BuiltIns, b.Doc())
 	}
 
-	if p.Constructors != nil {
-		for _, c := range p.Constructors {
-			if c.Description != "" {
-				c.Description = strings.TrimSpace(c.Description)
-				c.Description = strings.ReplaceAll(c.Description, "\n", "<br>")
-				c.Description = reInlineCode.ReplaceAllString(c.Description, inlineCodeReplacement)
-				c.Description = reLink.ReplaceAllString(c.Description, linkReplacement)
-				c.Description = reTypeLink.ReplaceAllStringFunc(c.Description, getTypeLink)
-			}
-		}
+	for _, f := range p.Functions {
+		t.Functions = append(t.Functions, f.Doc())
 	}
 
-	if p.Functions != nil {
-		for _, f := range p.Functions {
-			if f.Description != "" {
-				f.Description = strings.TrimSpace(f.Description)
-				f.Description = strings.ReplaceAll(f.Description, "\n", "<br>")
-				f.Description = reInlineCode.ReplaceAllString(f.Description, inlineCodeReplacement)
-				f.Description = reLink.ReplaceAllString(f.Description, linkReplacement)
-				f.Description = reTypeLink.ReplaceAllStringFunc(f.Description, getTypeLink)
-			}
-		}
+	for _, prop := range p.Properties {
+		t.Properties = append(t.Properties, prop.Doc())
 	}
 
 	if p.BaseFunctions != nil {
-		for _, functions := range p.BaseFunctions {
+		t.BaseFunctions = make(map[string][]*DocFunction)
+		for base, functions := range p.BaseFunctions {
+			t.BaseFunctions[base] = make([]*DocFunction, 0)
 			for _, f := range functions {
-				if f.Description != "" {
-					f.Description = strings.TrimSpace(f.Description)
-					f.Description = strings.ReplaceAll(f.Description, "\n", "<br>")
-					f.Description = reInlineCode.ReplaceAllString(f.Description, inlineCodeReplacement)
-					f.Description = reLink.ReplaceAllString(f.Description, linkReplacement)
-					f.Description = reTypeLink.ReplaceAllStringFunc(f.Description, getTypeLink)
-				}
-			}
-		}
-	}
-
-	if p.Properties != nil {
-		for _, prop := range p.Properties {
-			if prop.Description != "" {
-				prop.Description = strings.TrimSpace(prop.Description)
-				prop.Description = strings.ReplaceAll(prop.Description, "\n", "<br>")
-				prop.Description = reInlineCode.ReplaceAllString(prop.Description, inlineCodeReplacement)
-				prop.Description = reLink.ReplaceAllString(prop.Description, linkReplacement)
-				prop.Description = reTypeLink.ReplaceAllStringFunc(prop.Description, getTypeLink)
+				t.BaseFunctions[base] = append(t.BaseFunctions[base], f.Doc())
 			}
 		}
 	}
 
 	if p.BaseProperties != nil {
-		for _, properties := range p.BaseProperties {
+		t.BaseProperties = make(map[string][]*DocProperty)
+		for base, properties := range p.BaseProperties {
+			t.BaseProperties[base] = make([]*DocProperty, 0)
 			for _, prop := range properties {
-				if prop.Description != "" {
-					prop.Description = strings.TrimSpace(prop.Description)
-					prop.Description = strings.ReplaceAll(prop.Description, "\n", "<br>")
-					prop.Description = reInlineCode.ReplaceAllString(prop.Description, inlineCodeReplacement)
-					prop.Description = reLink.ReplaceAllString(prop.Description, linkReplacement)
-					prop.Description = reTypeLink.ReplaceAllStringFunc(prop.Description, getTypeLink)
-				}
+				t.BaseProperties[base] = append(t.BaseProperties[base], prop.Doc())
 			}
 		}
 	}
 
-	if p.BuiltIns != nil {
-		for _, b := range p.BuiltIns {
-			if b.Description != "" {
-				b.Description = strings.TrimSpace(b.Description)
-				b.Description = strings.ReplaceAll(b.Description, "\n", "<br>")
-				b.Description = reInlineCode.ReplaceAllString(b.Description, inlineCodeReplacement)
-				b.Description = reLink.ReplaceAllString(b.Description, linkReplacement)
-				b.Description = reTypeLink.ReplaceAllStringFunc(b.Description, getTypeLink)
-			}
+	doc.Types = []*DocType{t}
+
+	return doc
+}
+
+func (f *Function) Doc() *DocFunction {
+
+	function := &DocFunction{
+		Name:        f.Name,
+		Description: textBlocks(f.Description),
+		Samples:     make([]*Sample, 0),
+		Return:      make([]*DocValue, 0),
+		ComingSoon:  f.ComingSoon,
+		Hide:        f.Hide,
+	}
+
+	// ArgumentSets is used instead of Arguments
+	// when different argument options are available.
+	argumentSets := f.ArgumentSets
+	if len(argumentSets) == 0 && len(f.Arguments) > 0 {
+		argumentSets = [][]*Argument{f.Arguments}
+	}
+
+	for _, arguments := range argumentSets {
+		params := make([]*DocParameter, 0)
+		for _, a := range arguments {
+			params = append(params, a.Doc())
 		}
+		function.ParameterSets = append(function.ParameterSets, params)
 	}
 
-	sort.Sort(FunctionsByName(p.Functions))
-	sort.Sort(PropertiesByName(p.Properties))
-	sort.Sort(PropertiesByName(p.BuiltIns))
+	for _, s := range f.Samples {
+		function.Samples = append(function.Samples, s.Copy())
+	}
+
+	for _, v := range f.Return {
+		function.Return = append(function.Return, v.Doc())
+	}
+
+	return function
 }
 
-func GetAnchorLink(s string) string {
-	return slug.Make(s)
+func (a *Argument) Doc() *DocParameter {
+	return &DocParameter{
+		Name:     a.Name,
+		Types:    singleType(a.Type),
+		Optional: a.Optional,
+	}
 }
 
-// sort.Interface implementations
+func (v *Value) Doc() *DocValue {
+	return &DocValue{
+		Types:       singleType(v.Type),
+		Description: v.Description,
+	}
+}
 
-type PropertiesByName []*Property
+func (p *Property) Doc() *DocProperty {
 
-func (a PropertiesByName) Len() int           { return len(a) }
-func (a PropertiesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a PropertiesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+	property := &DocProperty{
+		Name:        p.Name,
+		Types:       make([]string, 0),
+		Description: textBlocks(p.Description),
+		Samples:     make([]*Sample, 0),
+		ReadOnly:    p.ReadOnly,
+		ComingSoon:  p.ComingSoon,
+		Hide:        p.Hide,
+	}
+
+	// Type has priority over Types
+	if p.Type != "" {
+		property.Types = append(property.Types, p.Type)
+	} else {
+		property.Types = append(property.Types, p.Types...)
+	}
+
+	for _, s := range p.Samples {
+		property.Samples = append(property.Samples, s.Copy())
+	}
 
-type FunctionsByName []*Function
+	return property
+}
 
-func (a FunctionsByName) Len() int           { return len(a) }
-func (a FunctionsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a FunctionsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func singleType(t string) []string {
+	if t == "" {
+		return make([]string, 0)
+	}
+	return []string{t}
+}
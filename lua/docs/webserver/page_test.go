@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+)
+
+func functionNames(functions []*Function) []string {
+	names := make([]string, 0)
+	for _, f := range functions {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func propertyNames(properties []*Property) []string {
+	names := make([]string, 0)
+	for _, p := range properties {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetExtentionBase(t *testing.T) {
+
+	object := &Page{
+		Type: "Object",
+		Functions: []*Function{
+			{Name: "AddChild", Description: "Adds a child."},
+			{Name: "RemoveChild", Description: "Removes a child."},
+		},
+		Properties: []*Property{
+			{Name: "Position", Type: "Number3", Description: "Position."},
+			{Name: "IsHidden", Type: "boolean", Description: "Hidden.", ReadOnly: true, ComingSoon: true,
+				Samples: []*Sample{{Code: "o.IsHidden = true"}}},
+		},
+	}
+
+	shape := &Page{
+		Type:    "Shape",
+		Extends: "Object",
+		Functions: []*Function{
+			{Name: "RemoveChild", Description: "Removes a child shape."},
+			{Name: "GetBlock"},
+		},
+		Properties: []*Property{
+			{Name: "IsHidden", Type: "boolean"},
+			{Name: "Width", Type: "number"},
+		},
+	}
+
+	mutableShape := &Page{
+		Type:    "MutableShape",
+		Extends: "Shape",
+		Functions: []*Function{
+			{Name: "AddBlock"},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		page               *Page
+		base               *Page
+		baseFunctions      map[string][]string
+		baseProperties     map[string][]string
+		expectedFunctions  []string
+		expectedProperties []string
+	}{
+		{
+			name: "direct extension",
+			page: shape,
+			base: object,
+			baseFunctions: map[string][]string{
+				"Object": {"AddChild"},
+			},
+			baseProperties: map[string][]string{
+				"Object": {"Position"},
+			},
+			expectedFunctions:  []string{"RemoveChild", "GetBlock"},
+			expectedProperties: []string{"IsHidden", "Width"},
+		},
+		{
+			name: "extension of an extension",
+			page: mutableShape,
+			base: shape,
+			baseFunctions: map[string][]string{
+				"Object": {"AddChild", "RemoveChild"},
+				"Shape":  {"RemoveChild", "GetBlock"},
+			},
+			baseProperties: map[string][]string{
+				"Object": {"Position", "IsHidden"},
+				"Shape":  {"IsHidden", "Width"},
+			},
+			expectedFunctions:  []string{"AddBlock"},
+			expectedProperties: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.page.SetExtentionBase(test.base)
+
+			if test.page.Base != test.base {
+				t.Errorf("Base not set")
+			}
+
+			if len(test.page.BaseFunctions) != len(test.baseFunctions) {
+				t.Errorf("functions from %d bases, want %d", len(test.page.BaseFunctions), len(test.baseFunctions))
+			}
+			for typeName, expected := range test.baseFunctions {
+				if names := functionNames(test.page.BaseFunctions[typeName]); !equalStrings(names, expected) {
+					t.Errorf("functions from %s: %v, want %v", typeName, names, expected)
+				}
+			}
+
+			if len(test.page.BaseProperties) != len(test.baseProperties) {
+				t.Errorf("properties from %d bases, want %d", len(test.page.BaseProperties), len(test.baseProperties))
+			}
+			for typeName, expected := range test.baseProperties {
+				if names := propertyNames(test.page.BaseProperties[typeName]); !equalStrings(names, expected) {
+					t.Errorf("properties from %s: %v, want %v", typeName, names, expected)
+				}
+			}
+
+			if names := functionNames(test.page.Functions); !equalStrings(names, test.expectedFunctions) {
+				t.Errorf("functions: %v, want %v", names, test.expectedFunctions)
+			}
+			if names := propertyNames(test.page.Properties); !equalStrings(names, test.expectedProperties) {
+				t.Errorf("properties: %v, want %v", names, test.expectedProperties)
+			}
+		})
+	}
+
+	// inherited members are copies
+	if shape.BaseFunctions["Object"][0] == object.Functions[0] {
+		t.Errorf("base function is not a copy")
+	}
+	if shape.BaseProperties["Object"][0] == object.Properties[0] {
+		t.Errorf("base property is not a copy")
+	}
+
+	// overridden property gets missing fields from base
+	isHidden := shape.Properties[0]
+	if isHidden.Description != "Hidden." {
+		t.Errorf("overridden property description %q, want %q", isHidden.Description, "Hidden.")
+	}
+	if !isHidden.ReadOnly {
+		t.Errorf("overridden property should be read-only, like its base")
+	}
+	if !isHidden.ComingSoon {
+		t.Errorf("overridden property should be coming soon, like its base")
+	}
+	if len(isHidden.Samples) != 1 || isHidden.Samples[0] == object.Properties[1].Samples[0] {
+		t.Errorf("overridden property should get a copy of base samples")
+	}
+}
+
+func TestReadyToBeSetAsBase(t *testing.T) {
+	tests := []struct {
+		page     *Page
+		expected bool
+	}{
+		{&Page{Type: "Object"}, true},
+		{&Page{Type: "Shape", Extends: "Object"}, false},
+		{&Page{Type: "Shape", Extends: "Object", ExtentionBaseSet: true}, true},
+	}
+
+	for _, test := range tests {
+		if result := test.page.ReadyToBeSetAsBase(); result != test.expected {
+			t.Errorf("%s extends %q: ReadyToBeSetAsBase() = %v, want %v", test.page.Type, test.page.Extends, result, test.expected)
+		}
+	}
+}
@@ -69,6 +69,7 @@ local mt = {
 		if prefLanguages == nil then
 			-- hack to test languages:
 			-- prefLanguages = { "pl" } -- ua
+			-- prefLanguages = { "xx" } -- pseudo-locale, to test layouts
 			prefLanguages = Client.PreferredLanguages
 		end
 
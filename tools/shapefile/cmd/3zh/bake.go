@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/lighting"
+)
+
+var bakeCommand = &command{
+	name:  "bake",
+	usage: "[flags] <file.3zh|directory>...",
+	description: "Computes baked lighting of .3zh files, like the engine does when loading them, " +
+		"and writes it in the files.",
+	run: runBake,
+}
+
+func runBake(flags *flag.FlagSet, args []string) error {
+
+	check := flags.Bool("check", false, "only list files with missing or outdated baked lighting")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files, err := shapeFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	outdated := 0
+	for _, path := range files {
+		f, err := shapefile.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		shapes := lighting.Check(f)
+		if len(shapes) == 0 {
+			fmt.Println(path+":", "ok")
+			continue
+		}
+		outdated++
+
+		if *check {
+			for _, s := range shapes {
+				name := s.Name
+				if name == "" {
+					name = "(no name)"
+				}
+				fmt.Printf("%s: %s (#%d) baked lighting missing or outdated\n", path, name, s.ID)
+			}
+			continue
+		}
+
+		lighting.Bake(f)
+		if err := shapefile.WriteFile(path, f); err != nil {
+			return err
+		}
+		fmt.Println(path+":", "baked")
+	}
+
+	if *check && outdated > 0 {
+		return fmt.Errorf("%d files to bake out of %d", outdated, len(files))
+	}
+	return nil
+}
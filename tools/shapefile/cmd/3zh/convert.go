@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/gltf"
+	"cu.bzh/tools/shapefile/vox"
+)
+
+var convertCommand = &command{
+	name:        "convert",
+	usage:       "[flags] <input> <output>",
+	description: "Converts between .3zh and MagicaVoxel .vox files, listing what's lost, or exports .3zh files to glTF (.gltf, .glb).",
+	run:         runConvert,
+}
+
+func runConvert(flags *flag.FlagSet, args []string) error {
+
+	strict := flags.Bool("strict", false, "fail if anything is lost in the conversion")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	input, output := flags.Arg(0), flags.Arg(1)
+
+	var report *vox.Report
+	var err error
+
+	switch ext := strings.ToLower(filepath.Ext(input)) + strings.ToLower(filepath.Ext(output)); ext {
+	case ".3zh.vox":
+		var f *shapefile.File
+		if f, err = shapefile.ReadFile(input); err != nil {
+			return err
+		}
+		var v *vox.File
+		if v, report, err = vox.FromShapefile(f); err != nil {
+			return err
+		}
+		err = writeIfComplete(report, *strict, func() error { return vox.WriteFile(output, v) })
+	case ".vox.3zh":
+		var v *vox.File
+		if v, err = vox.ReadFile(input); err != nil {
+			return err
+		}
+		var f *shapefile.File
+		if f, report, err = vox.ToShapefile(v); err != nil {
+			return err
+		}
+		err = writeIfComplete(report, *strict, func() error { return shapefile.WriteFile(output, f) })
+	case ".3zh.gltf", ".3zh.glb":
+		var f *shapefile.File
+		if f, err = shapefile.ReadFile(input); err != nil {
+			return err
+		}
+		err = writeGLTF(output, f, ext == ".3zh.glb")
+	default:
+		return fmt.Errorf("can only convert .3zh to .vox, .gltf or .glb, or .vox to .3zh")
+	}
+
+	if err != nil {
+		return err
+	}
+	fmt.Println(input, "->", output)
+	return nil
+}
+
+// writeIfComplete prints what was lost, and writes the output
+// unless something was lost in strict mode.
+func writeIfComplete(report *vox.Report, strict bool, write func() error) error {
+	for _, lost := range report.Lost {
+		fmt.Fprintln(os.Stderr, "lost:", lost)
+	}
+	if strict && len(report.Lost) > 0 {
+		return fmt.Errorf("%d things lost in conversion", len(report.Lost))
+	}
+	return write()
+}
+
+// writeGLTF exports a file to glTF, binary (.glb) or not.
+func writeGLTF(path string, f *shapefile.File, binary bool) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if binary {
+		err = gltf.EncodeGLB(out, f)
+	} else {
+		err = gltf.EncodeGLTF(out, f)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
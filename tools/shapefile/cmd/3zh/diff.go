@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/diff"
+	"cu.bzh/tools/shapefile/render"
+)
+
+var diffCommand = &command{
+	name:  "diff",
+	usage: "[flags] <old.3zh> <new.3zh>",
+	description: "Lists changes between .3zh files, object by object. " +
+		"Also works as a git external diff driver (7 arguments).",
+	run: runDiff,
+}
+
+var textconvCommand = &command{
+	name:        "textconv",
+	usage:       "<file.3zh>",
+	description: "Prints a text summary of a .3zh file, for git textconv.",
+	run:         runTextconv,
+}
+
+// nullFile is what git gives for added or removed files.
+const nullFile = "/dev/null"
+
+func runDiff(flags *flag.FlagSet, args []string) error {
+
+	pngOutput := flags.String("png", "", "also write renders of both files side by side in a PNG image")
+	flags.Parse(args)
+
+	var path, oldPath, newPath string
+	switch flags.NArg() {
+	case 2:
+		oldPath, newPath = flags.Arg(0), flags.Arg(1)
+	case 7:
+		// path old-file old-hex old-mode new-file new-hex new-mode
+		path, oldPath, newPath = flags.Arg(0), flags.Arg(1), flags.Arg(4)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, err := readDiffFile(oldPath)
+	if err != nil {
+		return err
+	}
+	b, err := readDiffFile(newPath)
+	if err != nil {
+		return err
+	}
+
+	d := diff.Compare(a, b)
+	if path != "" {
+		fmt.Println("diff", path)
+	}
+	if d.Empty() {
+		fmt.Println("no changes")
+	} else if err := d.Write(os.Stdout); err != nil {
+		return err
+	}
+
+	if *pngOutput != "" {
+		return writeSideBySide(*pngOutput, a, b)
+	}
+	return nil
+}
+
+func runTextconv(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	f, err := readDiffFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return diff.WriteSummary(os.Stdout, f)
+}
+
+// readDiffFile reads a file to compare, /dev/null being an empty file.
+func readDiffFile(path string) (*shapefile.File, error) {
+	if path == nullFile {
+		return &shapefile.File{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("version https://git-lfs")) {
+		return nil, fmt.Errorf("%s: Git LFS pointer (objects not pulled?)", path)
+	}
+	f, err := shapefile.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// writeSideBySide renders both files, the old one on the left.
+// Files without shapes leave their half empty.
+func writeSideBySide(path string, a, b *shapefile.File) error {
+	opts := render.DefaultOptions()
+	img := image.NewNRGBA(image.Rect(0, 0, opts.Width*2, opts.Height))
+
+	for i, f := range []*shapefile.File{a, b} {
+		rendered, err := render.Render(f, opts)
+		if errors.Is(err, render.ErrNoShape) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		draw.Draw(img, rendered.Bounds().Add(image.Pt(i*opts.Width, 0)), rendered, image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
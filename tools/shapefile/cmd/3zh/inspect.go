@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+)
+
+var inspectCommand = &command{
+	name:        "inspect",
+	usage:       "[flags] <file.3zh|directory>...",
+	description: "Describes the structure of .3zh files: chunks, objects, palette and points.",
+	run:         runInspect,
+}
+
+var validateCommand = &command{
+	name:        "validate",
+	usage:       "<file.3zh|directory>...",
+	description: "Checks .3zh files, listing problems found in invalid ones.",
+	run:         runValidate,
+}
+
+// inspection is what 3zh inspect prints about a file.
+type inspection struct {
+	Path        string        `json:"path"`
+	Size        int           `json:"size"`
+	Version     uint32        `json:"version,omitempty"`
+	Compression string        `json:"compression,omitempty"`
+	Chunks      []*chunkInfo  `json:"chunks"`
+	Objects     []*objectInfo `json:"objects"`
+	Palette     []colorInfo   `json:"palette,omitempty"`
+	Problems    []string      `json:"problems"`
+}
+
+type chunkInfo struct {
+	ID               uint8           `json:"id"`
+	Name             string          `json:"name"`
+	Offset           int             `json:"offset"`
+	Size             uint32          `json:"size"`
+	Compressed       bool            `json:"compressed,omitempty"`
+	UncompressedSize uint32          `json:"uncompressedSize,omitempty"`
+	SubChunks        []*subChunkInfo `json:"subChunks,omitempty"`
+}
+
+type subChunkInfo struct {
+	ID     uint8  `json:"id"`
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Size   uint32 `json:"size"`
+}
+
+type objectInfo struct {
+	ID       uint16        `json:"id"`
+	Name     string        `json:"name,omitempty"`
+	Size     [3]uint16     `json:"size"`
+	Blocks   int           `json:"blocks"`
+	Hidden   bool          `json:"hidden,omitempty"`
+	Position *[3]float32   `json:"position,omitempty"`
+	Rotation *[3]float32   `json:"rotation,omitempty"`
+	Scale    *[3]float32   `json:"scale,omitempty"`
+	Pivot    *[3]float32   `json:"pivot,omitempty"`
+	Lighting bool          `json:"bakedLighting,omitempty"`
+	Palette  []colorInfo   `json:"palette,omitempty"`
+	Points   []*pointInfo  `json:"points,omitempty"`
+	Children []*objectInfo `json:"children,omitempty"`
+}
+
+type colorInfo struct {
+	Color    string `json:"color"`
+	Emissive bool   `json:"emissive,omitempty"`
+}
+
+type pointInfo struct {
+	Name     string      `json:"name"`
+	Position *[3]float32 `json:"position,omitempty"`
+	Rotation *[3]float32 `json:"rotation,omitempty"`
+}
+
+func runInspect(flags *flag.FlagSet, args []string) error {
+
+	asJSON := flags.Bool("json", false, "print a JSON array, one object per file")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files, err := shapeFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	inspections := make([]*inspection, 0)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		inspections = append(inspections, inspect(path, shapefile.Inspect(data)))
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inspections)
+	}
+
+	for i, in := range inspections {
+		if i > 0 {
+			fmt.Println()
+		}
+		in.print(os.Stdout)
+	}
+	return nil
+}
+
+func runValidate(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files, err := shapeFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		problems := shapefile.Inspect(data).Problems
+		if len(problems) == 0 {
+			fmt.Println(path+":", "ok")
+			continue
+		}
+		invalid++
+		for _, p := range problems {
+			fmt.Println(path+":", p)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid files out of %d", invalid, len(files))
+	}
+	return nil
+}
+
+// inspect returns what's printed about a file.
+func inspect(path string, l *shapefile.Layout) *inspection {
+	in := &inspection{
+		Path:     path,
+		Size:     l.Size,
+		Chunks:   make([]*chunkInfo, 0),
+		Objects:  make([]*objectInfo, 0),
+		Problems: make([]string, 0),
+	}
+	if l.Header != nil {
+		in.Version = l.Header.Version
+		in.Compression = l.Header.Compression.String()
+	}
+
+	for _, c := range l.Chunks {
+		info := &chunkInfo{
+			ID:               uint8(c.ID),
+			Name:             c.ID.String(),
+			Offset:           c.Offset,
+			Size:             c.Size,
+			Compressed:       c.Compressed,
+			UncompressedSize: c.UncompressedSize,
+		}
+		for _, sc := range c.SubChunks {
+			info.SubChunks = append(info.SubChunks, &subChunkInfo{ID: uint8(sc.ID), Name: sc.ID.String(), Offset: sc.Offset, Size: sc.Size})
+		}
+		in.Chunks = append(in.Chunks, info)
+	}
+
+	f := l.File()
+	in.Palette = colors(f.Palette())
+
+	// shapes without a valid parent are listed at the top level,
+	// so that none is missing
+	shapes := f.Shapes()
+	listed := make(map[*shapefile.Shape]bool)
+	var object func(s *shapefile.Shape) *objectInfo
+	object = func(s *shapefile.Shape) *objectInfo {
+		listed[s] = true
+		o := newObjectInfo(s)
+		for _, child := range f.Children(s) {
+			if !listed[child] {
+				o.Children = append(o.Children, object(child))
+			}
+		}
+		return o
+	}
+	for _, s := range shapes {
+		if !listed[s] && f.Parent(s) == nil {
+			in.Objects = append(in.Objects, object(s))
+		}
+	}
+	for _, s := range shapes {
+		if !listed[s] {
+			in.Objects = append(in.Objects, object(s))
+		}
+	}
+
+	for _, p := range l.Problems {
+		in.Problems = append(in.Problems, p.String())
+	}
+	return in
+}
+
+func newObjectInfo(s *shapefile.Shape) *objectInfo {
+	o := &objectInfo{
+		ID:       s.ID,
+		Name:     s.Name,
+		Size:     [3]uint16{s.Width, s.Height, s.Depth},
+		Blocks:   s.BlockCount(),
+		Hidden:   s.Hidden,
+		Lighting: s.BakedLighting != nil,
+		Palette:  colors(s.Palette),
+	}
+	if s.Transform != nil {
+		o.Position = vec3(s.Transform.Position)
+		o.Rotation = vec3(s.Transform.Rotation)
+		o.Scale = vec3(s.Transform.Scale)
+	}
+	if s.Pivot != nil {
+		o.Pivot = vec3(*s.Pivot)
+	}
+
+	points := make(map[string]*pointInfo)
+	point := func(name string) *pointInfo {
+		if p, ok := points[name]; ok {
+			return p
+		}
+		p := &pointInfo{Name: name}
+		points[name] = p
+		o.Points = append(o.Points, p)
+		return p
+	}
+	for _, p := range s.Points {
+		point(p.Name).Position = vec3(p.Value)
+	}
+	for _, p := range s.PointRotations {
+		point(p.Name).Rotation = vec3(p.Value)
+	}
+	return o
+}
+
+func vec3(v shapefile.Vec3) *[3]float32 {
+	return &[3]float32{v.X, v.Y, v.Z}
+}
+
+func colors(p *shapefile.Palette) []colorInfo {
+	if p == nil {
+		return nil
+	}
+	colors := make([]colorInfo, 0, len(p.Colors))
+	for i, c := range p.Colors {
+		colors = append(colors, colorInfo{
+			Color:    fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A),
+			Emissive: i < len(p.Emissive) && p.Emissive[i],
+		})
+	}
+	return colors
+}
+
+func (in *inspection) print(w io.Writer) {
+	fmt.Fprintf(w, "%s: %d bytes", in.Path, in.Size)
+	if in.Version != 0 {
+		fmt.Fprintf(w, ", version %d, compression %s", in.Version, in.Compression)
+	}
+	fmt.Fprintln(w)
+
+	if len(in.Chunks) > 0 {
+		fmt.Fprintln(w, "chunks:")
+	}
+	for i, c := range in.Chunks {
+		fmt.Fprintf(w, "  #%-3d %-16s offset %-8d size %d", i, c.Name, c.Offset, c.Size)
+		if c.UncompressedSize != 0 {
+			if c.Compressed {
+				fmt.Fprintf(w, " (compressed, %d uncompressed)", c.UncompressedSize)
+			} else {
+				fmt.Fprint(w, " (uncompressed)")
+			}
+		}
+		fmt.Fprintln(w)
+		for _, sc := range c.SubChunks {
+			fmt.Fprintf(w, "         %-24s offset %-6d size %d\n", sc.Name, sc.Offset, sc.Size)
+		}
+	}
+
+	if len(in.Objects) > 0 {
+		fmt.Fprintln(w, "objects:")
+	}
+	var object func(o *objectInfo, indent string)
+	object = func(o *objectInfo, indent string) {
+		name := o.Name
+		if name == "" {
+			name = "(no name)"
+		}
+		attributes := []string{
+			fmt.Sprintf("%dx%dx%d", o.Size[0], o.Size[1], o.Size[2]),
+			fmt.Sprintf("%d blocks", o.Blocks),
+		}
+		if o.Hidden {
+			attributes = append(attributes, "hidden")
+		}
+		if o.Lighting {
+			attributes = append(attributes, "baked lighting")
+		}
+		if o.Pivot != nil {
+			attributes = append(attributes, "pivot "+formatVec3(o.Pivot))
+		}
+		if o.Position != nil {
+			attributes = append(attributes, "position "+formatVec3(o.Position), "rotation "+formatVec3(o.Rotation), "scale "+formatVec3(o.Scale))
+		}
+		if o.Palette != nil {
+			attributes = append(attributes, fmt.Sprintf("%d colors", len(o.Palette)))
+		}
+		fmt.Fprintf(w, "%s%s (#%d) %s\n", indent, name, o.ID, strings.Join(attributes, ", "))
+
+		for _, p := range o.Points {
+			fmt.Fprintf(w, "%s  point %q", indent, p.Name)
+			if p.Position != nil {
+				fmt.Fprint(w, " position ", formatVec3(p.Position))
+			}
+			if p.Rotation != nil {
+				fmt.Fprint(w, " rotation ", formatVec3(p.Rotation))
+			}
+			fmt.Fprintln(w)
+		}
+		for _, child := range o.Children {
+			object(child, indent+"  ")
+		}
+	}
+	for _, o := range in.Objects {
+		object(o, "  ")
+	}
+
+	// palettes of shapes, then the artist palette
+	var palettes func(objects []*objectInfo)
+	palettes = func(objects []*objectInfo) {
+		for _, o := range objects {
+			if o.Palette != nil {
+				printPalette(w, fmt.Sprintf("palette of %s (#%d):", o.Name, o.ID), o.Palette)
+			}
+			palettes(o.Children)
+		}
+	}
+	palettes(in.Objects)
+	if in.Palette != nil {
+		printPalette(w, "artist palette:", in.Palette)
+	}
+
+	if len(in.Problems) > 0 {
+		fmt.Fprintln(w, "problems:")
+	}
+	for _, p := range in.Problems {
+		fmt.Fprintln(w, " ", p)
+	}
+}
+
+func printPalette(w io.Writer, title string, colors []colorInfo) {
+	fmt.Fprintln(w, title)
+	for i, c := range colors {
+		if c.Emissive {
+			fmt.Fprintf(w, "  %3d %s emissive\n", i, c.Color)
+		} else {
+			fmt.Fprintf(w, "  %3d %s\n", i, c.Color)
+		}
+	}
+}
+
+func formatVec3(v *[3]float32) string {
+	return fmt.Sprintf("(%g, %g, %g)", v[0], v[1], v[2])
+}
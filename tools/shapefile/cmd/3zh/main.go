@@ -0,0 +1,97 @@
+// 3zh is a command line tool to work with .3zh files.
+//
+// usage: 3zh <command> [arguments]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// command is a 3zh sub command.
+type command struct {
+	name        string
+	usage       string
+	description string
+	// run is called with the flags of the command,
+	// after its name, returning errors to print
+	run func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = []*command{
+	renderCommand,
+	convertCommand,
+	inspectCommand,
+	validateCommand,
+	diffCommand,
+	textconvCommand,
+	bakeCommand,
+	optimizeCommand,
+	sanitizeCommand,
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+
+		flags := flag.NewFlagSet(c.name, flag.ExitOnError)
+		flags.Usage = func() {
+			fmt.Fprintf(flags.Output(), "usage: 3zh %s %s\n\n%s\n\n", c.name, c.usage, c.description)
+			flags.PrintDefaults()
+		}
+
+		err := c.run(flags, os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR:", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: 3zh <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.description)
+	}
+}
+
+// shapeFiles returns .3zh files found at given paths,
+// looking for them recursively in directories.
+func shapeFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(walkPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// explicit paths are accepted whatever their extension
+			if walkPath == path && !d.IsDir() {
+				files = append(files, walkPath)
+			} else if !d.IsDir() && strings.EqualFold(filepath.Ext(walkPath), ".3zh") {
+				files = append(files, walkPath)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/optimize"
+)
+
+var optimizeCommand = &command{
+	name:  "optimize",
+	usage: "[flags] <file.3zh|directory>...",
+	description: "Makes .3zh files smaller: trims empty borders of shapes, compacts palettes, " +
+		"recompresses chunks and renders previews again, reporting bytes saved.",
+	run: runOptimize,
+}
+
+func runOptimize(flags *flag.FlagSet, args []string) error {
+
+	defaults := optimize.DefaultOptions()
+
+	dryRun := flags.Bool("n", false, "only report bytes that would be saved, without writing files")
+	trim := flags.Bool("trim", defaults.Trim, "trim empty borders of shapes")
+	palettes := flags.Bool("palettes", defaults.Palettes, "remove unused and duplicate palette colors")
+	previews := flags.Bool("previews", defaults.Previews, "render previews again")
+	recompress := flags.Bool("recompress", true, "recompress chunks at maximum zip level")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := optimize.Options{Trim: *trim, Palettes: *palettes, Previews: *previews}
+	encodeOpts := shapefile.EncodeOptions{Recompress: *recompress, Level: zlib.BestCompression}
+
+	files, err := shapeFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	failed, totalBefore, totalAfter := 0, 0, 0
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(data, []byte("version https://git-lfs")) {
+			fmt.Println(path+":", "skipped, Git LFS pointer")
+			continue
+		}
+
+		f, err := shapefile.Decode(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		report, err := optimize.Optimize(f, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+
+		var b bytes.Buffer
+		if err := shapefile.EncodeWithOptions(&b, f, encodeOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		// nothing to gain: the file is kept as is
+		optimized := b.Bytes()
+		if !report.Changed() && len(optimized) >= len(data) {
+			optimized = data
+		}
+
+		if !*dryRun && !bytes.Equal(optimized, data) {
+			if err := os.WriteFile(path, optimized, 0644); err != nil {
+				return err
+			}
+		}
+
+		totalBefore += len(data)
+		totalAfter += len(optimized)
+		fmt.Printf("%s: %s%s\n", path, formatSaved(len(data), len(optimized)), formatReport(report))
+	}
+
+	if len(files) > 1 {
+		fmt.Printf("total: %s\n", formatSaved(totalBefore, totalAfter))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d files not optimized", failed, len(files))
+	}
+	return nil
+}
+
+func formatSaved(before, after int) string {
+	s := fmt.Sprintf("%d -> %d bytes", before, after)
+	if before > 0 {
+		s += fmt.Sprintf(" (%+.1f%%)", float64(after-before)*100/float64(before))
+	}
+	return s
+}
+
+func formatReport(r *optimize.Report) string {
+	changes := make([]string, 0)
+	if r.TrimmedShapes > 0 {
+		changes = append(changes, fmt.Sprintf("%d shapes trimmed", r.TrimmedShapes))
+	}
+	if r.RemovedColors > 0 {
+		changes = append(changes, fmt.Sprintf("%d colors removed", r.RemovedColors))
+	}
+	if r.Preview {
+		changes = append(changes, "preview rendered")
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(changes, ", ")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/render"
+)
+
+var renderCommand = &command{
+	name:        "render",
+	usage:       "[flags] <file.3zh|directory>...",
+	description: "Renders shapes to PNG images, next to .3zh files by default.",
+	run:         runRender,
+}
+
+func runRender(flags *flag.FlagSet, args []string) error {
+
+	defaults := render.DefaultOptions()
+
+	output := flags.String("o", "", "output file, or directory when rendering several files")
+	embed := flags.Bool("embed", false, "write images in the preview chunk of .3zh files instead")
+	size := flags.Int("size", defaults.Width, "image width and height, in pixels")
+	padding := flags.Int("padding", defaults.Padding, "empty space around shapes, in pixels")
+	view := flags.String("view", "isometric", "camera orientation: "+strings.Join(viewNames(), ", "))
+	yaw := flags.Float64("yaw", 0, "camera yaw in degrees, overrides -view")
+	pitch := flags.Float64("pitch", 0, "camera pitch in degrees, overrides -view")
+	background := flags.String("background", "", "background color (#rrggbb or #rrggbbaa), transparent by default")
+	lighting := flags.Bool("lighting", defaults.BakedLighting, "use baked lighting")
+	supersampling := flags.Int("supersampling", defaults.Supersampling, "samples per pixel, on each axis")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := defaults
+	opts.Width, opts.Height = *size, *size
+	opts.Padding = *padding
+	opts.BakedLighting = *lighting
+	opts.Supersampling = *supersampling
+
+	var ok bool
+	opts.View, ok = render.Views[*view]
+	if !ok {
+		return fmt.Errorf("unknown view: %s", *view)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "yaw":
+			opts.View.Yaw = *yaw
+		case "pitch":
+			opts.View.Pitch = *pitch
+		}
+	})
+
+	if *background != "" {
+		c, err := parseColor(*background)
+		if err != nil {
+			return err
+		}
+		opts.Background = c
+	}
+
+	files, err := shapeFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	// -o is a directory if it exists as one, or if there are several files
+	outputDirectory := ""
+	if *output != "" {
+		if info, err := os.Stat(*output); (err == nil && info.IsDir()) || len(files) > 1 {
+			outputDirectory = *output
+		}
+	}
+
+	failed := 0
+	for _, path := range files {
+
+		f, err := shapefile.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+
+		var buf bytes.Buffer
+		err = render.EncodePNG(&buf, f, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+
+		outputPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+		if *embed {
+			f.SetPreview(buf.Bytes())
+			err = shapefile.WriteFile(path, f)
+			outputPath = path
+		} else {
+			if outputDirectory != "" {
+				outputPath = filepath.Join(outputDirectory, filepath.Base(outputPath))
+				err = os.MkdirAll(outputDirectory, 0755)
+			} else if *output != "" {
+				outputPath = *output
+			}
+			if err == nil {
+				err = os.WriteFile(outputPath, buf.Bytes(), 0644)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s: %v\n", path, err)
+			failed++
+			continue
+		}
+
+		fmt.Println(path, "->", outputPath)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d/%d files not rendered", failed, len(files))
+	}
+	return nil
+}
+
+func viewNames() []string {
+	names := make([]string, 0, len(render.Views))
+	for name := range render.Views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseColor parses #rrggbb and #rrggbbaa colors.
+func parseColor(s string) (color.Color, error) {
+	c := color.NRGBA{A: 255}
+	var err error
+	switch len(s) {
+	case 7:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 9:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = errors.New("wrong length")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q: %v", s, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"cu.bzh/tools/shapefile/sanitize"
+)
+
+var sanitizeCommand = &command{
+	name:  "sanitize",
+	usage: "[flags] <file.3zh> <sanitized.3zh> | -http <address>",
+	description: "Checks a .3zh file within upload limits and writes it encoded again, " +
+		"without unknown chunks. With -http, serves it: POST files to get them sanitized.",
+	run: runSanitize,
+}
+
+func runSanitize(flags *flag.FlagSet, args []string) error {
+
+	defaults := sanitize.DefaultLimits()
+
+	address := flags.String("http", "", "address to serve on, like localhost:8080")
+	maxFileSize := flags.Int64("max-file-size", defaults.MaxFileSize, "max size of files, in bytes")
+	maxUncompressedSize := flags.Int64("max-uncompressed-size", defaults.MaxUncompressedSize, "max size of uncompressed chunks, in bytes")
+	maxShapes := flags.Int("max-shapes", defaults.MaxShapes, "max number of shapes")
+	maxDimension := flags.Int("max-dimension", defaults.MaxDimension, "max width, height and depth of shapes")
+	flags.Parse(args)
+
+	limits := defaults
+	limits.MaxFileSize = *maxFileSize
+	limits.MaxUncompressedSize = *maxUncompressedSize
+	limits.MaxShapes = *maxShapes
+	limits.MaxDimension = *maxDimension
+
+	if *address != "" {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		fmt.Println("sanitizing .3zh files posted to", *address)
+		return http.ListenAndServe(*address, sanitize.Handler(limits))
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	sanitized, report, err := sanitize.Sanitize(data, limits)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	if err := os.WriteFile(flags.Arg(1), sanitized, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %d -> %d bytes, removed %d unknown chunks, %d unknown sub-chunks, %d invalid previews, %d invalid baked lightings\n",
+		flags.Arg(0), len(data), len(sanitized), report.UnknownChunks, report.UnknownSubChunks, report.InvalidPreviews, report.BakedLighting)
+	return nil
+}
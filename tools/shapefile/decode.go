@@ -0,0 +1,503 @@
+package shapefile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Header is the .3zh file header.
+type Header struct {
+	Version     uint32
+	Compression Compression
+	// TotalSize is the size of all chunks, in bytes
+	TotalSize uint32
+}
+
+// nameSizePadding is the number of bytes found after NAME sub-chunks
+// written by the engine: it allocates them as if NAME had a size field.
+const nameSizePadding = 4
+
+// Decoder reads chunks from a .3zh stream, one at a time.
+type Decoder struct {
+	r      *bufio.Reader
+	header *Header
+	// bytes of chunks read so far
+	read uint32
+	// index of the next chunk
+	index int
+	// maxSize limits chunk data (uncompressed), 0 for no limit
+	maxSize int64
+	// size of chunk data read so far, uncompressed
+	size int64
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// SetMaxSize limits the size of chunk data, once uncompressed, to n bytes
+// for the whole file, 0 for no limit. Chunks going over it make Next return
+// ErrTooLarge, before they're uncompressed.
+func (d *Decoder) SetMaxSize(n int64) {
+	d.maxSize = n
+}
+
+// Offset returns the offset, in the file, of the next chunk.
+func (d *Decoder) Offset() int {
+	return headerSize + int(d.read)
+}
+
+// Header reads the file header, if not read already.
+func (d *Decoder) Header() (*Header, error) {
+	if d.header != nil {
+		return d.header, nil
+	}
+
+	b := make([]byte, len(MagicBytes)+4+1+4)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidMagic
+		}
+		return nil, err
+	}
+
+	if string(b[:len(MagicBytes)]) != MagicBytes {
+		return nil, ErrInvalidMagic
+	}
+
+	r := &reader{data: b[len(MagicBytes):]}
+	header := &Header{
+		Version:     r.u32(),
+		Compression: Compression(r.u8()),
+		TotalSize:   r.u32(),
+	}
+	if header.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+	}
+
+	d.header = header
+	return header, nil
+}
+
+// Next reads the next chunk. It returns io.EOF once all chunks have been read.
+// Errors found in chunks are *ChunkError.
+func (d *Decoder) Next() (Chunk, error) {
+	header, err := d.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.read >= header.TotalSize {
+		return nil, io.EOF
+	}
+
+	offset := d.Offset()
+	var b [1]byte
+	if err := d.readFull(b[:]); err != nil {
+		return nil, &ChunkError{Index: d.index, Offset: offset, Err: err}
+	}
+	id := ChunkID(b[0])
+
+	chunk, err := d.next(id)
+	if err != nil {
+		return nil, &ChunkError{Index: d.index, ID: id, Offset: offset, Err: err}
+	}
+	d.index++
+	return chunk, nil
+}
+
+// next reads the chunk that follows its id.
+func (d *Decoder) next(id ChunkID) (Chunk, error) {
+	if id == 0 || id >= chunkIDMax {
+		return nil, fmt.Errorf("%w: unknown id", ErrInvalidChunk)
+	}
+
+	switch id {
+	case ChunkIDPalette, ChunkIDPaletteLegacy, ChunkIDPaletteID, ChunkIDShape:
+		src, payload, err := d.readV6Chunk()
+		if err != nil {
+			return nil, err
+		}
+		return decodeV6Chunk(id, src, payload)
+	default:
+		data, err := d.readV5Chunk()
+		if err != nil {
+			return nil, err
+		}
+		if id == ChunkIDPreview {
+			return &Preview{Data: data}, nil
+		}
+		return &UnknownChunk{ID: id, Data: data}, nil
+	}
+}
+
+// readFull reads chunk bytes, within the total size announced by the header.
+func (d *Decoder) readFull(b []byte) error {
+	if uint64(d.read)+uint64(len(b)) > uint64(d.header.TotalSize) {
+		return fmt.Errorf("%w: exceeds file size", ErrInvalidChunk)
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.read += uint32(len(b))
+	return nil
+}
+
+// readData reads size bytes of chunk data. Memory is allocated as data
+// is read: sizes come from the file, they can be much larger than it.
+func (d *Decoder) readData(size uint32) ([]byte, error) {
+	if uint64(d.read)+uint64(size) > uint64(d.header.TotalSize) {
+		return nil, fmt.Errorf("%w: exceeds file size", ErrInvalidChunk)
+	}
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < int(size) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	d.read += size
+	return data, nil
+}
+
+// grow counts size bytes of chunk data, checking the decoder's max size.
+func (d *Decoder) grow(size uint32) error {
+	if d.maxSize > 0 && d.size+int64(size) > d.maxSize {
+		return fmt.Errorf("%w: %d bytes after %d, max %d", ErrTooLarge, size, d.size, d.maxSize)
+	}
+	d.size += int64(size)
+	return nil
+}
+
+func (d *Decoder) readV5Chunk() ([]byte, error) {
+	var b [4]byte
+	if err := d.readFull(b[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(b[:])
+	if err := d.grow(size); err != nil {
+		return nil, err
+	}
+	return d.readData(size)
+}
+
+// readV6Chunk reads a chunk with a v6 header,
+// returning what it's been read from and its uncompressed data.
+func (d *Decoder) readV6Chunk() (*source, []byte, error) {
+	var b [9]byte
+	if err := d.readFull(b[:]); err != nil {
+		return nil, nil, err
+	}
+	r := &reader{data: b[:]}
+	size := r.u32()
+	src := &source{compressed: r.u8() != 0, uncompressedSize: r.u32()}
+
+	if size == 0 || src.uncompressedSize == 0 {
+		return nil, nil, fmt.Errorf("%w: empty", ErrInvalidChunk)
+	}
+	grown := src.uncompressedSize
+	if !src.compressed {
+		grown = size
+	}
+	if err := d.grow(grown); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := d.readData(size)
+	if err != nil {
+		return nil, nil, err
+	}
+	src.data = data
+
+	if !src.compressed {
+		return src, data, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	defer zr.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(zr, int64(src.uncompressedSize)+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	if len(payload) != int(src.uncompressedSize) {
+		return nil, nil, fmt.Errorf("%w: uncompressed size %d, expected %d", ErrInvalidChunk, len(payload), src.uncompressedSize)
+	}
+
+	return src, payload, nil
+}
+
+func decodeV6Chunk(id ChunkID, src *source, payload []byte) (Chunk, error) {
+	var chunk Chunk
+	var err error
+
+	switch id {
+	case ChunkIDPalette:
+		c := &PaletteChunk{src: src}
+		r := &reader{data: payload}
+		c.Palette = r.palette(false)
+		chunk, err = c, r.err
+	case ChunkIDPaletteLegacy:
+		c := &LegacyPaletteChunk{src: src}
+		c.Rows, c.Columns, c.DefaultColor, c.DefaultBackgroundColor, c.Palette, err = decodeLegacyPalette(payload)
+		chunk = c
+	case ChunkIDPaletteID:
+		chunk = &PaletteIDChunk{PaletteID: payload[0], src: src}
+	case ChunkIDShape:
+		var s *Shape
+		s, err = decodeShape(payload)
+		if s != nil {
+			s.src = src
+		}
+		chunk = s
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+
+	// encoding the chunk again tells whether it's been modified
+	src.payload, err = encodeChunkPayload(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+
+	return chunk, nil
+}
+
+func decodeLegacyPalette(payload []byte) (rows, columns, defaultColor, defaultBackgroundColor uint8, palette *Palette, err error) {
+	r := &reader{data: payload}
+	rows = r.u8()
+	columns = r.u8()
+	count := int(r.u16())
+	defaultColor = r.u8()
+	defaultBackgroundColor = r.u8()
+	palette = r.colors(count)
+	return rows, columns, defaultColor, defaultBackgroundColor, palette, r.err
+}
+
+func decodeShape(payload []byte) (*Shape, error) {
+	s := &Shape{}
+	r := &reader{data: payload}
+
+	hasSize := false
+	previous := SubChunkID(0)
+
+	for len(r.data) > 0 && r.err == nil {
+
+		// padding written by the engine after names
+		if previous == SubChunkIDName && len(r.data) == nameSizePadding {
+			break
+		}
+
+		id := SubChunkID(r.u8())
+		previous = id
+
+		if id == SubChunkIDName {
+			s.Name = string(r.read(int(r.u8())))
+			continue
+		}
+
+		if !isKnownSubChunk(id) {
+			// the engine skips unknown sub-chunks as having a v6 header
+			start := len(payload) - len(r.data) - 1
+			size := r.u32()
+			r.read(5)
+			r.read(int(size))
+			if r.err == nil {
+				s.Unknown = append(s.Unknown, bytes.Clone(payload[start:len(payload)-len(r.data)]))
+			}
+			continue
+		}
+
+		size := r.u32()
+		body := &reader{data: r.read(int(size))}
+		if r.err != nil {
+			break
+		}
+
+		switch id {
+		case SubChunkIDSize:
+			s.Width, s.Height, s.Depth = body.u16(), body.u16(), body.u16()
+			hasSize = true
+		case SubChunkIDBlocks:
+			s.Blocks = bytes.Clone(body.read(int(size)))
+		case SubChunkIDPoint:
+			s.Points = append(s.Points, body.point())
+		case SubChunkIDPointRotation:
+			s.PointRotations = append(s.PointRotations, body.point())
+		case SubChunkIDBakedLighting:
+			s.BakedLighting = bytes.Clone(body.read(int(size)))
+		case SubChunkIDShapeID:
+			s.ID = body.u16()
+		case SubChunkIDParentID:
+			s.ParentID = body.u16()
+		case SubChunkIDTransform:
+			s.Transform = &Transform{Position: body.vec3(), Rotation: body.vec3(), Scale: body.vec3()}
+		case SubChunkIDPivot:
+			pivot := body.vec3()
+			s.Pivot = &pivot
+		case SubChunkIDPalette:
+			s.Palette = body.palette(true)
+		case SubChunkIDCollisionBox:
+			s.CollisionBox = &Box{Min: body.vec3(), Max: body.vec3()}
+		case SubChunkIDIsHidden:
+			s.Hidden = body.u8() != 0
+		}
+
+		if body.err != nil {
+			return nil, fmt.Errorf("sub-chunk %d: %v", id, body.err)
+		}
+		if len(body.data) > 0 {
+			return nil, fmt.Errorf("sub-chunk %d: %d unexpected bytes", id, len(body.data))
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !hasSize {
+		return nil, fmt.Errorf("shape has no size")
+	}
+	if len(s.Blocks) != int(s.Width)*int(s.Height)*int(s.Depth) {
+		return nil, fmt.Errorf("%d blocks for a %dx%dx%d shape", len(s.Blocks), s.Width, s.Height, s.Depth)
+	}
+
+	return s, nil
+}
+
+func isKnownSubChunk(id SubChunkID) bool {
+	switch id {
+	case SubChunkIDSize, SubChunkIDBlocks, SubChunkIDPoint, SubChunkIDBakedLighting,
+		SubChunkIDPointRotation, SubChunkIDShapeID, SubChunkIDName, SubChunkIDParentID,
+		SubChunkIDTransform, SubChunkIDPivot, SubChunkIDPalette, SubChunkIDCollisionBox,
+		SubChunkIDIsHidden:
+		return true
+	}
+	return false
+}
+
+// Decode reads a whole .3zh file.
+func Decode(r io.Reader) (*File, error) {
+	d := NewDecoder(r)
+	header, err := d.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{Compression: header.Compression, Chunks: make([]Chunk, 0)}
+	for {
+		chunk, err := d.Next()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.Chunks = append(f.Chunks, chunk)
+	}
+}
+
+// ReadFile reads the .3zh file at path.
+func ReadFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Decode(file)
+}
+
+// reader reads little-endian values from a buffer.
+// The first error is kept, subsequent reads return zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) u8() uint8 {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.read(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *reader) f32() float32 {
+	return math.Float32frombits(r.u32())
+}
+
+func (r *reader) vec3() Vec3 {
+	return Vec3{X: r.f32(), Y: r.f32(), Z: r.f32()}
+}
+
+func (r *reader) point() Point {
+	name := string(r.read(int(r.u8())))
+	return Point{Name: name, Value: r.vec3()}
+}
+
+// palette reads a palette. Shape palette sub-chunks have to be read entirely,
+// palette chunks may have trailing bytes (the engine ignores them).
+func (r *reader) palette(exact bool) *Palette {
+	p := r.colors(int(r.u8()))
+	if !exact {
+		r.data = nil
+	}
+	return p
+}
+
+func (r *reader) colors(count int) *Palette {
+	p := &Palette{Colors: make([]Color, 0, count), Emissive: make([]bool, 0, count)}
+	for i := 0; i < count && r.err == nil; i++ {
+		b := r.read(4)
+		if b != nil {
+			p.Colors = append(p.Colors, Color{R: b[0], G: b[1], B: b[2], A: b[3]})
+		}
+	}
+	for i := 0; i < count && r.err == nil; i++ {
+		p.Emissive = append(p.Emissive, r.u8() != 0)
+	}
+	return p
+}
@@ -0,0 +1,377 @@
+// Package diff compares .3zh files, object by object.
+//
+// Objects (shapes) are matched by their path in the hierarchy, made of
+// their names and the names of their parents. Unnamed shapes are named
+// after their position among unnamed siblings: "(shape 1)", "(shape 2)"...
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// Diff lists the changes between two files.
+type Diff struct {
+	// Preview is true if the preview image changed
+	Preview bool
+	// Palette lists changes of the artist palette
+	Palette []ColorChange
+	// Objects that changed, in the order of the new file,
+	// followed by removed ones
+	Objects []*ObjectDiff
+}
+
+// ObjectDiff lists the changes of an object.
+type ObjectDiff struct {
+	Path    string
+	Added   bool
+	Removed bool
+	// Shape is the new shape, or the removed one
+	Shape   *shapefile.Shape
+	Changes []Change
+	Palette []ColorChange
+	Blocks  *BlockChanges
+}
+
+// Change is a change of an object property.
+type Change struct {
+	Property string
+	Old, New string
+	// Delta is set for vectors: position, rotation, scale, pivot and points
+	Delta *shapefile.Vec3
+}
+
+// ColorChange is a change of a palette color. Old or New is nil
+// for colors added or removed at the end of the palette.
+type ColorChange struct {
+	Index                    int
+	Old, New                 *shapefile.Color
+	OldEmissive, NewEmissive bool
+}
+
+// BlockChanges summarizes block changes, in shape coordinates.
+// Blocks are recolored when their color changes, not only their index.
+type BlockChanges struct {
+	Added, Removed, Recolored int
+	// Min and Max are the corners of the region containing changes, inclusive
+	Min, Max [3]int
+}
+
+// Empty returns true if the files are the same, as far as the engine sees them.
+func (d *Diff) Empty() bool {
+	return !d.Preview && len(d.Palette) == 0 && len(d.Objects) == 0
+}
+
+// Compare returns the changes from a to b.
+func Compare(a, b *shapefile.File) *Diff {
+	d := &Diff{
+		Preview: string(a.Preview()) != string(b.Preview()),
+		Palette: comparePalettes(a.Palette(), b.Palette()),
+	}
+
+	oldPaths, oldShapes := paths(a)
+	newPaths, newShapes := paths(b)
+
+	for _, path := range newPaths {
+		s := newShapes[path]
+		old, ok := oldShapes[path]
+		if !ok {
+			d.Objects = append(d.Objects, &ObjectDiff{Path: path, Added: true, Shape: s})
+			continue
+		}
+		if o := compareShapes(a, b, old, s); o != nil {
+			o.Path = path
+			d.Objects = append(d.Objects, o)
+		}
+	}
+	for _, path := range oldPaths {
+		if _, ok := newShapes[path]; !ok {
+			d.Objects = append(d.Objects, &ObjectDiff{Path: path, Removed: true, Shape: oldShapes[path]})
+		}
+	}
+
+	return d
+}
+
+// paths returns the paths of shapes, in file order, and shapes by path.
+func paths(f *shapefile.File) ([]string, map[string]*shapefile.Shape) {
+	names := make([]string, 0)
+	shapes := make(map[string]*shapefile.Shape)
+	// corrupted files could have cycles
+	walked := make(map[*shapefile.Shape]bool)
+
+	var walk func(s *shapefile.Shape, path string)
+	walk = func(s *shapefile.Shape, path string) {
+		names = append(names, path)
+		shapes[path] = s
+		walked[s] = true
+
+		used := make(map[string]int)
+		unnamed := 0
+		for _, child := range f.Children(s) {
+			if walked[child] {
+				continue
+			}
+			name := child.Name
+			if name == "" {
+				unnamed++
+				name = fmt.Sprintf("(shape %d)", unnamed)
+			}
+			// siblings with the same name
+			used[name]++
+			if used[name] > 1 {
+				name = fmt.Sprintf("%s[%d]", name, used[name])
+			}
+			walk(child, path+"/"+name)
+		}
+	}
+
+	if root := f.Root(); root != nil {
+		name := root.Name
+		if name == "" {
+			name = "(root)"
+		}
+		walk(root, name)
+	}
+	return names, shapes
+}
+
+// compareShapes returns the changes of a shape, nil if there are none.
+func compareShapes(a, b *shapefile.File, old, s *shapefile.Shape) *ObjectDiff {
+	o := &ObjectDiff{Shape: s}
+
+	change := func(property string, oldValue, newValue string) {
+		if oldValue != newValue {
+			o.Changes = append(o.Changes, Change{Property: property, Old: oldValue, New: newValue})
+		}
+	}
+	vector := func(property string, oldValue, newValue shapefile.Vec3) {
+		if oldValue != newValue {
+			o.Changes = append(o.Changes, Change{Property: property, Old: formatVec3(oldValue), New: formatVec3(newValue), Delta: delta(oldValue, newValue)})
+		}
+	}
+
+	change("size", formatSize(old), formatSize(s))
+
+	// the root shape's transform isn't used by the engine
+	if a.Parent(old) != nil || b.Parent(s) != nil {
+		oldTransform, newTransform := transform(old), transform(s)
+		vector("position", oldTransform.Position, newTransform.Position)
+		vector("rotation", oldTransform.Rotation, newTransform.Rotation)
+		vector("scale", oldTransform.Scale, newTransform.Scale)
+	}
+	vector("pivot", pivot(old), pivot(s))
+	change("collision box", formatBox(old.CollisionBox), formatBox(s.CollisionBox))
+	change("hidden", fmt.Sprint(old.Hidden), fmt.Sprint(s.Hidden))
+	change("baked lighting", formatLighting(old), formatLighting(s))
+
+	comparePoints(o, "point", old.Points, s.Points)
+	comparePoints(o, "point rotation", old.PointRotations, s.PointRotations)
+
+	switch {
+	case old.Palette == nil && s.Palette != nil:
+		change("palette", "shared", fmt.Sprintf("own, %d colors", len(s.Palette.Colors)))
+	case old.Palette != nil && s.Palette == nil:
+		change("palette", fmt.Sprintf("own, %d colors", len(old.Palette.Colors)), "shared")
+	default:
+		o.Palette = comparePalettes(old.Palette, s.Palette)
+	}
+
+	o.Blocks = compareBlocks(a.ShapePalette(old), b.ShapePalette(s), old, s)
+
+	if len(o.Changes) == 0 && len(o.Palette) == 0 && o.Blocks == nil {
+		return nil
+	}
+	return o
+}
+
+func transform(s *shapefile.Shape) shapefile.Transform {
+	if s.Transform == nil {
+		return shapefile.Transform{Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	}
+	return *s.Transform
+}
+
+// pivot returns the pivot of a shape, the center of its blocks by default.
+func pivot(s *shapefile.Shape) shapefile.Vec3 {
+	if s.Pivot == nil {
+		return shapefile.Vec3{X: float32(s.Width) / 2, Y: float32(s.Height) / 2, Z: float32(s.Depth) / 2}
+	}
+	return *s.Pivot
+}
+
+func delta(old, v shapefile.Vec3) *shapefile.Vec3 {
+	return &shapefile.Vec3{X: v.X - old.X, Y: v.Y - old.Y, Z: v.Z - old.Z}
+}
+
+func comparePoints(o *ObjectDiff, kind string, old, points []shapefile.Point) {
+	oldValues := make(map[string]shapefile.Vec3)
+	for _, p := range old {
+		oldValues[p.Name] = p.Value
+	}
+	values := make(map[string]bool)
+	for _, p := range points {
+		values[p.Name] = true
+		property := fmt.Sprintf("%s %q", kind, p.Name)
+		oldValue, ok := oldValues[p.Name]
+		if !ok {
+			o.Changes = append(o.Changes, Change{Property: property, Old: "none", New: formatVec3(p.Value)})
+		} else if oldValue != p.Value {
+			o.Changes = append(o.Changes, Change{Property: property, Old: formatVec3(oldValue), New: formatVec3(p.Value), Delta: delta(oldValue, p.Value)})
+		}
+	}
+	for _, p := range old {
+		if !values[p.Name] {
+			o.Changes = append(o.Changes, Change{Property: fmt.Sprintf("%s %q", kind, p.Name), Old: formatVec3(p.Value), New: "none"})
+		}
+	}
+}
+
+func comparePalettes(old, p *shapefile.Palette) []ColorChange {
+	if old == nil {
+		old = &shapefile.Palette{}
+	}
+	if p == nil {
+		p = &shapefile.Palette{}
+	}
+
+	changes := make([]ColorChange, 0)
+	for i := 0; i < len(old.Colors) || i < len(p.Colors); i++ {
+		c := ColorChange{Index: i}
+		if i < len(old.Colors) {
+			c.Old = &old.Colors[i]
+			c.OldEmissive = i < len(old.Emissive) && old.Emissive[i]
+		}
+		if i < len(p.Colors) {
+			c.New = &p.Colors[i]
+			c.NewEmissive = i < len(p.Emissive) && p.Emissive[i]
+		}
+		if c.Old == nil || c.New == nil || *c.Old != *c.New || c.OldEmissive != c.NewEmissive {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// blockColor returns the color of a block, and whether it's emissive.
+// Indexes out of the palette are kept, to tell them apart.
+type blockColor struct {
+	color    shapefile.Color
+	emissive bool
+	index    int
+}
+
+func colorOf(palette *shapefile.Palette, index uint8) blockColor {
+	if palette == nil || int(index) >= len(palette.Colors) {
+		return blockColor{index: int(index)}
+	}
+	return blockColor{
+		color:    palette.Colors[index],
+		emissive: int(index) < len(palette.Emissive) && palette.Emissive[index],
+		index:    -1,
+	}
+}
+
+// compareBlocks compares blocks at the same coordinates,
+// returning nil if none changed.
+func compareBlocks(oldPalette, palette *shapefile.Palette, old, s *shapefile.Shape) *BlockChanges {
+	changes := &BlockChanges{}
+	width := max(int(old.Width), int(s.Width))
+	height := max(int(old.Height), int(s.Height))
+	depth := max(int(old.Depth), int(s.Depth))
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			for z := 0; z < depth; z++ {
+				before, after := old.Block(x, y, z), s.Block(x, y, z)
+				switch {
+				case before == shapefile.AirBlock && after == shapefile.AirBlock:
+					continue
+				case before == shapefile.AirBlock:
+					changes.Added++
+				case after == shapefile.AirBlock:
+					changes.Removed++
+				case colorOf(oldPalette, before) != colorOf(palette, after):
+					changes.Recolored++
+				default:
+					continue
+				}
+				changes.include(x, y, z)
+			}
+		}
+	}
+
+	if changes.Count() == 0 {
+		return nil
+	}
+	return changes
+}
+
+// Count returns the number of changed blocks.
+func (c *BlockChanges) Count() int {
+	return c.Added + c.Removed + c.Recolored
+}
+
+func (c *BlockChanges) include(x, y, z int) {
+	p := [3]int{x, y, z}
+	if c.Count() == 1 {
+		c.Min, c.Max = p, p
+		return
+	}
+	for i := range p {
+		c.Min[i] = min(c.Min[i], p[i])
+		c.Max[i] = max(c.Max[i], p[i])
+	}
+}
+
+func (c *BlockChanges) String() string {
+	counts := make([]string, 0)
+	for _, count := range []struct {
+		n    int
+		verb string
+	}{{c.Added, "added"}, {c.Removed, "removed"}, {c.Recolored, "recolored"}} {
+		if count.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count.n, count.verb))
+		}
+	}
+	return fmt.Sprintf("%s, in (%d, %d, %d)-(%d, %d, %d)", strings.Join(counts, ", "),
+		c.Min[0], c.Min[1], c.Min[2], c.Max[0], c.Max[1], c.Max[2])
+}
+
+func formatVec3(v shapefile.Vec3) string {
+	return fmt.Sprintf("(%g, %g, %g)", v.X, v.Y, v.Z)
+}
+
+func formatSize(s *shapefile.Shape) string {
+	return fmt.Sprintf("%dx%dx%d", s.Width, s.Height, s.Depth)
+}
+
+func formatBox(b *shapefile.Box) string {
+	if b == nil {
+		return "default"
+	}
+	return formatVec3(b.Min) + "-" + formatVec3(b.Max)
+}
+
+func formatLighting(s *shapefile.Shape) string {
+	if s.BakedLighting == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%08x", hash(s.BakedLighting))
+}
+
+func formatColor(c *shapefile.Color, emissive bool) string {
+	if c == nil {
+		return "none"
+	}
+	s := fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	if emissive {
+		s += " emissive"
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+var (
+	red   = shapefile.Color{R: 255, A: 255}
+	green = shapefile.Color{G: 255, A: 128}
+	blue  = shapefile.Color{B: 255, A: 255}
+)
+
+func shape(f *shapefile.File, i int) *shapefile.Shape {
+	return f.Shapes()[i]
+}
+
+func TestCompareSame(t *testing.T) {
+	if d := Compare(testutil.Chest(), testutil.Chest()); !d.Empty() {
+		t.Errorf("diff of the same file: %+v", d)
+	}
+
+	// the same colors at other indexes don't change blocks
+	a, b := testutil.Chest(), testutil.Chest()
+	body := shape(b, 0)
+	body.Palette = &shapefile.Palette{Colors: []shapefile.Color{green, red}, Emissive: []bool{true, false}}
+	body.SetBlock(0, 0, 0, 1)
+	body.SetBlock(1, 2, 3, 0)
+	d := Compare(a, b)
+	if len(d.Objects) != 2 {
+		t.Fatalf("objects %+v", d.Objects)
+	}
+	if o := d.Objects[0]; o.Blocks != nil || len(o.Palette) != 2 {
+		t.Errorf("chest diff %+v", o)
+	}
+	// the lid uses the root palette: its block changed color
+	if o := d.Objects[1]; o.Blocks == nil || o.Blocks.Recolored != 1 {
+		t.Errorf("lid diff %+v", o)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a, b := testutil.Chest(), testutil.Chest()
+
+	body := shape(b, 0)
+	body.SetBlock(0, 0, 0, shapefile.AirBlock)
+	body.SetBlock(1, 0, 0, 1)
+	body.SetBlock(0, 1, 0, 0)
+	body.SetBlock(1, 2, 3, 0)
+	body.Points = []shapefile.Point{{Name: "ModelPoint_Hand", Value: shapefile.Vec3{X: 2, Y: 2, Z: 3}}, {Name: "back", Value: shapefile.Vec3{Z: -1}}}
+	body.Palette.Colors[1] = blue
+	body.Palette.Emissive[1] = false
+
+	lid := shape(b, 1)
+	lid.Transform.Position.Y = 4
+	lid.Transform.Rotation.X = 0
+	lid.Pivot = &shapefile.Vec3{X: 1}
+
+	// the lock is removed, a key is added
+	key := shapefile.NewShape(1, 2, 1)
+	key.ParentID = 1
+	key.Name = "key"
+	key.SetBlock(0, 0, 0, 0)
+	b.Chunks[4] = key
+
+	d := Compare(a, b)
+
+	var out bytes.Buffer
+	if err := d.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `~ chest
+    point "ModelPoint_Hand": (1, 2, 3) -> (2, 2, 3) (+1, +0, +0)
+    point "back": none -> (0, 0, -1)
+    point "origin": (0, 0, 0) -> none
+    palette:
+        1: #00ff0080 emissive -> #0000ffff
+    blocks: 2 added, 1 removed, 1 recolored, in (0, 0, 0)-(1, 2, 3)
+~ chest/lid
+    position: (0, 3, 0) -> (0, 4, 0) (+0, +1, +0)
+    rotation: (0.5, 0, 0) -> (0, 0, 0) (-0.5, +0, +0)
+    pivot: (0, 0, 0) -> (1, 0, 0) (+1, +0, +0)
+    blocks: 1 recolored, in (1, 0, 2)-(1, 0, 2)
++ chest/key (1x2x1, 1 blocks)
+- chest/lid/(shape 1) (1x1x1, 0 blocks)
+`
+	if out.String() != expected {
+		t.Errorf("diff:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestCompareSizes(t *testing.T) {
+	a, b := testutil.Chest(), testutil.Chest()
+	body := shapefile.NewShape(3, 3, 4)
+	body.ID = 1
+	body.Name = "chest"
+	body.Palette = shape(a, 0).Palette
+	body.Points = shape(a, 0).Points
+	body.PointRotations = shape(a, 0).PointRotations
+	body.SetBlock(0, 0, 0, 0)
+	body.SetBlock(1, 2, 3, 1)
+	body.SetBlock(2, 0, 0, 0)
+	b.Chunks[2] = body
+
+	d := Compare(a, b)
+	o := d.Objects[0]
+	if len(o.Changes) != 2 || o.Changes[0].Property != "size" || o.Changes[1].Property != "pivot" {
+		t.Errorf("changes %+v", o.Changes)
+	}
+	if o.Blocks == nil || o.Blocks.Added != 1 || o.Blocks.Min != [3]int{2, 0, 0} || o.Blocks.Max != [3]int{2, 0, 0} {
+		t.Errorf("blocks %+v", o.Blocks)
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	f := testutil.Chest()
+	twin := shapefile.NewShape(1, 1, 1)
+	twin.ParentID = 1
+	twin.Name = "lid"
+	f.Chunks = append(f.Chunks, twin)
+
+	names, _ := paths(f)
+	if want := []string{"chest", "chest/lid", "chest/lid/(shape 1)", "chest/lid[2]"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("paths %v, want %v", names, want)
+	}
+
+	// empty files have no objects
+	if d := Compare(&shapefile.File{}, f); len(d.Objects) != 4 || !d.Objects[0].Added {
+		t.Errorf("diff from an empty file %+v", d.Objects)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var a, b bytes.Buffer
+	f := testutil.Chest()
+	if err := WriteSummary(&a, f); err != nil {
+		t.Fatal(err)
+	}
+	shape(f, 1).Transform.Position.Y = 4
+	if err := WriteSummary(&b, f); err != nil {
+		t.Fatal(err)
+	}
+
+	// a line based diff shows the change
+	oldLines, newLines := strings.Split(a.String(), "\n"), strings.Split(b.String(), "\n")
+	if len(oldLines) != len(newLines) {
+		t.Fatalf("%d lines, then %d", len(oldLines), len(newLines))
+	}
+	changed := make([]string, 0)
+	for i := range oldLines {
+		if oldLines[i] != newLines[i] {
+			changed = append(changed, newLines[i])
+		}
+	}
+	if len(changed) != 1 || changed[0] != "  position: (0, 4, 0)" {
+		t.Errorf("changed lines %q", changed)
+	}
+	if !strings.Contains(a.String(), "\nchest/lid/(shape 1)\n") {
+		t.Errorf("summary:\n%s", a.String())
+	}
+}
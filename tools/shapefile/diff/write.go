@@ -0,0 +1,125 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// Write prints the changes: one line per object, prefixed with
+// "+" (added), "-" (removed) or "~" (modified), followed by its changes.
+func (d *Diff) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	if d.Preview {
+		fmt.Fprintln(b, "~ preview image")
+	}
+	if len(d.Palette) > 0 {
+		fmt.Fprintln(b, "~ artist palette")
+		writeColorChanges(b, d.Palette)
+	}
+
+	for _, o := range d.Objects {
+		switch {
+		case o.Added:
+			fmt.Fprintf(b, "+ %s (%s)\n", o.Path, describe(o.Shape))
+		case o.Removed:
+			fmt.Fprintf(b, "- %s (%s)\n", o.Path, describe(o.Shape))
+		default:
+			fmt.Fprintf(b, "~ %s\n", o.Path)
+		}
+
+		for _, c := range o.Changes {
+			fmt.Fprintf(b, "    %s: %s -> %s", c.Property, c.Old, c.New)
+			if c.Delta != nil {
+				fmt.Fprintf(b, " (%+g, %+g, %+g)", c.Delta.X, c.Delta.Y, c.Delta.Z)
+			}
+			fmt.Fprintln(b)
+		}
+		if len(o.Palette) > 0 {
+			fmt.Fprintln(b, "    palette:")
+			writeColorChanges(b, o.Palette)
+		}
+		if o.Blocks != nil {
+			fmt.Fprintf(b, "    blocks: %s\n", o.Blocks)
+		}
+	}
+
+	return b.Flush()
+}
+
+func writeColorChanges(w io.Writer, changes []ColorChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "      %3d: %s -> %s\n", c.Index, formatColor(c.Old, c.OldEmissive), formatColor(c.New, c.NewEmissive))
+	}
+}
+
+// describe returns a short description of a shape.
+func describe(s *shapefile.Shape) string {
+	return fmt.Sprintf("%s, %d blocks", formatSize(s), s.BlockCount())
+}
+
+// WriteSummary prints a text version of a file, for line based diffs
+// (git textconv): one section per object, with its properties.
+// Blocks and baked lighting are summarized by their count and a hash.
+func WriteSummary(w io.Writer, f *shapefile.File) error {
+	b := bufio.NewWriter(w)
+
+	if preview := f.Preview(); preview != nil {
+		fmt.Fprintf(b, "preview: %d bytes, %08x\n", len(preview), hash(preview))
+	}
+	if p := f.Palette(); p != nil {
+		fmt.Fprintln(b, "artist palette:")
+		writePalette(b, p)
+	}
+
+	names, shapes := paths(f)
+	for _, path := range names {
+		s := shapes[path]
+		fmt.Fprintf(b, "\n%s\n", path)
+		fmt.Fprintf(b, "  size: %s\n", formatSize(s))
+		fmt.Fprintf(b, "  blocks: %d, %08x\n", s.BlockCount(), hash(s.Blocks))
+		if s.Transform != nil && f.Parent(s) != nil {
+			fmt.Fprintf(b, "  position: %s\n", formatVec3(s.Transform.Position))
+			fmt.Fprintf(b, "  rotation: %s\n", formatVec3(s.Transform.Rotation))
+			fmt.Fprintf(b, "  scale: %s\n", formatVec3(s.Transform.Scale))
+		}
+		fmt.Fprintf(b, "  pivot: %s\n", formatVec3(pivot(s)))
+		if s.CollisionBox != nil {
+			fmt.Fprintf(b, "  collision box: %s\n", formatBox(s.CollisionBox))
+		}
+		if s.Hidden {
+			fmt.Fprintln(b, "  hidden")
+		}
+		if s.BakedLighting != nil {
+			fmt.Fprintf(b, "  baked lighting: %s\n", formatLighting(s))
+		}
+		for _, p := range s.Points {
+			fmt.Fprintf(b, "  point %q: %s\n", p.Name, formatVec3(p.Value))
+		}
+		for _, p := range s.PointRotations {
+			fmt.Fprintf(b, "  point rotation %q: %s\n", p.Name, formatVec3(p.Value))
+		}
+		if s.Palette != nil {
+			fmt.Fprintln(b, "  palette:")
+			writePalette(b, s.Palette)
+		}
+	}
+
+	return b.Flush()
+}
+
+func writePalette(w io.Writer, p *shapefile.Palette) {
+	for i := range p.Colors {
+		fmt.Fprintf(w, "    %3d: %s\n", i, formatColor(&p.Colors[i], i < len(p.Emissive) && p.Emissive[i]))
+	}
+}
+
+func hash(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
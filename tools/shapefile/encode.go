@@ -0,0 +1,386 @@
+package shapefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// EncodeOptions change how chunks are compressed.
+type EncodeOptions struct {
+	// Recompress compresses all palette and shape chunks again, even
+	// unmodified ones, keeping their original bytes when they're smaller.
+	Recompress bool
+	// Canonical compresses all palette and shape chunks again, never
+	// writing their original bytes: files with the same chunks are encoded
+	// with the same bytes, whatever they've been decoded from.
+	Canonical bool
+	// Level is the zlib compression level, zlib.DefaultCompression if 0.
+	Level int
+}
+
+// Encode writes f to w. Chunks that haven't been modified since
+// they were decoded are written exactly as they were read.
+func Encode(w io.Writer, f *File) error {
+	return EncodeWithOptions(w, f, EncodeOptions{})
+}
+
+// EncodeWithOptions writes f to w, like Encode, with options.
+func EncodeWithOptions(w io.Writer, f *File, opts EncodeOptions) error {
+	if opts.Level == 0 {
+		opts.Level = zlib.DefaultCompression
+	}
+
+	chunks := &writer{opts: opts}
+	for _, chunk := range f.Chunks {
+		if err := encodeChunk(chunks, chunk); err != nil {
+			return err
+		}
+	}
+
+	if uint64(chunks.Len()) > math.MaxUint32 {
+		return fmt.Errorf("file too large: %d bytes", chunks.Len())
+	}
+
+	header := &writer{}
+	header.WriteString(MagicBytes)
+	header.u32(Version)
+	header.u8(uint8(f.Compression))
+	header.u32(uint32(chunks.Len()))
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(chunks.Bytes())
+	return err
+}
+
+// WriteFile writes f in a .3zh file at path.
+func WriteFile(path string, f *File) error {
+	b := &bytes.Buffer{}
+	if err := Encode(b, f); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
+
+func encodeChunk(w *writer, chunk Chunk) error {
+	switch c := chunk.(type) {
+	case *Preview:
+		return w.v5Chunk(ChunkIDPreview, c.Data)
+	case *UnknownChunk:
+		if c.ID == 0 || c.ID >= chunkIDMax || c.ID == ChunkIDPreview || isV6Chunk(c.ID) {
+			return fmt.Errorf("%w: unknown chunk can't have id %d", ErrInvalidChunk, c.ID)
+		}
+		return w.v5Chunk(c.ID, c.Data)
+	}
+
+	payload, err := encodeChunkPayload(chunk)
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", chunk.ChunkID(), err)
+	}
+
+	var src *source
+	switch c := chunk.(type) {
+	case *PaletteChunk:
+		src = c.src
+	case *LegacyPaletteChunk:
+		src = c.src
+	case *PaletteIDChunk:
+		src = c.src
+	case *Shape:
+		src = c.src
+	}
+
+	return w.v6Chunk(chunk.ChunkID(), payload, src)
+}
+
+func isV6Chunk(id ChunkID) bool {
+	return id == ChunkIDPalette || id == ChunkIDPaletteLegacy || id == ChunkIDPaletteID || id == ChunkIDShape
+}
+
+// encodeChunkPayload returns the uncompressed data of a chunk with a v6 header.
+func encodeChunkPayload(chunk Chunk) ([]byte, error) {
+	w := &writer{}
+	var err error
+
+	switch c := chunk.(type) {
+	case *PaletteChunk:
+		err = w.palette(c.Palette)
+	case *LegacyPaletteChunk:
+		err = w.legacyPalette(c)
+	case *PaletteIDChunk:
+		w.u8(c.PaletteID)
+	case *Shape:
+		err = w.shape(c)
+	default:
+		err = fmt.Errorf("%w: unexpected chunk type %T", ErrInvalidChunk, chunk)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// writer writes little-endian values.
+type writer struct {
+	bytes.Buffer
+	// opts is only used to write chunks
+	opts EncodeOptions
+}
+
+func (w *writer) u8(v uint8) {
+	w.WriteByte(v)
+}
+
+func (w *writer) u16(v uint16) {
+	w.Write(binary.LittleEndian.AppendUint16(nil, v))
+}
+
+func (w *writer) u32(v uint32) {
+	w.Write(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (w *writer) f32(v float32) {
+	w.u32(math.Float32bits(v))
+}
+
+func (w *writer) vec3(v Vec3) {
+	w.f32(v.X)
+	w.f32(v.Y)
+	w.f32(v.Z)
+}
+
+func (w *writer) v5Chunk(id ChunkID, data []byte) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("chunk %d too large: %d bytes", id, len(data))
+	}
+	w.u8(uint8(id))
+	w.u32(uint32(len(data)))
+	w.Write(data)
+	return nil
+}
+
+// v6Chunk writes a chunk with a v6 header. Its original bytes are written
+// if its payload hasn't changed, new chunks are compressed.
+// When recompressing, all chunks are compressed, original bytes are
+// only written if they're smaller.
+func (w *writer) v6Chunk(id ChunkID, payload []byte, src *source) error {
+	data := payload
+	compressed := true
+	uncompressedSize := uint64(len(payload))
+	unmodified := src != nil && !w.opts.Canonical && bytes.Equal(src.payload, payload)
+
+	if unmodified && !w.opts.Recompress {
+		data = src.data
+		compressed = src.compressed
+		uncompressedSize = uint64(src.uncompressedSize)
+	} else {
+		if src != nil && !w.opts.Recompress && !w.opts.Canonical {
+			compressed = src.compressed
+		}
+		if compressed {
+			b := &bytes.Buffer{}
+			zw, err := zlib.NewWriterLevel(b, w.opts.Level)
+			if err != nil {
+				return err
+			}
+			if _, err := zw.Write(payload); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data = b.Bytes()
+		}
+		if unmodified && len(src.data) <= len(data) {
+			data = src.data
+			compressed = src.compressed
+			uncompressedSize = uint64(src.uncompressedSize)
+		}
+	}
+
+	if uint64(len(data)) > math.MaxUint32 || uncompressedSize > math.MaxUint32 {
+		return fmt.Errorf("chunk %d too large: %d bytes", id, uncompressedSize)
+	}
+
+	w.u8(uint8(id))
+	w.u32(uint32(len(data)))
+	if compressed {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+	w.u32(uint32(uncompressedSize))
+	w.Write(data)
+	return nil
+}
+
+// subChunk writes a shape sub-chunk.
+func (w *writer) subChunk(id SubChunkID, write func(w *writer) error) error {
+	data := &writer{}
+	if err := write(data); err != nil {
+		return err
+	}
+	if uint64(data.Len()) > math.MaxUint32 {
+		return fmt.Errorf("sub-chunk %d too large: %d bytes", id, data.Len())
+	}
+	w.u8(uint8(id))
+	w.u32(uint32(data.Len()))
+	w.Write(data.Bytes())
+	return nil
+}
+
+func (w *writer) colors(p *Palette) {
+	for _, c := range p.Colors {
+		w.Write([]byte{c.R, c.G, c.B, c.A})
+	}
+	for i := range p.Colors {
+		if i < len(p.Emissive) && p.Emissive[i] {
+			w.u8(1)
+		} else {
+			w.u8(0)
+		}
+	}
+}
+
+func (w *writer) palette(p *Palette) error {
+	if p == nil {
+		return fmt.Errorf("missing palette")
+	}
+	if len(p.Colors) > math.MaxUint8 {
+		return fmt.Errorf("too many colors in palette: %d", len(p.Colors))
+	}
+	w.u8(uint8(len(p.Colors)))
+	w.colors(p)
+	return nil
+}
+
+func (w *writer) legacyPalette(c *LegacyPaletteChunk) error {
+	if c.Palette == nil {
+		return fmt.Errorf("missing palette")
+	}
+	if len(c.Palette.Colors) > math.MaxUint16 {
+		return fmt.Errorf("too many colors in palette: %d", len(c.Palette.Colors))
+	}
+	w.u8(c.Rows)
+	w.u8(c.Columns)
+	w.u16(uint16(len(c.Palette.Colors)))
+	w.u8(c.DefaultColor)
+	w.u8(c.DefaultBackgroundColor)
+	w.colors(c.Palette)
+	return nil
+}
+
+func (w *writer) point(p Point) error {
+	if len(p.Name) > math.MaxUint8 {
+		return fmt.Errorf("point name too long: %q", p.Name)
+	}
+	w.u8(uint8(len(p.Name)))
+	w.WriteString(p.Name)
+	w.vec3(p.Value)
+	return nil
+}
+
+// shape writes shape sub-chunks in the order used by the engine.
+func (w *writer) shape(s *Shape) error {
+	if len(s.Blocks) != int(s.Width)*int(s.Height)*int(s.Depth) {
+		return fmt.Errorf("%d blocks for a %dx%dx%d shape", len(s.Blocks), s.Width, s.Height, s.Depth)
+	}
+	if len(s.Name) > math.MaxUint8 {
+		return fmt.Errorf("shape name too long: %q", s.Name)
+	}
+
+	subChunks := []struct {
+		id      SubChunkID
+		written bool
+		write   func(w *writer) error
+	}{
+		{SubChunkIDSize, true, func(w *writer) error {
+			w.u16(s.Width)
+			w.u16(s.Height)
+			w.u16(s.Depth)
+			return nil
+		}},
+		{SubChunkIDShapeID, s.ID != 0, func(w *writer) error {
+			w.u16(s.ID)
+			return nil
+		}},
+		{SubChunkIDParentID, s.ParentID != 0, func(w *writer) error {
+			w.u16(s.ParentID)
+			return nil
+		}},
+		{SubChunkIDTransform, s.Transform != nil, func(w *writer) error {
+			w.vec3(s.Transform.Position)
+			w.vec3(s.Transform.Rotation)
+			w.vec3(s.Transform.Scale)
+			return nil
+		}},
+		{SubChunkIDPivot, s.Pivot != nil, func(w *writer) error {
+			w.vec3(*s.Pivot)
+			return nil
+		}},
+		{SubChunkIDCollisionBox, s.CollisionBox != nil, func(w *writer) error {
+			w.vec3(s.CollisionBox.Min)
+			w.vec3(s.CollisionBox.Max)
+			return nil
+		}},
+		{SubChunkIDIsHidden, s.Hidden, func(w *writer) error {
+			w.u8(1)
+			return nil
+		}},
+		{SubChunkIDPalette, s.Palette != nil, func(w *writer) error {
+			return w.palette(s.Palette)
+		}},
+		{SubChunkIDBlocks, true, func(w *writer) error {
+			w.Write(s.Blocks)
+			return nil
+		}},
+	}
+
+	for _, subChunk := range subChunks {
+		if !subChunk.written {
+			continue
+		}
+		if err := w.subChunk(subChunk.id, subChunk.write); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range s.Points {
+		if err := w.subChunk(SubChunkIDPoint, func(w *writer) error { return w.point(p) }); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.PointRotations {
+		if err := w.subChunk(SubChunkIDPointRotation, func(w *writer) error { return w.point(p) }); err != nil {
+			return err
+		}
+	}
+
+	if s.BakedLighting != nil {
+		if err := w.subChunk(SubChunkIDBakedLighting, func(w *writer) error {
+			w.Write(s.BakedLighting)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, unknown := range s.Unknown {
+		w.Write(unknown)
+	}
+
+	if s.Name != "" {
+		w.u8(uint8(SubChunkIDName))
+		w.u8(uint8(len(s.Name)))
+		w.WriteString(s.Name)
+	}
+
+	return nil
+}
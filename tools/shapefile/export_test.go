@@ -0,0 +1,12 @@
+package shapefile
+
+// Internals used by external tests (package shapefile_test), which can
+// use fixtures of internal/testutil.
+
+const HeaderSize = headerSize
+
+var (
+	ClearSources     = clearSources
+	EngineFile       = engineFile
+	EngineShapeChunk = engineShapeChunk
+)
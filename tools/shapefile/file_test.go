@@ -0,0 +1,168 @@
+package shapefile_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+func decode(t *testing.T, data []byte) *shapefile.File {
+	t.Helper()
+	f, err := shapefile.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRoundTrip(t *testing.T) {
+	original := testutil.Chest()
+	data := testutil.Encode(t, original)
+
+	decoded := decode(t, data)
+	if again := testutil.Encode(t, decoded); !bytes.Equal(again, data) {
+		t.Errorf("encoding a decoded file gives different bytes")
+	}
+
+	shapefile.ClearSources(decoded)
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("decoded file differs from encoded one:\n got: %+v\nwant: %+v", decoded, original)
+	}
+}
+
+func TestHierarchy(t *testing.T) {
+	f := testutil.Chest()
+	shapes := f.Shapes()
+
+	if len(shapes) != 3 || f.Root() != shapes[0] {
+		t.Fatalf("%d shapes, root %v", len(shapes), f.Root())
+	}
+	if f.Parent(shapes[0]) != nil || f.Parent(shapes[1]) != shapes[0] || f.Parent(shapes[2]) != shapes[1] {
+		t.Errorf("wrong parents")
+	}
+	if children := f.Children(shapes[0]); len(children) != 1 || children[0] != shapes[1] {
+		t.Errorf("root children: %v", children)
+	}
+	if children := f.Children(shapes[2]); len(children) != 0 {
+		t.Errorf("leaf children: %v", children)
+	}
+	if f.Palette() == nil || len(f.Palette().Colors) != 1 {
+		t.Errorf("palette: %v", f.Palette())
+	}
+	if string(f.Preview()) != "\x89PNG fake preview" {
+		t.Errorf("preview: %q", f.Preview())
+	}
+	if count := shapes[0].BlockCount(); count != 2 {
+		t.Errorf("root has %d blocks, want 2", count)
+	}
+}
+
+func TestSetPreview(t *testing.T) {
+	f := testutil.Chest()
+	f.SetPreview([]byte("new"))
+	if string(f.Preview()) != "new" || len(f.Chunks) != len(testutil.Chest().Chunks) {
+		t.Errorf("preview not replaced")
+	}
+
+	f = &shapefile.File{Chunks: []shapefile.Chunk{shapefile.NewShape(1, 1, 1)}}
+	f.SetPreview([]byte("added"))
+	if _, ok := f.Chunks[0].(*shapefile.Preview); !ok || string(f.Preview()) != "added" {
+		t.Errorf("preview not added first")
+	}
+}
+
+func TestStreamingDecoder(t *testing.T) {
+	d := shapefile.NewDecoder(bytes.NewReader(testutil.Encode(t, testutil.Chest())))
+
+	ids := make([]shapefile.ChunkID, 0)
+	for {
+		chunk, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, chunk.ChunkID())
+	}
+
+	expected := []shapefile.ChunkID{shapefile.ChunkIDPreview, shapefile.ChunkIDPalette, shapefile.ChunkIDShape, shapefile.ChunkIDShape, shapefile.ChunkIDShape, 12}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("chunks %v, want %v", ids, expected)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	original := testutil.Chest()
+	data := testutil.Encode(t, original)
+	l := shapefile.Inspect(data)
+
+	if len(l.Problems) > 0 {
+		t.Fatalf("problems in a valid file: %v", l.Problems)
+	}
+	if *l.Header != (shapefile.Header{Version: shapefile.Version, Compression: shapefile.CompressionZip, TotalSize: uint32(len(data) - shapefile.HeaderSize)}) {
+		t.Errorf("header %+v", l.Header)
+	}
+
+	ids := make([]string, 0)
+	for _, c := range l.Chunks {
+		ids = append(ids, c.ID.String())
+	}
+	if want := []string{"PREVIEW", "PALETTE", "SHAPE", "SHAPE", "SHAPE", "UNKNOWN_12"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("chunks %v, want %v", ids, want)
+	}
+
+	// chunks follow each other
+	offset := shapefile.HeaderSize
+	for _, c := range l.Chunks {
+		if c.Offset != offset {
+			t.Errorf("%s chunk at offset %d, want %d", c.ID, c.Offset, offset)
+		}
+		offset = c.Offset + c.HeaderSize + int(c.Size)
+	}
+	if offset != len(data) {
+		t.Errorf("chunks end at %d, file has %d bytes", offset, len(data))
+	}
+
+	lid := l.Chunks[3]
+	subChunks := make([]string, 0)
+	for _, sc := range lid.SubChunks {
+		subChunks = append(subChunks, sc.ID.String())
+	}
+	want := []string{"SHAPE_SIZE", "SHAPE_ID", "SHAPE_PARENT_ID", "SHAPE_TRANSFORM", "SHAPE_PIVOT",
+		"OBJECT_COLLISION_BOX", "OBJECT_IS_HIDDEN", "SHAPE_BLOCKS", "SHAPE_BAKED_LIGHTING", "SHAPE_NAME"}
+	if !reflect.DeepEqual(subChunks, want) {
+		t.Errorf("sub-chunks %v, want %v", subChunks, want)
+	}
+	if !lid.Compressed || lid.UncompressedSize == 0 || lid.SubChunks[7].Size != 2*1*4 {
+		t.Errorf("lid chunk %+v", lid)
+	}
+
+	f := l.File()
+	shapefile.ClearSources(f)
+	if !reflect.DeepEqual(f, original) {
+		t.Errorf("inspected file differs from encoded one")
+	}
+}
+
+// TestBundleShapes round-trips the shapes bundled with the app.
+// It is skipped when all of them are Git LFS pointers (objects not pulled).
+func TestBundleShapes(t *testing.T) {
+	shapes := testutil.BundleShapes(t)
+
+	for _, s := range shapes {
+		t.Run(s.Name, func(t *testing.T) {
+			f := decode(t, s.Data)
+			if len(f.Shapes()) == 0 {
+				t.Errorf("no shapes")
+			}
+			if again := testutil.Encode(t, f); !bytes.Equal(again, s.Data) {
+				t.Errorf("encoding gives different bytes")
+			}
+		})
+	}
+}
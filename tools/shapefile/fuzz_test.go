@@ -0,0 +1,55 @@
+package shapefile_test
+
+import (
+	"bytes"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+// addSeeds adds shapes bundled with the app (when Git LFS objects are
+// pulled) and test files to the corpus.
+func addSeeds(f *testing.F) {
+	testutil.AddBundleSeeds(f)
+	f.Add(testutil.Encode(f, testutil.Chest()))
+	f.Add(shapefile.EngineFile(shapefile.EngineShapeChunk(f)))
+}
+
+func FuzzDecode(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := shapefile.NewDecoder(bytes.NewReader(data))
+		d.SetMaxSize(4 << 20)
+		file := &shapefile.File{}
+		for {
+			chunk, err := d.Next()
+			if err != nil {
+				break
+			}
+			file.Chunks = append(file.Chunks, chunk)
+		}
+
+		// decoded chunks can always be encoded
+		var b bytes.Buffer
+		if err := shapefile.Encode(&b, file); err != nil {
+			t.Fatalf("decoded chunks can't be encoded: %v", err)
+		}
+		if _, err := shapefile.Decode(&b); err != nil {
+			t.Fatalf("encoded chunks can't be decoded: %v", err)
+		}
+	})
+}
+
+func FuzzInspect(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 1<<20 {
+			return
+		}
+		l := shapefile.Inspect(data)
+		if _, err := shapefile.Decode(bytes.NewReader(data)); err == nil && l.Header == nil {
+			t.Fatalf("decoded file without header")
+		}
+	})
+}
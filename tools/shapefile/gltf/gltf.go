@@ -0,0 +1,485 @@
+// Package gltf exports .3zh files to glTF 2.0 (.gltf and .glb).
+//
+// Each shape is a node, with the shape's transform, and a mesh built with
+// greedy meshing: faces of the same color are merged in rectangles.
+// Colors are vertex colors, emissive colors get their own material.
+// Shape points are empty nodes, children of their shape's node.
+//
+// Cubzh uses a left-handed coordinate system (Y up, Z forward),
+// glTF a right-handed one: Z coordinates are negated.
+// One block is one unit.
+package gltf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"cu.bzh/tools/shapefile"
+)
+
+const (
+	Generator = "cubzh 3zh exporter"
+
+	componentTypeFloat = 5126
+	componentTypeUint  = 5125
+
+	targetArrayBuffer        = 34962
+	targetElementArrayBuffer = 34963
+
+	glbMagic     = 0x46546C67
+	glbVersion   = 2
+	glbChunkJSON = 0x4E4F534A
+	glbChunkBIN  = 0x004E4942
+)
+
+var ErrNoShape = errors.New("no shape")
+
+// Document is a glTF JSON document, with what the exporter uses.
+type Document struct {
+	Asset       Asset         `json:"asset"`
+	Scene       int           `json:"scene"`
+	Scenes      []*Scene      `json:"scenes"`
+	Nodes       []*Node       `json:"nodes"`
+	Meshes      []*Mesh       `json:"meshes,omitempty"`
+	Materials   []*Material   `json:"materials,omitempty"`
+	Accessors   []*Accessor   `json:"accessors,omitempty"`
+	BufferViews []*BufferView `json:"bufferViews,omitempty"`
+	Buffers     []*Buffer     `json:"buffers,omitempty"`
+}
+
+type Asset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator,omitempty"`
+}
+
+type Scene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type Node struct {
+	Name        string      `json:"name,omitempty"`
+	Mesh        *int        `json:"mesh,omitempty"`
+	Children    []int       `json:"children,omitempty"`
+	Translation *[3]float32 `json:"translation,omitempty"`
+	Rotation    *[4]float32 `json:"rotation,omitempty"`
+	Scale       *[3]float32 `json:"scale,omitempty"`
+	// hidden shapes have no mesh, and "hidden": true,
+	// points have "point": true
+	Extras map[string]interface{} `json:"extras,omitempty"`
+}
+
+type Mesh struct {
+	Name       string       `json:"name,omitempty"`
+	Primitives []*Primitive `json:"primitives"`
+}
+
+type Primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+}
+
+type Material struct {
+	Name                 string                `json:"name,omitempty"`
+	PBRMetallicRoughness *PBRMetallicRoughness `json:"pbrMetallicRoughness"`
+	EmissiveFactor       *[3]float32           `json:"emissiveFactor,omitempty"`
+	AlphaMode            string                `json:"alphaMode,omitempty"`
+}
+
+type PBRMetallicRoughness struct {
+	BaseColorFactor [4]float32 `json:"baseColorFactor"`
+	MetallicFactor  float32    `json:"metallicFactor"`
+	RoughnessFactor float32    `json:"roughnessFactor"`
+}
+
+type Accessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type BufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target,omitempty"`
+}
+
+type Buffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri,omitempty"`
+}
+
+// materialKey identifies materials: one for opaque colors, one for
+// transparent colors, and one per emissive color.
+type materialKey struct {
+	transparent bool
+	emissive    bool
+	color       shapefile.Color
+}
+
+type exporter struct {
+	file      *shapefile.File
+	doc       *Document
+	buf       bytes.Buffer
+	materials map[materialKey]int
+	visited   map[*shapefile.Shape]bool
+}
+
+// Export returns the glTF document of a file, with its binary buffer.
+// The buffer's URI is not set.
+func Export(f *shapefile.File) (*Document, []byte, error) {
+
+	root := f.Root()
+	if root == nil {
+		return nil, nil, ErrNoShape
+	}
+
+	e := &exporter{
+		file: f,
+		doc: &Document{
+			Asset:  Asset{Version: "2.0", Generator: Generator},
+			Scenes: []*Scene{{Nodes: []int{0}}},
+			Nodes:  []*Node{},
+		},
+		materials: make(map[materialKey]int),
+		visited:   make(map[*shapefile.Shape]bool),
+	}
+
+	e.addShape(root)
+
+	if e.buf.Len() > 0 {
+		e.doc.Buffers = []*Buffer{{ByteLength: e.buf.Len()}}
+	}
+
+	return e.doc, e.buf.Bytes(), nil
+}
+
+// addShape adds the node of a shape, with its children, returning its index.
+func (e *exporter) addShape(s *shapefile.Shape) int {
+	e.visited[s] = true
+
+	node := &Node{Name: s.Name}
+	if node.Name == "" {
+		node.Name = fmt.Sprintf("shape #%d", s.ID)
+	}
+	index := len(e.doc.Nodes)
+	e.doc.Nodes = append(e.doc.Nodes, node)
+
+	// the root shape's transform places the object in the world
+	if t := s.Transform; t != nil && s != e.file.Root() {
+		if t.Position != (shapefile.Vec3{}) {
+			node.Translation = &[3]float32{t.Position.X, t.Position.Y, -t.Position.Z}
+		}
+		if t.Rotation != (shapefile.Vec3{}) {
+			node.Rotation = quaternion(t.Rotation)
+		}
+		if t.Scale != (shapefile.Vec3{X: 1, Y: 1, Z: 1}) {
+			node.Scale = &[3]float32{t.Scale.X, t.Scale.Y, t.Scale.Z}
+		}
+	}
+
+	pivot := shapefile.Vec3{X: float32(s.Width) / 2, Y: float32(s.Height) / 2, Z: float32(s.Depth) / 2}
+	if s.Pivot != nil {
+		pivot = *s.Pivot
+	}
+
+	if s.Hidden {
+		node.Extras = map[string]interface{}{"hidden": true}
+	} else if mesh := e.addMesh(s, node.Name, pivot); mesh != nil {
+		node.Mesh = mesh
+	}
+
+	for _, child := range e.file.Children(s) {
+		// corrupted files could have cycles
+		if !e.visited[child] {
+			node.Children = append(node.Children, e.addShape(child))
+		}
+	}
+
+	rotations := make(map[string]shapefile.Vec3)
+	for _, p := range s.PointRotations {
+		rotations[p.Name] = p.Value
+	}
+	for _, p := range s.Points {
+		point := &Node{
+			Name:        p.Name,
+			Translation: &[3]float32{p.Value.X - pivot.X, p.Value.Y - pivot.Y, -(p.Value.Z - pivot.Z)},
+			Extras:      map[string]interface{}{"point": true},
+		}
+		if rotation, ok := rotations[p.Name]; ok && rotation != (shapefile.Vec3{}) {
+			point.Rotation = quaternion(rotation)
+		}
+		node.Children = append(node.Children, len(e.doc.Nodes))
+		e.doc.Nodes = append(e.doc.Nodes, point)
+	}
+
+	return index
+}
+
+// vertices of a primitive
+type vertices struct {
+	positions []float32
+	normals   []float32
+	colors    []float32
+	indices   []uint32
+}
+
+// addMesh adds the mesh of a shape, nil if it has no visible faces.
+func (e *exporter) addMesh(s *shapefile.Shape, name string, pivot shapefile.Vec3) *int {
+
+	palette := shapePalette(e.file, s)
+	quads := greedyMesh(s, palette)
+	if len(quads) == 0 {
+		return nil
+	}
+
+	primitives := make(map[materialKey]*vertices)
+	keys := make([]materialKey, 0)
+
+	for _, q := range quads {
+		c := paletteColor(palette, q.index)
+		key := materialKey{transparent: c.A < 255}
+		if isEmissive(palette, q.index) {
+			key.emissive = true
+			key.color = shapefile.Color{R: c.R, G: c.G, B: c.B, A: 255}
+		}
+		v, ok := primitives[key]
+		if !ok {
+			v = &vertices{}
+			primitives[key] = v
+			keys = append(keys, key)
+		}
+
+		var normal [3]float32
+		normal[q.axis] = float32(q.direction)
+		normal[2] = -normal[2]
+
+		var corners [4][3]float32
+		for i, corner := range q.corners {
+			corners[i] = [3]float32{corner[0] - pivot.X, corner[1] - pivot.Y, -(corner[2] - pivot.Z)}
+		}
+
+		first := uint32(len(v.positions) / 3)
+		for _, corner := range corners {
+			v.positions = append(v.positions, corner[:]...)
+			v.normals = append(v.normals, normal[:]...)
+			v.colors = append(v.colors, linear(c.R), linear(c.G), linear(c.B), float32(c.A)/255)
+		}
+
+		// counter-clockwise, seen from the front
+		if dot(cross(sub(corners[1], corners[0]), sub(corners[2], corners[0])), normal) > 0 {
+			v.indices = append(v.indices, first, first+1, first+2, first, first+2, first+3)
+		} else {
+			v.indices = append(v.indices, first, first+2, first+1, first, first+3, first+2)
+		}
+	}
+
+	mesh := &Mesh{Name: name}
+	for _, key := range keys {
+		v := primitives[key]
+		min, max := bounds(v.positions)
+		mesh.Primitives = append(mesh.Primitives, &Primitive{
+			Attributes: map[string]int{
+				"POSITION": e.addAccessor(v.positions, componentTypeFloat, "VEC3", targetArrayBuffer, min, max),
+				"NORMAL":   e.addAccessor(v.normals, componentTypeFloat, "VEC3", targetArrayBuffer, nil, nil),
+				"COLOR_0":  e.addAccessor(v.colors, componentTypeFloat, "VEC4", targetArrayBuffer, nil, nil),
+			},
+			Indices:  e.addAccessor(v.indices, componentTypeUint, "SCALAR", targetElementArrayBuffer, nil, nil),
+			Material: e.material(key),
+		})
+	}
+
+	index := len(e.doc.Meshes)
+	e.doc.Meshes = append(e.doc.Meshes, mesh)
+	return &index
+}
+
+// addAccessor writes data in the buffer, returning the index of its accessor.
+func (e *exporter) addAccessor(data interface{}, componentType int, accessorType string, target int, min, max []float32) int {
+
+	// accessors must be aligned on their component size
+	for e.buf.Len()%4 != 0 {
+		e.buf.WriteByte(0)
+	}
+	offset := e.buf.Len()
+	binary.Write(&e.buf, binary.LittleEndian, data)
+
+	components := map[string]int{"SCALAR": 1, "VEC3": 3, "VEC4": 4}[accessorType]
+	length := e.buf.Len() - offset
+
+	e.doc.BufferViews = append(e.doc.BufferViews, &BufferView{Buffer: 0, ByteOffset: offset, ByteLength: length, Target: target})
+	e.doc.Accessors = append(e.doc.Accessors, &Accessor{
+		BufferView:    len(e.doc.BufferViews) - 1,
+		ComponentType: componentType,
+		Count:         length / 4 / components,
+		Type:          accessorType,
+		Min:           min,
+		Max:           max,
+	})
+	return len(e.doc.Accessors) - 1
+}
+
+// material returns the index of a material, adding it if needed.
+func (e *exporter) material(key materialKey) int {
+	if index, ok := e.materials[key]; ok {
+		return index
+	}
+
+	m := &Material{
+		Name: "opaque",
+		PBRMetallicRoughness: &PBRMetallicRoughness{
+			BaseColorFactor: [4]float32{1, 1, 1, 1},
+			MetallicFactor:  0,
+			RoughnessFactor: 1,
+		},
+	}
+	if key.transparent {
+		m.Name = "transparent"
+		m.AlphaMode = "BLEND"
+	}
+	if key.emissive {
+		m.Name = fmt.Sprintf("emissive #%02x%02x%02x", key.color.R, key.color.G, key.color.B)
+		if key.transparent {
+			m.Name += " transparent"
+		}
+		m.EmissiveFactor = &[3]float32{linear(key.color.R), linear(key.color.G), linear(key.color.B)}
+	}
+
+	index := len(e.doc.Materials)
+	e.doc.Materials = append(e.doc.Materials, m)
+	e.materials[key] = index
+	return index
+}
+
+// shapePalette returns the palette used by a shape: its own,
+// or the root shape's one, or the file's one.
+func shapePalette(f *shapefile.File, s *shapefile.Shape) *shapefile.Palette {
+	if s.Palette != nil {
+		return s.Palette
+	}
+	if root := f.Root(); root.Palette != nil {
+		return root.Palette
+	}
+	return f.Palette()
+}
+
+// quaternion returns the rotation of euler angles, as a glTF quaternion (x, y, z, w).
+func quaternion(euler shapefile.Vec3) *[4]float32 {
+	m := shapefile.RotationMatrix(euler)
+	// negating Z on both sides
+	m[0][2], m[1][2], m[2][0], m[2][1] = -m[0][2], -m[1][2], -m[2][0], -m[2][1]
+
+	var x, y, z, w float64
+	if trace := m[0][0] + m[1][1] + m[2][2]; trace > 0 {
+		s := 2 * math.Sqrt(trace+1)
+		w, x, y, z = s/4, (m[2][1]-m[1][2])/s, (m[0][2]-m[2][0])/s, (m[1][0]-m[0][1])/s
+	} else if m[0][0] > m[1][1] && m[0][0] > m[2][2] {
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		w, x, y, z = (m[2][1]-m[1][2])/s, s/4, (m[0][1]+m[1][0])/s, (m[0][2]+m[2][0])/s
+	} else if m[1][1] > m[2][2] {
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		w, x, y, z = (m[0][2]-m[2][0])/s, (m[0][1]+m[1][0])/s, s/4, (m[1][2]+m[2][1])/s
+	} else {
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		w, x, y, z = (m[1][0]-m[0][1])/s, (m[0][2]+m[2][0])/s, (m[1][2]+m[2][1])/s, s/4
+	}
+	return &[4]float32{float32(x), float32(y), float32(z), float32(w)}
+}
+
+// linear converts an sRGB color component to linear, as glTF expects.
+func linear(c uint8) float32 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return float32(v / 12.92)
+	}
+	return float32(math.Pow((v+0.055)/1.055, 2.4))
+}
+
+func sub(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func cross(a, b [3]float32) [3]float32 {
+	return [3]float32{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func dot(a, b [3]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func bounds(positions []float32) ([]float32, []float32) {
+	min := []float32{positions[0], positions[1], positions[2]}
+	max := []float32{positions[0], positions[1], positions[2]}
+	for i := 3; i < len(positions); i += 3 {
+		for k := 0; k < 3; k++ {
+			min[k] = float32(math.Min(float64(min[k]), float64(positions[i+k])))
+			max[k] = float32(math.Max(float64(max[k]), float64(positions[i+k])))
+		}
+	}
+	return min, max
+}
+
+// EncodeGLTF writes a .gltf file, with its buffer embedded as a data URI.
+func EncodeGLTF(w io.Writer, f *shapefile.File) error {
+	doc, buf, err := Export(f)
+	if err != nil {
+		return err
+	}
+	if len(doc.Buffers) > 0 {
+		doc.Buffers[0].URI = "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// EncodeGLB writes a .glb file: a JSON chunk followed by a binary chunk.
+func EncodeGLB(w io.Writer, f *shapefile.File) error {
+	doc, buf, err := Export(f)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// chunks are aligned on 4 bytes, JSON with spaces
+	for len(data)%4 != 0 {
+		data = append(data, ' ')
+	}
+	bin := append([]byte{}, buf...)
+	for len(bin)%4 != 0 {
+		bin = append(bin, 0)
+	}
+
+	length := 12 + 8 + len(data)
+	if len(bin) > 0 {
+		length += 8 + len(bin)
+	}
+
+	out := &bytes.Buffer{}
+	binary.Write(out, binary.LittleEndian, []uint32{glbMagic, glbVersion, uint32(length)})
+	binary.Write(out, binary.LittleEndian, []uint32{uint32(len(data)), glbChunkJSON})
+	out.Write(data)
+	if len(bin) > 0 {
+		binary.Write(out, binary.LittleEndian, []uint32{uint32(len(bin)), glbChunkBIN})
+		out.Write(bin)
+	}
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
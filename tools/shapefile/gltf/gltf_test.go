@@ -0,0 +1,442 @@
+package gltf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var (
+	brown = shapefile.Color{R: 120, G: 72, B: 30, A: 255}
+	gold  = shapefile.Color{R: 255, G: 200, B: 40, A: 255}
+	skin  = shapefile.Color{R: 240, G: 190, B: 150, A: 255}
+	blue  = shapefile.Color{R: 40, G: 80, B: 200, A: 255}
+	glass = shapefile.Color{R: 180, G: 220, B: 255, A: 128}
+)
+
+// box returns a shape filled with a color index.
+func box(id, parentID uint16, width, height, depth uint16, index uint8) *shapefile.Shape {
+	s := shapefile.NewShape(width, height, depth)
+	s.ID = id
+	s.ParentID = parentID
+	for x := 0; x < int(width); x++ {
+		for y := 0; y < int(height); y++ {
+			for z := 0; z < int(depth); z++ {
+				s.SetBlock(x, y, z, index)
+			}
+		}
+	}
+	if parentID != 0 {
+		s.Transform = &shapefile.Transform{Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	}
+	return s
+}
+
+// avatar returns a small avatar: a body with a head (wearing a glass visor),
+// two arms, one of them hidden, and a hand point.
+func avatar() *shapefile.File {
+	body := box(1, 0, 4, 6, 2, 1)
+	body.Name = "Body"
+	body.Palette = &shapefile.Palette{Colors: []shapefile.Color{skin, blue, glass}, Emissive: make([]bool, 3)}
+
+	head := box(2, 1, 4, 4, 4, 0)
+	head.Name = "Head"
+	head.Transform.Position = shapefile.Vec3{X: 0, Y: 5, Z: 0}
+	for x := 0; x < 4; x++ {
+		head.SetBlock(x, 2, 0, 2)
+	}
+
+	right := box(3, 1, 2, 6, 2, 0)
+	right.Name = "RightArm"
+	right.Pivot = &shapefile.Vec3{X: 0, Y: 5, Z: 1}
+	right.Transform.Position = shapefile.Vec3{X: 2, Y: 2, Z: 0}
+	right.Transform.Rotation = shapefile.Vec3{Z: -math.Pi / 2}
+	right.Points = []shapefile.Point{{Name: "Hand", Value: shapefile.Vec3{X: 1, Y: 0, Z: 1}}}
+	right.PointRotations = []shapefile.Point{{Name: "Hand", Value: shapefile.Vec3{Y: math.Pi}}}
+
+	left := box(4, 1, 2, 6, 2, 0)
+	left.Name = "LeftArm"
+	left.Hidden = true
+	left.Transform.Position = shapefile.Vec3{X: -3, Y: 0, Z: 0}
+	left.Transform.Scale = shapefile.Vec3{X: 0.5, Y: 0.5, Z: 0.5}
+
+	return &shapefile.File{Chunks: []shapefile.Chunk{body, head, right, left}}
+}
+
+func export(t *testing.T, f *shapefile.File) (*Document, []byte) {
+	t.Helper()
+	doc, buf, err := Export(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Buffers) != 1 || doc.Buffers[0].ByteLength != len(buf) {
+		t.Fatalf("buffers %v, %d bytes", doc.Buffers, len(buf))
+	}
+	return doc, buf
+}
+
+// floats returns the float32 values of an accessor.
+func floats(t *testing.T, doc *Document, buf []byte, accessor int) []float32 {
+	t.Helper()
+	a := doc.Accessors[accessor]
+	view := doc.BufferViews[a.BufferView]
+	values := make([]float32, view.ByteLength/4)
+	binary.Read(bytes.NewReader(buf[view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, values)
+	return values
+}
+
+// indices returns the uint32 values of an accessor.
+func indices(t *testing.T, doc *Document, buf []byte, accessor int) []uint32 {
+	t.Helper()
+	a := doc.Accessors[accessor]
+	view := doc.BufferViews[a.BufferView]
+	values := make([]uint32, view.ByteLength/4)
+	binary.Read(bytes.NewReader(buf[view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, values)
+	return values
+}
+
+func TestGreedyMesh(t *testing.T) {
+	palette := &shapefile.Palette{Colors: []shapefile.Color{brown, gold, glass, glass}}
+
+	cases := []struct {
+		name  string
+		shape func() *shapefile.Shape
+		quads int
+	}{
+		{"cube", func() *shapefile.Shape { return box(1, 0, 3, 3, 3, 0) }, 6},
+		{"bar", func() *shapefile.Shape { return box(1, 0, 8, 1, 1, 0) }, 6},
+		{"empty", func() *shapefile.Shape { return shapefile.NewShape(2, 2, 2) }, 0},
+		// one block of another color in the middle of the top face
+		// splits it in 5 rectangles
+		{"top block", func() *shapefile.Shape {
+			s := box(1, 0, 3, 3, 3, 0)
+			s.SetBlock(1, 2, 1, 1)
+			return s
+		}, 5 + 5},
+		// glass hides glass of the same color only
+		{"glass", func() *shapefile.Shape { return box(1, 0, 2, 2, 2, 2) }, 6},
+		{"two glasses", func() *shapefile.Shape {
+			s := box(1, 0, 2, 1, 1, 2)
+			s.SetBlock(1, 0, 0, 3)
+			return s
+		}, 12},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if quads := greedyMesh(c.shape(), palette); len(quads) != c.quads {
+				t.Errorf("%d quads, want %d", len(quads), c.quads)
+			}
+		})
+	}
+}
+
+func TestExportCube(t *testing.T) {
+	s := box(1, 0, 2, 3, 4, 0)
+	s.Palette = &shapefile.Palette{Colors: []shapefile.Color{brown}, Emissive: []bool{false}}
+	doc, buf := export(t, &shapefile.File{Chunks: []shapefile.Chunk{s}})
+
+	if len(doc.Meshes) != 1 || len(doc.Meshes[0].Primitives) != 1 {
+		t.Fatalf("meshes %+v", doc.Meshes)
+	}
+	p := doc.Meshes[0].Primitives[0]
+	positions := floats(t, doc, buf, p.Attributes["POSITION"])
+	normals := floats(t, doc, buf, p.Attributes["NORMAL"])
+
+	// centered on the default pivot, Z negated
+	position := doc.Accessors[p.Attributes["POSITION"]]
+	if !equal(position.Min, []float32{-1, -1.5, -2}) || !equal(position.Max, []float32{1, 1.5, 2}) {
+		t.Errorf("bounds %v %v", position.Min, position.Max)
+	}
+	if position.Count != 6*4 {
+		t.Errorf("%d vertices, want 24", position.Count)
+	}
+
+	// triangles are counter-clockwise seen from outside
+	for _, i := range indices(t, doc, buf, p.Indices) {
+		if i >= uint32(position.Count) {
+			t.Fatalf("index %d out of range", i)
+		}
+	}
+	tris := indices(t, doc, buf, p.Indices)
+	vertex := func(i uint32) [3]float32 {
+		return [3]float32{positions[i*3], positions[i*3+1], positions[i*3+2]}
+	}
+	for i := 0; i < len(tris); i += 3 {
+		a, b, c := vertex(tris[i]), vertex(tris[i+1]), vertex(tris[i+2])
+		n := [3]float32{normals[tris[i]*3], normals[tris[i]*3+1], normals[tris[i]*3+2]}
+		center := [3]float32{(a[0] + b[0] + c[0]) / 3, (a[1] + b[1] + c[1]) / 3, (a[2] + b[2] + c[2]) / 3}
+		if dot(cross(sub(b, a), sub(c, a)), n) <= 0 || dot(center, n) <= 0 {
+			t.Errorf("triangle %v %v %v, normal %v", a, b, c, n)
+		}
+	}
+
+	colors := floats(t, doc, buf, p.Attributes["COLOR_0"])
+	if want := linear(brown.R); colors[0] != want || colors[3] != 1 {
+		t.Errorf("color %v, want red component %v", colors[:4], want)
+	}
+}
+
+func TestExportMaterials(t *testing.T) {
+	s := box(1, 0, 3, 1, 1, 0)
+	s.Palette = &shapefile.Palette{Colors: []shapefile.Color{brown, gold, glass}, Emissive: []bool{false, true, false}}
+	s.SetBlock(1, 0, 0, 1)
+	s.SetBlock(2, 0, 0, 2)
+	doc, _ := export(t, &shapefile.File{Chunks: []shapefile.Chunk{s}})
+
+	names := make([]string, 0)
+	for _, p := range doc.Meshes[0].Primitives {
+		names = append(names, doc.Materials[p.Material].Name)
+	}
+	if want := []string{"opaque", "emissive #ffc828", "transparent"}; !equalStrings(names, want) {
+		t.Errorf("materials %v, want %v", names, want)
+	}
+
+	emissive := doc.Materials[1]
+	if emissive.EmissiveFactor == nil || emissive.EmissiveFactor[0] != 1 || emissive.EmissiveFactor[1] != linear(200) {
+		t.Errorf("emissive factor %v", emissive.EmissiveFactor)
+	}
+	if doc.Materials[2].AlphaMode != "BLEND" {
+		t.Errorf("transparent alpha mode %q", doc.Materials[2].AlphaMode)
+	}
+}
+
+func TestExportHierarchy(t *testing.T) {
+	doc, _ := export(t, avatar())
+
+	names := make([]string, 0)
+	for _, n := range doc.Nodes {
+		names = append(names, n.Name)
+	}
+	if want := []string{"Body", "Head", "RightArm", "Hand", "LeftArm"}; !equalStrings(names, want) {
+		t.Fatalf("nodes %v, want %v", names, want)
+	}
+	if !equalInts(doc.Nodes[0].Children, []int{1, 2, 4}) || !equalInts(doc.Nodes[2].Children, []int{3}) {
+		t.Errorf("children %v %v", doc.Nodes[0].Children, doc.Nodes[2].Children)
+	}
+
+	body, head, arm, hand, left := doc.Nodes[0], doc.Nodes[1], doc.Nodes[2], doc.Nodes[3], doc.Nodes[4]
+	if body.Translation != nil || body.Rotation != nil || body.Scale != nil {
+		t.Errorf("root node has a transform")
+	}
+	if head.Translation == nil || *head.Translation != [3]float32{0, 5, 0} || head.Rotation != nil || head.Scale != nil {
+		t.Errorf("head transform %v %v %v", head.Translation, head.Rotation, head.Scale)
+	}
+
+	// rotations are reversed around X and Y, Z being negated
+	s2 := float32(math.Sqrt2 / 2)
+	if arm.Rotation == nil || !near(arm.Rotation[:], []float32{0, 0, -s2, s2}) {
+		t.Errorf("arm rotation %v", arm.Rotation)
+	}
+	if hand.Translation == nil || *hand.Translation != [3]float32{1, -5, 0} || hand.Mesh != nil || hand.Extras["point"] != true {
+		t.Errorf("hand %+v", hand)
+	}
+	if hand.Rotation == nil || !near(hand.Rotation[:], []float32{0, 1, 0, 0}) {
+		t.Errorf("hand rotation %v", hand.Rotation)
+	}
+
+	if left.Mesh != nil || left.Extras["hidden"] != true || left.Scale == nil || *left.Scale != [3]float32{0.5, 0.5, 0.5} {
+		t.Errorf("hidden arm %+v", left)
+	}
+	if *left.Translation != [3]float32{-3, 0, 0} {
+		t.Errorf("hidden arm translation %v", *left.Translation)
+	}
+}
+
+func TestQuaternion(t *testing.T) {
+	s2 := float32(math.Sqrt2 / 2)
+	cases := []struct {
+		euler shapefile.Vec3
+		want  []float32
+	}{
+		{shapefile.Vec3{}, []float32{0, 0, 0, 1}},
+		{shapefile.Vec3{X: math.Pi / 2}, []float32{-s2, 0, 0, s2}},
+		{shapefile.Vec3{Y: math.Pi / 2}, []float32{0, -s2, 0, s2}},
+		{shapefile.Vec3{Z: math.Pi / 2}, []float32{0, 0, s2, s2}},
+		{shapefile.Vec3{X: math.Pi}, []float32{1, 0, 0, 0}},
+	}
+	for _, c := range cases {
+		q := quaternion(c.euler)
+		// q and -q are the same rotation
+		if !near(q[:], c.want) && !near([]float32{-q[0], -q[1], -q[2], -q[3]}, c.want) {
+			t.Errorf("quaternion(%v) = %v, want %v", c.euler, q, c.want)
+		}
+	}
+}
+
+func TestExportGolden(t *testing.T) {
+	for name, f := range map[string]*shapefile.File{"chest": testutil.Chest(), "avatar": avatar()} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := EncodeGLTF(&out, f); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, filepath.Join("testdata", name+".gltf"), out.Bytes())
+		})
+	}
+}
+
+// TestExportBundle compares exports of bundled shapes to golden files.
+// Bundled shapes are stored with Git LFS, the test can only run once
+// objects have been pulled.
+func TestExportBundle(t *testing.T) {
+	for _, name := range []string{"chest", "avatar"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(testutil.BundleShapesDirectory, name+".3zh")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testutil.IsLFSPointer(data) {
+				t.Skipf("%s is a Git LFS pointer (objects not pulled?)", path)
+			}
+			f, err := shapefile.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := EncodeGLTF(&out, f); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, filepath.Join("testdata", "bundle_"+name+".gltf"), out.Bytes())
+		})
+	}
+}
+
+func golden(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("no golden file %s, run tests with -update to create it", path)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("differs from %s, run tests with -update if changes are expected", path)
+	}
+}
+
+func TestEncodeGLB(t *testing.T) {
+	var out bytes.Buffer
+	if err := EncodeGLB(&out, testutil.Chest()); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+
+	var header [5]uint32
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &header)
+	if header[0] != glbMagic || header[1] != 2 || int(header[2]) != len(data) || header[4] != glbChunkJSON {
+		t.Fatalf("header %x", header)
+	}
+	jsonLength := int(header[3])
+	if jsonLength%4 != 0 {
+		t.Errorf("JSON chunk length %d not aligned", jsonLength)
+	}
+
+	doc := &Document{}
+	if err := json.Unmarshal(data[20:20+jsonLength], doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Buffers) != 1 || doc.Buffers[0].URI != "" {
+		t.Fatalf("buffers %+v", doc.Buffers)
+	}
+
+	bin := data[20+jsonLength:]
+	var chunk [2]uint32
+	binary.Read(bytes.NewReader(bin), binary.LittleEndian, &chunk)
+	if chunk[1] != glbChunkBIN || int(chunk[0]) != len(bin)-8 || int(chunk[0]) < doc.Buffers[0].ByteLength {
+		t.Errorf("binary chunk %x, %d bytes", chunk, len(bin))
+	}
+
+	_, buf := export(t, testutil.Chest())
+	if !bytes.Equal(bin[8:8+len(buf)], buf) {
+		t.Errorf("binary chunk differs from buffer")
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	if _, _, err := Export(&shapefile.File{}); !errors.Is(err, ErrNoShape) {
+		t.Errorf("no shape: %v", err)
+	}
+	if err := EncodeGLB(&bytes.Buffer{}, &shapefile.File{}); !errors.Is(err, ErrNoShape) {
+		t.Errorf("no shape (glb): %v", err)
+	}
+
+	// empty shapes have no mesh, nor buffer
+	doc, buf, err := Export(&shapefile.File{Chunks: []shapefile.Chunk{shapefile.NewShape(1, 1, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Nodes) != 1 || doc.Nodes[0].Mesh != nil || len(doc.Buffers) != 0 || len(buf) != 0 {
+		t.Errorf("empty shape exported as %+v", doc)
+	}
+	var out bytes.Buffer
+	if err := EncodeGLB(&out, &shapefile.File{Chunks: []shapefile.Chunk{shapefile.NewShape(1, 1, 1)}}); err != nil || out.Len()%4 != 0 {
+		t.Errorf("empty shape glb: %d bytes, %v", out.Len(), err)
+	}
+}
+
+func equal(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func near(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > 1e-5 {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
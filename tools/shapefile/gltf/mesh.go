@@ -0,0 +1,123 @@
+package gltf
+
+import (
+	"cu.bzh/tools/shapefile"
+)
+
+// quad is a rectangle covering block faces of the same color.
+type quad struct {
+	// corners, in shape coordinates
+	corners [4][3]float32
+	// axis (0: x, 1: y, 2: z) and direction (1 or -1) of the normal
+	axis      int
+	direction int
+	index     uint8
+}
+
+// greedyMesh returns the visible faces of a shape, merged in rectangles
+// when they have the same color.
+func greedyMesh(s *shapefile.Shape, palette *shapefile.Palette) []quad {
+
+	quads := make([]quad, 0)
+	size := [3]int{int(s.Width), int(s.Height), int(s.Depth)}
+	block := func(p [3]int) uint8 {
+		return s.Block(p[0], p[1], p[2])
+	}
+
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		// mask of visible faces in a slice, color index + 1 (0: no face)
+		mask := make([]int, size[u]*size[v])
+
+		for _, direction := range []int{-1, 1} {
+			for slice := 0; slice < size[axis]; slice++ {
+
+				for j := 0; j < size[v]; j++ {
+					for i := 0; i < size[u]; i++ {
+						var p [3]int
+						p[axis], p[u], p[v] = slice, i, j
+						mask[j*size[u]+i] = 0
+
+						index := block(p)
+						if index == shapefile.AirBlock {
+							continue
+						}
+						neighbor := p
+						neighbor[axis] += direction
+						if !hidesFace(palette, index, block(neighbor)) {
+							mask[j*size[u]+i] = int(index) + 1
+						}
+					}
+				}
+
+				// merge faces in rectangles, first along u, then v
+				for j := 0; j < size[v]; j++ {
+					for i := 0; i < size[u]; {
+						value := mask[j*size[u]+i]
+						if value == 0 {
+							i++
+							continue
+						}
+
+						w := 1
+						for i+w < size[u] && mask[j*size[u]+i+w] == value {
+							w++
+						}
+						h := 1
+					rows:
+						for j+h < size[v] {
+							for k := 0; k < w; k++ {
+								if mask[(j+h)*size[u]+i+k] != value {
+									break rows
+								}
+							}
+							h++
+						}
+						for l := 0; l < h; l++ {
+							for k := 0; k < w; k++ {
+								mask[(j+l)*size[u]+i+k] = 0
+							}
+						}
+
+						q := quad{axis: axis, direction: direction, index: uint8(value - 1)}
+						plane := float32(slice)
+						if direction > 0 {
+							plane++
+						}
+						for c, offset := range [4][2]int{{0, 0}, {w, 0}, {w, h}, {0, h}} {
+							q.corners[c][axis] = plane
+							q.corners[c][u] = float32(i + offset[0])
+							q.corners[c][v] = float32(j + offset[1])
+						}
+						quads = append(quads, q)
+
+						i += w
+					}
+				}
+			}
+		}
+	}
+
+	return quads
+}
+
+// hidesFace returns true if a block hides the face of its neighbor:
+// opaque blocks do, transparent blocks only hide faces of the same color.
+func hidesFace(palette *shapefile.Palette, index, neighbor uint8) bool {
+	if neighbor == shapefile.AirBlock {
+		return false
+	}
+	return paletteColor(palette, neighbor).A == 255 || neighbor == index
+}
+
+// paletteColor returns a palette color, gray if missing.
+func paletteColor(palette *shapefile.Palette, index uint8) shapefile.Color {
+	if palette == nil || int(index) >= len(palette.Colors) {
+		return shapefile.Color{R: 128, G: 128, B: 128, A: 255}
+	}
+	return palette.Colors[index]
+}
+
+func isEmissive(palette *shapefile.Palette, index uint8) bool {
+	return palette != nil && int(index) < len(palette.Emissive) && palette.Emissive[index]
+}
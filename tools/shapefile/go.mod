@@ -0,0 +1,3 @@
+module cu.bzh/tools/shapefile
+
+go 1.22.6
@@ -0,0 +1,336 @@
+package shapefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Layout is the structure of a .3zh file, as found in its bytes.
+//
+// Unlike Decode, Inspect doesn't stop at the first error: it goes as far
+// as it can, listing problems, and decodes the chunks that can be.
+type Layout struct {
+	// Header is nil if the file doesn't start with a header
+	Header *Header
+	// Size is the size of the file, in bytes
+	Size   int
+	Chunks []*ChunkLayout
+	// Problems found in the file, none if it's valid
+	Problems []Problem
+}
+
+// ChunkLayout is a top level chunk of a file.
+type ChunkLayout struct {
+	ID ChunkID
+	// Offset of the chunk in the file, starting with its id
+	Offset int
+	// HeaderSize is the size of the chunk header, its id included
+	HeaderSize int
+	// Size is the size of the chunk data, as written in its header
+	Size uint32
+	// Compressed and UncompressedSize are only set by v6 headers
+	Compressed       bool
+	UncompressedSize uint32
+	// SubChunks of shape chunks
+	SubChunks []*SubChunkLayout
+	// Chunk is the decoded chunk, nil if it can't be decoded
+	Chunk Chunk
+}
+
+// SubChunkLayout is a sub-chunk of a shape chunk.
+type SubChunkLayout struct {
+	ID SubChunkID
+	// Offset of the sub-chunk in the uncompressed chunk data
+	Offset int
+	// Size of the sub-chunk data (name length for SHAPE_NAME)
+	Size uint32
+}
+
+// Problem is something wrong found in a file.
+type Problem struct {
+	// Chunk is the index of the chunk in Layout.Chunks, -1 for the file
+	Chunk   int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Chunk < 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("chunk #%d: %s", p.Chunk, p.Message)
+}
+
+// sizes of headers, chunk ids included
+const (
+	v5HeaderSize = 1 + 4
+	v6HeaderSize = 1 + 4 + 1 + 4
+	headerSize   = len(MagicBytes) + 4 + 1 + 4
+)
+
+// fixed sizes of sub-chunks
+var subChunkSizes = map[SubChunkID]uint32{
+	SubChunkIDSize:         3 * 2,
+	SubChunkIDShapeID:      2,
+	SubChunkIDParentID:     2,
+	SubChunkIDTransform:    3 * 3 * 4,
+	SubChunkIDPivot:        3 * 4,
+	SubChunkIDCollisionBox: 2 * 3 * 4,
+	SubChunkIDIsHidden:     1,
+}
+
+// Inspect returns the layout of a .3zh file, and the problems found in it:
+// inconsistent sizes, invalid chunks, blocks and baked lighting not matching
+// shape sizes, color indexes out of palettes and parents that don't exist.
+func Inspect(data []byte) *Layout {
+	l := &Layout{Size: len(data)}
+
+	if len(data) < headerSize || string(data[:len(MagicBytes)]) != MagicBytes {
+		l.problem(-1, "%v", ErrInvalidMagic)
+		return l
+	}
+
+	r := &reader{data: data[len(MagicBytes):headerSize]}
+	l.Header = &Header{Version: r.u32(), Compression: Compression(r.u8()), TotalSize: r.u32()}
+
+	// files of other versions are inspected anyway, the header is the same
+	if l.Header.Version != Version {
+		l.problem(-1, "%v: %d", ErrUnsupportedVersion, l.Header.Version)
+	}
+
+	chunks := data[headerSize:]
+	if int(l.Header.TotalSize) != len(chunks) {
+		l.problem(-1, "header announces %d bytes of chunks, file has %d", l.Header.TotalSize, len(chunks))
+		if int(l.Header.TotalSize) < len(chunks) {
+			chunks = chunks[:l.Header.TotalSize]
+		}
+	}
+
+	for offset := 0; offset < len(chunks); {
+		c, err := l.inspectChunk(chunks[offset:], headerSize+offset)
+		if err != nil {
+			// chunks that follow can't be found
+			l.problem(len(l.Chunks)-1, "%v", err)
+			break
+		}
+		offset += c.HeaderSize + int(c.Size)
+	}
+
+	l.validateShapes()
+	return l
+}
+
+func (l *Layout) problem(chunk int, format string, args ...interface{}) {
+	l.Problems = append(l.Problems, Problem{Chunk: chunk, Message: fmt.Sprintf(format, args...)})
+}
+
+// inspectChunk reads the chunk at the start of data, adding it to the layout.
+// It returns an error when the size of the chunk is unknown.
+func (l *Layout) inspectChunk(data []byte, offset int) (*ChunkLayout, error) {
+	c := &ChunkLayout{ID: ChunkID(data[0]), Offset: offset, HeaderSize: v5HeaderSize}
+	l.Chunks = append(l.Chunks, c)
+	index := len(l.Chunks) - 1
+
+	if c.ID == 0 || c.ID >= chunkIDMax {
+		return nil, fmt.Errorf("%w: id %d at offset %d", ErrInvalidChunk, c.ID, offset)
+	}
+	if isV6Chunk(c.ID) {
+		c.HeaderSize = v6HeaderSize
+	}
+	if len(data) < c.HeaderSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidChunk)
+	}
+
+	r := &reader{data: data[1:c.HeaderSize]}
+	c.Size = r.u32()
+	if isV6Chunk(c.ID) {
+		c.Compressed = r.u8() != 0
+		c.UncompressedSize = r.u32()
+	}
+	if uint64(c.HeaderSize)+uint64(c.Size) > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: %d bytes of data, %d left in file", ErrInvalidChunk, c.Size, len(data)-c.HeaderSize)
+	}
+	body := data[c.HeaderSize : c.HeaderSize+int(c.Size)]
+
+	if !isV6Chunk(c.ID) {
+		if c.ID == ChunkIDPreview {
+			c.Chunk = &Preview{Data: bytes.Clone(body)}
+		} else {
+			c.Chunk = &UnknownChunk{ID: c.ID, Data: bytes.Clone(body)}
+		}
+		return c, nil
+	}
+
+	if c.Size == 0 || c.UncompressedSize == 0 {
+		l.problem(index, "%s chunk is empty", c.ID)
+		return c, nil
+	}
+
+	payload := body
+	if c.Compressed {
+		var err error
+		payload, err = uncompress(body, c.UncompressedSize)
+		if err != nil {
+			l.problem(index, "%s chunk: %v", c.ID, err)
+			return c, nil
+		}
+	}
+	if len(payload) != int(c.UncompressedSize) {
+		l.problem(index, "%s chunk: %d bytes of data, header announces %d", c.ID, len(payload), c.UncompressedSize)
+		return c, nil
+	}
+
+	problems := len(l.Problems)
+	if c.ID == ChunkIDShape {
+		l.inspectSubChunks(c, index, payload)
+	}
+
+	chunk, err := decodeV6Chunk(c.ID, &source{data: body, compressed: c.Compressed, uncompressedSize: c.UncompressedSize}, payload)
+	if err != nil {
+		// sub-chunk problems are more precise
+		if len(l.Problems) == problems {
+			l.problem(index, "%v", err)
+		}
+		return c, nil
+	}
+	c.Chunk = chunk
+	return c, nil
+}
+
+// uncompress returns at most size+1 bytes of zlib compressed data,
+// so that the uncompressed size can be checked.
+func uncompress(data []byte, size uint32) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, int64(size)+1))
+}
+
+// inspectSubChunks lists the sub-chunks of a shape chunk,
+// checking their sizes like decodeShape reads them.
+func (l *Layout) inspectSubChunks(c *ChunkLayout, index int, payload []byte) {
+	r := &reader{data: payload}
+	previous := SubChunkID(0)
+	hasSize := false
+	var width, height, depth uint16
+	var blocks, lighting *SubChunkLayout
+
+	for len(r.data) > 0 {
+		if previous == SubChunkIDName && len(r.data) == nameSizePadding {
+			break
+		}
+
+		sc := &SubChunkLayout{Offset: len(payload) - len(r.data)}
+		sc.ID = SubChunkID(r.u8())
+		previous = sc.ID
+
+		switch {
+		case sc.ID == SubChunkIDName:
+			sc.Size = uint32(r.u8())
+			r.read(int(sc.Size))
+		case !isKnownSubChunk(sc.ID):
+			sc.Size = r.u32()
+			r.read(5)
+			r.read(int(sc.Size))
+		default:
+			sc.Size = r.u32()
+			body := r.read(int(sc.Size))
+			if expected, ok := subChunkSizes[sc.ID]; ok && r.err == nil && sc.Size != expected {
+				l.problem(index, "%s sub-chunk at offset %d: %d bytes, expected %d", sc.ID, sc.Offset, sc.Size, expected)
+			} else if sc.ID == SubChunkIDSize && r.err == nil {
+				s := &reader{data: body}
+				width, height, depth = s.u16(), s.u16(), s.u16()
+				hasSize = true
+			}
+		}
+
+		if r.err != nil {
+			l.problem(index, "%s sub-chunk at offset %d exceeds chunk size", sc.ID, sc.Offset)
+			return
+		}
+		c.SubChunks = append(c.SubChunks, sc)
+
+		switch sc.ID {
+		case SubChunkIDBlocks:
+			blocks = sc
+		case SubChunkIDBakedLighting:
+			lighting = sc
+		}
+	}
+
+	count := uint64(width) * uint64(height) * uint64(depth)
+	if !hasSize {
+		l.problem(index, "shape has no %s sub-chunk", SubChunkIDSize)
+	}
+	if blocks == nil {
+		l.problem(index, "shape has no %s sub-chunk", SubChunkIDBlocks)
+	} else if uint64(blocks.Size) != count {
+		l.problem(index, "%s: %d blocks for a %dx%dx%d shape (%d)", SubChunkIDBlocks, blocks.Size, width, height, depth, count)
+	}
+	if lighting != nil && uint64(lighting.Size) != 2*count {
+		l.problem(index, "%s: %d bytes for a %dx%dx%d shape (2 per block: %d)", SubChunkIDBakedLighting, lighting.Size, width, height, depth, 2*count)
+	}
+}
+
+// File returns a file made of the chunks that could be decoded.
+func (l *Layout) File() *File {
+	f := &File{}
+	if l.Header != nil {
+		f.Compression = l.Header.Compression
+	}
+	for _, c := range l.Chunks {
+		if c.Chunk != nil {
+			f.Chunks = append(f.Chunks, c.Chunk)
+		}
+	}
+	return f
+}
+
+// validateShapes checks color indexes and parents of decoded shapes.
+// Shapes that couldn't be decoded are ignored.
+func (l *Layout) validateShapes() {
+	f := l.File()
+
+	// shapes, with the index of their chunk
+	shapes := make([]*Shape, 0)
+	indexes := make(map[*Shape]int)
+	for i, c := range l.Chunks {
+		if s, ok := c.Chunk.(*Shape); ok {
+			shapes = append(shapes, s)
+			indexes[s] = i
+		}
+	}
+
+	for position, s := range shapes {
+		index := indexes[s]
+
+		if palette := f.ShapePalette(s); palette != nil {
+			invalid, max := 0, uint8(0)
+			for _, b := range s.Blocks {
+				if b != AirBlock && int(b) >= len(palette.Colors) {
+					invalid++
+					if b > max {
+						max = b
+					}
+				}
+			}
+			if invalid > 0 {
+				l.problem(index, "colors out of the palette (%d colors): %d blocks, index up to %d", len(palette.Colors), invalid, max)
+			}
+		}
+
+		// like the engine, parents are positions in the file,
+		// and they're loaded before their children
+		switch {
+		case s.ParentID == 0 && position > 0:
+			l.problem(index, "shape has no parent, only the first shape should be a root")
+		case int(s.ParentID) > len(shapes):
+			l.problem(index, "parent %d doesn't exist (%d shapes)", s.ParentID, len(shapes))
+		case int(s.ParentID) > position:
+			l.problem(index, "parent %d is not before the shape, the engine can't attach it", s.ParentID)
+		}
+	}
+}
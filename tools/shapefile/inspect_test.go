@@ -0,0 +1,131 @@
+package shapefile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// rawShapeChunk returns an uncompressed shape chunk with the given sub-chunks.
+func rawShapeChunk(t *testing.T, subChunks ...func(w *writer)) []byte {
+	t.Helper()
+	payload := &writer{}
+	for _, write := range subChunks {
+		write(payload)
+	}
+	chunk := &writer{}
+	chunk.u8(uint8(ChunkIDShape))
+	chunk.u32(uint32(payload.Len()))
+	chunk.u8(0)
+	chunk.u32(uint32(payload.Len()))
+	chunk.Write(payload.Bytes())
+	return chunk.Bytes()
+}
+
+func subChunk(id SubChunkID, data ...byte) func(w *writer) {
+	return func(w *writer) {
+		w.subChunk(id, func(w *writer) error {
+			w.Write(data)
+			return nil
+		})
+	}
+}
+
+func TestInspectEngineFile(t *testing.T) {
+	preview := []byte{uint8(ChunkIDPreview), 0, 0, 0, 0}
+	l := Inspect(engineFile(preview, engineShapeChunk(t)))
+	if len(l.Problems) > 0 {
+		t.Fatalf("problems in an engine file: %v", l.Problems)
+	}
+	// the unknown camera sub-chunk is listed
+	if s := l.Chunks[1].SubChunks; len(s) != 4 || s[2].ID != 10 || s[2].Size != 2 {
+		t.Errorf("sub-chunks %+v", s)
+	}
+}
+
+func TestInspectProblems(t *testing.T) {
+	valid := engineFile([]byte{uint8(ChunkIDPreview), 0, 0, 0, 0}, engineShapeChunk(t))
+
+	wrongSize := append([]byte{}, valid...)
+	wrongSize[headerSize-4]++
+
+	size := subChunk(SubChunkIDSize, 2, 0, 1, 0, 1, 0)
+
+	uncompressedSize := rawShapeChunk(t, size, subChunk(SubChunkIDBlocks, 0, 0))
+	copy(uncompressedSize[6:10], []byte{3, 0, 0, 0})
+
+	withShapes := func(shapes ...*Shape) []byte {
+		chunks := make([]Chunk, 0)
+		for _, s := range shapes {
+			chunks = append(chunks, s)
+		}
+		return encode(t, &File{Chunks: chunks})
+	}
+	root := func() *Shape {
+		s := NewShape(1, 1, 1)
+		s.Palette = &Palette{Colors: []Color{{1, 2, 3, 255}}, Emissive: []bool{false}}
+		return s
+	}
+	child := func(parentID uint16) *Shape {
+		s := NewShape(1, 1, 1)
+		s.ParentID = parentID
+		return s
+	}
+	colors := root()
+	colors.Blocks[0] = 3
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"magic", []byte("PARTICUBES!...."), "not a .3zh file"},
+		{"total size", wrongSize, "header announces"},
+		{"truncated", valid[:len(valid)-10], "bytes of data"},
+		{"chunk id", engineFile([]byte{30, 0, 0, 0, 0}), "id 30"},
+		{"empty shape", engineFile(rawShapeChunk(t)), "SHAPE chunk is empty"},
+		{"uncompressed size", engineFile(uncompressedSize), "header announces 3"},
+		{"no size", engineFile(rawShapeChunk(t, subChunk(SubChunkIDBlocks, 0))), "no SHAPE_SIZE"},
+		{"no blocks", engineFile(rawShapeChunk(t, size)), "no SHAPE_BLOCKS"},
+		{"blocks", engineFile(rawShapeChunk(t, size, subChunk(SubChunkIDBlocks, 0, 0, 0))), "3 blocks for a 2x1x1 shape"},
+		{"baked lighting", engineFile(rawShapeChunk(t, size, subChunk(SubChunkIDBlocks, 0, 0), subChunk(SubChunkIDBakedLighting, 0, 0))), "2 per block: 4"},
+		{"sub-chunk size", engineFile(rawShapeChunk(t, size, subChunk(SubChunkIDShapeID, 1, 0, 0), subChunk(SubChunkIDBlocks, 0, 0))), "SHAPE_ID sub-chunk at offset 11: 3 bytes, expected 2"},
+		{"sub-chunk overflow", engineFile(rawShapeChunk(t, size, func(w *writer) { w.Write([]byte{uint8(SubChunkIDBlocks), 9, 0, 0, 0, 0}) })), "exceeds chunk size"},
+		{"colors", withShapes(colors), "colors out of the palette (1 colors): 1 blocks, index up to 3"},
+		{"no parent", withShapes(root(), child(0)), "only the first shape should be a root"},
+		{"parent", withShapes(root(), child(3)), "parent 3 doesn't exist"},
+		{"parent order", withShapes(root(), child(3), child(1)), "parent 3 is not before the shape"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := Inspect(test.data)
+			if len(l.Problems) == 0 {
+				t.Fatal("no problems")
+			}
+			for _, p := range l.Problems {
+				if strings.Contains(p.String(), test.expected) {
+					return
+				}
+			}
+			t.Errorf("problems %v, want %q", l.Problems, test.expected)
+		})
+	}
+}
+
+func TestInspectKeepsGoing(t *testing.T) {
+	// a broken shape doesn't prevent inspecting the next chunks
+	broken := rawShapeChunk(t, subChunk(SubChunkIDSize, 1, 0, 1, 0, 1, 0), subChunk(SubChunkIDBlocks, 0, 0))
+	preview := []byte{uint8(ChunkIDPreview), 1, 0, 0, 0, 'p'}
+	l := Inspect(engineFile(broken, preview))
+
+	if len(l.Chunks) != 2 || l.Chunks[0].Chunk != nil || l.Chunks[1].Chunk == nil {
+		t.Fatalf("chunks %+v", l.Chunks)
+	}
+	if len(l.Problems) != 1 || l.Problems[0].Chunk != 0 {
+		t.Errorf("problems %v", l.Problems)
+	}
+	if p := l.File().Preview(); !bytes.Equal(p, []byte("p")) {
+		t.Errorf("preview %q", p)
+	}
+}
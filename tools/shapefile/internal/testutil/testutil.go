@@ -0,0 +1,139 @@
+// Package testutil provides fixtures shared by tests of shapefile packages.
+package testutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// BundleShapesDirectory is the directory of shapes bundled with the app.
+var BundleShapesDirectory = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "..", "bundle", "shapes")
+}()
+
+// Chest returns a file using all chunks and sub-chunks: a chest, with a
+// hidden lid and an unnamed lock on the lid.
+func Chest() *shapefile.File {
+	root := shapefile.NewShape(2, 3, 4)
+	root.ID = 1
+	root.Name = "chest"
+	root.Pivot = &shapefile.Vec3{X: 1, Y: 1.5, Z: 2}
+	root.Palette = &shapefile.Palette{
+		Colors:   []shapefile.Color{{R: 255, A: 255}, {G: 255, A: 128}},
+		Emissive: []bool{false, true},
+	}
+	root.SetBlock(0, 0, 0, 0)
+	root.SetBlock(1, 2, 3, 1)
+	root.Points = []shapefile.Point{{Name: "ModelPoint_Hand", Value: shapefile.Vec3{X: 1, Y: 2, Z: 3}}, {Name: "origin"}}
+	root.PointRotations = []shapefile.Point{{Name: "ModelPoint_Hand", Value: shapefile.Vec3{Y: 3.14}}}
+
+	lid := shapefile.NewShape(2, 1, 4)
+	lid.ID = 2
+	lid.ParentID = 1
+	lid.Name = "lid"
+	lid.Transform = &shapefile.Transform{Position: shapefile.Vec3{Y: 3}, Rotation: shapefile.Vec3{X: 0.5}, Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	lid.Pivot = &shapefile.Vec3{}
+	lid.CollisionBox = &shapefile.Box{Max: shapefile.Vec3{X: 2, Y: 1, Z: 4}}
+	lid.Hidden = true
+	lid.SetBlock(1, 0, 2, 1)
+	lid.BakedLighting = make([]byte, 2*2*1*4)
+	lid.BakedLighting[3] = 0xf0
+
+	lock := shapefile.NewShape(1, 1, 1)
+	lock.ID = 3
+	lock.ParentID = 2
+	lock.Transform = &shapefile.Transform{Scale: shapefile.Vec3{X: 0.5, Y: 0.5, Z: 0.5}}
+	lock.Pivot = &shapefile.Vec3{X: 0.5, Y: 0.5, Z: 0.5}
+
+	return &shapefile.File{
+		Compression: shapefile.CompressionZip,
+		Chunks: []shapefile.Chunk{
+			&shapefile.Preview{Data: []byte("\x89PNG fake preview")},
+			&shapefile.PaletteChunk{Palette: &shapefile.Palette{Colors: []shapefile.Color{{R: 1, G: 2, B: 3, A: 4}}, Emissive: []bool{true}}},
+			root,
+			lid,
+			lock,
+			&shapefile.UnknownChunk{ID: 12, Data: []byte("source metadata")},
+		},
+	}
+}
+
+// Encode encodes a file, failing the test on error.
+func Encode(tb testing.TB, f *shapefile.File) []byte {
+	tb.Helper()
+	var b bytes.Buffer
+	if err := shapefile.Encode(&b, f); err != nil {
+		tb.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// IsLFSPointer returns true if data is a Git LFS pointer, found instead of
+// files when LFS objects haven't been pulled.
+func IsLFSPointer(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("version https://git-lfs"))
+}
+
+// BundleShape is a .3zh file of BundleShapesDirectory.
+type BundleShape struct {
+	// Name is the name of the file
+	Name string
+	Data []byte
+}
+
+// BundleShapes returns shapes bundled with the app. The test is skipped
+// when all of them are Git LFS pointers, and fails when there are none.
+func BundleShapes(tb testing.TB) []BundleShape {
+	tb.Helper()
+	shapes, pointers := bundleShapes(tb)
+	if len(shapes) == 0 && pointers > 0 {
+		tb.Skipf("all %d .3zh files in %s are Git LFS pointers, run git lfs pull", pointers, BundleShapesDirectory)
+	}
+	if len(shapes) == 0 {
+		tb.Fatalf("no .3zh files in %s", BundleShapesDirectory)
+	}
+	if pointers > 0 {
+		tb.Logf("%d .3zh files in %s are Git LFS pointers, skipped", pointers, BundleShapesDirectory)
+	}
+	return shapes
+}
+
+// bundleShapes returns shapes bundled with the app that aren't Git LFS
+// pointers, and the number of pointers.
+func bundleShapes(tb testing.TB) ([]BundleShape, int) {
+	tb.Helper()
+	paths, err := filepath.Glob(filepath.Join(BundleShapesDirectory, "*.3zh"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	shapes := make([]BundleShape, 0)
+	pointers := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if IsLFSPointer(data) {
+			pointers++
+			continue
+		}
+		shapes = append(shapes, BundleShape{Name: filepath.Base(path), Data: data})
+	}
+	return shapes, pointers
+}
+
+// AddBundleSeeds adds shapes bundled with the app (when Git LFS objects
+// are pulled) to the corpus of a fuzz test.
+func AddBundleSeeds(f *testing.F) {
+	f.Helper()
+	shapes, _ := bundleShapes(f)
+	for _, s := range shapes {
+		f.Add(s.Data)
+	}
+}
@@ -0,0 +1,346 @@
+// Package lighting computes baked lighting of .3zh shapes.
+//
+// It's the flood fill of the engine (shape_compute_baked_lighting in
+// core/shape.c), giving the same SHAPE_BAKED_LIGHTING data: sunlight comes
+// from above the shape, going down without loss and losing 1 per block in
+// other directions, emissive colors light surrounding blocks with their
+// color, transparent blocks absorb part of the light going through them.
+//
+// Like in the engine, blocks are grouped in chunks of 16x16x16 blocks,
+// chunks without blocks don't exist: sunlight goes through them, but they
+// don't propagate light to their neighbors. Solid blocks, and air blocks
+// out of chunks, get the default light (full sunlight, no emission).
+//
+// testdata/vectors.txt lists shapes lit by the engine, testdata/vectors.c
+// checks them against core/shape.c.
+package lighting
+
+import (
+	"math"
+
+	"cu.bzh/tools/shapefile"
+)
+
+const (
+	chunkSize = 16
+	// max value of light channels (4 bits)
+	maxLight = 15
+)
+
+// light is the light of a block, channels are 4 bits.
+type light struct {
+	ambient, red, green, blue uint8
+}
+
+// defaultLight is the light of solid blocks and blocks out of chunks.
+var defaultLight = light{ambient: maxLight}
+
+// Compute returns the baked lighting of a shape, 2 bytes per block,
+// indexed like blocks: ambient | red << 4, then green | blue << 4.
+// The palette is the one used by the shape (see shapefile.File.ShapePalette).
+func Compute(s *shapefile.Shape, p *shapefile.Palette) []byte {
+	v := newVolume(s, p)
+	v.propagate()
+
+	data := make([]byte, 0, len(s.Blocks)*2)
+	for x := 0; x < int(s.Width); x++ {
+		for y := 0; y < int(s.Height); y++ {
+			for z := 0; z < int(s.Depth); z++ {
+				l := defaultLight
+				if color, ok := v.block(x, y, z); ok && color == shapefile.AirBlock {
+					l = v.light[v.index(x, y, z)]
+				}
+				data = append(data, l.ambient|l.red<<4, l.green|l.blue<<4)
+			}
+		}
+	}
+	return data
+}
+
+// Bake computes the baked lighting of all shapes of a file.
+func Bake(f *shapefile.File) {
+	for _, s := range f.Shapes() {
+		s.BakedLighting = Compute(s, f.ShapePalette(s))
+	}
+}
+
+// Check returns shapes without baked lighting,
+// or with baked lighting that isn't the computed one.
+func Check(f *shapefile.File) []*shapefile.Shape {
+	invalid := make([]*shapefile.Shape, 0)
+	for _, s := range f.Shapes() {
+		if string(s.BakedLighting) != string(Compute(s, f.ShapePalette(s))) {
+			invalid = append(invalid, s)
+		}
+	}
+	return invalid
+}
+
+// volume is the region lit by the flood fill: chunks containing blocks.
+type volume struct {
+	shape   *shapefile.Shape
+	palette *shapefile.Palette
+	// min and max corners of chunks (max excluded)
+	min, max [3]int
+	// chunks tells which chunks exist, indexed like blocks
+	chunks []bool
+	// light of blocks in chunks
+	light []light
+	// nodes to process, the last one first
+	queue [][3]int
+}
+
+func newVolume(s *shapefile.Shape, p *shapefile.Palette) *volume {
+	v := &volume{shape: s, palette: p}
+	if p == nil {
+		v.palette = &shapefile.Palette{}
+	}
+
+	first := true
+	for x := 0; x < int(s.Width); x++ {
+		for y := 0; y < int(s.Height); y++ {
+			for z := 0; z < int(s.Depth); z++ {
+				if s.Block(x, y, z) == shapefile.AirBlock {
+					continue
+				}
+				origin := [3]int{x / chunkSize * chunkSize, y / chunkSize * chunkSize, z / chunkSize * chunkSize}
+				for i := range origin {
+					if first || origin[i] < v.min[i] {
+						v.min[i] = origin[i]
+					}
+					if first || origin[i]+chunkSize > v.max[i] {
+						v.max[i] = origin[i] + chunkSize
+					}
+				}
+				first = false
+			}
+		}
+	}
+
+	size := v.chunkCount(0) * v.chunkCount(1) * v.chunkCount(2)
+	v.chunks = make([]bool, size)
+	v.light = make([]light, size*chunkSize*chunkSize*chunkSize)
+	for x := 0; x < int(s.Width); x++ {
+		for y := 0; y < int(s.Height); y++ {
+			for z := 0; z < int(s.Depth); z++ {
+				if s.Block(x, y, z) != shapefile.AirBlock {
+					v.chunks[v.chunkIndex(x, y, z)] = true
+				}
+			}
+		}
+	}
+	return v
+}
+
+func (v *volume) chunkCount(axis int) int {
+	return (v.max[axis] - v.min[axis]) / chunkSize
+}
+
+func (v *volume) chunkIndex(x, y, z int) int {
+	cx, cy, cz := (x-v.min[0])/chunkSize, (y-v.min[1])/chunkSize, (z-v.min[2])/chunkSize
+	return (cx*v.chunkCount(1)+cy)*v.chunkCount(2) + cz
+}
+
+// index returns the index of a block in light.
+func (v *volume) index(x, y, z int) int {
+	height, depth := v.max[1]-v.min[1], v.max[2]-v.min[2]
+	return ((x-v.min[0])*height+(y-v.min[1]))*depth + (z - v.min[2])
+}
+
+// block returns the color index of a block, false if it's out of chunks.
+// Blocks out of the shape, in its chunks, are air.
+func (v *volume) block(x, y, z int) (uint8, bool) {
+	if x < v.min[0] || y < v.min[1] || z < v.min[2] || x >= v.max[0] || y >= v.max[1] || z >= v.max[2] {
+		return 0, false
+	}
+	if !v.chunks[v.chunkIndex(x, y, z)] {
+		return 0, false
+	}
+	return v.shape.Block(x, y, z), true
+}
+
+func (v *volume) transparent(color uint8) bool {
+	return int(color) < len(v.palette.Colors) && v.palette.Colors[color].A < 255
+}
+
+func (v *volume) emissive(color uint8) bool {
+	return int(color) < len(v.palette.Emissive) && int(color) < len(v.palette.Colors) && v.palette.Emissive[color]
+}
+
+// emission returns the light of an emissive color, nothing for other colors.
+func (v *volume) emission(color uint8) light {
+	if !v.emissive(color) {
+		return light{}
+	}
+	c := v.palette.Colors[color]
+	return light{red: c.R >> 4, green: c.G >> 4, blue: c.B >> 4}
+}
+
+func (v *volume) push(x, y, z int) {
+	v.queue = append(v.queue, [3]int{x, y, z})
+}
+
+// neighbors are visited in that order, the first one being below
+var neighbors = [6][3]int{{0, -1, 0}, {0, 1, 0}, {1, 0, 0}, {-1, 0, 0}, {0, 0, 1}, {0, 0, -1}}
+
+// propagate lights the volume, from sunlight above it and emissive blocks.
+func (v *volume) propagate() {
+	// sunlight: a layer above chunks, one block larger on each side
+	for x := v.min[0] - 1; x <= v.max[0]; x++ {
+		for z := v.min[2] - 1; z <= v.max[2]; z++ {
+			v.push(x, v.max[1], z)
+		}
+	}
+	// emissive blocks, chunk by chunk
+	for cx := v.min[0]; cx < v.max[0]; cx += chunkSize {
+		for cy := v.min[1]; cy < v.max[1]; cy += chunkSize {
+			for cz := v.min[2]; cz < v.max[2]; cz += chunkSize {
+				if !v.chunks[v.chunkIndex(cx, cy, cz)] {
+					continue
+				}
+				for x := cx; x < cx+chunkSize; x++ {
+					for y := cy; y < cy+chunkSize; y++ {
+						for z := cz; z < cz+chunkSize; z++ {
+							if v.emissive(v.shape.Block(x, y, z)) {
+								v.push(x, y, z)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for len(v.queue) > 0 {
+		n := v.queue[len(v.queue)-1]
+		v.queue = v.queue[:len(v.queue)-1]
+
+		// blocks out of chunks are air with the default light
+		current, inChunk := v.block(n[0], n[1], n[2])
+		currentLight := defaultLight
+		if inChunk {
+			currentLight = v.light[v.index(n[0], n[1], n[2])]
+		} else {
+			current = shapefile.AirBlock
+		}
+		currentAir := current == shapefile.AirBlock
+		currentTransparent := v.transparent(current)
+		// open: at least one neighbor isn't opaque
+		open := false
+
+		for i, d := range neighbors {
+			x, y, z := n[0]+d[0], n[1]+d[1], n[2]+d[2]
+			neighbor, ok := v.block(x, y, z)
+			if !ok {
+				// sunlight goes down through missing chunks
+				if i == 0 && y >= v.min[1] && y < v.max[1] &&
+					x >= v.min[0]-1 && z >= v.min[2]-1 && x <= v.max[0] && z <= v.max[2] {
+					v.push(x, y, z)
+				}
+				continue
+			}
+
+			neighborAir := neighbor == shapefile.AirBlock
+			neighborTransparent := v.transparent(neighbor)
+			if neighborAir || neighborTransparent {
+				open = true
+			}
+			if currentAir || currentTransparent {
+				// sunlight goes down without loss
+				stepAmbient := 1
+				if i == 0 {
+					stepAmbient = 0
+				}
+				v.propagateTo(x, y, z, neighbor, currentLight, stepAmbient, 1)
+			}
+		}
+
+		// emissive blocks light the 26 blocks around them, for homogeneous
+		// self-lighting, instead of the 6 lit by the first propagation
+		if currentAir || !open {
+			continue
+		}
+		source := v.emission(current)
+		if source.red == 0 && source.green == 0 && source.blue == 0 {
+			continue
+		}
+		for xo := -1; xo <= 1; xo++ {
+			for yo := -1; yo <= 1; yo++ {
+				for zo := -1; zo <= 1; zo++ {
+					x, y, z := n[0]+xo, n[1]+yo, n[2]+zo
+					neighbor, ok := v.block(x, y, z)
+					if ok && (neighbor == shapefile.AirBlock || v.transparent(neighbor)) {
+						v.setSource(x, y, z, source)
+					}
+				}
+			}
+		}
+	}
+}
+
+// propagateTo propagates light to a neighbor, enqueuing it if its light changed.
+func (v *volume) propagateTo(x, y, z int, neighbor uint8, l light, stepAmbient, stepRGB int) {
+	i := v.index(x, y, z)
+
+	if neighbor != shapefile.AirBlock && !v.transparent(neighbor) {
+		// emissive blocks get their color, to propagate it
+		if v.emissive(neighbor) {
+			v.light[i] = v.emission(neighbor)
+			v.push(x, y, z)
+		}
+		return
+	}
+
+	if neighbor != shapefile.AirBlock {
+		// transparent blocks absorb part of the light, depending on their alpha,
+		// computed with float32 like the engine for the same roundings
+		a := float32(v.palette.Colors[neighbor].A) / 255
+		// circular ease-in
+		a = 1 - float32(math.Sqrt(float64(1-float32(a*a))))
+		absorbRGB := 1 - max(a-float32(stepRGB)/15, 0)
+		absorbAmbient := 1 - max(a-float32(stepAmbient)/15, 0)
+		l.red = uint8(float32(l.red)*absorbRGB) & maxLight
+		l.green = uint8(float32(l.green)*absorbRGB) & maxLight
+		l.blue = uint8(float32(l.blue)*absorbRGB) & maxLight
+		l.ambient = uint8(float32(l.ambient)*absorbAmbient) & maxLight
+	}
+
+	current := v.light[i]
+	changed := false
+	channel := func(value *uint8, source uint8, step int) {
+		if int(*value) < int(source)-step {
+			*value = uint8(int(source)-step) & maxLight
+			changed = true
+		}
+	}
+	channel(&current.ambient, l.ambient, stepAmbient)
+	channel(&current.red, l.red, stepRGB)
+	channel(&current.green, l.green, stepRGB)
+	channel(&current.blue, l.blue, stepRGB)
+	if changed {
+		v.light[i] = current
+		v.push(x, y, z)
+	}
+}
+
+// setSource raises the light of a block to the given one,
+// enqueuing it if it changed.
+func (v *volume) setSource(x, y, z int, source light) {
+	i := v.index(x, y, z)
+	current := v.light[i]
+	changed := false
+	channel := func(value *uint8, source uint8) {
+		if *value < source {
+			*value = source
+			changed = true
+		}
+	}
+	channel(&current.ambient, source.ambient)
+	channel(&current.red, source.red)
+	channel(&current.green, source.green)
+	channel(&current.blue, source.blue)
+	if changed {
+		v.light[i] = current
+		v.push(x, y, z)
+	}
+}
@@ -0,0 +1,161 @@
+package lighting
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+)
+
+var (
+	stone = shapefile.Color{R: 128, G: 128, B: 128, A: 255}
+	lamp  = shapefile.Color{R: 255, G: 128, B: 32, A: 255}
+)
+
+// vector is a shape lit by the engine, see testdata/vectors.c.
+type vector struct {
+	name     string
+	shape    *shapefile.Shape
+	lighting []byte
+}
+
+func readVectors(path string) ([]*vector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+	next := func() string {
+		scanner.Scan()
+		return scanner.Text()
+	}
+	number := func() int {
+		n, err := strconv.Atoi(next())
+		if err != nil {
+			panic(err)
+		}
+		return n
+	}
+	// hex values can be split on several lines
+	bytes := func(count int) []byte {
+		var s strings.Builder
+		for s.Len() < count*2 && scanner.Scan() {
+			s.WriteString(scanner.Text())
+		}
+		data, err := hex.DecodeString(s.String())
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}
+
+	vectors := make([]*vector, 0)
+	var v *vector
+	for scanner.Scan() {
+		switch token := scanner.Text(); token {
+		case "shape":
+			v = &vector{name: next(), shape: &shapefile.Shape{Palette: &shapefile.Palette{}}}
+		case "size":
+			v.shape.Width, v.shape.Height, v.shape.Depth = uint16(number()), uint16(number()), uint16(number())
+		case "color":
+			c := bytes(4)
+			v.shape.Palette.Colors = append(v.shape.Palette.Colors, shapefile.Color{R: c[0], G: c[1], B: c[2], A: c[3]})
+			v.shape.Palette.Emissive = append(v.shape.Palette.Emissive, next() == "1")
+		case "blocks":
+			v.shape.Blocks = bytes(int(v.shape.Width) * int(v.shape.Height) * int(v.shape.Depth))
+		case "lighting":
+			v.lighting = bytes(len(v.shape.Blocks) * 2)
+		case "end":
+			vectors = append(vectors, v)
+		default:
+			return nil, fmt.Errorf("unexpected %q", token)
+		}
+	}
+	return vectors, scanner.Err()
+}
+
+func TestComputeVectors(t *testing.T) {
+	vectors, err := readVectors("testdata/vectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no vectors")
+	}
+
+	for _, v := range vectors {
+		lighting := Compute(v.shape, v.shape.Palette)
+		if len(lighting) != len(v.lighting) {
+			t.Errorf("%s: %d bytes, want %d", v.name, len(lighting), len(v.lighting))
+			continue
+		}
+		for i := 0; i < len(lighting); i += 2 {
+			if lighting[i] != v.lighting[i] || lighting[i+1] != v.lighting[i+1] {
+				block := i / 2
+				depth, height := int(v.shape.Depth), int(v.shape.Height)
+				t.Errorf("%s: block (%d, %d, %d) light %02x%02x, want %02x%02x", v.name,
+					block/depth/height, block/depth%height, block%depth,
+					lighting[i], lighting[i+1], v.lighting[i], v.lighting[i+1])
+				break
+			}
+		}
+	}
+}
+
+func TestComputeEmpty(t *testing.T) {
+	s := shapefile.NewShape(2, 1, 1)
+	lighting := Compute(s, nil)
+	if string(lighting) != "\x0f\x00\x0f\x00" {
+		t.Errorf("lighting % x", lighting)
+	}
+}
+
+func TestBake(t *testing.T) {
+	// a closed box containing a lamp, with a child using the same palette
+	root := shapefile.NewShape(3, 3, 3)
+	root.ID = 1
+	root.Palette = &shapefile.Palette{Colors: []shapefile.Color{stone, lamp}, Emissive: []bool{false, true}}
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 3; y++ {
+			for z := 0; z < 3; z++ {
+				root.SetBlock(x, y, z, 0)
+			}
+		}
+	}
+	root.SetBlock(1, 1, 1, shapefile.AirBlock)
+
+	child := shapefile.NewShape(1, 3, 1)
+	child.ID = 2
+	child.ParentID = 1
+	child.SetBlock(0, 0, 0, 1)
+	f := &shapefile.File{Chunks: []shapefile.Chunk{root, child}}
+
+	if invalid := Check(f); len(invalid) != 2 {
+		t.Errorf("%d shapes to bake", len(invalid))
+	}
+	Bake(f)
+	if invalid := Check(f); len(invalid) != 0 {
+		t.Errorf("%d shapes to bake after baking", len(invalid))
+	}
+
+	// no sunlight in the box
+	if center := root.BakedLighting[13*2:][:2]; center[0] != 0 || center[1] != 0 {
+		t.Errorf("center of the box % x", center)
+	}
+	// the lamp lights blocks around it with its color (255 >> 4, 128 >> 4, 32 >> 4),
+	// losing 1 per block after that, under full sunlight
+	if above := child.BakedLighting[2:4]; above[0] != 0xff || above[1] != 0x28 {
+		t.Errorf("above the lamp % x", above)
+	}
+	if top := child.BakedLighting[4:6]; top[0] != 0xef || top[1] != 0x17 {
+		t.Errorf("top % x", top)
+	}
+}
@@ -0,0 +1,188 @@
+// Computes baked lighting of vectors.txt shapes with the engine (core/shape.c),
+// to check that the Go implementation gives the same results.
+//
+// build (from this directory):
+//   cc -O2 -I../../../../core -o vectors vectors.c ../../../../core/*.c -lz -lm -lpthread
+//
+// usage:
+//   ./vectors < vectors.txt        checks lighting of all shapes
+//   ./vectors -w < vectors.txt     prints vectors with lighting computed by the engine
+//
+// vectors.txt format, tokens separated by spaces or new lines:
+//   shape <name>
+//   size <width> <height> <depth>
+//   color <rrggbbaa> <emissive: 0|1>    (once per palette color)
+//   blocks <hex, 1 byte per block>      (indexed by (x * height + y) * depth + z)
+//   lighting <hex, 2 bytes per block>   (ambient | red << 4, green | blue << 4)
+//   end
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "chunk.h"
+#include "color_atlas.h"
+#include "color_palette.h"
+#include "int3.h"
+#include "shape.h"
+
+#define MAX_COLORS 255
+#define MAX_BLOCKS (64 * 64 * 64)
+
+typedef struct {
+    char name[64];
+    int width, height, depth;
+    int colorCount;
+    RGBAColor colors[MAX_COLORS];
+    int emissive[MAX_COLORS];
+    uint8_t blocks[MAX_BLOCKS];
+    uint8_t lighting[MAX_BLOCKS * 2];
+    int hasLighting;
+} Vector;
+
+static int read_hex(uint8_t *out, int count) {
+    for (int i = 0; i < count; i++) {
+        unsigned int v;
+        if (scanf(" %2x", &v) != 1) {
+            return 0;
+        }
+        out[i] = (uint8_t)v;
+    }
+    return 1;
+}
+
+static void print_hex(const uint8_t *data, int rows, int rowSize) {
+    for (int r = 0; r < rows; r++) {
+        printf(r == 0 ? "" : "\n");
+        for (int i = 0; i < rowSize; i++) {
+            printf("%02x", data[r * rowSize + i]);
+        }
+    }
+    printf("\n");
+}
+
+// read_vector returns 1 if a vector has been read, 0 at the end, -1 on errors
+static int read_vector(Vector *v) {
+    char token[64];
+    memset(v, 0, sizeof(Vector));
+    if (scanf("%63s", token) != 1) {
+        return 0;
+    }
+    if (strcmp(token, "shape") != 0 || scanf("%63s", v->name) != 1) {
+        return -1;
+    }
+    while (scanf("%63s", token) == 1) {
+        const int count = v->width * v->height * v->depth;
+        if (strcmp(token, "end") == 0) {
+            return 1;
+        } else if (strcmp(token, "size") == 0) {
+            if (scanf("%d %d %d", &v->width, &v->height, &v->depth) != 3 ||
+                v->width * v->height * v->depth > MAX_BLOCKS) {
+                return -1;
+            }
+        } else if (strcmp(token, "color") == 0) {
+            unsigned int rgba;
+            if (v->colorCount == MAX_COLORS ||
+                scanf("%8x %d", &rgba, &v->emissive[v->colorCount]) != 2) {
+                return -1;
+            }
+            v->colors[v->colorCount] = (RGBAColor){(uint8_t)(rgba >> 24),
+                                                   (uint8_t)(rgba >> 16),
+                                                   (uint8_t)(rgba >> 8),
+                                                   (uint8_t)rgba};
+            v->colorCount++;
+        } else if (strcmp(token, "blocks") == 0) {
+            if (read_hex(v->blocks, count) == 0) {
+                return -1;
+            }
+        } else if (strcmp(token, "lighting") == 0) {
+            if (read_hex(v->lighting, count * 2) == 0) {
+                return -1;
+            }
+            v->hasLighting = 1;
+        } else {
+            return -1;
+        }
+    }
+    return -1;
+}
+
+// compute returns baked lighting of the vector's shape, NULL if its blocks
+// don't fill its size (the engine only stores lighting of its bounding box)
+static VERTEX_LIGHT_STRUCT_T *compute(const Vector *v, ColorAtlas *atlas) {
+    Shape *s = shape_make();
+    ColorPalette *p = color_palette_new(atlas);
+    for (int i = 0; i < v->colorCount; i++) {
+        SHAPE_COLOR_INDEX_INT_T entry;
+        color_palette_check_and_add_color(p, v->colors[i], &entry, true);
+        color_palette_set_emissive(p, entry, v->emissive[i] != 0);
+    }
+    shape_set_palette(s, p, false);
+
+    for (int x = 0; x < v->width; x++) {
+        for (int y = 0; y < v->height; y++) {
+            for (int z = 0; z < v->depth; z++) {
+                const uint8_t b = v->blocks[(x * v->height + y) * v->depth + z];
+                if (b != SHAPE_COLOR_INDEX_AIR_BLOCK) {
+                    shape_add_block(s, b, x, y, z, false);
+                }
+            }
+        }
+    }
+
+    SHAPE_COORDS_INT3_T min, max;
+    shape_get_model_aabb_2(s, &min, &max);
+    if (min.x != 0 || min.y != 0 || min.z != 0 || max.x != v->width || max.y != v->height ||
+        max.z != v->depth) {
+        shape_free(s);
+        return NULL;
+    }
+
+    shape_compute_baked_lighting(s);
+    VERTEX_LIGHT_STRUCT_T *blob = shape_create_lighting_data_blob(s, NULL);
+    shape_free(s);
+    return blob;
+}
+
+int main(int argc, const char *argv[]) {
+    const int write = argc > 1 && strcmp(argv[1], "-w") == 0;
+    chunk_alloc_default_light();
+    ColorAtlas *atlas = color_atlas_new();
+
+    Vector *v = malloc(sizeof(Vector));
+    int n, failures = 0;
+    while ((n = read_vector(v)) == 1) {
+        VERTEX_LIGHT_STRUCT_T *blob = compute(v, atlas);
+        if (blob == NULL) {
+            fprintf(stderr, "%s: blocks don't fill the shape size\n", v->name);
+            return 1;
+        }
+        const int count = v->width * v->height * v->depth;
+
+        if (write) {
+            printf("shape %s\nsize %d %d %d\n", v->name, v->width, v->height, v->depth);
+            for (int i = 0; i < v->colorCount; i++) {
+                const RGBAColor c = v->colors[i];
+                printf("color %02x%02x%02x%02x %d\n", c.r, c.g, c.b, c.a, v->emissive[i]);
+            }
+            printf("blocks\n");
+            print_hex(v->blocks, v->width * v->height, v->depth);
+            printf("lighting\n");
+            print_hex((uint8_t *)blob, v->width * v->height, v->depth * 2);
+            printf("end\n\n");
+        } else if (v->hasLighting == 0 || memcmp(blob, v->lighting, (size_t)count * 2) != 0) {
+            fprintf(stderr, "%s: lighting differs\n", v->name);
+            failures++;
+        } else {
+            fprintf(stderr, "%s: ok\n", v->name);
+        }
+        free(blob);
+    }
+    free(v);
+
+    if (n < 0) {
+        fprintf(stderr, "can't read vectors\n");
+        return 1;
+    }
+    return failures > 0 ? 1 : 0;
+}
@@ -0,0 +1,238 @@
+// Package optimize makes .3zh files smaller, without changing how the
+// engine displays them: empty borders of shapes are removed, palettes
+// lose unused and duplicate colors, previews are rendered again.
+//
+// Chunks are recompressed when encoding, see shapefile.EncodeOptions.
+package optimize
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/lighting"
+	"cu.bzh/tools/shapefile/render"
+)
+
+// Options select optimizations.
+type Options struct {
+	// Trim removes empty borders of shapes
+	Trim bool
+	// Palettes removes unused and duplicate colors from palettes used by shapes
+	Palettes bool
+	// Previews renders preview images again, at their current size
+	Previews bool
+}
+
+// DefaultOptions returns options with all optimizations.
+func DefaultOptions() Options {
+	return Options{Trim: true, Palettes: true, Previews: true}
+}
+
+// Report lists what Optimize changed.
+type Report struct {
+	TrimmedShapes int
+	RemovedColors int
+	Preview       bool
+}
+
+// Changed returns true if the file has been modified.
+func (r *Report) Changed() bool {
+	return r.TrimmedShapes > 0 || r.RemovedColors > 0 || r.Preview
+}
+
+// Optimize modifies a file to make it smaller.
+func Optimize(f *shapefile.File, opts Options) (*Report, error) {
+	r := &Report{}
+
+	if opts.Trim {
+		for _, s := range f.Shapes() {
+			if trim(f, s) {
+				r.TrimmedShapes++
+			}
+		}
+	}
+	if opts.Palettes {
+		r.RemovedColors = compactPalettes(f)
+	}
+	if opts.Previews && f.Preview() != nil {
+		preview, err := renderPreview(f)
+		if err != nil {
+			return nil, err
+		}
+		if preview != nil && !bytes.Equal(preview, f.Preview()) {
+			f.SetPreview(preview)
+			r.Preview = true
+		}
+	}
+
+	return r, nil
+}
+
+// trim removes empty borders of a shape, returning false if there are none.
+// Its pivot, points and collision box are moved with its blocks, its baked
+// lighting is computed again. Shapes without blocks are kept as they are.
+func trim(f *shapefile.File, s *shapefile.Shape) bool {
+	var from, to [3]int
+	found := false
+	for x := 0; x < int(s.Width); x++ {
+		for y := 0; y < int(s.Height); y++ {
+			for z := 0; z < int(s.Depth); z++ {
+				if s.Block(x, y, z) == shapefile.AirBlock {
+					continue
+				}
+				p := [3]int{x, y, z}
+				if !found {
+					from, to, found = p, p, true
+					continue
+				}
+				for i := range p {
+					from[i] = min(from[i], p[i])
+					to[i] = max(to[i], p[i])
+				}
+			}
+		}
+	}
+	if !found || (from == [3]int{} && to == [3]int{int(s.Width) - 1, int(s.Height) - 1, int(s.Depth) - 1}) {
+		return false
+	}
+
+	trimmed := shapefile.NewShape(uint16(to[0]-from[0]+1), uint16(to[1]-from[1]+1), uint16(to[2]-from[2]+1))
+	for x := from[0]; x <= to[0]; x++ {
+		for y := from[1]; y <= to[1]; y++ {
+			for z := from[2]; z <= to[2]; z++ {
+				trimmed.SetBlock(x-from[0], y-from[1], z-from[2], s.Block(x, y, z))
+			}
+		}
+	}
+	s.Width, s.Height, s.Depth, s.Blocks = trimmed.Width, trimmed.Height, trimmed.Depth, trimmed.Blocks
+
+	// the engine places blocks relative to the pivot, children relative
+	// to the shape's transform: they don't move. Without pivot, the engine
+	// uses the center of blocks, it stays where it was.
+	offset := shapefile.Vec3{X: float32(from[0]), Y: float32(from[1]), Z: float32(from[2])}
+	if s.Pivot != nil {
+		pivot := sub(*s.Pivot, offset)
+		s.Pivot = &pivot
+	}
+	for i := range s.Points {
+		s.Points[i].Value = sub(s.Points[i].Value, offset)
+	}
+	// collision boxes are relative to blocks too (the engine doesn't move
+	// them when it removes empty space while saving, it should)
+	if s.CollisionBox != nil {
+		s.CollisionBox = &shapefile.Box{Min: sub(s.CollisionBox.Min, offset), Max: sub(s.CollisionBox.Max, offset)}
+	}
+	// lighting depends on the position of blocks in chunks
+	if s.BakedLighting != nil {
+		s.BakedLighting = lighting.Compute(s, f.ShapePalette(s))
+	}
+	return true
+}
+
+func sub(v, o shapefile.Vec3) shapefile.Vec3 {
+	return shapefile.Vec3{X: v.X - o.X, Y: v.Y - o.Y, Z: v.Z - o.Z}
+}
+
+// colorKey identifies a palette entry, duplicates having the same key.
+type colorKey struct {
+	color    shapefile.Color
+	emissive bool
+}
+
+// compactPalettes removes unused and duplicate colors from palettes used
+// by shapes, updating their blocks, and returns the number of removed colors.
+// Palettes with blocks out of them, or without blocks, are kept as they are.
+func compactPalettes(f *shapefile.File) int {
+	palettes := make([]*shapefile.Palette, 0)
+	shapes := make(map[*shapefile.Palette][]*shapefile.Shape)
+	for _, s := range f.Shapes() {
+		p := f.ShapePalette(s)
+		if p == nil {
+			continue
+		}
+		if _, ok := shapes[p]; !ok {
+			palettes = append(palettes, p)
+		}
+		shapes[p] = append(shapes[p], s)
+	}
+
+	removed := 0
+	for _, p := range palettes {
+		removed += compactPalette(p, shapes[p])
+	}
+	return removed
+}
+
+func compactPalette(p *shapefile.Palette, shapes []*shapefile.Shape) int {
+	used := make([]bool, len(p.Colors))
+	blocks := 0
+	for _, s := range shapes {
+		for _, b := range s.Blocks {
+			if b == shapefile.AirBlock {
+				continue
+			}
+			if int(b) >= len(p.Colors) {
+				return 0
+			}
+			used[b] = true
+			blocks++
+		}
+	}
+	if blocks == 0 {
+		return 0
+	}
+
+	// colors keep their order, duplicates use the first one
+	mapping := make([]uint8, len(p.Colors))
+	indexes := make(map[colorKey]uint8)
+	colors := make([]shapefile.Color, 0)
+	emissive := make([]bool, 0)
+	for i, c := range p.Colors {
+		if !used[i] {
+			continue
+		}
+		key := colorKey{color: c, emissive: i < len(p.Emissive) && p.Emissive[i]}
+		index, ok := indexes[key]
+		if !ok {
+			index = uint8(len(colors))
+			indexes[key] = index
+			colors = append(colors, key.color)
+			emissive = append(emissive, key.emissive)
+		}
+		mapping[i] = index
+	}
+
+	removed := len(p.Colors) - len(colors)
+	if removed == 0 {
+		return 0
+	}
+	for _, s := range shapes {
+		for i, b := range s.Blocks {
+			if b != shapefile.AirBlock {
+				s.Blocks[i] = mapping[b]
+			}
+		}
+	}
+	p.Colors, p.Emissive = colors, emissive
+	return removed
+}
+
+// renderPreview renders the file at the size of its preview,
+// returning nil if there's nothing to render.
+func renderPreview(f *shapefile.File) ([]byte, error) {
+	opts := render.DefaultOptions()
+	if config, err := png.DecodeConfig(bytes.NewReader(f.Preview())); err == nil && config.Width > 0 && config.Height > 0 {
+		opts.Width, opts.Height = config.Width, config.Height
+	}
+
+	var b bytes.Buffer
+	err := render.EncodePNG(&b, f, opts)
+	if errors.Is(err, render.ErrNoShape) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+package optimize
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/lighting"
+	"cu.bzh/tools/shapefile/render"
+)
+
+var (
+	red   = shapefile.Color{R: 255, A: 255}
+	green = shapefile.Color{G: 255, A: 255}
+	blue  = shapefile.Color{B: 255, A: 255}
+)
+
+// table returns a file with a table, in a shape larger than its blocks,
+// and a lamp on it.
+func table() *shapefile.File {
+	s := shapefile.NewShape(6, 5, 6)
+	s.ID = 1
+	s.Palette = &shapefile.Palette{
+		Colors:   []shapefile.Color{red, blue, red, green, red},
+		Emissive: []bool{false, false, false, false, true},
+	}
+	for x := 1; x <= 3; x++ {
+		for z := 2; z <= 3; z++ {
+			s.SetBlock(x, 2, z, 2)
+		}
+	}
+	s.SetBlock(1, 1, 2, 0)
+	s.SetBlock(3, 1, 3, 0)
+	s.Pivot = &shapefile.Vec3{X: 2.5, Y: 1, Z: 3}
+	s.CollisionBox = &shapefile.Box{Min: shapefile.Vec3{X: 1, Y: 1, Z: 2}, Max: shapefile.Vec3{X: 4, Y: 3, Z: 4}}
+	s.Points = []shapefile.Point{{Name: "top", Value: shapefile.Vec3{X: 2.5, Y: 3, Z: 3}}}
+	s.PointRotations = []shapefile.Point{{Name: "top", Value: shapefile.Vec3{Y: 1}}}
+
+	lamp := shapefile.NewShape(3, 3, 3)
+	lamp.ID = 2
+	lamp.ParentID = 1
+	lamp.SetBlock(1, 1, 1, 4)
+	lamp.Transform = &shapefile.Transform{Position: shapefile.Vec3{Y: 2}, Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+
+	f := &shapefile.File{Chunks: []shapefile.Chunk{s, lamp}}
+	lighting.Bake(f)
+	return f
+}
+
+func TestTrim(t *testing.T) {
+	f := table()
+	r, err := Optimize(f, Options{Trim: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TrimmedShapes != 2 || r.RemovedColors != 0 || r.Preview {
+		t.Errorf("report %+v", r)
+	}
+
+	s, lamp := f.Shapes()[0], f.Shapes()[1]
+	if s.Width != 3 || s.Height != 2 || s.Depth != 2 || s.BlockCount() != 8 || s.Block(0, 0, 0) != 0 || s.Block(2, 1, 1) != 2 {
+		t.Errorf("table %dx%dx%d, %d blocks", s.Width, s.Height, s.Depth, s.BlockCount())
+	}
+	if *s.Pivot != (shapefile.Vec3{X: 1.5, Y: 0, Z: 1}) {
+		t.Errorf("pivot %v", *s.Pivot)
+	}
+	if s.Points[0].Value != (shapefile.Vec3{X: 1.5, Y: 2, Z: 1}) || s.PointRotations[0].Value != (shapefile.Vec3{Y: 1}) {
+		t.Errorf("points %v, rotations %v", s.Points, s.PointRotations)
+	}
+	if s.CollisionBox.Min != (shapefile.Vec3{}) || s.CollisionBox.Max != (shapefile.Vec3{X: 3, Y: 2, Z: 2}) {
+		t.Errorf("collision box %v", *s.CollisionBox)
+	}
+	if invalid := lighting.Check(f); len(invalid) != 0 {
+		t.Errorf("%d shapes with outdated baked lighting", len(invalid))
+	}
+
+	// without pivot, the center of blocks stays the pivot
+	if lamp.Width != 1 || lamp.Pivot != nil || *lamp.Transform != *table().Shapes()[1].Transform {
+		t.Errorf("lamp %dx%dx%d, pivot %v, transform %v", lamp.Width, lamp.Height, lamp.Depth, lamp.Pivot, lamp.Transform)
+	}
+
+	// the table didn't move, its pivot being set
+	f.Chunks = f.Chunks[:1]
+	original := table()
+	original.Chunks = original.Chunks[:1]
+	if !bytes.Equal(renderPNG(t, f), renderPNG(t, original)) {
+		t.Errorf("trimmed table rendered differently")
+	}
+
+	// shapes without empty borders, or without blocks, are kept
+	empty := shapefile.NewShape(2, 2, 2)
+	f = &shapefile.File{Chunks: []shapefile.Chunk{s, empty}}
+	if r, _ := Optimize(f, Options{Trim: true}); r.Changed() || empty.Width != 2 {
+		t.Errorf("report %+v, empty shape %dx%dx%d", r, empty.Width, empty.Height, empty.Depth)
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	f := table()
+	// a shape with its own palette
+	own := shapefile.NewShape(1, 1, 2)
+	own.ID = 3
+	own.ParentID = 1
+	own.Palette = &shapefile.Palette{Colors: []shapefile.Color{green, green}, Emissive: []bool{false, false}}
+	own.SetBlock(0, 0, 0, 1)
+	own.SetBlock(0, 0, 1, 0)
+	f.Chunks = append(f.Chunks, own)
+
+	r, err := Optimize(f, Options{Palettes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unused blue and green, duplicate red, duplicate green
+	if r.RemovedColors != 4 || r.TrimmedShapes != 0 {
+		t.Errorf("report %+v", r)
+	}
+
+	s, lamp := f.Shapes()[0], f.Shapes()[1]
+	p := s.Palette
+	if len(p.Colors) != 2 || p.Colors[0] != red || p.Colors[1] != red || p.Emissive[0] || !p.Emissive[1] {
+		t.Errorf("palette %+v", p)
+	}
+	if s.Block(1, 1, 2) != 0 || s.Block(1, 2, 2) != 0 || lamp.Block(1, 1, 1) != 1 {
+		t.Errorf("blocks not remapped")
+	}
+	if len(own.Palette.Colors) != 1 || own.Block(0, 0, 0) != 0 || own.Block(0, 0, 1) != 0 {
+		t.Errorf("own palette %+v", own.Palette)
+	}
+
+	// blocks out of the palette: it's kept as is
+	own.SetBlock(0, 0, 0, 5)
+	own.Palette.Colors = append(own.Palette.Colors, blue)
+	if r, _ := Optimize(f, Options{Palettes: true}); r.Changed() || len(own.Palette.Colors) != 2 {
+		t.Errorf("report %+v, palette %+v", r, own.Palette)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	f := table()
+	if r, _ := Optimize(f, Options{Previews: true}); r.Changed() || f.Preview() != nil {
+		t.Errorf("preview added")
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, 48, 32))); err != nil {
+		t.Fatal(err)
+	}
+	f.SetPreview(b.Bytes())
+	r, err := Optimize(f, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Preview {
+		t.Fatalf("preview not rendered")
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(f.Preview()))
+	if err != nil || config.Width != 48 || config.Height != 32 {
+		t.Errorf("preview %+v, %v", config, err)
+	}
+}
+
+func renderPNG(t *testing.T, f *shapefile.File) []byte {
+	t.Helper()
+	opts := render.DefaultOptions()
+	opts.Width, opts.Height = 32, 32
+	var b bytes.Buffer
+	if err := render.EncodePNG(&b, f, opts); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
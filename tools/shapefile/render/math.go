@@ -0,0 +1,114 @@
+package render
+
+import (
+	"math"
+
+	"cu.bzh/tools/shapefile"
+)
+
+type vec3 [3]float64
+
+func newVec3(v shapefile.Vec3) vec3 {
+	return vec3{float64(v.X), float64(v.Y), float64(v.Z)}
+}
+
+func (a vec3) add(b vec3) vec3 {
+	return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func (a vec3) sub(b vec3) vec3 {
+	return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func (a vec3) dot(b vec3) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func (a vec3) normalize() vec3 {
+	l := math.Sqrt(a.dot(a))
+	if l == 0 {
+		return a
+	}
+	return a.scale(1 / l)
+}
+
+// mat3 is a 3x3 matrix, indexed by row then column.
+type mat3 [3][3]float64
+
+var identity = mat3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m mat3) apply(v vec3) vec3 {
+	return vec3{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// normalMatrix returns the inverse transpose of m, transforming normals.
+// Degenerate matrices (scale 0) give a zero matrix.
+func (m mat3) normalMatrix() mat3 {
+	var cofactors mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			i1, i2 := (i+1)%3, (i+2)%3
+			j1, j2 := (j+1)%3, (j+2)%3
+			cofactors[i][j] = m[i1][j1]*m[i2][j2] - m[i1][j2]*m[i2][j1]
+		}
+	}
+	det := m[0][0]*cofactors[0][0] + m[0][1]*cofactors[0][1] + m[0][2]*cofactors[0][2]
+	if det == 0 {
+		return mat3{}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			cofactors[i][j] /= det
+		}
+	}
+	return cofactors
+}
+
+// affine is a linear transformation followed by a translation.
+type affine struct {
+	linear      mat3
+	translation vec3
+}
+
+func (a affine) apply(v vec3) vec3 {
+	return a.linear.apply(v).add(a.translation)
+}
+
+func (a affine) mul(b affine) affine {
+	return affine{linear: a.linear.mul(b.linear), translation: a.apply(b.translation)}
+}
+
+// localTransform returns the transform of a shape relative to its parent.
+func localTransform(t *shapefile.Transform) affine {
+	if t == nil {
+		return affine{linear: identity}
+	}
+	return affine{
+		linear:      mat3(t.Matrix()),
+		translation: newVec3(t.Position),
+	}
+}
@@ -0,0 +1,168 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// canvas is a float buffer, storing premultiplied colors and depth.
+type canvas struct {
+	width  int
+	height int
+	// 4 values per pixel: r, g, b, a
+	colors []float64
+	depth  []float64
+}
+
+func newCanvas(width, height int, background color.Color) *canvas {
+	c := &canvas{
+		width:  width,
+		height: height,
+		colors: make([]float64, 4*width*height),
+		depth:  make([]float64, width*height),
+	}
+	var bg [4]float64
+	if background != nil {
+		r, g, b, a := background.RGBA()
+		bg = [4]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff, float64(a) / 0xffff}
+	}
+	for i := range c.depth {
+		c.depth[i] = math.Inf(1)
+		copy(c.colors[4*i:4*i+4], bg[:])
+	}
+	return c
+}
+
+// drawQuad draws a face, projected to screen space (x, y, depth).
+// Opaque faces replace colors and write depth,
+// transparent faces are blended over what's in front of them.
+func (c *canvas) drawQuad(project func(vec3) vec3, fc *face, opaque bool) {
+
+	var p [4]vec3
+	for i, corner := range fc.corners {
+		p[i] = project(corner)
+	}
+
+	// faces seen from the side have no area
+	area := 0.0
+	for i := 0; i < 4; i++ {
+		a, b := p[i], p[(i+1)%4]
+		area += a[0]*b[1] - b[0]*a[1]
+	}
+	if math.Abs(area) < 1e-9 {
+		return
+	}
+	if area < 0 {
+		p[1], p[3] = p[3], p[1]
+	}
+
+	// depth is linear in screen space (orthographic projection),
+	// found from the plane going through the first 3 corners
+	e1, e2 := p[1].sub(p[0]), p[2].sub(p[0])
+	det := e1[0]*e2[1] - e1[1]*e2[0]
+	if math.Abs(det) < 1e-12 {
+		// first corners are aligned, the last 3 can't be
+		e1, e2 = p[2].sub(p[0]), p[3].sub(p[0])
+		det = e1[0]*e2[1] - e1[1]*e2[0]
+	}
+	depthX := (e1[2]*e2[1] - e2[2]*e1[1]) / det
+	depthY := (e2[2]*e1[0] - e1[2]*e2[0]) / det
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, v := range p {
+		minX, maxX = math.Min(minX, v[0]), math.Max(maxX, v[0])
+		minY, maxY = math.Min(minY, v[1]), math.Max(maxY, v[1])
+	}
+	x0, x1 := clamp(int(math.Floor(minX)), 0, c.width), clamp(int(math.Ceil(maxX)), 0, c.width)
+	y0, y1 := clamp(int(math.Floor(minY)), 0, c.height), clamp(int(math.Ceil(maxY)), 0, c.height)
+
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			if !inside(p, px, py) {
+				continue
+			}
+
+			z := p[0][2] + (px-p[0][0])*depthX + (py-p[0][1])*depthY
+			i := y*c.width + x
+			if z >= c.depth[i] {
+				continue
+			}
+
+			pixel := c.colors[4*i : 4*i+4]
+			if opaque {
+				c.depth[i] = z
+				copy(pixel, fc.color[:])
+				continue
+			}
+			for k := 0; k < 4; k++ {
+				pixel[k] = fc.color[k] + pixel[k]*(1-fc.color[3])
+			}
+		}
+	}
+}
+
+// inside returns true if (x, y) is inside a convex polygon with positive area.
+// Points on edges only belong to one of the polygons sharing that edge,
+// so that transparent faces aren't blended twice.
+func inside(p [4]vec3, x, y float64) bool {
+	for i := 0; i < 4; i++ {
+		a, b := p[i], p[(i+1)%4]
+		dx, dy := b[0]-a[0], b[1]-a[1]
+		e := dx*(y-a[1]) - dy*(x-a[0])
+		if e < 0 || (e == 0 && (dy < 0 || (dy == 0 && dx < 0))) {
+			return false
+		}
+	}
+	return true
+}
+
+// image averages samples, returning an image supersampling times smaller.
+func (c *canvas) image(supersampling int) *image.NRGBA {
+
+	width, height := c.width/supersampling, c.height/supersampling
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	samples := float64(supersampling * supersampling)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for sy := 0; sy < supersampling; sy++ {
+				for sx := 0; sx < supersampling; sx++ {
+					i := 4 * ((y*supersampling+sy)*c.width + x*supersampling + sx)
+					for k := 0; k < 4; k++ {
+						sum[k] += c.colors[i+k]
+					}
+				}
+			}
+			a := sum[3] / samples
+			if a <= 0 {
+				continue
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: toByte(sum[0] / samples / a),
+				G: toByte(sum[1] / samples / a),
+				B: toByte(sum[2] / samples / a),
+				A: toByte(a),
+			})
+		}
+	}
+
+	return img
+}
+
+func toByte(v float64) uint8 {
+	return uint8(clamp(int(math.Round(v*255)), 0, 255))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,346 @@
+// Package render draws .3zh shapes to images, on the CPU.
+//
+// Shapes are drawn with their whole hierarchy (transforms and pivots),
+// with an orthographic camera orbiting around them. It doesn't need
+// a GPU or a display, previews can be generated on any machine.
+package render
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// View is the orientation of the camera, in degrees.
+// With Yaw and Pitch at 0, the camera looks towards +Z, like the engine's
+// default camera. Yaw turns it around the Y axis, positive Pitch looks down.
+type View struct {
+	Yaw   float64
+	Pitch float64
+}
+
+var (
+	// Isometric looks at shapes from the top left front corner.
+	Isometric = View{Yaw: 45, Pitch: 35.26439}
+	Front     = View{Yaw: 0, Pitch: 0}
+	Back      = View{Yaw: 180, Pitch: 0}
+	Left      = View{Yaw: 90, Pitch: 0}
+	Right     = View{Yaw: -90, Pitch: 0}
+	Top       = View{Yaw: 0, Pitch: 90}
+
+	// Views are views that can be referenced by name.
+	Views = map[string]View{
+		"isometric": Isometric,
+		"front":     Front,
+		"back":      Back,
+		"left":      Left,
+		"right":     Right,
+		"top":       Top,
+	}
+)
+
+// Options configure rendering.
+type Options struct {
+	// image size, in pixels
+	Width  int
+	Height int
+	// empty space around shapes, in pixels
+	Padding int
+	View    View
+	// transparent if nil
+	Background color.Color
+	// use baked lighting, for shapes having some
+	BakedLighting bool
+	// each pixel is the average of Supersampling x Supersampling samples
+	Supersampling int
+}
+
+// DefaultOptions returns options to render isometric previews.
+func DefaultOptions() Options {
+	return Options{
+		Width:         256,
+		Height:        256,
+		Padding:       8,
+		View:          Isometric,
+		BakedLighting: true,
+		Supersampling: 2,
+	}
+}
+
+var (
+	ErrNoShape     = errors.New("no shape to render")
+	ErrInvalidSize = errors.New("invalid image size")
+)
+
+const (
+	// shading of faces not facing the light
+	ambientShading = 0.55
+	// baked lighting never makes faces darker than this
+	minimumBakedLight = 0.2
+	// light values are 4 bits
+	maxLightValue = 15.0
+)
+
+// lightDirection is the direction faces are shaded from, towards the light.
+var lightDirection = vec3{-0.4, 1, -0.7}.normalize()
+
+// face is a block face to draw.
+type face struct {
+	corners [4]vec3
+	// premultiplied color, alpha in [0, 1]
+	color [4]float64
+}
+
+// faceDirections are the normals of block faces,
+// with the offsets of their corners from the block origin.
+var faceDirections = []struct {
+	normal  [3]int
+	corners [4]vec3
+}{
+	{[3]int{1, 0, 0}, [4]vec3{{1, 0, 0}, {1, 1, 0}, {1, 1, 1}, {1, 0, 1}}},
+	{[3]int{-1, 0, 0}, [4]vec3{{0, 0, 0}, {0, 0, 1}, {0, 1, 1}, {0, 1, 0}}},
+	{[3]int{0, 1, 0}, [4]vec3{{0, 1, 0}, {0, 1, 1}, {1, 1, 1}, {1, 1, 0}}},
+	{[3]int{0, -1, 0}, [4]vec3{{0, 0, 0}, {1, 0, 0}, {1, 0, 1}, {0, 0, 1}}},
+	{[3]int{0, 0, 1}, [4]vec3{{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1}}},
+	{[3]int{0, 0, -1}, [4]vec3{{0, 0, 0}, {0, 1, 0}, {1, 1, 0}, {1, 0, 0}}},
+}
+
+// Render draws all shapes of f. The root shape is drawn at the origin,
+// its own transform only places the object in the world.
+func Render(f *shapefile.File, opts Options) (*image.NRGBA, error) {
+
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return nil, ErrInvalidSize
+	}
+	if opts.Supersampling < 1 {
+		opts.Supersampling = 1
+	}
+
+	root := f.Root()
+	if root == nil {
+		return nil, ErrNoShape
+	}
+
+	yaw := opts.View.Yaw * math.Pi / 180
+	pitch := opts.View.Pitch * math.Pi / 180
+	forward := vec3{math.Sin(yaw) * math.Cos(pitch), -math.Sin(pitch), math.Cos(yaw) * math.Cos(pitch)}
+	right := vec3{math.Cos(yaw), 0, -math.Sin(yaw)}
+	up := forward.cross(right)
+
+	faces := make([]*face, 0)
+	visited := make(map[*shapefile.Shape]bool)
+	var collect func(s *shapefile.Shape, parent affine)
+	collect = func(s *shapefile.Shape, parent affine) {
+		// corrupted files could have cycles
+		if visited[s] {
+			return
+		}
+		visited[s] = true
+
+		world := parent
+		if s != root {
+			world = parent.mul(localTransform(s.Transform))
+		}
+		if !s.Hidden {
+			faces = append(faces, shapeFaces(f, root, s, world, forward, opts.BakedLighting)...)
+		}
+		for _, child := range f.Children(s) {
+			collect(child, world)
+		}
+	}
+	collect(root, affine{linear: identity})
+
+	ss := opts.Supersampling
+	c := newCanvas(opts.Width*ss, opts.Height*ss, opts.Background)
+
+	if len(faces) > 0 {
+		// fit all faces in the image
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, fc := range faces {
+			for _, p := range fc.corners {
+				x, y := p.dot(right), p.dot(up)
+				minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+				minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+			}
+		}
+
+		padding := float64(opts.Padding * ss)
+		availableWidth := math.Max(1, float64(c.width)-2*padding)
+		availableHeight := math.Max(1, float64(c.height)-2*padding)
+		scale := math.Min(availableWidth/math.Max(maxX-minX, 1e-6), availableHeight/math.Max(maxY-minY, 1e-6))
+		centerX, centerY := (minX+maxX)/2, (minY+maxY)/2
+
+		project := func(p vec3) vec3 {
+			return vec3{
+				float64(c.width)/2 + (p.dot(right)-centerX)*scale,
+				float64(c.height)/2 - (p.dot(up)-centerY)*scale,
+				p.dot(forward),
+			}
+		}
+
+		// opaque faces first, then transparent ones from back to front
+		transparent := make([]*face, 0)
+		for _, fc := range faces {
+			if fc.color[3] < 1 {
+				transparent = append(transparent, fc)
+				continue
+			}
+			c.drawQuad(project, fc, true)
+		}
+
+		sort.SliceStable(transparent, func(i, j int) bool {
+			return faceDepth(transparent[i], forward) > faceDepth(transparent[j], forward)
+		})
+		for _, fc := range transparent {
+			c.drawQuad(project, fc, false)
+		}
+	}
+
+	return c.image(ss), nil
+}
+
+// EncodePNG renders f and writes a PNG image to w.
+func EncodePNG(w io.Writer, f *shapefile.File, opts Options) error {
+	img, err := Render(f, opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+func faceDepth(fc *face, forward vec3) float64 {
+	depth := 0.0
+	for _, p := range fc.corners {
+		depth += p.dot(forward)
+	}
+	return depth / 4
+}
+
+// shapePalette returns the palette used by s. Like the engine does,
+// shapes without palette use the root shape's one, then the file's one.
+func shapePalette(f *shapefile.File, root, s *shapefile.Shape) *shapefile.Palette {
+	if s.Palette != nil {
+		return s.Palette
+	}
+	if root.Palette != nil {
+		return root.Palette
+	}
+	return f.Palette()
+}
+
+// shapeFaces returns the visible faces of a shape, in world space.
+func shapeFaces(f *shapefile.File, root, s *shapefile.Shape, world affine, forward vec3, bakedLighting bool) []*face {
+
+	palette := shapePalette(f, root, s)
+	normals := world.linear.normalMatrix()
+
+	// blocks are placed relative to the pivot, at the center by default
+	pivot := vec3{float64(s.Width) / 2, float64(s.Height) / 2, float64(s.Depth) / 2}
+	if s.Pivot != nil {
+		pivot = newVec3(*s.Pivot)
+	}
+
+	lighting := bakedLighting && len(s.BakedLighting) == 2*len(s.Blocks)
+
+	faces := make([]*face, 0)
+
+	for x := 0; x < int(s.Width); x++ {
+		for y := 0; y < int(s.Height); y++ {
+			for z := 0; z < int(s.Depth); z++ {
+
+				index := s.Block(x, y, z)
+				if index == shapefile.AirBlock {
+					continue
+				}
+
+				color, emissive := paletteColor(palette, index)
+
+				for _, dir := range faceDirections {
+
+					nx, ny, nz := x+dir.normal[0], y+dir.normal[1], z+dir.normal[2]
+					if hidesFace(palette, index, s.Block(nx, ny, nz)) {
+						continue
+					}
+
+					normal := normals.apply(vec3{float64(dir.normal[0]), float64(dir.normal[1]), float64(dir.normal[2])}).normalize()
+					// back faces of opaque blocks can't be seen
+					if color[3] == 1 && normal.dot(forward) >= 0 {
+						continue
+					}
+
+					fc := &face{color: color}
+					for i, corner := range dir.corners {
+						fc.corners[i] = world.apply(vec3{float64(x), float64(y), float64(z)}.add(corner).sub(pivot))
+					}
+
+					if !emissive {
+						shading := ambientShading + (1-ambientShading)*math.Max(0, normal.dot(lightDirection))
+						light := [3]float64{shading, shading, shading}
+						if lighting {
+							light = applyBakedLight(s, nx, ny, nz, light)
+						}
+						for i := 0; i < 3; i++ {
+							fc.color[i] *= light[i]
+						}
+					}
+
+					faces = append(faces, fc)
+				}
+			}
+		}
+	}
+
+	return faces
+}
+
+// hidesFace returns true if a block hides the face of its neighbor:
+// opaque blocks do, transparent blocks only hide faces of the same color.
+func hidesFace(palette *shapefile.Palette, index, neighbor uint8) bool {
+	if neighbor == shapefile.AirBlock {
+		return false
+	}
+	neighborColor, _ := paletteColor(palette, neighbor)
+	return neighborColor[3] == 1 || neighbor == index
+}
+
+// paletteColor returns the premultiplied color of a palette entry,
+// and whether it's emissive. Missing colors are gray.
+func paletteColor(palette *shapefile.Palette, index uint8) ([4]float64, bool) {
+	c := shapefile.Color{R: 128, G: 128, B: 128, A: 255}
+	emissive := false
+	if palette != nil && int(index) < len(palette.Colors) {
+		c = palette.Colors[index]
+		emissive = int(index) < len(palette.Emissive) && palette.Emissive[index]
+	}
+	a := float64(c.A) / 255
+	return [4]float64{float64(c.R) / 255 * a, float64(c.G) / 255 * a, float64(c.B) / 255 * a, a}, emissive
+}
+
+// applyBakedLight multiplies light by the baked light of the block in front of a face.
+// Blocks store 4 bits for ambient (sun) light, and 4 bits for each red, green
+// and blue channels: ambient | red << 4, then green | blue << 4.
+// Faces at the border of the shape get full ambient light.
+func applyBakedLight(s *shapefile.Shape, x, y, z int, light [3]float64) [3]float64 {
+	if x < 0 || y < 0 || z < 0 || x >= int(s.Width) || y >= int(s.Height) || z >= int(s.Depth) {
+		return light
+	}
+	i := 2 * ((x*int(s.Height)+y)*int(s.Depth) + z)
+	ambient := float64(s.BakedLighting[i] & 0x0f)
+	channels := [3]float64{
+		float64(s.BakedLighting[i] >> 4),
+		float64(s.BakedLighting[i+1] & 0x0f),
+		float64(s.BakedLighting[i+1] >> 4),
+	}
+	for c := 0; c < 3; c++ {
+		value := math.Max(ambient, channels[c]) / maxLightValue
+		light[c] *= minimumBakedLight + (1-minimumBakedLight)*value
+	}
+	return light
+}
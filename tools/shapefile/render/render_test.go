@@ -0,0 +1,257 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+)
+
+var (
+	red   = shapefile.Color{R: 255, A: 255}
+	green = shapefile.Color{G: 255, A: 255}
+	glass = shapefile.Color{B: 255, A: 128}
+)
+
+// cube returns a file with a single shape, filled with the first palette color.
+func cube(size uint16, colors ...shapefile.Color) (*shapefile.File, *shapefile.Shape) {
+	s := shapefile.NewShape(size, size, size)
+	s.ID = 1
+	s.Palette = &shapefile.Palette{Colors: colors, Emissive: make([]bool, len(colors))}
+	for x := 0; x < int(size); x++ {
+		for y := 0; y < int(size); y++ {
+			for z := 0; z < int(size); z++ {
+				s.SetBlock(x, y, z, 0)
+			}
+		}
+	}
+	return &shapefile.File{Chunks: []shapefile.Chunk{s}}, s
+}
+
+// child returns a 1x1x1 shape, placed relative to its parent.
+func child(id, parentID uint16, index uint8, position shapefile.Vec3) *shapefile.Shape {
+	s := shapefile.NewShape(1, 1, 1)
+	s.ID = id
+	s.ParentID = parentID
+	s.SetBlock(0, 0, 0, index)
+	s.Transform = &shapefile.Transform{Position: position, Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	return s
+}
+
+func testOptions(view View) Options {
+	opts := DefaultOptions()
+	opts.Width, opts.Height = 32, 32
+	opts.View = view
+	return opts
+}
+
+func render(t *testing.T, f *shapefile.File, opts Options) *image.NRGBA {
+	t.Helper()
+	img, err := Render(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, opts.Width, opts.Height) {
+		t.Fatalf("image bounds %v", img.Bounds())
+	}
+	return img
+}
+
+func TestRenderCube(t *testing.T) {
+	f, _ := cube(2, red)
+	img := render(t, f, testOptions(Isometric))
+
+	if c := img.NRGBAAt(0, 0); c.A != 0 {
+		t.Errorf("corner %v, want transparent", c)
+	}
+	if c := img.NRGBAAt(16, 16); c.A != 255 || c.R == 0 || c.G != 0 || c.B != 0 {
+		t.Errorf("center %v, want red", c)
+	}
+
+	// the top face is lit, the left face is darker
+	top, left := img.NRGBAAt(16, 12), img.NRGBAAt(12, 20)
+	if top.A != 255 || left.A != 255 || top.R <= left.R {
+		t.Errorf("top %v, left %v", top, left)
+	}
+}
+
+func TestRenderViews(t *testing.T) {
+	f, _ := cube(1, red)
+	for name, view := range Views {
+		if c := render(t, f, testOptions(view)).NRGBAAt(16, 16); c.A != 255 || c.R == 0 {
+			t.Errorf("%s: center %v", name, c)
+		}
+	}
+
+	// seen from the front, the cube fills the image but its padding
+	opts := testOptions(Front)
+	img := render(t, f, opts)
+	if img.NRGBAAt(opts.Padding, opts.Padding).A != 255 || img.NRGBAAt(opts.Padding-1, opts.Padding).A != 0 {
+		t.Errorf("cube doesn't fit the image")
+	}
+}
+
+func TestRenderBackground(t *testing.T) {
+	f, _ := cube(1, red)
+	opts := testOptions(Isometric)
+	opts.Background = color.White
+	if c := render(t, f, opts).NRGBAAt(0, 0); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("background %v", c)
+	}
+}
+
+func TestRenderEmissive(t *testing.T) {
+	f, s := cube(1, red)
+	opts := testOptions(Front)
+
+	// front faces aren't facing the light
+	shaded := render(t, f, opts).NRGBAAt(16, 16)
+	s.Palette.Emissive[0] = true
+	emissive := render(t, f, opts).NRGBAAt(16, 16)
+
+	if shaded.R == 255 || emissive.R != 255 {
+		t.Errorf("shaded %v, emissive %v", shaded, emissive)
+	}
+}
+
+func TestRenderTransparency(t *testing.T) {
+	f, _ := cube(1, glass)
+	opts := testOptions(Front)
+	opts.Background = color.White
+
+	// back and front faces, blended over white
+	c := render(t, f, opts).NRGBAAt(16, 16)
+	if c.A != 255 || c.R == 0 || c.R == 255 || c.B <= c.R {
+		t.Errorf("transparent cube %v", c)
+	}
+}
+
+func TestRenderHierarchy(t *testing.T) {
+	f, root := cube(1, red, green)
+	f.Chunks = append(f.Chunks, child(2, 1, 1, shapefile.Vec3{X: 2}))
+
+	opts := testOptions(Front)
+	opts.Padding = 0
+
+	// the child is at the right of its parent, using its palette,
+	// with a block of space between them
+	img := render(t, f, opts)
+	left, middle, right := img.NRGBAAt(4, 16), img.NRGBAAt(16, 16), img.NRGBAAt(28, 16)
+	if left.R == 0 || left.G != 0 || middle.A != 0 || right.G == 0 || right.R != 0 {
+		t.Errorf("left %v, middle %v, right %v", left, middle, right)
+	}
+
+	// hidden shapes are not drawn, but their children are
+	root.Hidden = true
+	img = render(t, f, opts)
+	if c := img.NRGBAAt(16, 16); c.G == 0 {
+		t.Errorf("center %v, want child", c)
+	}
+}
+
+func TestRenderTransforms(t *testing.T) {
+	f, _ := cube(1, red)
+	c := child(2, 1, 0, shapefile.Vec3{X: 2})
+	f.Chunks = append(f.Chunks, c, child(3, 2, 0, shapefile.Vec3{X: 2}))
+
+	opts := testOptions(Front)
+	opts.Padding = 0
+
+	// rotating the child by 90° around Z moves its own child above it:
+	// . x
+	// . .
+	// x x
+	c.Transform.Rotation.Z = math.Pi / 2
+	img := render(t, f, opts)
+	if img.NRGBAAt(4, 28).A != 255 || img.NRGBAAt(28, 28).A != 255 || img.NRGBAAt(28, 4).A != 255 || img.NRGBAAt(4, 4).A != 0 {
+		t.Errorf("child not rotated")
+	}
+
+	// scaling it by 2 also moves its child 2 times further
+	c.Transform.Scale = shapefile.Vec3{X: 2, Y: 2, Z: 2}
+	img = render(t, f, opts)
+	if img.NRGBAAt(20, 26).A != 255 || img.NRGBAAt(20, 16).A != 0 || img.NRGBAAt(20, 5).A != 255 {
+		t.Errorf("child not scaled")
+	}
+}
+
+func TestRenderPivot(t *testing.T) {
+	f, _ := cube(1, red)
+	c := child(2, 1, 0, shapefile.Vec3{X: 2})
+	f.Chunks = append(f.Chunks, c)
+
+	opts := testOptions(Front)
+	opts.Padding = 0
+	before := render(t, f, opts)
+
+	// moving the child's pivot to its corner moves its block
+	// by half a block, making both shapes closer
+	c.Pivot = &shapefile.Vec3{X: 1, Y: 0.5, Z: 0.5}
+	after := render(t, f, opts)
+
+	if before.NRGBAAt(20, 16).A != 0 || after.NRGBAAt(20, 16).A != 255 {
+		t.Errorf("pivot ignored")
+	}
+}
+
+func TestRenderBakedLighting(t *testing.T) {
+	// light is stored for the block above the top face
+	s := shapefile.NewShape(1, 2, 1)
+	s.ID = 1
+	s.Palette = &shapefile.Palette{Colors: []shapefile.Color{red}}
+	s.SetBlock(0, 0, 0, 0)
+	s.BakedLighting = []byte{0x00, 0x00, 0x00, 0x00}
+	f := &shapefile.File{Chunks: []shapefile.Chunk{s}}
+
+	opts := testOptions(Top)
+
+	dark := render(t, f, opts).NRGBAAt(16, 16)
+	s.BakedLighting[2] = 0x0f
+	lit := render(t, f, opts).NRGBAAt(16, 16)
+	opts.BakedLighting = false
+	ignored := render(t, f, opts).NRGBAAt(16, 16)
+
+	if dark.R >= lit.R || lit != ignored {
+		t.Errorf("dark %v, lit %v, without lighting %v", dark, lit, ignored)
+	}
+
+	// colored light
+	opts.BakedLighting = true
+	s.BakedLighting[2] = 0xf0
+	if c := render(t, f, opts).NRGBAAt(16, 16); c.R != lit.R {
+		t.Errorf("red light %v, want %v", c, lit)
+	}
+	s.BakedLighting[2], s.BakedLighting[3] = 0x00, 0xff
+	if c := render(t, f, opts).NRGBAAt(16, 16); c.R != dark.R {
+		t.Errorf("green and blue light %v, want %v", c, dark)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	if _, err := Render(&shapefile.File{}, DefaultOptions()); err != ErrNoShape {
+		t.Errorf("no shape: %v", err)
+	}
+	f, _ := cube(1, red)
+	if _, err := Render(f, Options{}); err != ErrInvalidSize {
+		t.Errorf("no size: %v", err)
+	}
+}
+
+func TestEncodePNG(t *testing.T) {
+	f, _ := cube(1, red)
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, f, testOptions(Isometric)); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 32, 32) {
+		t.Errorf("image bounds %v", img.Bounds())
+	}
+}
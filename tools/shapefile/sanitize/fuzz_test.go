@@ -0,0 +1,42 @@
+package sanitize
+
+import (
+	"bytes"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+// addSeeds adds shapes bundled with the app (when Git LFS objects are
+// pulled) and test files to the corpus.
+func addSeeds(f *testing.F) {
+	testutil.AddBundleSeeds(f)
+
+	file := testutil.Chest()
+	f.Add(testutil.Encode(f, file))
+	file.Root().Unknown = [][]byte{{10, 2, 0, 0, 0, 0, 2, 0, 0, 0, 'c', 'c'}}
+	file.Chunks = append(file.Chunks, &shapefile.UnknownChunk{ID: 20, Data: []byte("unknown")})
+	f.Add(testutil.Encode(f, file))
+	f.Add(bomb(f))
+}
+
+func FuzzSanitize(f *testing.F) {
+	addSeeds(f)
+	limits := DefaultLimits()
+	limits.MaxUncompressedSize = 4 << 20
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sanitized, _, err := Sanitize(data, limits)
+		if err != nil {
+			return
+		}
+		again, r, err := Sanitize(sanitized, limits)
+		if err != nil {
+			t.Fatalf("sanitized file: %v", err)
+		}
+		if r.Changed() || !bytes.Equal(again, sanitized) {
+			t.Fatalf("sanitized file changed, report %+v", r)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package sanitize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// ErrorResponse is the JSON body of responses to files that can't be sanitized.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Code identifies the error: file_too_large, too_large, too_many_chunks,
+	// too_many_shapes, shape_too_large, invalid_shape, invalid_chunk,
+	// invalid_magic, unsupported_version or invalid_file
+	Code string `json:"code"`
+	// Chunk, ChunkID and Offset name the chunk the error was found in
+	Chunk   *int   `json:"chunk,omitempty"`
+	ChunkID string `json:"chunk_id,omitempty"`
+	Offset  *int   `json:"offset,omitempty"`
+}
+
+// error codes, with their HTTP status
+var errorCodes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ErrFileTooLarge, "file_too_large", http.StatusRequestEntityTooLarge},
+	{shapefile.ErrTooLarge, "too_large", http.StatusRequestEntityTooLarge},
+	{ErrTooManyChunks, "too_many_chunks", http.StatusRequestEntityTooLarge},
+	{ErrTooManyShapes, "too_many_shapes", http.StatusRequestEntityTooLarge},
+	{ErrShapeTooLarge, "shape_too_large", http.StatusRequestEntityTooLarge},
+	{ErrInvalidShape, "invalid_shape", http.StatusUnprocessableEntity},
+	{shapefile.ErrInvalidChunk, "invalid_chunk", http.StatusUnprocessableEntity},
+	{shapefile.ErrInvalidMagic, "invalid_magic", http.StatusUnprocessableEntity},
+	{shapefile.ErrUnsupportedVersion, "unsupported_version", http.StatusUnprocessableEntity},
+}
+
+// Handler returns an HTTP handler sanitizing .3zh files: POST requests
+// with a file as body get the sanitized file, or an ErrorResponse.
+func Handler(limits Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body := r.Body
+		if limits.MaxFileSize > 0 {
+			body = http.MaxBytesReader(w, r.Body, limits.MaxFileSize)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				err = fmt.Errorf("%w: max %d bytes", ErrFileTooLarge, limits.MaxFileSize)
+			}
+			writeError(w, err)
+			return
+		}
+
+		sanitized, report, err := Sanitize(data, limits)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Removed-Chunks", fmt.Sprint(report.UnknownChunks+report.InvalidPreviews))
+		w.Write(sanitized)
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	response := &ErrorResponse{Error: err.Error(), Code: "invalid_file"}
+	status := http.StatusUnprocessableEntity
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			response.Code, status = c.code, c.status
+			break
+		}
+	}
+
+	var chunkErr *shapefile.ChunkError
+	if errors.As(err, &chunkErr) {
+		response.Chunk, response.Offset = &chunkErr.Index, &chunkErr.Offset
+		if chunkErr.ID != 0 {
+			response.ChunkID = chunkErr.ID.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,223 @@
+// Package sanitize checks .3zh files uploaded by users, before they're
+// stored or loaded by the engine.
+//
+// Files are decoded within limits: sizes come from the files, a small
+// upload can announce or uncompress to gigabytes. Unknown chunks and
+// sub-chunks are removed, files are encoded again canonically (all chunks
+// compressed again), so that only what's been checked is kept.
+//
+// Handler serves it over HTTP.
+package sanitize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+
+	"cu.bzh/tools/shapefile"
+)
+
+var (
+	ErrFileTooLarge  = errors.New("file too large")
+	ErrTooManyChunks = errors.New("too many chunks")
+	ErrTooManyShapes = errors.New("too many shapes")
+	ErrShapeTooLarge = errors.New("shape too large")
+	ErrInvalidShape  = errors.New("invalid shape")
+)
+
+// Limits of sanitized files, 0 for no limit.
+type Limits struct {
+	// MaxFileSize is the size of the file, in bytes
+	MaxFileSize int64
+	// MaxUncompressedSize is the size of chunk data once uncompressed,
+	// for the whole file
+	MaxUncompressedSize int64
+	// MaxChunks is the number of chunks, removed ones included
+	MaxChunks int
+	// MaxShapes is the number of shapes (objects of the engine)
+	MaxShapes int
+	// MaxDimension is the width, height and depth of shapes
+	MaxDimension int
+	// MaxBlocks is the number of blocks of all shapes, air included
+	MaxBlocks int
+}
+
+// DefaultLimits returns limits for files uploaded by users.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileSize:         8 << 20,
+		MaxUncompressedSize: 64 << 20,
+		MaxChunks:           1024,
+		MaxShapes:           256,
+		MaxDimension:        1024,
+		MaxBlocks:           16 << 20,
+	}
+}
+
+// Report lists what Sanitize removed.
+type Report struct {
+	UnknownChunks    int
+	UnknownSubChunks int
+	// InvalidPreviews are previews that aren't PNG images
+	InvalidPreviews int
+	// BakedLighting is the number of shapes whose baked lighting
+	// doesn't match their size, the engine computes it again
+	BakedLighting int
+}
+
+// Changed returns true if something has been removed.
+func (r *Report) Changed() bool {
+	return r.UnknownChunks > 0 || r.UnknownSubChunks > 0 || r.InvalidPreviews > 0 || r.BakedLighting > 0
+}
+
+// location is where a chunk has been read.
+type location struct {
+	index  int
+	offset int
+}
+
+// Sanitize checks a .3zh file and returns it encoded again.
+//
+// Errors found in chunks are *shapefile.ChunkError, naming the chunk and
+// its offset, wrapping shapefile errors or errors of this package.
+func Sanitize(data []byte, limits Limits) ([]byte, *Report, error) {
+	if limits.MaxFileSize > 0 && int64(len(data)) > limits.MaxFileSize {
+		return nil, nil, fmt.Errorf("%w: %d bytes, max %d", ErrFileTooLarge, len(data), limits.MaxFileSize)
+	}
+
+	d := shapefile.NewDecoder(bytes.NewReader(data))
+	d.SetMaxSize(limits.MaxUncompressedSize)
+	if _, err := d.Header(); err != nil {
+		return nil, nil, err
+	}
+
+	r := &Report{}
+	f := &shapefile.File{Compression: shapefile.CompressionZip}
+	locations := make(map[shapefile.Chunk]location)
+	shapes, blocks := 0, 0
+
+	for index := 0; ; index++ {
+		offset := d.Offset()
+		chunk, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		l := location{index: index, offset: offset}
+
+		if limits.MaxChunks > 0 && index >= limits.MaxChunks {
+			return nil, nil, chunkError(chunk, l, fmt.Errorf("%w: max %d", ErrTooManyChunks, limits.MaxChunks))
+		}
+
+		switch c := chunk.(type) {
+		case *shapefile.UnknownChunk:
+			r.UnknownChunks++
+			continue
+		case *shapefile.Preview:
+			if _, err := png.DecodeConfig(bytes.NewReader(c.Data)); err != nil {
+				r.InvalidPreviews++
+				continue
+			}
+		case *shapefile.Shape:
+			shapes++
+			if limits.MaxShapes > 0 && shapes > limits.MaxShapes {
+				return nil, nil, chunkError(chunk, l, fmt.Errorf("%w: max %d", ErrTooManyShapes, limits.MaxShapes))
+			}
+			blocks += len(c.Blocks)
+			if err := checkShape(c, limits, blocks); err != nil {
+				return nil, nil, chunkError(chunk, l, err)
+			}
+
+			r.UnknownSubChunks += len(c.Unknown)
+			c.Unknown = nil
+			if c.BakedLighting != nil && len(c.BakedLighting) != len(c.Blocks)*2 {
+				r.BakedLighting++
+				c.BakedLighting = nil
+			}
+		}
+
+		f.Chunks = append(f.Chunks, chunk)
+		locations[chunk] = l
+	}
+
+	if err := checkHierarchy(f, locations); err != nil {
+		return nil, nil, err
+	}
+
+	var b bytes.Buffer
+	if err := shapefile.EncodeWithOptions(&b, f, shapefile.EncodeOptions{Canonical: true}); err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), r, nil
+}
+
+func chunkError(chunk shapefile.Chunk, l location, err error) error {
+	return &shapefile.ChunkError{Index: l.index, ID: chunk.ChunkID(), Offset: l.offset, Err: err}
+}
+
+// checkShape checks the size of a shape and its values,
+// blocks being the number of blocks of shapes read so far.
+func checkShape(s *shapefile.Shape, limits Limits, blocks int) error {
+	if max := limits.MaxDimension; max > 0 && (int(s.Width) > max || int(s.Height) > max || int(s.Depth) > max) {
+		return fmt.Errorf("%w: %dx%dx%d, max %d", ErrShapeTooLarge, s.Width, s.Height, s.Depth, max)
+	}
+	if limits.MaxBlocks > 0 && blocks > limits.MaxBlocks {
+		return fmt.Errorf("%w: %d blocks in shapes, max %d", ErrShapeTooLarge, blocks, limits.MaxBlocks)
+	}
+
+	vectors := make([]shapefile.Vec3, 0)
+	if s.Transform != nil {
+		vectors = append(vectors, s.Transform.Position, s.Transform.Rotation, s.Transform.Scale)
+	}
+	if s.Pivot != nil {
+		vectors = append(vectors, *s.Pivot)
+	}
+	if s.CollisionBox != nil {
+		vectors = append(vectors, s.CollisionBox.Min, s.CollisionBox.Max)
+	}
+	for _, p := range s.Points {
+		vectors = append(vectors, p.Value)
+	}
+	for _, p := range s.PointRotations {
+		vectors = append(vectors, p.Value)
+	}
+	for _, v := range vectors {
+		for _, c := range []float32{v.X, v.Y, v.Z} {
+			if math.IsNaN(float64(c)) || math.IsInf(float64(c), 0) {
+				return fmt.Errorf("%w: %v in a vector", ErrInvalidShape, c)
+			}
+		}
+	}
+	return nil
+}
+
+// checkHierarchy checks color indexes and parents of shapes, like
+// shapefile.Inspect: parents are positions in the file, before children.
+func checkHierarchy(f *shapefile.File, locations map[shapefile.Chunk]location) error {
+	for position, s := range f.Shapes() {
+		fail := func(format string, args ...interface{}) error {
+			return chunkError(s, locations[s], fmt.Errorf("%w: %s", ErrInvalidShape, fmt.Sprintf(format, args...)))
+		}
+
+		if palette := f.ShapePalette(s); palette != nil {
+			for _, b := range s.Blocks {
+				if b != shapefile.AirBlock && int(b) >= len(palette.Colors) {
+					return fail("color %d out of the palette (%d colors)", b, len(palette.Colors))
+				}
+			}
+		}
+
+		switch {
+		case s.ParentID == 0 && position > 0:
+			return fail("shape has no parent, only the first shape can be a root")
+		case int(s.ParentID) > position:
+			return fail("parent %d is not before the shape", s.ParentID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+// offsets in files with a single chunk
+const (
+	headerSize = len(shapefile.MagicBytes) + 4 + 1 + 4
+	// uncompressed size in v6 chunk headers
+	uncompressedSizeOffset = headerSize + 1 + 4 + 1
+)
+
+// newTestFile returns the chest of testutil with a PNG preview and without
+// its unknown chunk: a file that sanitizing doesn't change.
+func newTestFile(t testing.TB) *shapefile.File {
+	t.Helper()
+
+	var preview bytes.Buffer
+	if err := png.Encode(&preview, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	f := testutil.Chest()
+	f.SetPreview(preview.Bytes())
+	chunks := f.Chunks[:0]
+	for _, c := range f.Chunks {
+		if _, ok := c.(*shapefile.UnknownChunk); !ok {
+			chunks = append(chunks, c)
+		}
+	}
+	f.Chunks = chunks
+	return f
+}
+
+// bomb returns a file with a shape of 256x256x256 blocks, 16MB
+// uncompressed, a few kilobytes compressed.
+func bomb(t testing.TB) []byte {
+	t.Helper()
+	return testutil.Encode(t, &shapefile.File{Chunks: []shapefile.Chunk{shapefile.NewShape(256, 256, 256)}})
+}
+
+func TestSanitize(t *testing.T) {
+	f := newTestFile(t)
+	f.Chunks = append(f.Chunks,
+		&shapefile.UnknownChunk{ID: 20, Data: []byte("unknown")},
+		&shapefile.Preview{Data: []byte("not a png")})
+	root := f.Root()
+	root.Unknown = [][]byte{{10, 2, 0, 0, 0, 0, 2, 0, 0, 0, 'c', 'c'}}
+	root.BakedLighting = []byte{1, 2, 3}
+
+	sanitized, r, err := Sanitize(testutil.Encode(t, f), DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r != (Report{UnknownChunks: 1, UnknownSubChunks: 1, InvalidPreviews: 1, BakedLighting: 1}) {
+		t.Errorf("report %+v", r)
+	}
+
+	want := newTestFile(t)
+	var b bytes.Buffer
+	if err := shapefile.EncodeWithOptions(&b, want, shapefile.EncodeOptions{Canonical: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sanitized, b.Bytes()) {
+		t.Errorf("sanitized file isn't the canonical encoding of the file without unknown chunks")
+	}
+
+	// sanitized files stay the same
+	again, r, err := Sanitize(sanitized, DefaultLimits())
+	if err != nil || r.Changed() || !bytes.Equal(again, sanitized) {
+		t.Errorf("sanitized again: %v, report %+v", err, r)
+	}
+}
+
+func TestSanitizeErrors(t *testing.T) {
+	valid := testutil.Encode(t, newTestFile(t))
+
+	// compressed chunk uncompressing to more than announced
+	lying := bomb(t)
+	binary.LittleEndian.PutUint32(lying[uncompressedSizeOffset:], 1024)
+
+	nan := newTestFile(t)
+	nan.Root().Pivot.Y = float32(math.NaN())
+
+	outOfPalette := newTestFile(t)
+	outOfPalette.Shapes()[1].SetBlock(0, 0, 0, 2)
+
+	orphan := newTestFile(t)
+	orphan.Shapes()[1].ParentID = 3
+
+	limits := DefaultLimits()
+	limits.MaxUncompressedSize = 1 << 20
+	limits.MaxDimension = 128
+	limits.MaxShapes = 1
+
+	tests := []struct {
+		name     string
+		data     []byte
+		limits   Limits
+		expected error
+		// index of the chunk, -1 for no chunk
+		chunk int
+	}{
+		{"not a .3zh file", []byte("PARTICUBES!"), limits, shapefile.ErrInvalidMagic, -1},
+		{"file size", valid, Limits{MaxFileSize: 64}, ErrFileTooLarge, -1},
+		{"uncompressed size", bomb(t), limits, shapefile.ErrTooLarge, 0},
+		{"wrong uncompressed size", lying, DefaultLimits(), shapefile.ErrInvalidChunk, 0},
+		{"chunks", valid, Limits{MaxChunks: 2}, ErrTooManyChunks, 2},
+		{"shapes", valid, limits, ErrTooManyShapes, 3},
+		{"dimension", bomb(t), Limits{MaxDimension: 128}, ErrShapeTooLarge, 0},
+		{"blocks", valid, Limits{MaxBlocks: 8}, ErrShapeTooLarge, 2},
+		{"nan", testutil.Encode(t, nan), DefaultLimits(), ErrInvalidShape, 2},
+		{"colors", testutil.Encode(t, outOfPalette), DefaultLimits(), ErrInvalidShape, 3},
+		{"parent", testutil.Encode(t, orphan), DefaultLimits(), ErrInvalidShape, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Sanitize(test.data, test.limits)
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("got %v, want %v", err, test.expected)
+			}
+
+			var chunkErr *shapefile.ChunkError
+			if !errors.As(err, &chunkErr) {
+				if test.chunk >= 0 {
+					t.Errorf("got %v, want an error in chunk #%d", err, test.chunk)
+				}
+				return
+			}
+			offset := headerSize
+			if test.chunk > 0 {
+				offset = shapefile.Inspect(test.data).Chunks[test.chunk].Offset
+			}
+			if chunkErr.Index != test.chunk || chunkErr.Offset != offset {
+				t.Errorf("got chunk #%d at offset %d, want chunk #%d at offset %d", chunkErr.Index, chunkErr.Offset, test.chunk, offset)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	limits := DefaultLimits()
+	limits.MaxFileSize = 64 << 10
+	limits.MaxUncompressedSize = 1 << 20
+	server := httptest.NewServer(Handler(limits))
+	defer server.Close()
+
+	post := func(data []byte) *http.Response {
+		t.Helper()
+		response, err := http.Post(server.URL, "application/octet-stream", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	f := newTestFile(t)
+	f.Chunks = append(f.Chunks, &shapefile.UnknownChunk{ID: 20, Data: []byte("unknown")})
+	response := post(testutil.Encode(t, f))
+	var b bytes.Buffer
+	b.ReadFrom(response.Body)
+	if response.StatusCode != http.StatusOK || response.Header.Get("X-Removed-Chunks") != "1" {
+		t.Fatalf("status %d, headers %v: %s", response.StatusCode, response.Header, b.Bytes())
+	}
+	if sanitized, err := shapefile.Decode(&b); err != nil || len(sanitized.Chunks) != 5 {
+		t.Errorf("sanitized file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		status  int
+		code    string
+		chunkID string
+	}{
+		{"zip bomb", bomb(t), http.StatusRequestEntityTooLarge, "too_large", "SHAPE"},
+		{"upload size", make([]byte, 128<<10), http.StatusRequestEntityTooLarge, "file_too_large", ""},
+		{"not a .3zh file", []byte("PARTICUBES!"), http.StatusUnprocessableEntity, "invalid_magic", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := post(test.data)
+			var e ErrorResponse
+			if err := json.NewDecoder(response.Body).Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status || e.Code != test.code || e.ChunkID != test.chunkID {
+				t.Errorf("status %d, response %+v", response.StatusCode, e)
+			}
+			if test.chunkID != "" && (e.Chunk == nil || *e.Chunk != 0 || e.Offset == nil || *e.Offset != headerSize) {
+				t.Errorf("chunk %v, offset %v", e.Chunk, e.Offset)
+			}
+		})
+	}
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", response.StatusCode)
+	}
+}
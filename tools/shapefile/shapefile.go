@@ -0,0 +1,436 @@
+// Package shapefile reads and writes Cubzh .3zh files.
+//
+// The format is described in cubzh-file-format-3zh.txt, but this package
+// follows what core/serialization_v6.c actually reads and writes:
+//
+//	header:     "CUBZH!", uint32 version (6), uint8 compression, uint32 total size
+//	chunks:     uint8 id, then either a v5 header (uint32 size)
+//	            or a v6 header (uint32 size, uint8 compressed, uint32 uncompressed size)
+//	shape data: sub-chunks with a uint8 id and a uint32 size,
+//	            except NAME (uint8 length, name)
+//
+// All values are little-endian. PREVIEW and unknown chunks use v5 headers,
+// palette and shape chunks use v6 headers and are usually zlib compressed.
+//
+// There's no OBJECT chunk: objects are shapes, linked to their parent
+// with a PARENT_ID sub-chunk. File.Parent and File.Children expose that hierarchy.
+//
+// Decoded chunks keep the bytes they were read from. As long as a chunk
+// isn't modified, it's encoded exactly as it was read, so that decoding
+// and encoding a file gives the same bytes, even though Go's zlib
+// doesn't compress like the one the engine links with.
+package shapefile
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MagicBytes starts all .3zh files.
+	MagicBytes = "CUBZH!"
+	// Version is the only file format version supported.
+	Version uint32 = 6
+)
+
+// Compression is the compression algorithm mentioned in the file header.
+// Chunks say whether they're compressed or not, it's informative only.
+type Compression uint8
+
+const (
+	CompressionNone Compression = 0
+	CompressionZip  Compression = 1
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZip:
+		return "zip"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(c))
+}
+
+// ChunkID identifies top level chunks.
+type ChunkID uint8
+
+const (
+	ChunkIDPreview       ChunkID = 1
+	ChunkIDPaletteLegacy ChunkID = 2
+	ChunkIDShape         ChunkID = 3
+	ChunkIDPaletteID     ChunkID = 15
+	ChunkIDPalette       ChunkID = 16
+
+	// chunkIDMax isn't a chunk ID, ids from chunkIDMax are invalid
+	chunkIDMax ChunkID = 25
+)
+
+// SubChunkID identifies shape sub-chunks.
+type SubChunkID uint8
+
+const (
+	SubChunkIDSize          SubChunkID = 4
+	SubChunkIDBlocks        SubChunkID = 5
+	SubChunkIDPoint         SubChunkID = 6
+	SubChunkIDBakedLighting SubChunkID = 7
+	SubChunkIDPointRotation SubChunkID = 8
+	SubChunkIDShapeID       SubChunkID = 17
+	SubChunkIDName          SubChunkID = 18
+	SubChunkIDParentID      SubChunkID = 19
+	SubChunkIDTransform     SubChunkID = 20
+	SubChunkIDPivot         SubChunkID = 21
+	SubChunkIDPalette       SubChunkID = 22
+	SubChunkIDCollisionBox  SubChunkID = 23
+	SubChunkIDIsHidden      SubChunkID = 24
+)
+
+var chunkNames = map[ChunkID]string{
+	ChunkIDPreview:       "PREVIEW",
+	ChunkIDPaletteLegacy: "PALETTE_LEGACY",
+	ChunkIDShape:         "SHAPE",
+	ChunkIDPaletteID:     "PALETTE_ID",
+	ChunkIDPalette:       "PALETTE",
+}
+
+var subChunkNames = map[SubChunkID]string{
+	SubChunkIDSize:          "SHAPE_SIZE",
+	SubChunkIDBlocks:        "SHAPE_BLOCKS",
+	SubChunkIDPoint:         "SHAPE_POINT",
+	SubChunkIDBakedLighting: "SHAPE_BAKED_LIGHTING",
+	SubChunkIDPointRotation: "SHAPE_POINT_ROTATION",
+	SubChunkIDShapeID:       "SHAPE_ID",
+	SubChunkIDName:          "SHAPE_NAME",
+	SubChunkIDParentID:      "SHAPE_PARENT_ID",
+	SubChunkIDTransform:     "SHAPE_TRANSFORM",
+	SubChunkIDPivot:         "SHAPE_PIVOT",
+	SubChunkIDPalette:       "SHAPE_PALETTE",
+	SubChunkIDCollisionBox:  "OBJECT_COLLISION_BOX",
+	SubChunkIDIsHidden:      "OBJECT_IS_HIDDEN",
+}
+
+// String returns the name of the chunk in core/serialization_v6.c.
+func (id ChunkID) String() string {
+	if name, ok := chunkNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_%d", uint8(id))
+}
+
+// String returns the name of the sub-chunk in core/serialization_v6.c.
+func (id SubChunkID) String() string {
+	if name, ok := subChunkNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_%d", uint8(id))
+}
+
+// AirBlock is the color index of empty blocks.
+const AirBlock uint8 = 255
+
+var (
+	ErrInvalidMagic       = errors.New("not a .3zh file")
+	ErrUnsupportedVersion = errors.New("unsupported .3zh version")
+	ErrInvalidChunk       = errors.New("invalid chunk")
+	// ErrTooLarge is returned when chunks go over the size set with Decoder.SetMaxSize.
+	ErrTooLarge = errors.New("chunks too large")
+)
+
+// ChunkError is an error found in a chunk, it wraps ErrInvalidChunk,
+// ErrTooLarge or read errors.
+type ChunkError struct {
+	// Index of the chunk in the file, starting at 0
+	Index int
+	// ID is 0 if the chunk ends before its id
+	ID ChunkID
+	// Offset of the chunk in the file, starting with its id
+	Offset int
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	if e.ID == 0 {
+		return fmt.Sprintf("chunk #%d at offset %d: %v", e.Index, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("chunk #%d (%s) at offset %d: %v", e.Index, e.ID, e.Offset, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// Chunk is a top level chunk of a .3zh file:
+// *Preview, *PaletteChunk, *LegacyPaletteChunk, *PaletteIDChunk, *Shape or *UnknownChunk.
+type Chunk interface {
+	ChunkID() ChunkID
+}
+
+// File is a decoded .3zh file.
+type File struct {
+	Compression Compression
+	// Chunks in file order
+	Chunks []Chunk
+}
+
+// Preview is a PNG image of the file content.
+type Preview struct {
+	Data []byte
+}
+
+// PaletteChunk is the artist palette saved with the shapes.
+type PaletteChunk struct {
+	Palette *Palette
+	src     *source
+}
+
+// LegacyPaletteChunk is a palette written by former versions of the engine.
+type LegacyPaletteChunk struct {
+	Rows                   uint8
+	Columns                uint8
+	DefaultColor           uint8
+	DefaultBackgroundColor uint8
+	Palette                *Palette
+	src                    *source
+}
+
+// PaletteIDChunk is the id of the default palette used by legacy files.
+type PaletteIDChunk struct {
+	PaletteID uint8
+	src       *source
+}
+
+// UnknownChunk is a chunk this package doesn't know about, kept as is.
+// Like the engine does, it's assumed to have a v5 header.
+type UnknownChunk struct {
+	ID   ChunkID
+	Data []byte
+}
+
+func (*Preview) ChunkID() ChunkID            { return ChunkIDPreview }
+func (*PaletteChunk) ChunkID() ChunkID       { return ChunkIDPalette }
+func (*LegacyPaletteChunk) ChunkID() ChunkID { return ChunkIDPaletteLegacy }
+func (*PaletteIDChunk) ChunkID() ChunkID     { return ChunkIDPaletteID }
+func (*Shape) ChunkID() ChunkID              { return ChunkIDShape }
+func (c *UnknownChunk) ChunkID() ChunkID     { return c.ID }
+
+// Color is an RGBA color.
+type Color struct {
+	R, G, B, A uint8
+}
+
+// Palette is a list of colors, blocks reference them by index.
+type Palette struct {
+	Colors   []Color
+	Emissive []bool
+}
+
+// Vec3 is a float3 of the engine.
+type Vec3 struct {
+	X, Y, Z float32
+}
+
+// Transform is the local transform of a shape, relative to its parent.
+// Rotation is in euler angles (radians).
+type Transform struct {
+	Position Vec3
+	Rotation Vec3
+	Scale    Vec3
+}
+
+// Box is an axis aligned box.
+type Box struct {
+	Min Vec3
+	Max Vec3
+}
+
+// Point is a named point (or point rotation) of a shape.
+type Point struct {
+	Name  string
+	Value Vec3
+}
+
+// Shape is a SHAPE chunk. Optional sub-chunks are nil or zero when absent.
+type Shape struct {
+	// ID is the shape's position in the file, starting at 1 (0: not written)
+	ID uint16
+	// ParentID is the ID of the parent shape (0: root)
+	ParentID uint16
+	Name     string
+	// Transform is only written for shapes having a parent
+	Transform *Transform
+	Pivot     *Vec3
+	// CollisionBox is only written when not the default one
+	CollisionBox *Box
+	Hidden       bool
+	// Palette is only written for the root shape,
+	// and children not sharing its palette
+	Palette *Palette
+
+	Width, Height, Depth uint16
+	// Blocks are color indexes (AirBlock for empty blocks),
+	// indexed by (x * Height + y) * Depth + z.
+	Blocks []uint8
+
+	// Points are positions, relative to the shape's bounding box
+	Points         []Point
+	PointRotations []Point
+	// BakedLighting is 2 bytes per block, nil if not baked
+	BakedLighting []byte
+
+	// Unknown sub-chunks, kept as read (id included)
+	Unknown [][]byte
+
+	src *source
+}
+
+// source is what a chunk has been decoded from.
+type source struct {
+	// chunk data, as stored in the file
+	data             []byte
+	compressed       bool
+	uncompressedSize uint32
+	// encoding of the chunk when decoded, to know if it's been modified
+	payload []byte
+}
+
+// NewShape returns a shape of the given size, filled with air.
+func NewShape(width, height, depth uint16) *Shape {
+	s := &Shape{Width: width, Height: height, Depth: depth}
+	s.Blocks = make([]uint8, int(width)*int(height)*int(depth))
+	for i := range s.Blocks {
+		s.Blocks[i] = AirBlock
+	}
+	return s
+}
+
+// index returns the index of block (x, y, z) in Blocks.
+func (s *Shape) index(x, y, z int) int {
+	return (x*int(s.Height)+y)*int(s.Depth) + z
+}
+
+func (s *Shape) contains(x, y, z int) bool {
+	return x >= 0 && y >= 0 && z >= 0 && x < int(s.Width) && y < int(s.Height) && z < int(s.Depth)
+}
+
+// Block returns the color index of block (x, y, z), AirBlock if empty or out of bounds.
+func (s *Shape) Block(x, y, z int) uint8 {
+	if !s.contains(x, y, z) {
+		return AirBlock
+	}
+	return s.Blocks[s.index(x, y, z)]
+}
+
+// SetBlock sets the color index of block (x, y, z), within shape bounds.
+func (s *Shape) SetBlock(x, y, z int, color uint8) bool {
+	if !s.contains(x, y, z) {
+		return false
+	}
+	s.Blocks[s.index(x, y, z)] = color
+	return true
+}
+
+// BlockCount returns the number of blocks that are not air.
+func (s *Shape) BlockCount() int {
+	count := 0
+	for _, b := range s.Blocks {
+		if b != AirBlock {
+			count++
+		}
+	}
+	return count
+}
+
+// Preview returns the preview image, nil if the file has none.
+func (f *File) Preview() []byte {
+	for _, c := range f.Chunks {
+		if p, ok := c.(*Preview); ok && len(p.Data) > 0 {
+			return p.Data
+		}
+	}
+	return nil
+}
+
+// SetPreview replaces the preview image, or adds one as the first chunk,
+// where the engine writes it.
+func (f *File) SetPreview(data []byte) {
+	for _, c := range f.Chunks {
+		if p, ok := c.(*Preview); ok {
+			p.Data = data
+			return
+		}
+	}
+	f.Chunks = append([]Chunk{&Preview{Data: data}}, f.Chunks...)
+}
+
+// Palette returns the artist palette, nil if the file has none.
+func (f *File) Palette() *Palette {
+	for _, c := range f.Chunks {
+		switch p := c.(type) {
+		case *PaletteChunk:
+			return p.Palette
+		case *LegacyPaletteChunk:
+			return p.Palette
+		}
+	}
+	return nil
+}
+
+// ShapePalette returns the palette used by a shape: its own, or the root
+// shape's one, or the artist palette. It's nil for legacy files
+// using a default palette.
+func (f *File) ShapePalette(s *Shape) *Palette {
+	if s.Palette != nil {
+		return s.Palette
+	}
+	if root := f.Root(); root != nil && root.Palette != nil {
+		return root.Palette
+	}
+	return f.Palette()
+}
+
+// Shapes returns all shapes, in file order.
+func (f *File) Shapes() []*Shape {
+	shapes := make([]*Shape, 0)
+	for _, c := range f.Chunks {
+		if s, ok := c.(*Shape); ok {
+			shapes = append(shapes, s)
+		}
+	}
+	return shapes
+}
+
+// Root returns the first shape, parent of all others.
+func (f *File) Root() *Shape {
+	for _, c := range f.Chunks {
+		if s, ok := c.(*Shape); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// Parent returns the parent of a shape, nil for the root.
+// Like the engine does, ParentID is the position of the parent
+// in the file (starting at 1), shape IDs aren't considered.
+func (f *File) Parent(s *Shape) *Shape {
+	if s.ParentID == 0 {
+		return nil
+	}
+	shapes := f.Shapes()
+	if int(s.ParentID) > len(shapes) {
+		return nil
+	}
+	return shapes[s.ParentID-1]
+}
+
+// Children returns the shapes parented to s.
+func (f *File) Children(s *Shape) []*Shape {
+	children := make([]*Shape, 0)
+	shapes := f.Shapes()
+	for _, child := range shapes {
+		if child.ParentID != 0 && int(child.ParentID) <= len(shapes) && shapes[child.ParentID-1] == s {
+			children = append(children, child)
+		}
+	}
+	return children
+}
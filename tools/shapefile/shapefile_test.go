@@ -0,0 +1,301 @@
+package shapefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+// clearSources removes what chunks were decoded from, to compare files.
+func clearSources(f *File) {
+	for _, c := range f.Chunks {
+		switch c := c.(type) {
+		case *PaletteChunk:
+			c.src = nil
+		case *LegacyPaletteChunk:
+			c.src = nil
+		case *PaletteIDChunk:
+			c.src = nil
+		case *Shape:
+			c.src = nil
+		}
+	}
+}
+
+func encode(t testing.TB, f *File) []byte {
+	t.Helper()
+	b := &bytes.Buffer{}
+	if err := Encode(b, f); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decode(t *testing.T, data []byte) *File {
+	t.Helper()
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// engineShapeChunk returns a shape chunk as written by the engine:
+// compressed with another zlib level, with padding after the name
+// and a sub-chunk this package doesn't know.
+func engineShapeChunk(t testing.TB) []byte {
+	t.Helper()
+
+	payload := &writer{}
+	if err := payload.shape(&Shape{Width: 1, Height: 1, Depth: 1, Blocks: []uint8{7}}); err != nil {
+		t.Fatal(err)
+	}
+	// camera sub-chunk, from former versions
+	payload.Write([]byte{10, 2, 0, 0, 0, 0, 2, 0, 0, 0, 'c', 'c'})
+	payload.Write([]byte{uint8(SubChunkIDName), 4, 'c', 'u', 'b', 'e'})
+	payload.Write([]byte{0xde, 0xad, 0xbe, 0xef})
+
+	compressed := &bytes.Buffer{}
+	zw, err := zlib.NewWriterLevel(compressed, zlib.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(payload.Bytes())
+	zw.Close()
+
+	chunk := &writer{}
+	chunk.u8(uint8(ChunkIDShape))
+	chunk.u32(uint32(compressed.Len()))
+	chunk.u8(1)
+	chunk.u32(uint32(payload.Len()))
+	chunk.Write(compressed.Bytes())
+	return chunk.Bytes()
+}
+
+func engineFile(chunks ...[]byte) []byte {
+	w := &writer{}
+	w.WriteString(MagicBytes)
+	w.u32(Version)
+	w.u8(uint8(CompressionZip))
+	w.u32(uint32(len(bytes.Join(chunks, nil))))
+	w.Write(bytes.Join(chunks, nil))
+	return w.Bytes()
+}
+
+func TestEngineFile(t *testing.T) {
+	// preview chunk without image, as written by the engine
+	preview := []byte{uint8(ChunkIDPreview), 0, 0, 0, 0}
+	data := engineFile(preview, engineShapeChunk(t))
+
+	f := decode(t, data)
+
+	if again := encode(t, f); !bytes.Equal(again, data) {
+		t.Fatalf("encoding a decoded file gives different bytes")
+	}
+
+	shape := f.Root()
+	if shape == nil || shape.Name != "cube" || shape.Block(0, 0, 0) != 7 || len(shape.Unknown) != 1 {
+		t.Fatalf("unexpected shape: %+v", shape)
+	}
+	if f.Preview() != nil {
+		t.Errorf("empty preview should be nil")
+	}
+
+	// modified chunks are encoded again, others are kept as is
+	f.Chunks = append(f.Chunks, &PaletteIDChunk{PaletteID: 2})
+	shape.SetBlock(0, 0, 0, 3)
+	modified := encode(t, f)
+
+	headerSize := len(MagicBytes) + 9
+	if !bytes.Equal(modified[headerSize:headerSize+len(preview)], preview) {
+		t.Errorf("preview chunk has been modified")
+	}
+
+	decoded := decode(t, modified)
+	if decoded.Root().Block(0, 0, 0) != 3 || decoded.Root().Name != "cube" || len(decoded.Root().Unknown) != 1 {
+		t.Errorf("modified shape not encoded: %+v", decoded.Root())
+	}
+	if id, ok := decoded.Chunks[2].(*PaletteIDChunk); !ok || id.PaletteID != 2 {
+		t.Errorf("palette ID chunk not encoded: %+v", decoded.Chunks[2])
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	// an uncompressed shape chunk, followed by a well compressed one
+	payload := &writer{}
+	shape := NewShape(16, 16, 16)
+	if err := payload.shape(shape); err != nil {
+		t.Fatal(err)
+	}
+	uncompressed := &writer{}
+	uncompressed.u8(uint8(ChunkIDShape))
+	uncompressed.u32(uint32(payload.Len()))
+	uncompressed.u8(0)
+	uncompressed.u32(uint32(payload.Len()))
+	uncompressed.Write(payload.Bytes())
+	data := engineFile(uncompressed.Bytes(), engineShapeChunk(t))
+
+	var b bytes.Buffer
+	if err := EncodeWithOptions(&b, decode(t, data), EncodeOptions{Recompress: true, Level: zlib.BestCompression}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed := b.Bytes()
+
+	if len(recompressed) >= len(data)-payload.Len()/2 {
+		t.Errorf("%d bytes, from %d", len(recompressed), len(data))
+	}
+	layout := Inspect(recompressed)
+	if !layout.Chunks[0].Compressed {
+		t.Errorf("shape chunk not compressed: %+v", layout.Chunks[0])
+	}
+	// chunks are never larger than they were
+	if original := Inspect(data).Chunks[1]; layout.Chunks[1].Size > original.Size {
+		t.Errorf("compressed chunk: %d bytes, from %d", layout.Chunks[1].Size, original.Size)
+	}
+
+	f := decode(t, recompressed)
+	if s := f.Root(); s.Width != 16 || s.BlockCount() != 0 {
+		t.Errorf("unexpected shape %dx%dx%d, %d blocks", s.Width, s.Height, s.Depth, s.BlockCount())
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := engineFile([]byte{uint8(ChunkIDPreview), 0, 0, 0, 0}, engineShapeChunk(t))
+
+	truncated := append([]byte{}, valid[:len(valid)-10]...)
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[len(MagicBytes)] = 5
+
+	badChunkID := engineFile([]byte{30, 0, 0, 0, 0})
+
+	tooLarge := engineFile([]byte{uint8(ChunkIDPreview), 0xff, 0xff, 0, 0, 1, 2, 3})
+
+	emptyShape := engineFile([]byte{uint8(ChunkIDShape), 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrInvalidMagic},
+		{"magic", []byte("PARTICUBES!...."), ErrInvalidMagic},
+		{"version", badVersion, ErrUnsupportedVersion},
+		{"chunk id", badChunkID, ErrInvalidChunk},
+		{"chunk size", tooLarge, ErrInvalidChunk},
+		{"empty shape", emptyShape, ErrInvalidChunk},
+		{"truncated", truncated, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(test.data))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if test.expected != nil && !errors.Is(err, test.expected) {
+				t.Errorf("got %v, want %v", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestChunkError(t *testing.T) {
+	preview := []byte{uint8(ChunkIDPreview), 2, 0, 0, 0, 'p', 'p'}
+	// a chunk announcing 1GB, in a file announcing 4GB: nothing is
+	// allocated before being read
+	huge := engineFile(preview, []byte{uint8(ChunkIDPreview), 0, 0, 0, 0x40, 1, 2, 3})
+	binary.LittleEndian.PutUint32(huge[len(MagicBytes)+5:], math.MaxUint32)
+
+	_, err := Decode(bytes.NewReader(huge))
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("got %v, want a chunk error", err)
+	}
+	if chunkErr.Index != 1 || chunkErr.ID != ChunkIDPreview || chunkErr.Offset != headerSize+len(preview) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %+v", chunkErr)
+	}
+	if want := "chunk #1 (PREVIEW) at offset 22: unexpected EOF"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func TestDecodeMaxSize(t *testing.T) {
+	// the shape chunk is compressed, it uncompresses to more than its size
+	data := engineFile(engineShapeChunk(t))
+	layout := Inspect(data)
+	uncompressed := int64(layout.Chunks[0].UncompressedSize)
+
+	for _, max := range []int64{0, uncompressed} {
+		d := NewDecoder(bytes.NewReader(data))
+		d.SetMaxSize(max)
+		if _, err := d.Next(); err != nil {
+			t.Errorf("max %d: %v", max, err)
+		}
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	d.SetMaxSize(uncompressed - 1)
+	if _, err := d.Next(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	canonical := func(f *File) []byte {
+		var b bytes.Buffer
+		if err := EncodeWithOptions(&b, f, EncodeOptions{Canonical: true}); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	// the same shape, compressed by the engine or not compressed
+	data := engineFile(engineShapeChunk(t))
+	f := decode(t, data)
+	payload, err := encodeChunkPayload(f.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed := &writer{}
+	uncompressed.u8(uint8(ChunkIDShape))
+	uncompressed.u32(uint32(len(payload)))
+	uncompressed.u8(0)
+	uncompressed.u32(uint32(len(payload)))
+	uncompressed.Write(payload)
+	other := decode(t, engineFile(uncompressed.Bytes()))
+
+	if a, b := canonical(f), canonical(other); !bytes.Equal(a, b) {
+		t.Errorf("canonical encodings differ:\n% x\n% x", a, b)
+	}
+	if bytes.Equal(canonical(f), data) {
+		t.Errorf("original bytes written")
+	}
+	if again := canonical(decode(t, canonical(f))); !bytes.Equal(again, canonical(f)) {
+		t.Errorf("canonical encoding isn't stable")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk Chunk
+	}{
+		{"blocks", &Shape{Width: 2, Height: 2, Depth: 2, Blocks: []uint8{1}}},
+		{"palette", &PaletteChunk{Palette: &Palette{Colors: make([]Color, 256)}}},
+		{"unknown chunk id", &UnknownChunk{ID: ChunkIDShape}},
+		{"point name", &Shape{Width: 1, Height: 1, Depth: 1, Blocks: []uint8{1}, Points: []Point{{Name: string(make([]byte, 256))}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Encode(&bytes.Buffer{}, &File{Chunks: []Chunk{test.chunk}}); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
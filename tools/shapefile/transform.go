@@ -0,0 +1,49 @@
+package shapefile
+
+import "math"
+
+// Matrix is a 3x3 matrix, indexed by row then column.
+type Matrix [3][3]float64
+
+// IdentityMatrix is the identity matrix.
+var IdentityMatrix = Matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// RotationMatrix returns the rotation matrix of euler angles (radians),
+// computed like the engine does (core/quaternion.c, euler_to_quaternion
+// then quaternion_to_rotation_matrix), with its permuted quaternion axes.
+func RotationMatrix(euler Vec3) Matrix {
+	cx, sx := math.Cos(0.5*float64(euler.X)), math.Sin(0.5*float64(euler.X))
+	cy, sy := math.Cos(0.5*float64(euler.Y)), math.Sin(0.5*float64(euler.Y))
+	cz, sz := math.Cos(0.5*float64(euler.Z)), math.Sin(0.5*float64(euler.Z))
+
+	qx := sz*cx*cy - cz*sx*sy
+	qy := cz*sx*cy + sz*cx*sy
+	qz := cz*cx*sy - sz*sx*cy
+	qw := cz*cx*cy + sz*sx*sy
+
+	if l := math.Sqrt(qx*qx + qy*qy + qz*qz + qw*qw); l > 0 {
+		qx, qy, qz, qw = qx/l, qy/l, qz/l, qw/l
+	}
+
+	xx, xy, xz, xw := qy*qy, qy*qz, qy*qx, -qy*qw
+	yy, yz, yw := qz*qz, qz*qx, -qz*qw
+	zz, zw := qx*qx, -qx*qw
+
+	return Matrix{
+		{1 - 2*(yy+zz), 2 * (xy + zw), 2 * (xz - yw)},
+		{2 * (xy - zw), 1 - 2*(xx+zz), 2 * (yz + xw)},
+		{2 * (xz + yw), 2 * (yz - xw), 1 - 2*(xx+yy)},
+	}
+}
+
+// Matrix returns the rotation and scale of the transform, as a matrix.
+func (t *Transform) Matrix() Matrix {
+	m := RotationMatrix(t.Rotation)
+	scale := [3]float64{float64(t.Scale.X), float64(t.Scale.Y), float64(t.Scale.Z)}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] *= scale[j]
+		}
+	}
+	return m
+}
@@ -0,0 +1,804 @@
+package vox
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"cu.bzh/tools/shapefile"
+)
+
+// Conversions
+//
+// Y is up in Cubzh, Z in MagicaVoxel: Cubzh (x, y, z) is MagicaVoxel (x, z, y).
+//
+// Each shape is a transform node (nTRN) with the shape's name, followed by
+// a shape node (nSHP) referencing its model. Shapes with children are a
+// transform node followed by a group (nGRP), containing a transform node
+// with the same name for the shape's own model, then children transform
+// nodes. MagicaVoxel models are centered on floor(size / 2), where Cubzh
+// shapes are placed relative to their pivot: translations are adjusted
+// to keep blocks at the same place.
+//
+// MagicaVoxel only supports axis aligned rotations and whole block
+// translations, without scale. Exact values are kept in transform nodes
+// attributes, along with data that doesn't exist in .vox files (pivots,
+// points, collision boxes). They're used when converting back to .3zh,
+// if the node didn't move in the meantime.
+//
+// Cubzh color indexes start at 0 where .vox color indexes start at 1,
+// 0 being empty. Cubzh uses 255 (shapefile.AirBlock) for empty blocks,
+// so 255 colors can be used in both formats. Transparent colors are
+// glass materials, emissive colors are emissive materials.
+
+const (
+	attributeName         = "_name"
+	attributeHidden       = "_hidden"
+	attributeTransform    = "_cubzh_transform"
+	attributePivot        = "_cubzh_pivot"
+	attributeCollisionBox = "_cubzh_collision_box"
+	// followed by the point's name
+	attributePoint         = "_cubzh_point:"
+	attributePointRotation = "_cubzh_point_rotation:"
+
+	materialType     = "_type"
+	materialGlass    = "_glass"
+	materialEmissive = "_emit"
+	// transparency of glass materials, from 0 to 1
+	materialTransparency = "_trans"
+
+	// max color index
+	maxColors = 255
+	// matrices are compared with this tolerance
+	epsilon = 1e-4
+)
+
+var ErrNoShape = errors.New("no shape")
+
+// Report lists what was lost in a conversion.
+type Report struct {
+	Lost []string
+}
+
+func (r *Report) lost(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	for _, l := range r.Lost {
+		if l == message {
+			return
+		}
+	}
+	r.Lost = append(r.Lost, message)
+}
+
+func (r *Report) String() string {
+	return strings.Join(r.Lost, "\n")
+}
+
+// vec3 is a point or vector in Cubzh coordinates.
+type vec3 [3]float64
+
+func newVec3(v shapefile.Vec3) vec3 {
+	return vec3{float64(v.X), float64(v.Y), float64(v.Z)}
+}
+
+func (v vec3) shapefile() shapefile.Vec3 {
+	return shapefile.Vec3{X: float32(v[0]), Y: float32(v[1]), Z: float32(v[2])}
+}
+
+func apply(m shapefile.Matrix, v vec3) vec3 {
+	var r vec3
+	for i := 0; i < 3; i++ {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+// swapYZ converts between Cubzh and MagicaVoxel coordinates.
+func swapYZ[T any](v [3]T) [3]T {
+	return [3]T{v[0], v[2], v[1]}
+}
+
+// center returns the center of a shape's model, in Cubzh coordinates.
+func center(s *shapefile.Shape) vec3 {
+	return vec3{float64(s.Width / 2), float64(s.Height / 2), float64(s.Depth / 2)}
+}
+
+func roundVec3(v vec3) ([3]int32, bool) {
+	var r [3]int32
+	exact := true
+	for i := range v {
+		rounded := math.Round(v[i])
+		r[i] = int32(rounded)
+		exact = exact && math.Abs(v[i]-rounded) < epsilon
+	}
+	return r, exact
+}
+
+// voxRotation returns the .vox rotation of a Cubzh matrix,
+// false if it's not an axis aligned rotation (or reflection).
+func voxRotation(m shapefile.Matrix) (Rotation, bool) {
+	var rounded [3][3]int
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			rounded[i][j] = int(math.Round(m[i][j]))
+			if math.Abs(m[i][j]-float64(rounded[i][j])) > epsilon {
+				return IdentityRotation, false
+			}
+		}
+	}
+	// swapping Y and Z on both sides
+	return NewRotation([3][3]int{swapYZ(rounded[0]), swapYZ(rounded[2]), swapYZ(rounded[1])})
+}
+
+// cubzhMatrix returns the Cubzh matrix of a .vox rotation.
+func cubzhMatrix(r Rotation) shapefile.Matrix {
+	m := r.Matrix()
+	if m == nil {
+		return shapefile.IdentityMatrix
+	}
+	rows := [3][3]int{swapYZ(m[0]), swapYZ(m[2]), swapYZ(m[1])}
+	var result shapefile.Matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			result[i][j] = float64(rows[i][j])
+		}
+	}
+	return result
+}
+
+// cubzhRotation returns the euler angles and scale giving an axis aligned matrix.
+// Reflections use negative scales.
+func cubzhRotation(m shapefile.Matrix) (shapefile.Vec3, shapefile.Vec3) {
+	angles := []float32{0, math.Pi / 2, -math.Pi / 2, math.Pi}
+	signs := []float32{1, -1}
+	for _, sx := range signs {
+		for _, sy := range signs {
+			for _, sz := range signs {
+				for _, x := range angles {
+					for _, y := range angles {
+						for _, z := range angles {
+							t := &shapefile.Transform{Rotation: shapefile.Vec3{X: x, Y: y, Z: z}, Scale: shapefile.Vec3{X: sx, Y: sy, Z: sz}}
+							if equalMatrices(t.Matrix(), m) {
+								return t.Rotation, t.Scale
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return shapefile.Vec3{}, shapefile.Vec3{X: 1, Y: 1, Z: 1}
+}
+
+func equalMatrices(a, b shapefile.Matrix) bool {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(a[i][j]-b[i][j]) > epsilon {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// shapeFrame returns the rotation and translation of the transform node of a shape.
+// Shapes having children are placed by their group: their own model is placed
+// relative to the group, in its own transform node (see modelFrame).
+func shapeFrame(s *shapefile.Shape, grouped bool) (r Rotation, t [3]int32, rotationOK, translationOK bool) {
+	m := shapefile.IdentityMatrix
+	var position vec3
+	if s.Transform != nil {
+		m = s.Transform.Matrix()
+		position = newVec3(s.Transform.Position)
+	}
+	r, rotationOK = voxRotation(m)
+	if !grouped {
+		position = position.add(apply(m, center(s).sub(shapePivot(s))))
+	}
+	t, translationOK = roundVec3(position)
+	return r, swapYZ(t), rotationOK, translationOK
+}
+
+// modelFrame returns the translation of a grouped shape's own model.
+func modelFrame(s *shapefile.Shape) ([3]int32, bool) {
+	t, ok := roundVec3(center(s).sub(shapePivot(s)))
+	return swapYZ(t), ok
+}
+
+func (v vec3) add(w vec3) vec3 {
+	return vec3{v[0] + w[0], v[1] + w[1], v[2] + w[2]}
+}
+
+func (v vec3) sub(w vec3) vec3 {
+	return vec3{v[0] - w[0], v[1] - w[1], v[2] - w[2]}
+}
+
+// shapePivot returns the pivot of a shape, its center by default.
+func shapePivot(s *shapefile.Shape) vec3 {
+	if s.Pivot != nil {
+		return newVec3(*s.Pivot)
+	}
+	return vec3{float64(s.Width) / 2, float64(s.Height) / 2, float64(s.Depth) / 2}
+}
+
+func formatFloats(values ...float32) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+	return strings.Join(s, " ")
+}
+
+func parseFloats(s string, n int) ([]float32, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != n {
+		return nil, false
+	}
+	values := make([]float32, n)
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = float32(v)
+	}
+	return values, true
+}
+
+func formatVec3(v shapefile.Vec3) string {
+	return formatFloats(v.X, v.Y, v.Z)
+}
+
+func parseVec3(s string) (shapefile.Vec3, bool) {
+	v, ok := parseFloats(s, 3)
+	if !ok {
+		return shapefile.Vec3{}, false
+	}
+	return shapefile.Vec3{X: v[0], Y: v[1], Z: v[2]}, true
+}
+
+// shapePalette returns the palette of a shape: its own, or the root shape's,
+// or the file's one.
+func shapePalette(f *shapefile.File, s *shapefile.Shape) *shapefile.Palette {
+	if s.Palette != nil {
+		return s.Palette
+	}
+	if root := f.Root(); root != nil && root.Palette != nil {
+		return root.Palette
+	}
+	return f.Palette()
+}
+
+type paletteColor struct {
+	color    shapefile.Color
+	emissive bool
+}
+
+// FromShapefile converts a .3zh file to .vox.
+func FromShapefile(f *shapefile.File) (*File, *Report, error) {
+
+	report := &Report{}
+
+	root := f.Root()
+	if root == nil {
+		return nil, nil, ErrNoShape
+	}
+
+	for _, c := range f.Chunks {
+		switch c := c.(type) {
+		case *shapefile.Preview:
+			if len(c.Data) > 0 {
+				report.lost("preview image")
+			}
+		case *shapefile.UnknownChunk:
+			report.lost("unknown chunk %d", c.ID)
+		}
+	}
+
+	v := &File{
+		Version: Version,
+		Palette: &[256]color.NRGBA{},
+		Layers:  []*Layer{{ID: 0, Attributes: Dict{}, ReservedID: -1}},
+	}
+
+	// other palettes are merged into the root palette
+	colors := make([]paletteColor, 0)
+	mappings := make(map[*shapefile.Palette][]uint8)
+	mapping := func(p *shapefile.Palette, s *shapefile.Shape) []uint8 {
+		if p == nil {
+			return nil
+		}
+		if m, ok := mappings[p]; ok {
+			return m
+		}
+		m := make([]uint8, len(p.Colors))
+		for i, c := range p.Colors {
+			pc := paletteColor{color: c, emissive: i < len(p.Emissive) && p.Emissive[i]}
+			index := -1
+			// the root palette is kept as is, with its duplicates
+			if len(mappings) > 0 {
+				for j, existing := range colors {
+					if existing == pc {
+						index = j
+						break
+					}
+				}
+			}
+			if index == -1 {
+				if len(colors) < maxColors {
+					colors = append(colors, pc)
+					index = len(colors) - 1
+				} else {
+					index = nearestColor(colors, pc)
+					report.lost("shape %q: color #%d replaced by a similar one (more than %d colors)", shapeName(s), i, maxColors)
+				}
+			}
+			m[i] = uint8(index + 1)
+		}
+		mappings[p] = m
+		return m
+	}
+	mapping(shapePalette(f, root), root)
+
+	nextID := int32(0)
+	newID := func() int32 {
+		nextID++
+		return nextID - 1
+	}
+
+	rootTransform := &TransformNode{ID: newID(), Attributes: Dict{}, ReservedID: -1, LayerID: -1}
+	rootTransform.SetFrame(IdentityRotation, [3]int32{})
+	rootGroup := &GroupNode{ID: newID(), Attributes: Dict{}}
+	rootTransform.ChildID = rootGroup.ID
+	v.Nodes = append(v.Nodes, rootTransform, rootGroup)
+
+	if root.Transform != nil {
+		report.lost("root shape transform")
+	}
+
+	visited := make(map[*shapefile.Shape]bool)
+	var export func(s *shapefile.Shape, group *GroupNode) error
+	export = func(s *shapefile.Shape, group *GroupNode) error {
+		visited[s] = true
+
+		if s.Width > MaxModelSize || s.Height > MaxModelSize || s.Depth > MaxModelSize {
+			return fmt.Errorf("shape %q is too large: %dx%dx%d, max %d", shapeName(s), s.Width, s.Height, s.Depth, MaxModelSize)
+		}
+
+		m := mapping(shapePalette(f, s), s)
+		model := &Model{SizeX: int32(s.Width), SizeY: int32(s.Depth), SizeZ: int32(s.Height)}
+		for x := 0; x < int(s.Width); x++ {
+			for y := 0; y < int(s.Height); y++ {
+				for z := 0; z < int(s.Depth); z++ {
+					index := s.Block(x, y, z)
+					if index == shapefile.AirBlock {
+						continue
+					}
+					voxIndex := uint8(int(index) + 1)
+					if int(index) < len(m) {
+						voxIndex = m[index]
+					}
+					model.Voxels = append(model.Voxels, Voxel{X: uint8(x), Y: uint8(z), Z: uint8(y), Index: voxIndex})
+				}
+			}
+		}
+		v.Models = append(v.Models, model)
+		shapeNode := &ShapeNode{ID: newID(), Attributes: Dict{}, Models: []ShapeModel{{ModelID: int32(len(v.Models) - 1), Attributes: Dict{}}}}
+
+		children := make([]*shapefile.Shape, 0)
+		for _, child := range f.Children(s) {
+			if !visited[child] {
+				children = append(children, child)
+			}
+		}
+		grouped := len(children) > 0
+
+		t := &TransformNode{ID: newID(), Attributes: shapeAttributes(s, report), ReservedID: -1, LayerID: 0}
+		r, translation, rotationOK, translationOK := shapeFrame(s, grouped)
+		t.SetFrame(r, translation)
+		if !rotationOK {
+			report.lost("shape %q: rotation and scale (not axis aligned)", shapeName(s))
+		}
+		if !translationOK {
+			report.lost("shape %q: position rounded to whole blocks", shapeName(s))
+		}
+		group.ChildIDs = append(group.ChildIDs, t.ID)
+		v.Nodes = append(v.Nodes, t)
+
+		if !grouped {
+			t.ChildID = shapeNode.ID
+			v.Nodes = append(v.Nodes, shapeNode)
+			return nil
+		}
+
+		shapeGroup := &GroupNode{ID: newID(), Attributes: Dict{}}
+		t.ChildID = shapeGroup.ID
+		modelTransform := &TransformNode{ID: newID(), Attributes: Dict{}, ChildID: shapeNode.ID, ReservedID: -1, LayerID: 0}
+		if s.Name != "" {
+			modelTransform.Attributes.Set(attributeName, s.Name)
+		}
+		translation, ok := modelFrame(s)
+		if !ok {
+			report.lost("shape %q: pivot rounded to whole blocks", shapeName(s))
+		}
+		modelTransform.SetFrame(IdentityRotation, translation)
+		shapeGroup.ChildIDs = append(shapeGroup.ChildIDs, modelTransform.ID)
+		v.Nodes = append(v.Nodes, shapeGroup, modelTransform, shapeNode)
+
+		for _, child := range children {
+			if err := export(child, shapeGroup); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := export(root, rootGroup); err != nil {
+		return nil, nil, err
+	}
+
+	for i, c := range colors {
+		v.Palette[i+1] = color.NRGBA{R: c.color.R, G: c.color.G, B: c.color.B, A: c.color.A}
+		material := &Material{ID: int32(i + 1), Properties: Dict{}}
+		if c.emissive {
+			material.Properties.Set(materialType, materialEmissive)
+			material.Properties.Set(materialEmissive, "1")
+		} else if c.color.A < 255 {
+			material.Properties.Set(materialType, materialGlass)
+			material.Properties.Set(materialTransparency, formatFloats(1-float32(c.color.A)/255))
+		} else {
+			continue
+		}
+		v.Materials = append(v.Materials, material)
+	}
+
+	return v, report, nil
+}
+
+// shapeAttributes returns the attributes of a shape's transform node.
+func shapeAttributes(s *shapefile.Shape, report *Report) Dict {
+	d := Dict{}
+	if s.Name != "" {
+		d.Set(attributeName, s.Name)
+	}
+	if s.Hidden {
+		d.Set(attributeHidden, "1")
+	}
+	if t := s.Transform; t != nil {
+		d.Set(attributeTransform, formatFloats(t.Position.X, t.Position.Y, t.Position.Z, t.Rotation.X, t.Rotation.Y, t.Rotation.Z, t.Scale.X, t.Scale.Y, t.Scale.Z))
+	}
+	// also marks nodes exported from .3zh files
+	d.Set(attributePivot, formatVec3(shapePivot(s).shapefile()))
+	if b := s.CollisionBox; b != nil {
+		d.Set(attributeCollisionBox, formatFloats(b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z))
+	}
+	for _, p := range s.Points {
+		d.Set(attributePoint+p.Name, formatVec3(p.Value))
+	}
+	for _, p := range s.PointRotations {
+		d.Set(attributePointRotation+p.Name, formatVec3(p.Value))
+	}
+	if len(s.BakedLighting) > 0 {
+		report.lost("baked lighting (computed again by the engine)")
+	}
+	if len(s.Unknown) > 0 {
+		report.lost("shape %q: %d unknown sub-chunks", shapeName(s), len(s.Unknown))
+	}
+	return d
+}
+
+func shapeName(s *shapefile.Shape) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("#%d", s.ID)
+}
+
+// nearestColor returns the index of the closest color, ignoring emissive ones
+// when the color isn't emissive, and the other way around, if possible.
+func nearestColor(colors []paletteColor, c paletteColor) int {
+	nearest, nearestDistance := 0, math.Inf(1)
+	for i, other := range colors {
+		d := 0.0
+		for _, diff := range []int{int(c.color.R) - int(other.color.R), int(c.color.G) - int(other.color.G), int(c.color.B) - int(other.color.B), int(c.color.A) - int(other.color.A)} {
+			d += float64(diff * diff)
+		}
+		if c.emissive != other.emissive {
+			d += 4 * 255 * 255
+		}
+		if d < nearestDistance {
+			nearest, nearestDistance = i, d
+		}
+	}
+	return nearest
+}
+
+// ToShapefile converts a .vox file to .3zh.
+func ToShapefile(v *File) (*shapefile.File, *Report, error) {
+
+	report := &Report{}
+
+	if len(v.Models) == 0 {
+		return nil, nil, ErrNoShape
+	}
+
+	for _, c := range v.Chunks {
+		report.lost("%s chunk", c.ID)
+	}
+
+	// palette goes up to the highest color used
+	used := 0
+	for _, m := range v.Models {
+		for _, voxel := range m.Voxels {
+			used = max(used, int(voxel.Index))
+		}
+	}
+	palette := &shapefile.Palette{Colors: make([]shapefile.Color, used), Emissive: make([]bool, used)}
+	for i := 1; i <= used; i++ {
+		c := v.Color(uint8(i))
+		palette.Colors[i-1] = shapefile.Color{R: c.R, G: c.G, B: c.B, A: c.A}
+
+		material := v.Material(i)
+		if material == nil {
+			continue
+		}
+		switch materialType, _ := material.Properties.Get(materialType); materialType {
+		case "", "_diffuse":
+		case materialEmissive:
+			palette.Emissive[i-1] = true
+		case materialGlass:
+			// MagicaVoxel keeps alpha at 255, using transparency instead
+			if c.A == 255 {
+				if value, ok := material.Properties.Get(materialTransparency); ok {
+					if transparency, ok := parseFloats(value, 1); ok {
+						palette.Colors[i-1].A = uint8(math.Round(float64(255 * (1 - min(max(transparency[0], 0), 1)))))
+					}
+				}
+			}
+		default:
+			report.lost("%s materials", strings.TrimPrefix(materialType, "_"))
+		}
+	}
+
+	f := &shapefile.File{Chunks: []shapefile.Chunk{}}
+	shapes := make([]*shapefile.Shape, 0)
+	add := func(s *shapefile.Shape, parent *shapefile.Shape) {
+		shapes = append(shapes, s)
+		s.ID = uint16(len(shapes))
+		if parent != nil {
+			s.ParentID = parent.ID
+		}
+		f.Chunks = append(f.Chunks, s)
+	}
+
+	newShape := func(modelID int32) (*shapefile.Shape, error) {
+		if modelID < 0 || int(modelID) >= len(v.Models) {
+			return nil, fmt.Errorf("%w: model %d doesn't exist", ErrInvalidChunk, modelID)
+		}
+		m := v.Models[modelID]
+		if m.SizeX > math.MaxUint16 || m.SizeY > math.MaxUint16 || m.SizeZ > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: model %d is too large", ErrInvalidChunk, modelID)
+		}
+		s := shapefile.NewShape(uint16(m.SizeX), uint16(m.SizeZ), uint16(m.SizeY))
+		for _, voxel := range m.Voxels {
+			if voxel.Index == 0 || int32(voxel.X) >= m.SizeX || int32(voxel.Y) >= m.SizeY || int32(voxel.Z) >= m.SizeZ {
+				report.lost("model %d: invalid voxels", modelID)
+				continue
+			}
+			s.SetBlock(int(voxel.X), int(voxel.Z), int(voxel.Y), voxel.Index-1)
+		}
+		return s, nil
+	}
+
+	hiddenLayers := make(map[int32]bool)
+	for _, l := range v.Layers {
+		if hidden, _ := l.Attributes.Get(attributeHidden); hidden == "1" {
+			hiddenLayers[l.ID] = true
+		}
+	}
+
+	// files without scene graph have all models at the origin
+	rootNode, ok := v.Node(0).(*TransformNode)
+	if !ok {
+		var root *shapefile.Shape
+		for i := range v.Models {
+			s, err := newShape(int32(i))
+			if err != nil {
+				return nil, nil, err
+			}
+			s.Pivot = &shapefile.Vec3{}
+			*s.Pivot = center(s).shapefile()
+			if root == nil {
+				root = s
+				root.Palette = palette
+				add(root, nil)
+				continue
+			}
+			s.Transform = &shapefile.Transform{Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+			add(s, root)
+		}
+		return f, report, nil
+	}
+
+	visited := make(map[int32]bool)
+	var convert func(t *TransformNode, parent *shapefile.Shape) error
+	convert = func(t *TransformNode, parent *shapefile.Shape) error {
+		if visited[t.ID] {
+			return fmt.Errorf("%w: node %d is referenced twice", ErrInvalidChunk, t.ID)
+		}
+		visited[t.ID] = true
+
+		r, translation, err := t.Frame()
+		if err != nil {
+			return fmt.Errorf("%w: node %d: %v", ErrInvalidChunk, t.ID, err)
+		}
+		if len(t.Frames) > 1 {
+			report.lost("animations (only the first frame is kept)")
+		}
+
+		var s *shapefile.Shape
+		grouped := false
+		// transform node of the shape's own model, for groups
+		var modelTransform *TransformNode
+		children := make([]*TransformNode, 0)
+
+		switch child := v.Node(t.ChildID).(type) {
+		case *ShapeNode:
+			if len(child.Models) == 0 {
+				return fmt.Errorf("%w: shape node %d has no model", ErrInvalidChunk, child.ID)
+			}
+			if len(child.Models) > 1 {
+				report.lost("animations (only the first frame is kept)")
+			}
+			if s, err = newShape(child.Models[0].ModelID); err != nil {
+				return err
+			}
+		case *GroupNode:
+			grouped = true
+			name, _ := t.Attributes.Get(attributeName)
+			for _, id := range child.ChildIDs {
+				childTransform, ok := v.Node(id).(*TransformNode)
+				if !ok {
+					return fmt.Errorf("%w: group %d child %d is not a transform node", ErrInvalidChunk, child.ID, id)
+				}
+				childName, _ := childTransform.Attributes.Get(attributeName)
+				childRotation, _, _ := childTransform.Frame()
+				shapeNode, isShape := v.Node(childTransform.ChildID).(*ShapeNode)
+				if modelTransform == nil && len(children) == 0 && isShape && childName == name && childRotation == IdentityRotation && len(shapeNode.Models) > 0 {
+					modelTransform = childTransform
+					if s, err = newShape(shapeNode.Models[0].ModelID); err != nil {
+						return err
+					}
+					visited[childTransform.ID] = true
+					continue
+				}
+				children = append(children, childTransform)
+			}
+			if s == nil {
+				s = shapefile.NewShape(1, 1, 1)
+			}
+		default:
+			return fmt.Errorf("%w: transform node %d child %d doesn't exist", ErrInvalidChunk, t.ID, t.ChildID)
+		}
+
+		s.Name, _ = t.Attributes.Get(attributeName)
+		hidden, _ := t.Attributes.Get(attributeHidden)
+		s.Hidden = hidden == "1" || hiddenLayers[t.LayerID]
+
+		// pivot and transform, from the node's frame
+		m := cubzhMatrix(r)
+		position := newVec3(shapefile.Vec3{X: float32(translation[0]), Y: float32(translation[2]), Z: float32(translation[1])})
+		pivot := center(s)
+		if modelTransform != nil {
+			_, modelTranslation, err := modelTransform.Frame()
+			if err != nil {
+				return fmt.Errorf("%w: node %d: %v", ErrInvalidChunk, modelTransform.ID, err)
+			}
+			pivot = pivot.sub(vec3{float64(modelTranslation[0]), float64(modelTranslation[2]), float64(modelTranslation[1])})
+		}
+		rotation, scale := cubzhRotation(m)
+		s.Transform = &shapefile.Transform{Position: position.shapefile(), Rotation: rotation, Scale: scale}
+		s.Pivot = &shapefile.Vec3{}
+		*s.Pivot = pivot.shapefile()
+
+		// exact values, if the node didn't move since it was exported
+		applyShapeAttributes(s, t, modelTransform, grouped)
+
+		if parent == nil {
+			if r != IdentityRotation {
+				report.lost("root shape rotation")
+			}
+			s.Transform = nil
+			s.Palette = palette
+		}
+		add(s, parent)
+
+		for _, child := range children {
+			if err := convert(child, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the root group is skipped when it contains only one node
+	root := rootNode
+	if group, ok := v.Node(rootNode.ChildID).(*GroupNode); ok && len(group.ChildIDs) == 1 {
+		if child, ok := v.Node(group.ChildIDs[0]).(*TransformNode); ok {
+			if r, t, err := rootNode.Frame(); err == nil && r == IdentityRotation && t == [3]int32{} {
+				root = child
+			}
+		}
+	}
+	if err := convert(root, nil); err != nil {
+		return nil, nil, err
+	}
+
+	return f, report, nil
+}
+
+// applyShapeAttributes sets values exported in node attributes. Transform
+// and pivot are only used if they give the transform node's frame,
+// for nodes exported from .3zh files.
+func applyShapeAttributes(s *shapefile.Shape, t, modelTransform *TransformNode, grouped bool) {
+
+	for _, kv := range t.Attributes {
+		if name, ok := strings.CutPrefix(kv.Key, attributePoint); ok {
+			if value, ok := parseVec3(kv.Value); ok {
+				s.Points = append(s.Points, shapefile.Point{Name: name, Value: value})
+			}
+		} else if name, ok := strings.CutPrefix(kv.Key, attributePointRotation); ok {
+			if value, ok := parseVec3(kv.Value); ok {
+				s.PointRotations = append(s.PointRotations, shapefile.Point{Name: name, Value: value})
+			}
+		}
+	}
+
+	if value, ok := t.Attributes.Get(attributeCollisionBox); ok {
+		if b, ok := parseFloats(value, 6); ok {
+			s.CollisionBox = &shapefile.Box{Min: shapefile.Vec3{X: b[0], Y: b[1], Z: b[2]}, Max: shapefile.Vec3{X: b[3], Y: b[4], Z: b[5]}}
+		}
+	}
+
+	value, ok := t.Attributes.Get(attributePivot)
+	if !ok {
+		return
+	}
+	pivot, ok := parseVec3(value)
+	if !ok {
+		return
+	}
+	exported := &shapefile.Shape{Width: s.Width, Height: s.Height, Depth: s.Depth, Pivot: &pivot}
+	if value, ok := t.Attributes.Get(attributeTransform); ok {
+		if v, ok := parseFloats(value, 9); ok {
+			exported.Transform = &shapefile.Transform{
+				Position: shapefile.Vec3{X: v[0], Y: v[1], Z: v[2]},
+				Rotation: shapefile.Vec3{X: v[3], Y: v[4], Z: v[5]},
+				Scale:    shapefile.Vec3{X: v[6], Y: v[7], Z: v[8]},
+			}
+		}
+	}
+	r, translation, err := t.Frame()
+	if err != nil {
+		return
+	}
+	exportedRotation, exportedTranslation, _, _ := shapeFrame(exported, grouped)
+	if exportedRotation != r || exportedTranslation != translation {
+		return
+	}
+	if modelTransform != nil {
+		_, modelTranslation, err := modelTransform.Frame()
+		if exportedModelTranslation, _ := modelFrame(exported); err != nil || exportedModelTranslation != modelTranslation {
+			return
+		}
+	}
+
+	s.Transform = exported.Transform
+	if s.Transform == nil {
+		s.Transform = &shapefile.Transform{Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	}
+	s.Pivot = exported.Pivot
+}
@@ -0,0 +1,418 @@
+package vox
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cu.bzh/tools/shapefile"
+	"cu.bzh/tools/shapefile/internal/testutil"
+)
+
+var (
+	red    = shapefile.Color{R: 255, A: 255}
+	blue   = shapefile.Color{B: 255, A: 255}
+	glass  = shapefile.Color{G: 255, A: 128}
+	yellow = shapefile.Color{R: 255, G: 255, A: 255}
+)
+
+// newLosslessFile returns a file with a hierarchy of shapes, all placed
+// on whole blocks with axis aligned rotations, so that they can be
+// converted without loss.
+func newLosslessFile() *shapefile.File {
+	body := shapefile.NewShape(4, 2, 3)
+	body.ID = 1
+	body.Name = "body"
+	body.Pivot = &shapefile.Vec3{X: 1, Y: 0, Z: 1}
+	body.Palette = &shapefile.Palette{
+		Colors:   []shapefile.Color{red, glass, yellow},
+		Emissive: []bool{false, false, true},
+	}
+	body.SetBlock(0, 0, 0, 0)
+	body.SetBlock(3, 1, 2, 1)
+	body.SetBlock(2, 0, 1, 2)
+	body.Points = []shapefile.Point{{Name: "ModelPoint_Hand", Value: shapefile.Vec3{X: 1, Y: 2, Z: 3}}}
+	body.PointRotations = []shapefile.Point{{Name: "ModelPoint_Hand", Value: shapefile.Vec3{Y: 1.5}}}
+	body.CollisionBox = &shapefile.Box{Max: shapefile.Vec3{X: 4, Y: 1, Z: 3}}
+
+	// own palette, sharing a color with its parent
+	head := shapefile.NewShape(2, 2, 2)
+	head.ID = 2
+	head.ParentID = 1
+	head.Name = "head"
+	head.Transform = &shapefile.Transform{Position: shapefile.Vec3{Y: 3}, Rotation: shapefile.Vec3{Y: math.Pi / 2}, Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	head.Palette = &shapefile.Palette{Colors: []shapefile.Color{blue, red}, Emissive: []bool{false, false}}
+	head.SetBlock(0, 0, 0, 0)
+	head.SetBlock(1, 1, 0, 1)
+
+	hat := shapefile.NewShape(2, 1, 2)
+	hat.ID = 3
+	hat.ParentID = 2
+	hat.Name = "hat"
+	hat.Hidden = true
+	hat.Pivot = &shapefile.Vec3{}
+	hat.Transform = &shapefile.Transform{Position: shapefile.Vec3{Y: 2}, Rotation: shapefile.Vec3{X: math.Pi / 2}, Scale: shapefile.Vec3{X: 1, Y: 1, Z: 1}}
+	hat.SetBlock(1, 0, 0, 0)
+	hat.SetBlock(0, 0, 1, 2)
+
+	// mirrored
+	arm := shapefile.NewShape(1, 2, 1)
+	arm.ID = 4
+	arm.ParentID = 1
+	arm.Name = "arm"
+	arm.Pivot = &shapefile.Vec3{}
+	arm.Transform = &shapefile.Transform{Position: shapefile.Vec3{X: 3}, Scale: shapefile.Vec3{X: -1, Y: 1, Z: 1}}
+	arm.SetBlock(0, 1, 0, 0)
+
+	return &shapefile.File{Chunks: []shapefile.Chunk{body, head, hat, arm}}
+}
+
+// convert converts a .3zh file to .vox and back, through .vox bytes.
+func convert(t *testing.T, f *shapefile.File, edit func(v *File)) (*shapefile.File, *Report) {
+	t.Helper()
+
+	v, _, err := FromShapefile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(v)
+	}
+	decoded, err := Decode(bytes.NewReader(encode(t, v)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, report, err := ToShapefile(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return converted, report
+}
+
+// block is a block's color, in a file.
+type block struct {
+	color    shapefile.Color
+	emissive bool
+}
+
+func blockAt(f *shapefile.File, s *shapefile.Shape, x, y, z int) block {
+	index := s.Block(x, y, z)
+	p := shapePalette(f, s)
+	if index == shapefile.AirBlock || p == nil || int(index) >= len(p.Colors) {
+		return block{}
+	}
+	return block{color: p.Colors[index], emissive: p.Emissive[index]}
+}
+
+// worldBlocks returns blocks by world position of their center, in half blocks.
+// The root shape's transform is ignored, like the engine does.
+func worldBlocks(f *shapefile.File) map[[3]int]block {
+	blocks := make(map[[3]int]block)
+	for _, s := range f.Shapes() {
+		for x := 0; x < int(s.Width); x++ {
+			for y := 0; y < int(s.Height); y++ {
+				for z := 0; z < int(s.Depth); z++ {
+					b := blockAt(f, s, x, y, z)
+					if b == (block{}) {
+						continue
+					}
+					p := vec3{float64(x) + 0.5, float64(y) + 0.5, float64(z) + 0.5}.sub(shapePivot(s))
+					for shape := s; f.Parent(shape) != nil; shape = f.Parent(shape) {
+						if shape.Transform != nil {
+							p = apply(shape.Transform.Matrix(), p).add(newVec3(shape.Transform.Position))
+						}
+					}
+					blocks[[3]int{int(math.Round(2 * p[0])), int(math.Round(2 * p[1])), int(math.Round(2 * p[2]))}] = b
+				}
+			}
+		}
+	}
+	return blocks
+}
+
+// compareStructure compares names and hierarchies.
+func compareStructure(t *testing.T, got, want *shapefile.File) {
+	t.Helper()
+	gotShapes, wantShapes := got.Shapes(), want.Shapes()
+	if len(gotShapes) != len(wantShapes) {
+		t.Fatalf("%d shapes, want %d", len(gotShapes), len(wantShapes))
+	}
+	for i, s := range gotShapes {
+		w := wantShapes[i]
+		if s.ID != w.ID || s.ParentID != w.ParentID || s.Name != w.Name || s.Hidden != w.Hidden {
+			t.Errorf("shape %d: id %d parent %d name %q hidden %v, want id %d parent %d name %q hidden %v",
+				i, s.ID, s.ParentID, s.Name, s.Hidden, w.ID, w.ParentID, w.Name, w.Hidden)
+		}
+	}
+	if !reflect.DeepEqual(worldBlocks(got), worldBlocks(want)) {
+		t.Errorf("blocks are not at the same place:\n got: %v\nwant: %v", worldBlocks(got), worldBlocks(want))
+	}
+}
+
+// compareShapes compares everything that is kept in conversions.
+func compareShapes(t *testing.T, got, want *shapefile.File) {
+	t.Helper()
+	compareStructure(t, got, want)
+
+	for i, s := range got.Shapes() {
+		w := want.Shapes()[i]
+		if s.Width != w.Width || s.Height != w.Height || s.Depth != w.Depth {
+			t.Errorf("shape %q: size %dx%dx%d, want %dx%dx%d", s.Name, s.Width, s.Height, s.Depth, w.Width, w.Height, w.Depth)
+			continue
+		}
+		for x := 0; x < int(s.Width); x++ {
+			for y := 0; y < int(s.Height); y++ {
+				for z := 0; z < int(s.Depth); z++ {
+					if b, wb := blockAt(got, s, x, y, z), blockAt(want, w, x, y, z); b != wb {
+						t.Errorf("shape %q: block %d %d %d is %v, want %v", s.Name, x, y, z, b, wb)
+					}
+				}
+			}
+		}
+		if shapePivot(s) != shapePivot(w) {
+			t.Errorf("shape %q: pivot %v, want %v", s.Name, shapePivot(s), shapePivot(w))
+		}
+		if !reflect.DeepEqual(s.Transform, w.Transform) {
+			t.Errorf("shape %q: transform %+v, want %+v", s.Name, s.Transform, w.Transform)
+		}
+		if !reflect.DeepEqual(s.Points, w.Points) || !reflect.DeepEqual(s.PointRotations, w.PointRotations) {
+			t.Errorf("shape %q: points %v %v, want %v %v", s.Name, s.Points, s.PointRotations, w.Points, w.PointRotations)
+		}
+		if !reflect.DeepEqual(s.CollisionBox, w.CollisionBox) {
+			t.Errorf("shape %q: collision box %v, want %v", s.Name, s.CollisionBox, w.CollisionBox)
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	f := newLosslessFile()
+	converted, report := convert(t, f, nil)
+	compareShapes(t, converted, f)
+	if len(report.Lost) > 0 {
+		t.Errorf("lost: %v", report.Lost)
+	}
+}
+
+func TestConvertWithoutAttributes(t *testing.T) {
+	f := newLosslessFile()
+
+	// MagicaVoxel may not keep attributes it doesn't know
+	converted, _ := convert(t, f, func(v *File) {
+		for _, n := range v.Nodes {
+			if t, ok := n.(*TransformNode); ok {
+				attributes := Dict{}
+				for _, kv := range t.Attributes {
+					if !strings.HasPrefix(kv.Key, "_cubzh_") {
+						attributes = append(attributes, kv)
+					}
+				}
+				t.Attributes = attributes
+			}
+		}
+	})
+
+	compareStructure(t, converted, f)
+}
+
+func TestConvertMovedNode(t *testing.T) {
+	f := newLosslessFile()
+
+	// moving the hat in MagicaVoxel moves it in Cubzh
+	converted, _ := convert(t, f, func(v *File) {
+		for _, n := range v.Nodes {
+			if t, ok := n.(*TransformNode); ok {
+				if name, _ := t.Attributes.Get("_name"); name == "hat" {
+					r, translation, _ := t.Frame()
+					translation[2] += 2
+					t.SetFrame(r, translation)
+				}
+			}
+		}
+	})
+
+	// exported values are ignored, the hat is now placed relative to its center
+	hat := converted.Shapes()[2]
+	if hat.Name != "hat" || hat.Transform.Position != (shapefile.Vec3{X: 1, Y: 3}) || *hat.Pivot != (shapefile.Vec3{X: 1, Z: 1}) {
+		t.Errorf("hat transform: %+v, pivot %v", hat.Transform, hat.Pivot)
+	}
+}
+
+func TestConvertReport(t *testing.T) {
+	f := testutil.Chest()
+	lid := f.Shapes()[1]
+	lid.Transform.Position.X = 0.25
+
+	v, report, err := FromShapefile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"preview image",
+		"unknown chunk 12",
+		`shape "chest": pivot rounded to whole blocks`,
+		"baked lighting (computed again by the engine)",
+		`shape "lid": rotation and scale (not axis aligned)`,
+		`shape "lid": position rounded to whole blocks`,
+		`shape "#3": rotation and scale (not axis aligned)`,
+	}
+	for _, lost := range expected {
+		if !strings.Contains(report.String(), lost) {
+			t.Errorf("report doesn't contain %q:\n%s", lost, report)
+		}
+	}
+
+	// exact values are found back
+	converted, _, err := ToShapefile(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(converted.Shapes()[1].Transform, lid.Transform) {
+		t.Errorf("lid transform %+v, want %+v", converted.Shapes()[1].Transform, lid.Transform)
+	}
+}
+
+func TestConvertPalettes(t *testing.T) {
+	f := newLosslessFile()
+	v, _, err := FromShapefile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// root palette, followed by new colors of other palettes
+	expected := []shapefile.Color{red, glass, yellow, blue}
+	for i, c := range expected {
+		if got := v.Color(uint8(i + 1)); got.R != c.R || got.G != c.G || got.B != c.B || got.A != c.A {
+			t.Errorf("color %d: %v, want %v", i+1, got, c)
+		}
+	}
+	if m := v.Material(2); m == nil || !reflect.DeepEqual(m.Properties, Dict{{"_type", "_glass"}, {"_trans", "0.4980392"}}) {
+		t.Errorf("glass material: %+v", m)
+	}
+	if m := v.Material(3); m == nil || !reflect.DeepEqual(m.Properties, Dict{{"_type", "_emit"}, {"_emit", "1"}}) {
+		t.Errorf("emissive material: %+v", m)
+	}
+	if v.Material(1) != nil {
+		t.Errorf("material for opaque color")
+	}
+
+	// all colors are used, others are replaced by similar ones
+	s := f.Shapes()[1]
+	s.Palette = &shapefile.Palette{}
+	for i := 0; i < 255; i++ {
+		s.Palette.Colors = append(s.Palette.Colors, shapefile.Color{R: uint8(i), G: 1, A: 255})
+		s.Palette.Emissive = append(s.Palette.Emissive, false)
+	}
+	_, report, err := FromShapefile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "replaced by a similar one") {
+		t.Errorf("report: %s", report)
+	}
+}
+
+func TestConvertMagicaVoxelFile(t *testing.T) {
+	f, report, err := ToShapefile(newMagicaVoxelFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lost := range []string{"rCAM chunk", "metal materials"} {
+		if !strings.Contains(report.String(), lost) {
+			t.Errorf("report doesn't contain %q:\n%s", lost, report)
+		}
+	}
+
+	// the first model, without name, is the root group's own model
+	shapes := f.Shapes()
+	if len(shapes) != 2 || shapes[1].ParentID != 1 || shapes[1].Name != "hat" || !shapes[1].Hidden {
+		t.Fatalf("shapes: %+v", shapes)
+	}
+	if shapes[0].Width != 2 || shapes[0].Height != 4 || shapes[0].Depth != 3 {
+		t.Errorf("root size: %dx%dx%d", shapes[0].Width, shapes[0].Height, shapes[0].Depth)
+	}
+
+	// default palette, with glass transparency
+	palette := shapes[0].Palette
+	if len(palette.Colors) != 3 || palette.Colors[0] != (shapefile.Color{R: 255, G: 255, B: 255, A: 255}) || palette.Colors[1].A != 128 {
+		t.Errorf("palette: %+v", palette)
+	}
+
+	blocks := worldBlocks(f)
+	expected := map[[3]int]shapefile.Color{
+		// root voxel 0 0 0 (centered on 1 1 2, moved by 0 0 2)
+		{-1, 1, -1}: palette.Colors[0],
+		// hat, rotated and moved by 5 -1 3
+		{9, 7, -1}: palette.Colors[2],
+	}
+	for position, c := range expected {
+		if blocks[position].color != c {
+			t.Errorf("block at %v: %v, want %v", position, blocks[position], c)
+		}
+	}
+}
+
+func TestConvertWithoutSceneGraph(t *testing.T) {
+	v := newMagicaVoxelFile()
+	v.Nodes = nil
+
+	f, _, err := ToShapefile(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes := f.Shapes()
+	if len(shapes) != 2 || shapes[1].ParentID != 1 {
+		t.Fatalf("shapes: %+v", shapes)
+	}
+	// both models are centered at the origin
+	if _, ok := worldBlocks(f)[[3]int{-1, -3, -1}]; !ok {
+		t.Errorf("blocks: %v", worldBlocks(f))
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	if _, _, err := FromShapefile(&shapefile.File{}); err != ErrNoShape {
+		t.Errorf("no shape: %v", err)
+	}
+	if _, _, err := ToShapefile(&File{}); err != ErrNoShape {
+		t.Errorf("no model: %v", err)
+	}
+
+	f := newLosslessFile()
+	f.Chunks = append(f.Chunks, shapefile.NewShape(300, 1, 1))
+	f.Shapes()[4].ID = 5
+	f.Shapes()[4].ParentID = 1
+	if _, _, err := FromShapefile(f); err == nil {
+		t.Errorf("large shape converted")
+	}
+
+	v := newMagicaVoxelFile()
+	v.Nodes[3].(*ShapeNode).Models[0].ModelID = 12
+	if _, _, err := ToShapefile(v); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("missing model: %v", err)
+	}
+
+	v = newMagicaVoxelFile()
+	v.Nodes[1].(*GroupNode).ChildIDs = []int32{2, 2}
+	if _, _, err := ToShapefile(v); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("node referenced twice: %v", err)
+	}
+}
+
+func TestConvertBundleShapes(t *testing.T) {
+	shapes := testutil.BundleShapes(t)
+
+	for _, s := range shapes {
+		t.Run(s.Name, func(t *testing.T) {
+			f, err := shapefile.Decode(bytes.NewReader(s.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			converted, _ := convert(t, f, nil)
+			compareShapes(t, converted, f)
+		})
+	}
+}
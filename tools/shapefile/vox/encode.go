@@ -0,0 +1,153 @@
+package vox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encode writes a .vox file.
+func Encode(w io.Writer, f *File) error {
+
+	main := &writer{}
+
+	for i, m := range f.Models {
+		if m.SizeX < 0 || m.SizeY < 0 || m.SizeZ < 0 || m.SizeX > MaxModelSize || m.SizeY > MaxModelSize || m.SizeZ > MaxModelSize {
+			return fmt.Errorf("model %d: invalid size %dx%dx%d", i, m.SizeX, m.SizeY, m.SizeZ)
+		}
+		c := &writer{}
+		c.i32(m.SizeX)
+		c.i32(m.SizeY)
+		c.i32(m.SizeZ)
+		main.chunk("SIZE", c)
+
+		c = &writer{}
+		c.i32(int32(len(m.Voxels)))
+		for _, v := range m.Voxels {
+			c.Write([]byte{v.X, v.Y, v.Z, v.Index})
+		}
+		main.chunk("XYZI", c)
+	}
+
+	for _, n := range f.Nodes {
+		c := &writer{}
+		switch n := n.(type) {
+		case *TransformNode:
+			c.i32(n.ID)
+			c.dict(n.Attributes)
+			c.i32(n.ChildID)
+			c.i32(n.ReservedID)
+			c.i32(n.LayerID)
+			c.i32(int32(len(n.Frames)))
+			for _, frame := range n.Frames {
+				c.dict(frame)
+			}
+			main.chunk("nTRN", c)
+		case *GroupNode:
+			c.i32(n.ID)
+			c.dict(n.Attributes)
+			c.i32(int32(len(n.ChildIDs)))
+			for _, id := range n.ChildIDs {
+				c.i32(id)
+			}
+			main.chunk("nGRP", c)
+		case *ShapeNode:
+			c.i32(n.ID)
+			c.dict(n.Attributes)
+			c.i32(int32(len(n.Models)))
+			for _, m := range n.Models {
+				c.i32(m.ModelID)
+				c.dict(m.Attributes)
+			}
+			main.chunk("nSHP", c)
+		default:
+			return fmt.Errorf("unknown node type: %T", n)
+		}
+	}
+
+	for _, l := range f.Layers {
+		c := &writer{}
+		c.i32(l.ID)
+		c.dict(l.Attributes)
+		c.i32(l.ReservedID)
+		main.chunk("LAYR", c)
+	}
+
+	if f.Palette != nil {
+		c := &writer{}
+		for i := 1; i < 256; i++ {
+			p := f.Palette[i]
+			c.Write([]byte{p.R, p.G, p.B, p.A})
+		}
+		c.Write([]byte{0, 0, 0, 0})
+		main.chunk("RGBA", c)
+	}
+
+	for _, m := range f.Materials {
+		c := &writer{}
+		c.i32(m.ID)
+		c.dict(m.Properties)
+		main.chunk("MATL", c)
+	}
+
+	for _, chunk := range f.Chunks {
+		main.rawChunk(chunk.ID, chunk.Content, chunk.Children)
+	}
+
+	version := f.Version
+	if version == 0 {
+		version = Version
+	}
+
+	out := &writer{}
+	out.WriteString(MagicBytes)
+	out.i32(version)
+	out.rawChunk("MAIN", nil, main.Bytes())
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// WriteFile writes a .vox file at path.
+func WriteFile(path string, f *File) error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, f); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) i32(v int32) {
+	binary.Write(w, binary.LittleEndian, v)
+}
+
+func (w *writer) str(s string) {
+	w.i32(int32(len(s)))
+	w.WriteString(s)
+}
+
+func (w *writer) dict(d Dict) {
+	w.i32(int32(len(d)))
+	for _, kv := range d {
+		w.str(kv.Key)
+		w.str(kv.Value)
+	}
+}
+
+func (w *writer) chunk(id string, content *writer) {
+	w.rawChunk(id, content.Bytes(), nil)
+}
+
+func (w *writer) rawChunk(id string, content, children []byte) {
+	w.WriteString(id)
+	w.i32(int32(len(content)))
+	w.i32(int32(len(children)))
+	w.Write(content)
+	w.Write(children)
+}
@@ -0,0 +1,498 @@
+// Package vox reads and writes MagicaVoxel .vox files,
+// and converts them from and to .3zh files.
+//
+// Format: https://github.com/ephtracy/voxel-model/blob/master/MagicaVoxel-file-format-vox.txt
+// and https://github.com/ephtracy/voxel-model/blob/master/MagicaVoxel-file-format-vox-extension.txt
+//
+// A file starts with "VOX " and a version (int32), followed by the MAIN chunk.
+// Chunks have a 4 characters ID, a content size, a children size (int32),
+// then the content and children chunks. All MAIN children are listed at
+// the same level: models (SIZE then XYZI chunks), scene graph nodes
+// (nTRN, nGRP, nSHP), layers (LAYR), palette (RGBA), materials (MATL),
+// and others that are kept as is (rOBJ, rCAM, NOTE, IMAP...).
+package vox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	MagicBytes = "VOX "
+	// Version written by MagicaVoxel since scene graphs were added
+	Version = 200
+	// MaxModelSize is the maximum size of models, on each axis
+	MaxModelSize = 256
+)
+
+var (
+	ErrInvalidMagic = errors.New("not a .vox file")
+	ErrInvalidChunk = errors.New("invalid chunk")
+)
+
+// File is a decoded .vox file.
+type File struct {
+	Version int32
+	Models  []*Model
+	// Nodes are scene graph nodes (*TransformNode, *GroupNode, *ShapeNode),
+	// the root is the transform node with ID 0. Files without scene graph
+	// have models placed at the origin.
+	Nodes  []Node
+	Layers []*Layer
+	// Palette is indexed by color index, 0 being empty.
+	// nil when the file uses the default palette.
+	Palette   *[256]color.NRGBA
+	Materials []*Material
+	// Chunks that are not decoded, written back after known chunks
+	Chunks []*Chunk
+}
+
+// Model is a SIZE chunk, followed by a XYZI chunk.
+// Z is up in MagicaVoxel.
+type Model struct {
+	SizeX, SizeY, SizeZ int32
+	Voxels              []Voxel
+}
+
+// Voxel has a color index, from 1 to 255.
+type Voxel struct {
+	X, Y, Z, Index uint8
+}
+
+// Dict is a list of key value pairs, kept in file order.
+type Dict []KeyValue
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Get returns the value of a key, and whether it's set.
+func (d Dict) Get(key string) (string, bool) {
+	for _, kv := range d {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of a key, adding it at the end if not set.
+func (d *Dict) Set(key, value string) {
+	for i, kv := range *d {
+		if kv.Key == key {
+			(*d)[i].Value = value
+			return
+		}
+	}
+	*d = append(*d, KeyValue{Key: key, Value: value})
+}
+
+// Node is a scene graph node.
+type Node interface {
+	NodeID() int32
+}
+
+// TransformNode is a nTRN chunk. Frames have _r (rotation),
+// _t (translation) and _f (frame index) attributes.
+type TransformNode struct {
+	ID         int32
+	Attributes Dict
+	ChildID    int32
+	ReservedID int32
+	LayerID    int32
+	Frames     []Dict
+}
+
+// GroupNode is a nGRP chunk.
+type GroupNode struct {
+	ID         int32
+	Attributes Dict
+	ChildIDs   []int32
+}
+
+// ShapeNode is a nSHP chunk.
+type ShapeNode struct {
+	ID         int32
+	Attributes Dict
+	Models     []ShapeModel
+}
+
+// ShapeModel references a model, by index in the file.
+type ShapeModel struct {
+	ModelID    int32
+	Attributes Dict
+}
+
+func (n *TransformNode) NodeID() int32 { return n.ID }
+func (n *GroupNode) NodeID() int32     { return n.ID }
+func (n *ShapeNode) NodeID() int32     { return n.ID }
+
+// Layer is a LAYR chunk, with _name and _hidden attributes.
+type Layer struct {
+	ID         int32
+	Attributes Dict
+	ReservedID int32
+}
+
+// Material is a MATL chunk. ID is the color index it applies to.
+// Properties include _type (_diffuse, _metal, _glass, _emit...).
+type Material struct {
+	ID         int32
+	Properties Dict
+}
+
+// Chunk is a chunk that is not decoded.
+type Chunk struct {
+	ID       string
+	Content  []byte
+	Children []byte
+}
+
+// Node returns the node with given ID, nil if there's none.
+func (f *File) Node(id int32) Node {
+	for _, n := range f.Nodes {
+		if n.NodeID() == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// Material returns the material of a color index, nil if there's none.
+func (f *File) Material(index int) *Material {
+	for _, m := range f.Materials {
+		if int(m.ID) == index {
+			return m
+		}
+	}
+	return nil
+}
+
+// Color returns the color of a color index,
+// from the default palette if the file has none.
+func (f *File) Color(index uint8) color.NRGBA {
+	if f.Palette != nil {
+		return f.Palette[index]
+	}
+	return DefaultPalette[index]
+}
+
+// Rotation is a rotation chunk attribute (_r), an axis aligned rotation
+// (or reflection), as a row major matrix where each row has one 1 or -1.
+// Bits 0-1: column of the first row, bits 2-3: column of the second row,
+// bit 4, 5 and 6: sign of rows 1, 2 and 3 (1 for -1).
+type Rotation uint8
+
+// IdentityRotation doesn't rotate.
+const IdentityRotation Rotation = 0x04
+
+// Matrix returns the rotation matrix, nil if the rotation is invalid.
+func (r Rotation) Matrix() *[3][3]int {
+	c0, c1 := int(r&0x03), int(r>>2&0x03)
+	if c0 == 3 || c1 == 3 || c0 == c1 {
+		return nil
+	}
+	c2 := 3 - c0 - c1
+	m := &[3][3]int{}
+	for row, col := range []int{c0, c1, c2} {
+		m[row][col] = 1
+		if r&(1<<(4+row)) != 0 {
+			m[row][col] = -1
+		}
+	}
+	return m
+}
+
+// NewRotation returns the rotation of a matrix,
+// false if it's not an axis aligned rotation.
+func NewRotation(m [3][3]int) (Rotation, bool) {
+	var r Rotation
+	used := [3]bool{}
+	for row := 0; row < 3; row++ {
+		col := -1
+		for c := 0; c < 3; c++ {
+			switch m[row][c] {
+			case 0:
+			case 1, -1:
+				if col != -1 {
+					return 0, false
+				}
+				col = c
+			default:
+				return 0, false
+			}
+		}
+		if col == -1 || used[col] {
+			return 0, false
+		}
+		used[col] = true
+		if row < 2 {
+			r |= Rotation(col << (2 * row))
+		}
+		if m[row][col] < 0 {
+			r |= 1 << (4 + row)
+		}
+	}
+	return r, true
+}
+
+// Frame returns the rotation and translation of a transform node's first frame.
+func (n *TransformNode) Frame() (Rotation, [3]int32, error) {
+	r := IdentityRotation
+	var t [3]int32
+	if len(n.Frames) == 0 {
+		return r, t, nil
+	}
+	if value, ok := n.Frames[0].Get("_r"); ok {
+		v, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || Rotation(v).Matrix() == nil {
+			return r, t, fmt.Errorf("invalid rotation: %q", value)
+		}
+		r = Rotation(v)
+	}
+	if value, ok := n.Frames[0].Get("_t"); ok {
+		fields := strings.Fields(value)
+		if len(fields) != 3 {
+			return r, t, fmt.Errorf("invalid translation: %q", value)
+		}
+		for i, field := range fields {
+			v, err := strconv.ParseInt(field, 10, 32)
+			if err != nil {
+				return r, t, fmt.Errorf("invalid translation: %q", value)
+			}
+			t[i] = int32(v)
+		}
+	}
+	return r, t, nil
+}
+
+// SetFrame sets the rotation and translation of a transform node, as its only frame.
+func (n *TransformNode) SetFrame(r Rotation, t [3]int32) {
+	frame := Dict{}
+	if r != IdentityRotation {
+		frame.Set("_r", strconv.Itoa(int(r)))
+	}
+	if t != [3]int32{} {
+		frame.Set("_t", fmt.Sprintf("%d %d %d", t[0], t[1], t[2]))
+	}
+	n.Frames = []Dict{frame}
+}
+
+// DefaultPalette is the palette of files without RGBA chunk.
+// It's a color cube (without black), followed by red, green,
+// blue and gray ramps.
+var DefaultPalette = func() (p [256]color.NRGBA) {
+	i := 1
+	cube := []uint8{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+	for _, r := range cube {
+		for _, g := range cube {
+			for _, b := range cube {
+				if r == 0 && g == 0 && b == 0 {
+					continue
+				}
+				p[i] = color.NRGBA{R: r, G: g, B: b, A: 255}
+				i++
+			}
+		}
+	}
+	ramp := []uint8{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for _, channel := range []color.NRGBA{{R: 1}, {G: 1}, {B: 1}, {R: 1, G: 1, B: 1}} {
+		for _, v := range ramp {
+			p[i] = color.NRGBA{R: channel.R * v, G: channel.G * v, B: channel.B * v, A: 255}
+			i++
+		}
+	}
+	return p
+}()
+
+// Decode reads a .vox file.
+func Decode(r io.Reader) (*File, error) {
+
+	br := bufio.NewReader(r)
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrInvalidMagic
+	}
+	if string(header[:4]) != MagicBytes {
+		return nil, ErrInvalidMagic
+	}
+
+	f := &File{Version: int32(binary.LittleEndian.Uint32(header[4:]))}
+
+	main, err := readChunk(br)
+	if err != nil {
+		return nil, err
+	}
+	if main.ID != "MAIN" {
+		return nil, fmt.Errorf("%w: %s instead of MAIN", ErrInvalidChunk, main.ID)
+	}
+
+	children := bytes.NewReader(main.Children)
+	var size *Model
+	for children.Len() > 0 {
+		c, err := readChunk(children)
+		if err != nil {
+			return nil, err
+		}
+		r := &reader{data: c.Content}
+
+		switch c.ID {
+		case "PACK":
+			// number of models, known from SIZE chunks
+		case "SIZE":
+			size = &Model{SizeX: r.i32(), SizeY: r.i32(), SizeZ: r.i32()}
+			if size.SizeX < 0 || size.SizeY < 0 || size.SizeZ < 0 {
+				return nil, fmt.Errorf("%w: negative model size", ErrInvalidChunk)
+			}
+		case "XYZI":
+			if size == nil {
+				return nil, fmt.Errorf("%w: XYZI without SIZE", ErrInvalidChunk)
+			}
+			n := r.i32()
+			if n < 0 || int(n) > len(r.data)/4 {
+				return nil, fmt.Errorf("%w: %d voxels", ErrInvalidChunk, n)
+			}
+			size.Voxels = make([]Voxel, n)
+			for i := range size.Voxels {
+				b := r.bytes(4)
+				size.Voxels[i] = Voxel{X: b[0], Y: b[1], Z: b[2], Index: b[3]}
+			}
+			f.Models = append(f.Models, size)
+			size = nil
+		case "nTRN":
+			n := &TransformNode{ID: r.i32(), Attributes: r.dict(), ChildID: r.i32(), ReservedID: r.i32(), LayerID: r.i32()}
+			frames := r.count()
+			for i := 0; i < frames; i++ {
+				n.Frames = append(n.Frames, r.dict())
+			}
+			f.Nodes = append(f.Nodes, n)
+		case "nGRP":
+			n := &GroupNode{ID: r.i32(), Attributes: r.dict()}
+			children := r.count()
+			for i := 0; i < children; i++ {
+				n.ChildIDs = append(n.ChildIDs, r.i32())
+			}
+			f.Nodes = append(f.Nodes, n)
+		case "nSHP":
+			n := &ShapeNode{ID: r.i32(), Attributes: r.dict()}
+			models := r.count()
+			for i := 0; i < models; i++ {
+				n.Models = append(n.Models, ShapeModel{ModelID: r.i32(), Attributes: r.dict()})
+			}
+			f.Nodes = append(f.Nodes, n)
+		case "LAYR":
+			f.Layers = append(f.Layers, &Layer{ID: r.i32(), Attributes: r.dict(), ReservedID: r.i32()})
+		case "RGBA":
+			f.Palette = &[256]color.NRGBA{}
+			for i := 0; i < 255; i++ {
+				b := r.bytes(4)
+				f.Palette[i+1] = color.NRGBA{R: b[0], G: b[1], B: b[2], A: b[3]}
+			}
+		case "MATL":
+			f.Materials = append(f.Materials, &Material{ID: r.i32(), Properties: r.dict()})
+		default:
+			f.Chunks = append(f.Chunks, c)
+		}
+
+		if r.err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidChunk, c.ID, r.err)
+		}
+	}
+
+	return f, nil
+}
+
+// ReadFile reads the .vox file at path.
+func ReadFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Decode(file)
+}
+
+func readChunk(r io.Reader) (*Chunk, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	c := &Chunk{ID: string(header[:4])}
+	contentSize := binary.LittleEndian.Uint32(header[4:])
+	childrenSize := binary.LittleEndian.Uint32(header[8:])
+
+	// don't allocate more than what's available
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, int64(contentSize)+int64(childrenSize))
+	if err != nil || n != int64(contentSize)+int64(childrenSize) {
+		return nil, fmt.Errorf("%w: %s: unexpected end of file", ErrInvalidChunk, c.ID)
+	}
+	data := buf.Bytes()
+	c.Content, c.Children = data[:contentSize], data[contentSize:]
+	return c, nil
+}
+
+// reader reads little endian values from chunk contents,
+// keeping the first error.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) i32() int32 {
+	return int32(binary.LittleEndian.Uint32(r.bytes(4)))
+}
+
+// count reads a number of elements, each using at least 4 bytes.
+func (r *reader) count() int {
+	n := r.i32()
+	if n < 0 || int(n) > len(r.data)/4 {
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid count: %d", n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (r *reader) str() string {
+	n := r.i32()
+	if n < 0 {
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid string size: %d", n)
+		}
+		return ""
+	}
+	return string(r.bytes(int(n)))
+}
+
+func (r *reader) dict() Dict {
+	n := r.count()
+	d := make(Dict, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		d = append(d, KeyValue{Key: r.str(), Value: r.str()})
+	}
+	return d
+}
@@ -0,0 +1,165 @@
+package vox
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// newMagicaVoxelFile returns a file like MagicaVoxel writes them:
+// two models in the root group, the second one rotated and moved.
+func newMagicaVoxelFile() *File {
+	f := &File{
+		Version: 150,
+		Models: []*Model{
+			{SizeX: 2, SizeY: 3, SizeZ: 4, Voxels: []Voxel{{0, 0, 0, 1}, {1, 2, 3, 2}}},
+			{SizeX: 1, SizeY: 1, SizeZ: 1, Voxels: []Voxel{{0, 0, 0, 3}}},
+		},
+		Nodes: []Node{
+			&TransformNode{ID: 0, Attributes: Dict{}, ChildID: 1, ReservedID: -1, LayerID: -1, Frames: []Dict{{}}},
+			&GroupNode{ID: 1, Attributes: Dict{}, ChildIDs: []int32{2, 4}},
+			&TransformNode{ID: 2, Attributes: Dict{}, ChildID: 3, ReservedID: -1, LayerID: 0, Frames: []Dict{{{"_t", "0 0 2"}}}},
+			&ShapeNode{ID: 3, Attributes: Dict{}, Models: []ShapeModel{{ModelID: 0, Attributes: Dict{}}}},
+			&TransformNode{ID: 4, Attributes: Dict{{"_name", "hat"}}, ChildID: 5, ReservedID: -1, LayerID: 1, Frames: []Dict{{{"_r", "17"}, {"_t", "5 -1 3"}}}},
+			&ShapeNode{ID: 5, Attributes: Dict{}, Models: []ShapeModel{{ModelID: 1, Attributes: Dict{}}}},
+		},
+		Layers: []*Layer{
+			{ID: 0, Attributes: Dict{{"_name", "0"}}, ReservedID: -1},
+			{ID: 1, Attributes: Dict{{"_name", "1"}, {"_hidden", "1"}}, ReservedID: -1},
+		},
+		Materials: []*Material{
+			{ID: 2, Properties: Dict{{"_type", "_glass"}, {"_trans", "0.5"}}},
+			{ID: 3, Properties: Dict{{"_type", "_metal"}, {"_rough", "0.1"}}},
+		},
+		Chunks: []*Chunk{{ID: "rCAM", Content: []byte{0, 0, 0, 0, 0, 0, 0, 0}, Children: []byte{}}},
+	}
+	return f
+}
+
+func encode(t *testing.T, f *File) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	f := newMagicaVoxelFile()
+	f.Palette = &DefaultPalette
+	data := encode(t, f)
+
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, f) {
+		t.Errorf("decoded file:\n got: %+v\nwant: %+v", decoded, f)
+	}
+	if again := encode(t, decoded); !bytes.Equal(again, data) {
+		t.Errorf("encoding gives different bytes")
+	}
+}
+
+func TestDefaultPalette(t *testing.T) {
+	f := newMagicaVoxelFile()
+	if c := f.Color(1); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("first color %v, want white", c)
+	}
+	if c := f.Color(215); c != (color.NRGBA{0, 0, 0x33, 255}) {
+		t.Errorf("last color of the cube %v", c)
+	}
+	if c := f.Color(216); c != (color.NRGBA{0xee, 0, 0, 255}) {
+		t.Errorf("first color of the red ramp %v", c)
+	}
+	if c := f.Color(255); c != (color.NRGBA{0x11, 0x11, 0x11, 255}) {
+		t.Errorf("last color %v", c)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	if m := IdentityRotation.Matrix(); *m != [3][3]int{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+		t.Errorf("identity: %v", m)
+	}
+
+	// all axis aligned rotations and reflections
+	count := 0
+	for i := 0; i < 128; i++ {
+		m := Rotation(i).Matrix()
+		if m == nil {
+			continue
+		}
+		count++
+		r, ok := NewRotation(*m)
+		if !ok || r != Rotation(i) {
+			t.Errorf("rotation %d gives %d", i, r)
+		}
+	}
+	if count != 48 {
+		t.Errorf("%d rotations, want 48", count)
+	}
+
+	if _, ok := NewRotation([3][3]int{{1, 0, 0}, {1, 0, 0}, {0, 0, 1}}); ok {
+		t.Errorf("invalid matrix accepted")
+	}
+
+	n := &TransformNode{}
+	n.SetFrame(Rotation(17), [3]int32{5, -1, 3})
+	r, translation, err := n.Frame()
+	if err != nil || r != 17 || translation != [3]int32{5, -1, 3} {
+		t.Errorf("frame: %v %v %v", r, translation, err)
+	}
+	n.Frames[0].Set("_r", "3")
+	if _, _, err := n.Frame(); err == nil {
+		t.Errorf("invalid rotation accepted")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := encode(t, newMagicaVoxelFile())
+
+	xyziWithoutSize := &writer{}
+	xyziWithoutSize.WriteString(MagicBytes)
+	xyziWithoutSize.i32(Version)
+	xyzi := &writer{}
+	xyzi.i32(0)
+	children := &writer{}
+	children.chunk("XYZI", xyzi)
+	xyziWithoutSize.rawChunk("MAIN", nil, children.Bytes())
+
+	tooManyVoxels := &writer{}
+	tooManyVoxels.WriteString(MagicBytes)
+	tooManyVoxels.i32(Version)
+	size := &writer{}
+	size.i32(1)
+	size.i32(1)
+	size.i32(1)
+	xyzi = &writer{}
+	xyzi.i32(1000)
+	children = &writer{}
+	children.chunk("SIZE", size)
+	children.chunk("XYZI", xyzi)
+	tooManyVoxels.rawChunk("MAIN", nil, children.Bytes())
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrInvalidMagic},
+		{"magic", append([]byte("VOY "), valid[4:]...), ErrInvalidMagic},
+		{"truncated", valid[:len(valid)-3], ErrInvalidChunk},
+		{"not main", append(append([]byte{}, valid[:8]...), []byte("PACK\x00\x00\x00\x00\x00\x00\x00\x00")...), ErrInvalidChunk},
+		{"XYZI without SIZE", xyziWithoutSize.Bytes(), ErrInvalidChunk},
+		{"too many voxels", tooManyVoxels.Bytes(), ErrInvalidChunk},
+	}
+
+	for _, test := range tests {
+		if _, err := Decode(bytes.NewReader(test.data)); !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+}